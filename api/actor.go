@@ -0,0 +1,55 @@
+package main
+
+import (
+  "context"
+  "strings"
+)
+
+// ActorHeader names the user or system making a request
+const ActorHeader = "X-Actor"
+
+// Actor limits
+const (
+  // AnonymousActor is recorded when a request doesn't say who made it
+  AnonymousActor = "anonymous"
+  MaxActorLength = 100
+)
+
+type actorKey struct{}
+
+type onBehalfOfKey struct{}
+
+// WithActor returns a context that records who is making a change
+func WithActor(ctx context.Context, actor string) context.Context {
+  return context.WithValue(ctx, actorKey{}, actor)
+}
+
+// ActorFrom returns the actor recorded in ctx, or AnonymousActor
+func ActorFrom(ctx context.Context) string {
+  if actor, ok := ctx.Value(actorKey{}).(string); ok && actor != "" {
+    return actor
+  }
+  return AnonymousActor
+}
+
+// WithOnBehalfOf returns a context that records who an authenticated caller
+// says it is acting for, as named in the X-Actor header
+func WithOnBehalfOf(ctx context.Context, label string) context.Context {
+  return context.WithValue(ctx, onBehalfOfKey{}, label)
+}
+
+// OnBehalfOfFrom returns who the caller says it is acting for, if anyone
+func OnBehalfOfFrom(ctx context.Context) string {
+  label, _ := ctx.Value(onBehalfOfKey{}).(string)
+  return label
+}
+
+// cleanActor trims an actor name from a header and cuts it to MaxActorLength
+func cleanActor(raw string) string {
+  actor := strings.TrimSpace(raw)
+  if len(actor) > MaxActorLength {
+    // Cut on a rune boundary so the stored name stays valid UTF-8
+    actor = strings.ToValidUTF8(actor[:MaxActorLength], "")
+  }
+  return actor
+}
@@ -0,0 +1,416 @@
+package main
+
+import (
+  "bufio"
+  "encoding/json"
+  "errors"
+  "fmt"
+  "io"
+  "log/slog"
+  "net/url"
+  "os"
+  "path/filepath"
+  "slices"
+  "strconv"
+  "sync"
+  "time"
+)
+
+// auditFileName is the audit trail's file inside DATA_DIR
+const auditFileName = "audit.log"
+
+// Audit actions
+const (
+  AuditCreate  = "create"
+  AuditUpdate  = "update"
+  AuditDelete  = "delete"
+  AuditRestore = "restore"
+  AuditPurge   = "purge"
+  // AuditFailed cancels an earlier entry whose change the store then failed
+  // to apply; Reverts holds that entry's sequence number
+  AuditFailed = "failed"
+)
+
+// Audited resources
+const (
+  ResourcePlayer = "player"
+  ResourceTeam   = "team"
+  ResourceMatch  = "match"
+)
+
+// Audit query limits
+const (
+  DefaultAuditLimit = 100
+  MaxAuditLimit     = 1000
+)
+
+// DefaultAuditMemoryEntries is how many of the newest entries a trail keeps
+// in memory. Older entries of a file-backed trail are read from disk when a
+// query reaches them; a memory trail forgets them.
+const DefaultAuditMemoryEntries = 100_000
+
+// AuditEntry records one change made through PlayerService. Before is empty
+// for creates and After for deletes and purges.
+type AuditEntry struct {
+  Seq        int64           `json:"seq"`
+  At         time.Time       `json:"at"`
+  Actor      string          `json:"actor"`
+  OnBehalfOf string          `json:"on_behalf_of,omitempty"`
+  RequestID  string          `json:"request_id,omitempty"`
+  Action     string          `json:"action"`
+  Resource   string          `json:"resource"`
+  ResourceID string          `json:"resource_id"`
+  Before     json.RawMessage `json:"before,omitempty"`
+  After      json.RawMessage `json:"after,omitempty"`
+  Reverts    int64           `json:"reverts,omitempty"`
+}
+
+// failedEntries cancels entries whose change the store failed to apply
+func failedEntries(entries []AuditEntry, cause error) []AuditEntry {
+  failed := make([]AuditEntry, len(entries))
+  for i, entry := range entries {
+    failed[i] = AuditEntry{
+      At:         entry.At,
+      Actor:      entry.Actor,
+      OnBehalfOf: entry.OnBehalfOf,
+      RequestID:  entry.RequestID,
+      Action:     AuditFailed,
+      Resource:   entry.Resource,
+      ResourceID: entry.ResourceID,
+      Reverts:    entry.Seq,
+      After:      auditSnapshot(map[string]string{"error": cause.Error()}),
+    }
+  }
+  return failed
+}
+
+// AuditTrailOptions configures a file-backed AuditTrail
+type AuditTrailOptions struct {
+  // NoSync skips fsync after every append. Only useful for tests.
+  NoSync bool
+  // MemoryEntries caps the entries kept in memory; DefaultAuditMemoryEntries
+  // when zero
+  MemoryEntries int
+}
+
+// AuditTrail is an append-only list of audit entries. Entries can be added
+// and queried but never changed or removed. When backed by a file every
+// entry is written and fsynced before Append returns, and the file is read
+// back on open, so the trail survives restarts.
+type AuditTrail struct {
+  mu   sync.RWMutex
+  file *os.File
+  size int64
+  opts AuditTrailOptions
+  seq  int64
+
+  // entries are the newest entries, and offsets where each starts in the
+  // file. Entries before the first one are only on disk.
+  entries []AuditEntry
+  offsets []int64
+}
+
+// NewMemoryAuditTrail creates an audit trail that is lost on restart
+func NewMemoryAuditTrail() *AuditTrail {
+  return &AuditTrail{opts: AuditTrailOptions{MemoryEntries: DefaultAuditMemoryEntries}}
+}
+
+// OpenAuditTrail opens (or creates) the audit trail at path. Anything after
+// the last intact entry, such as an entry torn by a crash, is discarded.
+func OpenAuditTrail(path string, opts AuditTrailOptions) (*AuditTrail, error) {
+  if err := os.MkdirAll(filepath.Dir(path), 0o755); err != nil {
+    return nil, fmt.Errorf("failed to create audit directory: %w", err)
+  }
+  file, err := os.OpenFile(path, os.O_RDWR|os.O_CREATE, 0o600)
+  if err != nil {
+    return nil, fmt.Errorf("failed to open audit trail: %w", err)
+  }
+
+  if opts.MemoryEntries <= 0 {
+    opts.MemoryEntries = DefaultAuditMemoryEntries
+  }
+  trail := &AuditTrail{file: file, opts: opts}
+  reader := bufio.NewReader(file)
+  for {
+    line, err := reader.ReadBytes('\n')
+    if errors.Is(err, io.EOF) {
+      if len(line) > 0 {
+        slog.Warn("Discarding incomplete audit entry", "bytes", len(line))
+      }
+      break
+    }
+    if err != nil {
+      file.Close()
+      return nil, fmt.Errorf("failed to read audit trail: %w", err)
+    }
+
+    var entry AuditEntry
+    if err := decodeRecord(line, &entry); err != nil {
+      slog.Warn("Discarding corrupt audit trail", "offset", trail.size, LogKeyError, err)
+      break
+    }
+    trail.keep([]AuditEntry{entry}, []int64{trail.size})
+    trail.seq = entry.Seq
+    trail.size += int64(len(line))
+  }
+
+  if err := file.Truncate(trail.size); err != nil {
+    file.Close()
+    return nil, fmt.Errorf("failed to truncate audit trail: %w", err)
+  }
+  if _, err := file.Seek(trail.size, io.SeekStart); err != nil {
+    file.Close()
+    return nil, fmt.Errorf("failed to seek audit trail: %w", err)
+  }
+  return trail, nil
+}
+
+// Append numbers the entries and adds them to the trail. Either all of
+// them are stored or, on error, none. It returns the numbered entries.
+func (a *AuditTrail) Append(entries []AuditEntry) ([]AuditEntry, error) {
+  a.mu.Lock()
+  defer a.mu.Unlock()
+
+  seq := a.seq
+  numbered := make([]AuditEntry, len(entries))
+  offsets := make([]int64, len(entries))
+  var records []byte
+  for i, entry := range entries {
+    seq++
+    entry.Seq = seq
+    numbered[i] = entry
+
+    record, err := encodeRecord(entry)
+    if err != nil {
+      return nil, fmt.Errorf("failed to encode audit entry: %w", err)
+    }
+    offsets[i] = a.size + int64(len(records))
+    records = append(records, record...)
+  }
+
+  if a.file != nil {
+    if _, err := a.file.Write(records); err != nil {
+      a.rollbackWrite()
+      return nil, fmt.Errorf("failed to write audit trail: %w", err)
+    }
+    if !a.opts.NoSync {
+      if err := a.file.Sync(); err != nil {
+        a.rollbackWrite()
+        return nil, fmt.Errorf("failed to sync audit trail: %w", err)
+      }
+    }
+    a.size += int64(len(records))
+  }
+
+  a.seq = seq
+  a.keep(numbered, offsets)
+  return numbered, nil
+}
+
+// keep adds entries to the in-memory window, dropping the oldest beyond
+// MemoryEntries. Reslicing lets the next growth of the slices free them.
+func (a *AuditTrail) keep(entries []AuditEntry, offsets []int64) {
+  a.entries = append(a.entries, entries...)
+  a.offsets = append(a.offsets, offsets...)
+  if excess := len(a.entries) - a.opts.MemoryEntries; excess > 0 {
+    clear(a.entries[:excess])
+    a.entries = a.entries[excess:]
+    a.offsets = a.offsets[excess:]
+  }
+}
+
+// rollbackWrite removes partially written entries from the end of the file
+func (a *AuditTrail) rollbackWrite() {
+  if err := a.file.Truncate(a.size); err != nil {
+    slog.Error("Error truncating audit trail after failed write", LogKeyError, err)
+  }
+  if _, err := a.file.Seek(a.size, io.SeekStart); err != nil {
+    slog.Error("Error seeking audit trail after failed write", LogKeyError, err)
+  }
+}
+
+// Close closes the file behind the trail
+func (a *AuditTrail) Close() error {
+  a.mu.Lock()
+  defer a.mu.Unlock()
+
+  if a.file == nil {
+    return nil
+  }
+  err := a.file.Close()
+  a.file = nil
+  return err
+}
+
+// AuditQuery selects audit entries for GET /audit
+type AuditQuery struct {
+  Resource   string
+  ResourceID string
+  Actor      string
+  Action     string
+  Range      TimeRange
+  // Before only returns entries older than this sequence number
+  Before int64
+  Limit  int
+}
+
+// matches reports whether an entry passes the query's filters
+func (q AuditQuery) matches(entry AuditEntry) bool {
+  return (q.Resource == "" || entry.Resource == q.Resource) &&
+    (q.ResourceID == "" || entry.ResourceID == q.ResourceID) &&
+    (q.Actor == "" || entry.Actor == q.Actor) &&
+    (q.Action == "" || entry.Action == q.Action) &&
+    q.Range.contains(entry.At)
+}
+
+// parseAuditQuery builds an AuditQuery from query parameters. player_id is
+// shorthand for resource=player and resource_id.
+func parseAuditQuery(values url.Values) (AuditQuery, error) {
+  query := AuditQuery{
+    Resource:   values.Get("resource"),
+    ResourceID: values.Get("resource_id"),
+    Actor:      values.Get("actor"),
+    Action:     values.Get("action"),
+  }
+
+  for param := range values {
+    switch param {
+    case "player_id", "resource", "resource_id", "actor", "action", "from", "to", "cursor", "limit":
+    default:
+      return query, fmt.Errorf("%w: unknown query parameter %q", ErrInvalidInput, param)
+    }
+  }
+
+  if id := values.Get("player_id"); id != "" {
+    if (query.Resource != "" && query.Resource != ResourcePlayer) || (query.ResourceID != "" && query.ResourceID != id) {
+      return query, fmt.Errorf("%w: player_id conflicts with resource or resource_id", ErrInvalidInput)
+    }
+    query.Resource, query.ResourceID = ResourcePlayer, id
+  }
+  switch query.Resource {
+  case "", ResourcePlayer, ResourceTeam, ResourceMatch:
+  default:
+    return query, fmt.Errorf("%w: resource must be %s, %s or %s", ErrInvalidInput, ResourcePlayer, ResourceTeam, ResourceMatch)
+  }
+  switch query.Action {
+  case "", AuditCreate, AuditUpdate, AuditDelete, AuditRestore, AuditPurge, AuditFailed:
+  default:
+    return query, fmt.Errorf("%w: action must be %s, %s, %s, %s, %s or %s", ErrInvalidInput,
+      AuditCreate, AuditUpdate, AuditDelete, AuditRestore, AuditPurge, AuditFailed)
+  }
+
+  if raw := values.Get("cursor"); raw != "" {
+    before, err := strconv.ParseInt(raw, 10, 64)
+    if err != nil || before <= 0 {
+      return query, fmt.Errorf("%w: invalid cursor", ErrInvalidInput)
+    }
+    query.Before = before
+  }
+
+  var err error
+  if query.Range, err = parseTimeRange(values); err != nil {
+    return query, err
+  }
+  query.Limit, err = parseLimit(values.Get("limit"), DefaultAuditLimit, MaxAuditLimit)
+  return query, err
+}
+
+// AuditPage is one page of audit entries, newest first. Cursor, when set,
+// fetches the next (older) page.
+type AuditPage struct {
+  Entries []AuditEntry
+  Total   int
+  Cursor  string
+}
+
+// Query returns the newest entries matching the query. Once a file-backed
+// trail holds more entries than it keeps in memory, the older part of the
+// file is read as well so the total and later pages stay complete.
+func (a *AuditTrail) Query(query AuditQuery) (AuditPage, error) {
+  a.mu.RLock()
+  defer a.mu.RUnlock()
+
+  page := AuditPage{Entries: make([]AuditEntry, 0)}
+  add := func(entry AuditEntry) {
+    if !query.matches(entry) {
+      return
+    }
+    page.Total++
+    if query.Before != 0 && entry.Seq >= query.Before {
+      return
+    }
+    if len(page.Entries) == query.Limit {
+      page.Cursor = strconv.FormatInt(page.Entries[len(page.Entries)-1].Seq, 10)
+      return
+    }
+    page.Entries = append(page.Entries, entry)
+  }
+  for i := len(a.entries) - 1; i >= 0; i-- {
+    add(a.entries[i])
+  }
+
+  if a.file == nil || len(a.offsets) == 0 || a.offsets[0] == 0 {
+    return page, nil
+  }
+  // One more than the page has room for tells whether there is a next page
+  older, matches, err := a.readOlder(query, query.Limit-len(page.Entries)+1)
+  if err != nil {
+    return page, err
+  }
+  page.Total += matches
+  for _, entry := range older {
+    if len(page.Entries) == query.Limit {
+      page.Cursor = strconv.FormatInt(page.Entries[len(page.Entries)-1].Seq, 10)
+      break
+    }
+    page.Entries = append(page.Entries, entry)
+  }
+  return page, nil
+}
+
+// readOlder reads the entries before the in-memory window from the file. It
+// returns the newest keep of them that match query and come before its
+// cursor, newest first, and how many match query in all.
+func (a *AuditTrail) readOlder(query AuditQuery, keep int) ([]AuditEntry, int, error) {
+  var usable []AuditEntry
+  matches := 0
+  reader := bufio.NewReader(io.NewSectionReader(a.file, 0, a.offsets[0]))
+  for {
+    line, err := reader.ReadBytes('\n')
+    if errors.Is(err, io.EOF) {
+      break
+    }
+    if err != nil {
+      return nil, 0, fmt.Errorf("failed to read audit trail: %w", err)
+    }
+    var entry AuditEntry
+    if err := decodeRecord(line, &entry); err != nil {
+      return nil, 0, fmt.Errorf("failed to read audit trail: %w", err)
+    }
+    if !query.matches(entry) {
+      continue
+    }
+    matches++
+    if query.Before != 0 && entry.Seq >= query.Before {
+      continue
+    }
+    usable = append(usable, entry)
+    if len(usable) > keep {
+      usable = usable[1:]
+    }
+  }
+  slices.Reverse(usable)
+  return usable, matches, nil
+}
+
+// auditSnapshot encodes a resource for an audit entry
+func auditSnapshot(v any) json.RawMessage {
+  // Players, teams and matches always encode
+  raw, _ := json.Marshal(v)
+  return raw
+}
+
+// AuditLog returns a page of the audit trail
+func (s *PlayerService) AuditLog(query AuditQuery) (AuditPage, error) {
+  return s.audit.Query(query)
+}
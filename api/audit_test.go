@@ -0,0 +1,331 @@
+package main
+
+import (
+  "encoding/json"
+  "errors"
+  "fmt"
+  "net/http"
+  "net/http/httptest"
+  "os"
+  "path/filepath"
+  "strconv"
+  "strings"
+  "testing"
+  "time"
+)
+
+func openTestAuditTrail(t *testing.T, path string) *AuditTrail {
+  t.Helper()
+  trail, err := OpenAuditTrail(path, AuditTrailOptions{NoSync: true})
+  if err != nil {
+    t.Fatalf("Failed to open audit trail: %v", err)
+  }
+  return trail
+}
+
+func auditLog(t *testing.T, service *PlayerService, query AuditQuery) AuditPage {
+  t.Helper()
+  page, err := service.AuditLog(query)
+  if err != nil {
+    t.Fatalf("Failed to query audit trail: %v", err)
+  }
+  return page
+}
+
+func TestPlayerService_AuditsChanges(t *testing.T) {
+  service := NewPlayerService()
+  fakeClock(service, time.Date(2025, 3, 3, 9, 0, 0, 0, time.UTC))
+  ctx := WithRequestID(WithActor(t.Context(), "alice"), "req-1")
+
+  player, err := service.CreatePlayer(ctx, PlayerRequest{Name: "Pedri", JerseyNumber: 8, Rating: 86})
+  if err != nil {
+    t.Fatalf("Failed to create player: %v", err)
+  }
+  if _, err := service.UpdatePlayer(ctx, player.ID, PlayerRequest{Name: "Pedri", JerseyNumber: 8, Rating: 88}, AnyVersion); err != nil {
+    t.Fatalf("Failed to update player: %v", err)
+  }
+  if _, err := service.DeletePlayer(t.Context(), player.ID, AnyVersion); err != nil {
+    t.Fatalf("Failed to delete player: %v", err)
+  }
+  // Rejected writes leave no trace
+  if _, err := service.CreatePlayer(ctx, PlayerRequest{Name: "Messi", JerseyNumber: 10, Rating: 99}); !errors.Is(err, ErrPlayerExists) {
+    t.Fatalf("Expected ErrPlayerExists, got %v", err)
+  }
+
+  page := auditLog(t, service, AuditQuery{Limit: 10})
+  if page.Total != 3 {
+    t.Fatalf("Expected 3 audit entries, got %+v", page.Entries)
+  }
+
+  // Newest first
+  deleted, updated, created := page.Entries[0], page.Entries[1], page.Entries[2]
+  if created.Seq != 1 || created.Action != AuditCreate || created.Actor != "alice" || created.RequestID != "req-1" ||
+    created.Before != nil || created.ResourceID != player.ID || !created.At.Equal(time.Date(2025, 3, 3, 9, 0, 0, 0, time.UTC)) {
+    t.Errorf("Unexpected create entry: %+v", created)
+  }
+
+  var before, after Player
+  json.Unmarshal(updated.Before, &before)
+  json.Unmarshal(updated.After, &after)
+  if updated.Action != AuditUpdate || before.Rating != 86 || after.Rating != 88 || after.Version != 2 {
+    t.Errorf("Expected update from 86 to 88, got %+v", updated)
+  }
+  if deleted.Action != AuditDelete || deleted.Actor != AnonymousActor || deleted.After != nil || deleted.Before == nil {
+    t.Errorf("Unexpected delete entry: %+v", deleted)
+  }
+}
+
+func TestAuditTrail_Query(t *testing.T) {
+  service, miami, _ := newServiceWithTeams(t)
+  now := fakeClock(service, time.Date(2025, 3, 3, 9, 0, 0, 0, time.UTC))
+
+  for _, rating := range []int8{90, 91, 92} {
+    *now = now.Add(time.Hour)
+    setRating(t, service, "bob", "1", rating)
+  }
+  setRating(t, service, "carol", "2", 90)
+  if _, err := service.DeleteTeam(t.Context(), miami.ID, AnyVersion, TeamDeleteOptions{}); err != nil {
+    t.Fatalf("Failed to delete team: %v", err)
+  }
+
+  tests := []struct {
+    name  string
+    query AuditQuery
+    want  []int64
+  }{
+    {"player", AuditQuery{Resource: ResourcePlayer, ResourceID: "1", Limit: 10}, []int64{5, 4, 3}},
+    {"actor", AuditQuery{Actor: "carol", Limit: 10}, []int64{6}},
+    {"team deletes", AuditQuery{Resource: ResourceTeam, Action: AuditDelete, Limit: 10}, []int64{7}},
+    {"time range", AuditQuery{Range: TimeRange{From: time.Date(2025, 3, 3, 10, 30, 0, 0, time.UTC), To: time.Date(2025, 3, 3, 11, 30, 0, 0, time.UTC)}, Limit: 10}, []int64{4}},
+    {"first page", AuditQuery{Limit: 2}, []int64{7, 6}},
+    {"next page", AuditQuery{Before: 6, Limit: 2}, []int64{5, 4}},
+  }
+  for _, tt := range tests {
+    t.Run(tt.name, func(t *testing.T) {
+      page := auditLog(t, service, tt.query)
+      seqs := make([]int64, 0, len(page.Entries))
+      for _, entry := range page.Entries {
+        seqs = append(seqs, entry.Seq)
+      }
+      if len(seqs) != len(tt.want) {
+        t.Fatalf("Expected %v, got %v", tt.want, seqs)
+      }
+      for i := range seqs {
+        if seqs[i] != tt.want[i] {
+          t.Errorf("Expected %v, got %v", tt.want, seqs)
+          break
+        }
+      }
+    })
+  }
+
+  if page := auditLog(t, service, AuditQuery{Limit: 2}); page.Cursor != "6" || page.Total != 7 {
+    t.Errorf("Expected cursor 6 and 7 entries, got %q and %d", page.Cursor, page.Total)
+  }
+}
+
+func TestAuditTrail_PersistsAcrossRestart(t *testing.T) {
+  dir := t.TempDir()
+  path := filepath.Join(dir, auditFileName)
+
+  service := NewPlayerServiceWithAudit(openTestFileStore(t, dir, 0), openTestAuditTrail(t, path))
+  for _, name := range []string{"Pedri", "Gavi"} {
+    if _, err := service.CreatePlayer(WithActor(t.Context(), "alice"), PlayerRequest{Name: name, JerseyNumber: 8, Rating: 85}); err != nil {
+      t.Fatalf("Failed to create player: %v", err)
+    }
+  }
+  if err := service.Close(); err != nil {
+    t.Fatalf("Failed to close service: %v", err)
+  }
+
+  // A crash can leave half an entry at the end of the file
+  file, err := os.OpenFile(path, os.O_APPEND|os.O_WRONLY, 0o600)
+  if err != nil {
+    t.Fatalf("Failed to open audit file: %v", err)
+  }
+  file.WriteString(`1234abcd {"seq": 3, "act`)
+  file.Close()
+
+  service = NewPlayerServiceWithAudit(openTestFileStore(t, dir, 0), openTestAuditTrail(t, path))
+  defer service.Close()
+
+  page := auditLog(t, service, AuditQuery{Limit: 10})
+  if page.Total != 2 || page.Entries[1].Actor != "alice" {
+    t.Fatalf("Expected both entries to survive a restart, got %+v", page.Entries)
+  }
+  if _, err := service.DeletePlayer(t.Context(), "1", AnyVersion); err != nil {
+    t.Fatalf("Failed to delete player: %v", err)
+  }
+  if page := auditLog(t, service, AuditQuery{Limit: 1}); page.Entries[0].Seq != 3 || page.Entries[0].Action != AuditDelete {
+    t.Errorf("Expected the delete to be entry 3, got %+v", page.Entries)
+  }
+}
+
+func TestPlayerService_AuditFailureRefusesWrite(t *testing.T) {
+  trail := openTestAuditTrail(t, filepath.Join(t.TempDir(), auditFileName))
+  service := NewPlayerServiceWithAudit(NewMemoryStore(), trail)
+
+  // Writes to a closed file fail
+  trail.file.Close()
+  if _, err := service.CreatePlayer(t.Context(), PlayerRequest{Name: "Pedri", JerseyNumber: 8, Rating: 85}); err == nil {
+    t.Fatalf("Expected the create to fail without an audit trail")
+  }
+  if service.PlayerExists("1") {
+    t.Errorf("Expected the player not to be stored")
+  }
+  if suggestions := service.SuggestPlayers("Ped", 10); len(suggestions) != 0 {
+    t.Errorf("Expected the indexes to be rolled back, got %+v", suggestions)
+  }
+}
+
+// failingStore is a MemoryStore whose writes fail
+type failingStore struct {
+  *MemoryStore
+}
+
+func (failingStore) Apply(StoreBatch) error {
+  return errors.New("disk full")
+}
+
+func TestPlayerService_AuditCancelsFailedWrites(t *testing.T) {
+  trail := NewMemoryAuditTrail()
+  service := NewPlayerServiceWithAudit(failingStore{NewMemoryStore()}, trail)
+
+  if _, err := service.CreatePlayer(WithActor(t.Context(), "alice"), PlayerRequest{Name: "Pedri", JerseyNumber: 8, Rating: 85}); err == nil {
+    t.Fatalf("Expected the create to fail")
+  }
+  page := auditLog(t, service, AuditQuery{Limit: 10})
+  if page.Total != 2 {
+    t.Fatalf("Expected the create and its cancellation, got %+v", page.Entries)
+  }
+  failed, created := page.Entries[0], page.Entries[1]
+  if failed.Action != AuditFailed || failed.Reverts != created.Seq || failed.Actor != "alice" ||
+    failed.ResourceID != created.ResourceID || !strings.Contains(string(failed.After), "disk full") {
+    t.Errorf("Expected a failed entry reverting %+v, got %+v", created, failed)
+  }
+}
+
+func TestAuditTrail_MemoryWindow(t *testing.T) {
+  path := filepath.Join(t.TempDir(), auditFileName)
+  trail, err := OpenAuditTrail(path, AuditTrailOptions{NoSync: true, MemoryEntries: 3})
+  if err != nil {
+    t.Fatalf("Failed to open audit trail: %v", err)
+  }
+  for _, actor := range []string{"alice", "bob", "alice", "bob", "alice"} {
+    if _, err := trail.Append([]AuditEntry{{Actor: actor, Action: AuditCreate, Resource: ResourcePlayer}}); err != nil {
+      t.Fatalf("Failed to append: %v", err)
+    }
+  }
+  if len(trail.entries) != 3 || trail.entries[0].Seq != 3 {
+    t.Fatalf("Expected only entries 3-5 in memory, got %+v", trail.entries)
+  }
+
+  // Paging reaches the entries that are only on disk
+  collect := func(trail *AuditTrail, query AuditQuery) (seqs []int64, total int) {
+    query.Limit = 2
+    for {
+      page, err := trail.Query(query)
+      if err != nil {
+        t.Fatalf("Query failed: %v", err)
+      }
+      for _, entry := range page.Entries {
+        seqs = append(seqs, entry.Seq)
+      }
+      total = page.Total
+      if page.Cursor == "" {
+        return seqs, total
+      }
+      query.Before, _ = strconv.ParseInt(page.Cursor, 10, 64)
+    }
+  }
+  if seqs, total := collect(trail, AuditQuery{}); fmt.Sprint(seqs) != "[5 4 3 2 1]" || total != 5 {
+    t.Errorf("Expected every entry newest first, got %v of %d", seqs, total)
+  }
+  if seqs, total := collect(trail, AuditQuery{Actor: "alice"}); fmt.Sprint(seqs) != "[5 3 1]" || total != 3 {
+    t.Errorf("Expected alice's entries, got %v of %d", seqs, total)
+  }
+  trail.Close()
+
+  // Reopening keeps the same window and numbering
+  trail, err = OpenAuditTrail(path, AuditTrailOptions{NoSync: true, MemoryEntries: 3})
+  if err != nil {
+    t.Fatalf("Failed to reopen audit trail: %v", err)
+  }
+  defer trail.Close()
+  if appended, err := trail.Append([]AuditEntry{{Actor: "carol"}}); err != nil || appended[0].Seq != 6 || len(trail.entries) != 3 {
+    t.Fatalf("Expected entry 6 with a window of 3, got %+v %v", appended, err)
+  }
+  if seqs, _ := collect(trail, AuditQuery{}); fmt.Sprint(seqs) != "[6 5 4 3 2 1]" {
+    t.Errorf("Expected every entry after reopening, got %v", seqs)
+  }
+}
+
+func TestPlayerHandler_Audit(t *testing.T) {
+  service := NewPlayerService()
+  handler := NewPlayerHandler(service)
+
+  mux := http.NewServeMux()
+  mux.HandleFunc("POST /players", handler.CreatePlayer)
+  mux.HandleFunc("GET /audit", handler.GetAudit)
+  server := RequestIDMiddleware(ActorMiddleware(mux))
+
+  do := func(method, target, body string, header http.Header) *httptest.ResponseRecorder {
+    req := httptest.NewRequest(method, target, strings.NewReader(body))
+    for name, values := range header {
+      req.Header.Set(name, values[0])
+    }
+    w := httptest.NewRecorder()
+    server.ServeHTTP(w, req)
+    return w
+  }
+
+  w := do("POST", "/players", `{"name": "Pedri", "jersey_number": 8, "rating": 86}`,
+    http.Header{ActorHeader: {"alice"}, RequestIDHeader: {"abc-123"}})
+  if w.Code != http.StatusCreated || w.Header().Get(RequestIDHeader) != "abc-123" {
+    t.Fatalf("Expected 201 echoing the request ID, got %d %q", w.Code, w.Header().Get(RequestIDHeader))
+  }
+  w = do("POST", "/players", `{"name": "Gavi", "jersey_number": 6, "rating": 83}`,
+    http.Header{RequestIDHeader: {"bad id\n"}})
+  generated := w.Header().Get(RequestIDHeader)
+  if len(generated) != 32 {
+    t.Errorf("Expected a generated request ID for an unusable one, got %q", generated)
+  }
+
+  w = do("GET", "/audit?actor=alice", "", nil)
+  var response struct {
+    Data []AuditEntry `json:"data"`
+    Meta PageMeta     `json:"meta"`
+  }
+  if err := json.NewDecoder(w.Body).Decode(&response); err != nil {
+    t.Fatalf("Failed to decode response: %v", err)
+  }
+  if response.Meta.Total != 1 || response.Data[0].RequestID != "abc-123" || response.Data[0].ResourceID != "4" {
+    t.Errorf("Expected Pedri's create by alice, got %+v", response.Data)
+  }
+
+  w = do("GET", "/audit?player_id=5", "", nil)
+  response.Data = nil
+  json.NewDecoder(w.Body).Decode(&response)
+  if len(response.Data) != 1 || response.Data[0].RequestID != generated || response.Data[0].Actor != AnonymousActor {
+    t.Errorf("Expected Gavi's create under the generated request ID, got %+v", response.Data)
+  }
+
+  tests := []struct {
+    target string
+    want   int
+  }{
+    {"/audit?resource=coach", http.StatusBadRequest},
+    {"/audit?action=rename", http.StatusBadRequest},
+    {"/audit?player_id=1&resource=team", http.StatusBadRequest},
+    {"/audit?cursor=abc", http.StatusBadRequest},
+    {"/audit?seq=1", http.StatusBadRequest},
+    {"/audit?from=2025-01-01&limit=1", http.StatusOK},
+  }
+  for _, tt := range tests {
+    t.Run(tt.target, func(t *testing.T) {
+      if w := do("GET", tt.target, "", nil); w.Code != tt.want {
+        t.Errorf("Expected status %d, got %d: %s", tt.want, w.Code, w.Body.String())
+      }
+    })
+  }
+}
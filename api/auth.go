@@ -0,0 +1,349 @@
+package main
+
+import (
+  "context"
+  "crypto/rand"
+  "crypto/sha256"
+  "crypto/subtle"
+  "encoding/hex"
+  "encoding/json"
+  "errors"
+  "fmt"
+  "net/http"
+  "os"
+  "path/filepath"
+  "slices"
+  "strconv"
+  "strings"
+  "sync"
+  "time"
+)
+
+// apiKeysFileName is the API keys file inside DATA_DIR
+const apiKeysFileName = "apikeys.json"
+
+// Scopes an API key can carry. Each scope includes the ones before it, so a
+// write key can also read and an admin key can do anything.
+const (
+  ScopeRead  = "read"
+  ScopeWrite = "write"
+  ScopeAdmin = "admin"
+  // ScopePublic marks routes that need no authentication
+  ScopePublic = ""
+)
+
+// API key limits
+const (
+  // APIKeyPrefix starts every generated key, so leaked keys are easy to spot
+  APIKeyPrefix = "pk_"
+  // apiKeyShownPrefix is how much of a key is kept to tell keys apart
+  apiKeyShownPrefix   = len(APIKeyPrefix) + 8
+  MinAPIKeyLength     = 16
+  MaxAPIKeys          = 1000
+  MaxAPIKeyNameLength = 100
+  // BootstrapKeyName is the name changes made with the bootstrap key are
+  // attributed to
+  BootstrapKeyName = "bootstrap"
+)
+
+var (
+  ErrAPIKeyNotFound = errors.New("API key not found")
+  // ErrUnauthenticated is returned when a request carries no valid credentials
+  ErrUnauthenticated = errors.New("unauthenticated")
+  // ErrForbidden is returned when the credentials lack the scope a route needs
+  ErrForbidden = errors.New("forbidden")
+)
+
+// APIKey is a credential for the API. Only a hash of the key is kept; the
+// key itself is returned once, when it is created.
+type APIKey struct {
+  ID        string    `json:"id"`
+  Name      string    `json:"name"`
+  Scopes    []string  `json:"scopes"`
+  Prefix    string    `json:"prefix"`
+  Hash      string    `json:"hash,omitempty"`
+  Key       string    `json:"key,omitempty"`
+  CreatedAt time.Time `json:"created_at"`
+}
+
+// APIKeyRequest represents the request structure for creating API keys
+type APIKeyRequest struct {
+  Name   string   `json:"name"`
+  Scopes []string `json:"scopes"`
+}
+
+// Validate validates the API key request data
+func (kr *APIKeyRequest) Validate() error {
+  kr.Name = strings.TrimSpace(kr.Name)
+  if kr.Name == "" || len(kr.Name) > MaxAPIKeyNameLength {
+    return fmt.Errorf("%w: name is required and must be at most %d characters", ErrInvalidInput, MaxAPIKeyNameLength)
+  }
+  if len(kr.Scopes) == 0 {
+    return fmt.Errorf("%w: at least one scope is required", ErrInvalidInput)
+  }
+  for _, scope := range kr.Scopes {
+    if scopeRank(scope) == 0 {
+      return fmt.Errorf("%w: scopes must be %s, %s or %s", ErrInvalidInput, ScopeRead, ScopeWrite, ScopeAdmin)
+    }
+  }
+  return nil
+}
+
+// redacted returns a copy of the key without its hash or the key itself
+func (k *APIKey) redacted() APIKey {
+  copied := *k
+  copied.Hash, copied.Key = "", ""
+  copied.Scopes = slices.Clone(k.Scopes)
+  return copied
+}
+
+// Principal is who a request was authenticated as. ID is unique to the
+// credential, e.g. "apikey:3" or "jwt:alice"; Name is what changes are
+// attributed to.
+type Principal struct {
+  ID     string
+  Name   string
+  Scopes []string
+}
+
+type principalKey struct{}
+
+// WithPrincipal returns a context that records who a request was
+// authenticated as
+func WithPrincipal(ctx context.Context, principal Principal) context.Context {
+  return context.WithValue(ctx, principalKey{}, principal)
+}
+
+// PrincipalFrom returns the principal recorded in ctx, if any
+func PrincipalFrom(ctx context.Context) (Principal, bool) {
+  principal, ok := ctx.Value(principalKey{}).(Principal)
+  return principal, ok
+}
+
+// Allows reports whether the principal's scopes cover scope
+func (p Principal) Allows(scope string) bool {
+  need := scopeRank(scope)
+  for _, granted := range p.Scopes {
+    if scopeRank(granted) >= need {
+      return true
+    }
+  }
+  return need == 0
+}
+
+// scopeRank orders scopes by what they allow; unknown scopes rank 0
+func scopeRank(scope string) int {
+  switch scope {
+  case ScopeRead:
+    return 1
+  case ScopeWrite:
+    return 2
+  case ScopeAdmin:
+    return 3
+  }
+  return 0
+}
+
+// apiKeysFile is the on-disk format of the API keys
+type apiKeysFile struct {
+  IDCounter int      `json:"id_counter"`
+  Keys      []APIKey `json:"keys"`
+}
+
+// APIKeyStore holds the API keys, indexed by hash so a request's key can be
+// checked without ever storing it. Keys survive restarts when the store is
+// backed by a file.
+type APIKeyStore struct {
+  mu        sync.RWMutex
+  path      string
+  keys      map[string]*APIKey
+  byHash    map[string]*APIKey
+  idCounter int
+  bootstrap string
+  now       func() time.Time
+}
+
+// NewAPIKeyStore creates a store that keeps its keys in memory
+func NewAPIKeyStore() *APIKeyStore {
+  return &APIKeyStore{
+    keys:   make(map[string]*APIKey),
+    byHash: make(map[string]*APIKey),
+    now:    time.Now,
+  }
+}
+
+// OpenAPIKeyStore creates a store that keeps its keys in the file at path,
+// loading any saved there before
+func OpenAPIKeyStore(path string) (*APIKeyStore, error) {
+  ks := NewAPIKeyStore()
+  ks.path = path
+
+  raw, err := os.ReadFile(path)
+  if errors.Is(err, os.ErrNotExist) {
+    return ks, nil
+  }
+  if err != nil {
+    return nil, fmt.Errorf("failed to read API keys: %w", err)
+  }
+
+  var saved apiKeysFile
+  if err := json.Unmarshal(raw, &saved); err != nil {
+    return nil, fmt.Errorf("failed to decode API keys: %w", err)
+  }
+  ks.idCounter = saved.IDCounter
+  for _, key := range saved.Keys {
+    ks.keys[key.ID] = &key
+    ks.byHash[key.Hash] = &key
+  }
+  return ks, nil
+}
+
+// SetBootstrapKey accepts key as an admin key that is never stored, so the
+// first keys can be created on a fresh install
+func (ks *APIKeyStore) SetBootstrapKey(key string) error {
+  if len(key) < MinAPIKeyLength {
+    return fmt.Errorf("%w: bootstrap key must be at least %d characters", ErrInvalidInput, MinAPIKeyLength)
+  }
+
+  ks.mu.Lock()
+  defer ks.mu.Unlock()
+
+  ks.bootstrap = hashAPIKey(key)
+  return nil
+}
+
+// save writes the keys to disk. The caller must hold the lock.
+func (ks *APIKeyStore) save() error {
+  if ks.path == "" {
+    return nil
+  }
+  saved := apiKeysFile{IDCounter: ks.idCounter, Keys: make([]APIKey, 0, len(ks.keys))}
+  for _, key := range ks.keys {
+    saved.Keys = append(saved.Keys, *key)
+  }
+  slices.SortFunc(saved.Keys, func(a, b APIKey) int { return compareIDs(a.ID, b.ID) })
+
+  raw, err := json.Marshal(saved)
+  if err != nil {
+    return fmt.Errorf("failed to encode API keys: %w", err)
+  }
+  if err := os.MkdirAll(filepath.Dir(ks.path), 0o755); err != nil {
+    return fmt.Errorf("failed to create API keys directory: %w", err)
+  }
+  return writeFileAtomic(ks.path, raw, 0o600, true)
+}
+
+// CreateAPIKey generates a new key. The returned key includes the key
+// itself, which is not shown again.
+func (ks *APIKeyStore) CreateAPIKey(req APIKeyRequest) (APIKey, error) {
+  if err := req.Validate(); err != nil {
+    return APIKey{}, err
+  }
+
+  ks.mu.Lock()
+  defer ks.mu.Unlock()
+
+  if len(ks.keys) >= MaxAPIKeys {
+    return APIKey{}, fmt.Errorf("%w: at most %d API keys are allowed", ErrInvalidInput, MaxAPIKeys)
+  }
+  scopes := make([]string, 0, len(req.Scopes))
+  for _, scope := range req.Scopes {
+    if !slices.Contains(scopes, scope) {
+      scopes = append(scopes, scope)
+    }
+  }
+  var b [24]byte
+  rand.Read(b[:])
+  secret := APIKeyPrefix + hex.EncodeToString(b[:])
+
+  ks.idCounter++
+  key := &APIKey{
+    ID:        strconv.Itoa(ks.idCounter),
+    Name:      req.Name,
+    Scopes:    scopes,
+    Prefix:    secret[:apiKeyShownPrefix],
+    Hash:      hashAPIKey(secret),
+    CreatedAt: ks.now().UTC(),
+  }
+  ks.keys[key.ID] = key
+  ks.byHash[key.Hash] = key
+  if err := ks.save(); err != nil {
+    delete(ks.keys, key.ID)
+    delete(ks.byHash, key.Hash)
+    return APIKey{}, err
+  }
+
+  created := key.redacted()
+  created.Key = secret
+  return created, nil
+}
+
+// APIKeys returns every key, without hashes
+func (ks *APIKeyStore) APIKeys() []APIKey {
+  ks.mu.RLock()
+  defer ks.mu.RUnlock()
+
+  keys := make([]APIKey, 0, len(ks.keys))
+  for _, key := range ks.keys {
+    keys = append(keys, key.redacted())
+  }
+  slices.SortFunc(keys, func(a, b APIKey) int { return compareIDs(a.ID, b.ID) })
+  return keys
+}
+
+// DeleteAPIKey revokes a key. Requests using it fail from then on.
+func (ks *APIKeyStore) DeleteAPIKey(id string) (APIKey, error) {
+  ks.mu.Lock()
+  defer ks.mu.Unlock()
+
+  key, exists := ks.keys[id]
+  if !exists {
+    return APIKey{}, fmt.Errorf("%w: %s", ErrAPIKeyNotFound, id)
+  }
+  delete(ks.keys, id)
+  delete(ks.byHash, key.Hash)
+  if err := ks.save(); err != nil {
+    ks.keys[id] = key
+    ks.byHash[key.Hash] = key
+    return APIKey{}, err
+  }
+  return key.redacted(), nil
+}
+
+// Authenticate returns the principal for a key presented by a client
+func (ks *APIKeyStore) Authenticate(secret string) (Principal, error) {
+  hash := hashAPIKey(secret)
+
+  ks.mu.RLock()
+  defer ks.mu.RUnlock()
+
+  if ks.bootstrap != "" && subtle.ConstantTimeCompare([]byte(hash), []byte(ks.bootstrap)) == 1 {
+    return Principal{ID: "apikey:" + BootstrapKeyName, Name: BootstrapKeyName, Scopes: []string{ScopeAdmin}}, nil
+  }
+  key, exists := ks.byHash[hash]
+  if !exists {
+    return Principal{}, fmt.Errorf("%w: invalid API key", ErrUnauthenticated)
+  }
+  return Principal{ID: "apikey:" + key.ID, Name: key.Name, Scopes: slices.Clone(key.Scopes)}, nil
+}
+
+// hashAPIKey returns the hex SHA-256 of a key. Keys are long random strings,
+// so a fast hash is enough; there is nothing to brute-force.
+func hashAPIKey(secret string) string {
+  sum := sha256.Sum256([]byte(secret))
+  return hex.EncodeToString(sum[:])
+}
+
+// bearerToken returns the token of an "Authorization: Bearer <token>" header
+func bearerToken(r *http.Request) (string, error) {
+  header := r.Header.Get("Authorization")
+  if header == "" {
+    return "", fmt.Errorf("%w: missing Authorization header", ErrUnauthenticated)
+  }
+  scheme, token, found := strings.Cut(header, " ")
+  token = strings.TrimSpace(token)
+  if !found || !strings.EqualFold(scheme, "Bearer") || token == "" {
+    return "", fmt.Errorf("%w: Authorization header must be \"Bearer <token>\"", ErrUnauthenticated)
+  }
+  return token, nil
+}
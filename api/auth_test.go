@@ -0,0 +1,172 @@
+package main
+
+import (
+  "encoding/json"
+  "net/http"
+  "net/http/httptest"
+  "os"
+  "path/filepath"
+  "strings"
+  "testing"
+)
+
+func TestPrincipal_Allows(t *testing.T) {
+  tests := []struct {
+    scopes []string
+    need   string
+    want   bool
+  }{
+    {[]string{ScopeRead}, ScopeRead, true},
+    {[]string{ScopeRead}, ScopeWrite, false},
+    {[]string{ScopeWrite}, ScopeRead, true},
+    {[]string{ScopeWrite}, ScopeAdmin, false},
+    {[]string{ScopeAdmin}, ScopeWrite, true},
+    {[]string{ScopeRead, ScopeAdmin}, ScopeAdmin, true},
+    {nil, ScopeRead, false},
+    {nil, ScopePublic, true},
+  }
+  for _, tt := range tests {
+    if got := (Principal{Scopes: tt.scopes}).Allows(tt.need); got != tt.want {
+      t.Errorf("%v allows %q: expected %v, got %v", tt.scopes, tt.need, tt.want, got)
+    }
+  }
+}
+
+func TestAPIKeyStore_PersistsHashesOnly(t *testing.T) {
+  path := filepath.Join(t.TempDir(), apiKeysFileName)
+  keys, err := OpenAPIKeyStore(path)
+  if err != nil {
+    t.Fatalf("Failed to open API keys: %v", err)
+  }
+  reader, err := keys.CreateAPIKey(APIKeyRequest{Name: "dashboard", Scopes: []string{ScopeRead, ScopeRead}})
+  if err != nil {
+    t.Fatalf("Failed to create API key: %v", err)
+  }
+  if !strings.HasPrefix(reader.Key, APIKeyPrefix) || reader.Hash != "" || len(reader.Scopes) != 1 {
+    t.Errorf("Expected the new key without its hash, got %+v", reader)
+  }
+  writer, _ := keys.CreateAPIKey(APIKeyRequest{Name: "importer", Scopes: []string{ScopeWrite}})
+
+  raw, err := os.ReadFile(path)
+  if err != nil {
+    t.Fatalf("Failed to read API keys: %v", err)
+  }
+  if strings.Contains(string(raw), reader.Key) || !strings.Contains(string(raw), hashAPIKey(reader.Key)) {
+    t.Errorf("Expected only the key's hash on disk, got %s", raw)
+  }
+  if info, _ := os.Stat(path); info.Mode().Perm() != 0o600 {
+    t.Errorf("Expected the keys file to be private, got %v", info.Mode())
+  }
+
+  // Revoked keys stop working, across a restart too
+  if _, err := keys.DeleteAPIKey(writer.ID); err != nil {
+    t.Fatalf("Failed to delete API key: %v", err)
+  }
+  keys, err = OpenAPIKeyStore(path)
+  if err != nil {
+    t.Fatalf("Failed to reopen API keys: %v", err)
+  }
+  if principal, err := keys.Authenticate(reader.Key); err != nil || principal.Name != "dashboard" {
+    t.Errorf("Expected the reader key to still work, got %+v %v", principal, err)
+  }
+  if _, err := keys.Authenticate(writer.Key); err == nil {
+    t.Errorf("Expected the revoked key to be rejected")
+  }
+  if listed := keys.APIKeys(); len(listed) != 1 || listed[0].Hash != "" || listed[0].Prefix != reader.Key[:apiKeyShownPrefix] {
+    t.Errorf("Expected the reader key without its hash, got %+v", listed)
+  }
+  if _, err := keys.DeleteAPIKey(writer.ID); err == nil {
+    t.Errorf("Expected deleting a revoked key to fail")
+  }
+}
+
+func TestRequireScope(t *testing.T) {
+  service := NewPlayerService()
+  keys := NewAPIKeyStore()
+  if err := keys.SetBootstrapKey("short"); err == nil {
+    t.Errorf("Expected a short bootstrap key to be rejected")
+  }
+  keys.SetBootstrapKey("bootstrap-secret-key")
+  router := ActorMiddleware(newRouter(NewPlayerHandlerWithAuth(service, NewWebhookDispatcher(service.Events(), WebhookOptions{}), keys, nil), nil))
+
+  do := func(method, target, key, body string) *httptest.ResponseRecorder {
+    req := httptest.NewRequest(method, target, strings.NewReader(body))
+    if key != "" {
+      req.Header.Set("Authorization", "Bearer "+key)
+    }
+    w := httptest.NewRecorder()
+    router.ServeHTTP(w, req)
+    return w
+  }
+
+  // The bootstrap key issues the real keys
+  w := do("POST", "/apikeys", "bootstrap-secret-key", `{"name": "dashboard", "scopes": ["read"]}`)
+  var created struct {
+    Data APIKey `json:"data"`
+  }
+  json.NewDecoder(w.Body).Decode(&created)
+  if w.Code != http.StatusCreated || created.Data.Key == "" {
+    t.Fatalf("Expected a new key, got %d %+v", w.Code, created)
+  }
+  reader := created.Data.Key
+  writer, _ := keys.CreateAPIKey(APIKeyRequest{Name: "importer", Scopes: []string{ScopeWrite}})
+
+  tests := []struct {
+    name   string
+    method string
+    target string
+    key    string
+    want   int
+  }{
+    {"health is public", "GET", "/health", "", http.StatusOK},
+    {"no key", "GET", "/players", "", http.StatusUnauthorized},
+    {"unknown key", "GET", "/players", "pk_nope", http.StatusUnauthorized},
+    {"read", "GET", "/players/1", reader, http.StatusOK},
+    {"read can't write", "DELETE", "/players/1", reader, http.StatusForbidden},
+    {"write can read", "GET", "/teams", writer.Key, http.StatusOK},
+    {"write can't administer", "GET", "/audit", writer.Key, http.StatusForbidden},
+    {"write", "DELETE", "/players/1", writer.Key, http.StatusOK},
+    {"admin", "GET", "/audit", "bootstrap-secret-key", http.StatusOK},
+    {"keys are admin only", "GET", "/apikeys", reader, http.StatusForbidden},
+  }
+  for _, tt := range tests {
+    t.Run(tt.name, func(t *testing.T) {
+      w := do(tt.method, tt.target, tt.key, "")
+      if w.Code != tt.want {
+        t.Fatalf("Expected status %d, got %d: %s", tt.want, w.Code, w.Body.String())
+      }
+      if w.Code == http.StatusUnauthorized || w.Code == http.StatusForbidden {
+        var response Response
+        if err := json.NewDecoder(w.Body).Decode(&response); err != nil || response.Status != "error" || response.Error == "" {
+          t.Errorf("Expected a standard error response, got %+v %v", response, err)
+        }
+      }
+      if w.Code == http.StatusUnauthorized && w.Header().Get("WWW-Authenticate") == "" {
+        t.Errorf("Expected a WWW-Authenticate challenge")
+      }
+    })
+  }
+
+  // Changes are attributed to the key; X-Actor can't impersonate anyone and
+  // is only kept as who the caller acts for
+  entries := auditLog(t, service, AuditQuery{Resource: ResourcePlayer, Action: AuditDelete, Limit: 10}).Entries
+  if len(entries) != 1 || entries[0].Actor != "importer" || entries[0].OnBehalfOf != "" {
+    t.Errorf("Expected the delete to be attributed to the importer key, got %+v", entries)
+  }
+  req := httptest.NewRequest("DELETE", "/players/2", nil)
+  req.Header.Set("Authorization", "Bearer "+writer.Key)
+  req.Header.Set(ActorHeader, "bootstrap")
+  router.ServeHTTP(httptest.NewRecorder(), req)
+  entries = auditLog(t, service, AuditQuery{Resource: ResourcePlayer, ResourceID: "2", Limit: 10}).Entries
+  if len(entries) != 1 || entries[0].Actor != "importer" || entries[0].OnBehalfOf != "bootstrap" {
+    t.Errorf("Expected the importer key acting on behalf of bootstrap, got %+v", entries)
+  }
+
+  req = httptest.NewRequest("GET", "/players", nil)
+  req.Header.Set("Authorization", "Basic "+reader)
+  w = httptest.NewRecorder()
+  router.ServeHTTP(w, req)
+  if w.Code != http.StatusUnauthorized {
+    t.Errorf("Expected only bearer credentials to be accepted, got %d", w.Code)
+  }
+}
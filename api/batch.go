@@ -0,0 +1,168 @@
+package main
+
+import (
+  "context"
+  "errors"
+  "fmt"
+)
+
+// MaxBatchOperations caps the number of operations in one batch request
+const MaxBatchOperations = 1000
+
+// Batch modes
+const (
+  BatchAtomic     = "atomic"
+  BatchBestEffort = "best_effort"
+)
+
+// Batch operation types
+const (
+  BatchCreate = "create"
+  BatchUpdate = "update"
+  BatchDelete = "delete"
+)
+
+// ErrBatchAborted marks operations that were not applied because another
+// operation in the same atomic batch failed
+var ErrBatchAborted = errors.New("not applied")
+
+// BatchRequest is the body of POST /players/batch
+type BatchRequest struct {
+  Mode       string           `json:"mode"`
+  Operations []BatchOperation `json:"operations"`
+}
+
+// BatchOperation is a single create, update or delete in a batch
+type BatchOperation struct {
+  Op      string         `json:"op"`
+  ID      string         `json:"id,omitempty"`
+  Version int64          `json:"version,omitempty"`
+  Player  *PlayerRequest `json:"player,omitempty"`
+}
+
+// BatchResult is the outcome of one batch operation. Err is nil on success.
+type BatchResult struct {
+  Op     string
+  ID     string
+  Player *Player
+  Err    error
+}
+
+// Validate checks the batch request shape before anything is applied
+func (br *BatchRequest) Validate() error {
+  switch br.Mode {
+  case "":
+    br.Mode = BatchAtomic
+  case BatchAtomic, BatchBestEffort:
+  default:
+    return fmt.Errorf("%w: mode must be %q or %q", ErrInvalidInput, BatchAtomic, BatchBestEffort)
+  }
+  if len(br.Operations) == 0 {
+    return fmt.Errorf("%w: operations are required", ErrInvalidInput)
+  }
+  if len(br.Operations) > MaxBatchOperations {
+    return fmt.Errorf("%w: at most %d operations per batch", ErrInvalidInput, MaxBatchOperations)
+  }
+  return nil
+}
+
+// apply runs a single operation inside a transaction
+func (op BatchOperation) apply(tx *playerTx) (Player, error) {
+  switch op.Op {
+  case BatchCreate:
+    if op.Player == nil {
+      return Player{}, fmt.Errorf("%w: create needs a player", ErrInvalidInput)
+    }
+    return tx.create(*op.Player)
+  case BatchUpdate:
+    if op.ID == "" || op.Player == nil {
+      return Player{}, fmt.Errorf("%w: update needs an id and a player", ErrInvalidInput)
+    }
+    return tx.update(op.ID, *op.Player, op.Version)
+  case BatchDelete:
+    if op.ID == "" {
+      return Player{}, fmt.Errorf("%w: delete needs an id", ErrInvalidInput)
+    }
+    return tx.delete(op.ID, op.Version)
+  }
+  return Player{}, fmt.Errorf("%w: unknown operation %q", ErrInvalidInput, op.Op)
+}
+
+// ApplyBatch runs a list of operations under a single write lock.
+//
+// In atomic mode all operations share one transaction: the first failure
+// rolls everything back, and the returned error wraps that failure. In
+// best-effort mode every operation is committed on its own and failures are
+// only reported in the per-operation results.
+func (s *PlayerService) ApplyBatch(ctx context.Context, ops []BatchOperation, atomic bool) ([]BatchResult, error) {
+  ctx, span := StartSpan(ctx, "PlayerService.ApplyBatch")
+  defer span.End()
+  span.SetAttribute("operations", len(ops))
+  span.SetAttribute("atomic", atomic)
+
+  s.mu.Lock()
+  defer s.mu.Unlock()
+
+  results := make([]BatchResult, len(ops))
+  for i, op := range ops {
+    results[i] = BatchResult{Op: op.Op, ID: op.ID}
+  }
+
+  if !atomic {
+    for i, op := range ops {
+      tx := s.begin(ctx)
+      player, err := op.apply(tx)
+      if err == nil {
+        err = tx.commit()
+      } else {
+        tx.rollback()
+      }
+      results[i].Err = err
+      if err == nil {
+        results[i].ID = player.ID
+        results[i].Player = &player
+      }
+    }
+    return results, nil
+  }
+
+  tx := s.begin(ctx)
+  players := make([]Player, len(ops))
+  for i, op := range ops {
+    player, err := op.apply(tx)
+    if err != nil {
+      tx.rollback()
+      for j := range results {
+        results[j].Err = fmt.Errorf("%w: operation %d failed", ErrBatchAborted, i)
+      }
+      results[i].Err = err
+      span.RecordError(err)
+      return results, fmt.Errorf("operation %d: %w", i, err)
+    }
+    players[i] = player
+  }
+
+  if err := tx.commit(); err != nil {
+    for i := range results {
+      results[i].Err = err
+    }
+    span.RecordError(err)
+    return results, err
+  }
+
+  for i := range results {
+    results[i].ID = players[i].ID
+    results[i].Player = &players[i]
+  }
+  return results, nil
+}
+
+// BatchItemResponse reports the outcome of one batch operation over HTTP
+type BatchItemResponse struct {
+  Index  int     `json:"index"`
+  Op     string  `json:"op"`
+  ID     string  `json:"id,omitempty"`
+  Status int     `json:"status"`
+  Player *Player `json:"player,omitempty"`
+  Error  string  `json:"error,omitempty"`
+}
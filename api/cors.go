@@ -0,0 +1,231 @@
+package main
+
+import (
+  "fmt"
+  "net/http"
+  "net/url"
+  "slices"
+  "strconv"
+  "strings"
+  "time"
+)
+
+// CORS defaults
+var (
+  DefaultCORSMethods = []string{"GET", "POST", "PUT", "PATCH", "DELETE"}
+  DefaultCORSHeaders = []string{
+    "Authorization", "Content-Type", "If-Match", "Last-Event-ID",
+    ActorHeader, RequestIDHeader, TraceparentHeader, TracestateHeader,
+  }
+  // DefaultCORSExposedHeaders are the response headers browsers let scripts read
+  DefaultCORSExposedHeaders = []string{
+    "ETag", "Accept-Patch", RequestIDHeader, TraceIDHeader, "Retry-After",
+    RateLimitLimitHeader, RateLimitRemainingHeader, RateLimitResetHeader,
+  }
+)
+
+// DefaultCORSMaxAge is how long browsers may cache a preflight response
+const DefaultCORSMaxAge = 10 * time.Minute
+
+// CORSConfig configures which browser origins may call the API
+type CORSConfig struct {
+  // AllowedOrigins lists origins like "https://app.example.com". A leading
+  // "*." in the host, as in "https://*.example.com", matches any subdomain,
+  // and "*" alone matches every origin.
+  AllowedOrigins   []string
+  AllowedMethods   []string
+  AllowedHeaders   []string
+  ExposedHeaders   []string
+  AllowCredentials bool
+  MaxAge           time.Duration
+}
+
+// originPattern is an allowed origin, split so subdomain wildcards can be
+// matched
+type originPattern struct {
+  scheme string
+  // host includes the port, if any; for wildcards it is the suffix after "*"
+  host     string
+  wildcard bool
+}
+
+// CORSPolicy answers preflight requests and adds CORS headers to responses
+// for allowed origins. Requests from other origins are served without CORS
+// headers, so browsers don't let pages read them.
+type CORSPolicy struct {
+  config    CORSConfig
+  anyOrigin bool
+  origins   []originPattern
+  methods   []string
+  headers   []string
+}
+
+// NewCORSPolicy validates a config, filling in the default methods, headers
+// and max age
+func NewCORSPolicy(config CORSConfig) (*CORSPolicy, error) {
+  if config.AllowedMethods == nil {
+    config.AllowedMethods = DefaultCORSMethods
+  }
+  if config.AllowedHeaders == nil {
+    config.AllowedHeaders = DefaultCORSHeaders
+  }
+  if config.ExposedHeaders == nil {
+    config.ExposedHeaders = DefaultCORSExposedHeaders
+  }
+  if config.MaxAge == 0 {
+    config.MaxAge = DefaultCORSMaxAge
+  }
+
+  p := &CORSPolicy{config: config}
+  for _, origin := range config.AllowedOrigins {
+    if origin == "*" {
+      p.anyOrigin = true
+      continue
+    }
+    pattern, err := parseOriginPattern(origin)
+    if err != nil {
+      return nil, err
+    }
+    p.origins = append(p.origins, pattern)
+  }
+  if p.anyOrigin && config.AllowCredentials {
+    // Browsers refuse credentials with "*", and echoing every origin back
+    // with credentials would let any site act as the user
+    return nil, fmt.Errorf("%w: credentials can't be allowed for every origin", ErrInvalidInput)
+  }
+  for _, method := range config.AllowedMethods {
+    p.methods = append(p.methods, strings.ToUpper(method))
+  }
+  for _, header := range config.AllowedHeaders {
+    p.headers = append(p.headers, http.CanonicalHeaderKey(header))
+  }
+  return p, nil
+}
+
+// parseOriginPattern parses an allowed origin such as "https://*.example.com"
+func parseOriginPattern(origin string) (originPattern, error) {
+  u, err := url.Parse(strings.ToLower(origin))
+  if err != nil || (u.Scheme != "http" && u.Scheme != "https") || u.Host == "" || (u.Path != "" && u.Path != "/") {
+    return originPattern{}, fmt.Errorf("%w: allowed origin %q must look like https://host[:port]", ErrInvalidInput, origin)
+  }
+  pattern := originPattern{scheme: u.Scheme, host: u.Host}
+  if suffix, found := strings.CutPrefix(u.Host, "*."); found {
+    pattern.host, pattern.wildcard = "."+suffix, true
+  }
+  if strings.Contains(pattern.host, "*") {
+    return originPattern{}, fmt.Errorf("%w: allowed origin %q may only start with a wildcard", ErrInvalidInput, origin)
+  }
+  return pattern, nil
+}
+
+// allowsOrigin reports whether a request's Origin header is allowed
+func (p *CORSPolicy) allowsOrigin(origin string) bool {
+  if origin == "" {
+    return false
+  }
+  if p.anyOrigin {
+    return true
+  }
+  u, err := url.Parse(strings.ToLower(origin))
+  if err != nil || u.Host == "" || u.Path != "" {
+    return false
+  }
+  for _, pattern := range p.origins {
+    if u.Scheme != pattern.scheme {
+      continue
+    }
+    if pattern.wildcard && strings.HasSuffix(u.Host, pattern.host) && len(u.Host) > len(pattern.host) {
+      return true
+    }
+    if !pattern.wildcard && u.Host == pattern.host {
+      return true
+    }
+  }
+  return false
+}
+
+// Middleware applies the policy to every request
+func (p *CORSPolicy) Middleware(next http.Handler) http.Handler {
+  return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+    origin := r.Header.Get("Origin")
+    // Unless every origin gets the same answer, caches must keep responses
+    // for different origins apart
+    if !p.anyOrigin {
+      w.Header().Add("Vary", "Origin")
+    }
+
+    if r.Method == http.MethodOptions && r.Header.Get("Access-Control-Request-Method") != "" {
+      p.preflight(w, r, origin)
+      return
+    }
+
+    if p.allowsOrigin(origin) {
+      p.allowOrigin(w, origin)
+      if len(p.config.ExposedHeaders) > 0 {
+        w.Header().Set("Access-Control-Expose-Headers", strings.Join(p.config.ExposedHeaders, ", "))
+      }
+    }
+    next.ServeHTTP(w, r)
+  })
+}
+
+// preflight answers a CORS preflight request, rejecting origins, methods and
+// headers that aren't allowed
+func (p *CORSPolicy) preflight(w http.ResponseWriter, r *http.Request, origin string) {
+  w.Header().Add("Vary", "Access-Control-Request-Method")
+  w.Header().Add("Vary", "Access-Control-Request-Headers")
+
+  if !p.allowsOrigin(origin) {
+    writeErrorResponse(w, http.StatusForbidden, "CORS preflight rejected", fmt.Errorf("%w: origin %q is not allowed", ErrForbidden, origin))
+    return
+  }
+  method := r.Header.Get("Access-Control-Request-Method")
+  if !slices.Contains(p.methods, method) {
+    writeErrorResponse(w, http.StatusForbidden, "CORS preflight rejected", fmt.Errorf("%w: method %s is not allowed", ErrForbidden, method))
+    return
+  }
+  var requested []string
+  for _, header := range strings.Split(r.Header.Get("Access-Control-Request-Headers"), ",") {
+    header = http.CanonicalHeaderKey(strings.TrimSpace(header))
+    if header == "" {
+      continue
+    }
+    if !slices.Contains(p.headers, header) {
+      writeErrorResponse(w, http.StatusForbidden, "CORS preflight rejected", fmt.Errorf("%w: header %s is not allowed", ErrForbidden, header))
+      return
+    }
+    requested = append(requested, header)
+  }
+
+  p.allowOrigin(w, origin)
+  w.Header().Set("Access-Control-Allow-Methods", strings.Join(p.methods, ", "))
+  if len(requested) > 0 {
+    w.Header().Set("Access-Control-Allow-Headers", strings.Join(requested, ", "))
+  }
+  w.Header().Set("Access-Control-Max-Age", strconv.Itoa(int(p.config.MaxAge.Seconds())))
+  w.WriteHeader(http.StatusNoContent)
+}
+
+// allowOrigin sets the headers that let the origin read the response
+func (p *CORSPolicy) allowOrigin(w http.ResponseWriter, origin string) {
+  if p.anyOrigin {
+    w.Header().Set("Access-Control-Allow-Origin", "*")
+    return
+  }
+  w.Header().Set("Access-Control-Allow-Origin", origin)
+  if p.config.AllowCredentials {
+    w.Header().Set("Access-Control-Allow-Credentials", "true")
+  }
+}
+
+// ParseCORSList splits a comma-separated setting, returning nil when it is
+// empty so the default applies
+func ParseCORSList(raw string) []string {
+  var values []string
+  for value := range strings.SplitSeq(raw, ",") {
+    if value = strings.TrimSpace(value); value != "" {
+      values = append(values, value)
+    }
+  }
+  return values
+}
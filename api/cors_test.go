@@ -0,0 +1,174 @@
+package main
+
+import (
+  "net/http"
+  "net/http/httptest"
+  "strings"
+  "testing"
+)
+
+// corsRequest sends a request through the policy to a handler that always
+// succeeds, returning the response
+func corsRequest(t *testing.T, policy *CORSPolicy, method, origin string, headers map[string]string) *httptest.ResponseRecorder {
+  t.Helper()
+  next := http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+    w.WriteHeader(http.StatusOK)
+  })
+  req := httptest.NewRequest(method, "/players", nil)
+  if origin != "" {
+    req.Header.Set("Origin", origin)
+  }
+  for k, v := range headers {
+    req.Header.Set(k, v)
+  }
+  w := httptest.NewRecorder()
+  policy.Middleware(next).ServeHTTP(w, req)
+  return w
+}
+
+func TestCORSPolicy_Origins(t *testing.T) {
+  policy, err := NewCORSPolicy(CORSConfig{
+    AllowedOrigins:   []string{"https://app.example.com", "https://*.example.org", "http://localhost:3000"},
+    AllowCredentials: true,
+  })
+  if err != nil {
+    t.Fatalf("Failed to create policy: %v", err)
+  }
+
+  tests := []struct {
+    origin  string
+    allowed bool
+  }{
+    {"https://app.example.com", true},
+    {"https://APP.example.com", true},
+    {"https://a.b.example.org", true},
+    {"http://localhost:3000", true},
+    {"https://example.org", false},
+    {"https://evilexample.org", false},
+    {"http://app.example.com", false},
+    {"https://app.example.com.evil.com", false},
+    {"http://localhost:3001", false},
+    {"null", false},
+    {"", false},
+  }
+  for _, tt := range tests {
+    w := corsRequest(t, policy, "GET", tt.origin, nil)
+    if w.Code != http.StatusOK {
+      t.Errorf("%q: expected the request to be served, got %d", tt.origin, w.Code)
+    }
+    got := w.Header().Get("Access-Control-Allow-Origin")
+    if tt.allowed && (got != tt.origin || w.Header().Get("Access-Control-Allow-Credentials") != "true") {
+      t.Errorf("%q: expected the origin to be allowed with credentials, got %v", tt.origin, w.Header())
+    }
+    if !tt.allowed && got != "" {
+      t.Errorf("%q: expected no CORS headers, got %v", tt.origin, w.Header())
+    }
+    if w.Header().Get("Vary") != "Origin" {
+      t.Errorf("%q: expected Vary: Origin, got %v", tt.origin, w.Header())
+    }
+  }
+
+  w := corsRequest(t, policy, "GET", "https://app.example.com", nil)
+  if exposed := w.Header().Get("Access-Control-Expose-Headers"); !strings.Contains(exposed, RequestIDHeader) || !strings.Contains(exposed, TraceIDHeader) {
+    t.Errorf("Expected the request and trace IDs to be exposed, got %v", w.Header())
+  }
+}
+
+func TestCORSPolicy_Preflight(t *testing.T) {
+  policy, err := NewCORSPolicy(CORSConfig{
+    AllowedOrigins: []string{"https://app.example.com"},
+    AllowedMethods: []string{"get", "POST"},
+  })
+  if err != nil {
+    t.Fatalf("Failed to create policy: %v", err)
+  }
+  preflight := func(origin, method, headers string) *httptest.ResponseRecorder {
+    h := map[string]string{"Access-Control-Request-Method": method}
+    if headers != "" {
+      h["Access-Control-Request-Headers"] = headers
+    }
+    return corsRequest(t, policy, "OPTIONS", origin, h)
+  }
+
+  w := preflight("https://app.example.com", "POST", "content-type, x-actor")
+  if w.Code != http.StatusNoContent {
+    t.Fatalf("Expected status 204, got %d", w.Code)
+  }
+  want := map[string]string{
+    "Access-Control-Allow-Origin":  "https://app.example.com",
+    "Access-Control-Allow-Methods": "GET, POST",
+    "Access-Control-Allow-Headers": "Content-Type, X-Actor",
+    "Access-Control-Max-Age":       "600",
+  }
+  for header, value := range want {
+    if got := w.Header().Get(header); got != value {
+      t.Errorf("Expected %s %q, got %q", header, value, got)
+    }
+  }
+  if w.Header().Get("Access-Control-Allow-Credentials") != "" {
+    t.Errorf("Expected credentials not to be allowed by default")
+  }
+
+  // Callers may continue their own trace
+  if w := preflight("https://app.example.com", "GET", "traceparent, tracestate"); w.Code != http.StatusNoContent {
+    t.Errorf("Expected trace context headers to be allowed, got %d", w.Code)
+  }
+
+  rejected := []struct {
+    name                    string
+    origin, method, headers string
+  }{
+    {"origin", "https://evil.example.com", "GET", ""},
+    {"method", "https://app.example.com", "DELETE", ""},
+    {"header", "https://app.example.com", "POST", "Content-Type, X-Debug"},
+  }
+  for _, tt := range rejected {
+    w := preflight(tt.origin, tt.method, tt.headers)
+    if w.Code != http.StatusForbidden || w.Header().Get("Access-Control-Allow-Origin") != "" {
+      t.Errorf("%s: expected the preflight to be rejected, got %d %v", tt.name, w.Code, w.Header())
+    }
+  }
+
+  // OPTIONS without Access-Control-Request-Method isn't a preflight
+  if w := corsRequest(t, policy, "OPTIONS", "https://app.example.com", nil); w.Code != http.StatusOK {
+    t.Errorf("Expected a plain OPTIONS request to reach the handler, got %d", w.Code)
+  }
+}
+
+func TestCORSPolicy_AnyOrigin(t *testing.T) {
+  policy, err := NewCORSPolicy(CORSConfig{AllowedOrigins: []string{"*"}})
+  if err != nil {
+    t.Fatalf("Failed to create policy: %v", err)
+  }
+  w := corsRequest(t, policy, "GET", "https://anywhere.example", nil)
+  if w.Header().Get("Access-Control-Allow-Origin") != "*" || w.Header().Get("Vary") != "" {
+    t.Errorf("Expected a wildcard origin without Vary, got %v", w.Header())
+  }
+
+  // Without any allowed origins, browsers get no CORS headers at all
+  policy, _ = NewCORSPolicy(CORSConfig{})
+  if w := corsRequest(t, policy, "GET", "https://anywhere.example", nil); w.Header().Get("Access-Control-Allow-Origin") != "" {
+    t.Errorf("Expected cross-origin requests not to be allowed, got %v", w.Header())
+  }
+
+  for _, config := range []CORSConfig{
+    {AllowedOrigins: []string{"*"}, AllowCredentials: true},
+    {AllowedOrigins: []string{"app.example.com"}},
+    {AllowedOrigins: []string{"ftp://app.example.com"}},
+    {AllowedOrigins: []string{"https://app.example.com/path"}},
+    {AllowedOrigins: []string{"https://app.*.example.com"}},
+  } {
+    if _, err := NewCORSPolicy(config); err == nil {
+      t.Errorf("Expected %+v to be rejected", config)
+    }
+  }
+}
+
+func TestParseCORSList(t *testing.T) {
+  if got := ParseCORSList(" https://a.example , ,https://b.example"); len(got) != 2 || got[0] != "https://a.example" || got[1] != "https://b.example" {
+    t.Errorf("Unexpected list %q", got)
+  }
+  if got := ParseCORSList(""); got != nil {
+    t.Errorf("Expected nil for an empty setting, got %q", got)
+  }
+}
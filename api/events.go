@@ -0,0 +1,200 @@
+package main
+
+import (
+  "encoding/json"
+  "sync"
+  "time"
+)
+
+// Player change event types
+const (
+  ChangeCreated = "created"
+  ChangeUpdated = "updated"
+  ChangeDeleted = "deleted"
+  // ChangeReset tells a resuming client that events were missed and it
+  // should fetch the players again
+  ChangeReset = "reset"
+)
+
+// Event stream defaults
+const (
+  DefaultReplaySize        = 1000
+  DefaultSubscriberBuffer  = 64
+  DefaultHeartbeatInterval = 15 * time.Second
+)
+
+// NoReplay subscribes to new events only
+const NoReplay int64 = -1
+
+// ChangeEvent is a change to a player, as pushed to event stream clients.
+// Player holds the player after the change, or before it for deletes.
+// OnBehalfOf is who the authenticated actor said it was acting for.
+type ChangeEvent struct {
+  ID         int64           `json:"id"`
+  Type       string          `json:"type"`
+  At         time.Time       `json:"at"`
+  Actor      string          `json:"actor,omitempty"`
+  OnBehalfOf string          `json:"on_behalf_of,omitempty"`
+  PlayerID   string          `json:"player_id,omitempty"`
+  Player     json.RawMessage `json:"player,omitempty"`
+  // Trace is the span that made the change, so webhook deliveries continue
+  // its trace
+  Trace SpanContext `json:"-"`
+}
+
+// EventBroker fans player change events out to subscribers and keeps the
+// most recent ones so clients can resume after a reconnect. Subscribers that
+// fall too far behind are dropped; they can resume with the last event they
+// saw.
+type EventBroker struct {
+  mu          sync.Mutex
+  ring        []ChangeEvent
+  next        int
+  count       int
+  lastID      int64
+  bufferSize  int
+  subscribers map[chan ChangeEvent]struct{}
+  hooks       []func(ChangeEvent)
+  closed      bool
+}
+
+// NewEventBroker creates a broker that keeps the last replaySize events
+func NewEventBroker(replaySize int) *EventBroker {
+  return &EventBroker{
+    ring:        make([]ChangeEvent, replaySize),
+    bufferSize:  DefaultSubscriberBuffer,
+    subscribers: make(map[chan ChangeEvent]struct{}),
+  }
+}
+
+// Subscribe registers a subscriber that receives every event published from
+// now on, and returns the events after lastID for replay. New clients pass
+// NoReplay. When some of those events are no longer buffered, the replay is
+// a single ChangeReset event carrying the latest ID instead. The channel is
+// closed when the subscriber is dropped or the broker closes.
+func (b *EventBroker) Subscribe(lastID int64) (events chan ChangeEvent, replay []ChangeEvent) {
+  b.mu.Lock()
+  defer b.mu.Unlock()
+
+  events = make(chan ChangeEvent, b.bufferSize)
+  if b.closed {
+    close(events)
+    return events, nil
+  }
+  b.subscribers[events] = struct{}{}
+
+  if lastID == NoReplay {
+    return events, nil
+  }
+  // IDs are consecutive, so the buffer holds oldest..b.lastID
+  oldest := b.lastID - int64(b.count) + 1
+  if lastID > b.lastID || lastID < oldest-1 {
+    return events, []ChangeEvent{{ID: b.lastID, Type: ChangeReset, At: time.Now().UTC()}}
+  }
+  for id := lastID + 1; id <= b.lastID; id++ {
+    replay = append(replay, b.ring[(b.next-int(b.lastID-id)-1+len(b.ring))%len(b.ring)])
+  }
+  return events, replay
+}
+
+// Unsubscribe removes a subscriber and closes its channel
+func (b *EventBroker) Unsubscribe(events chan ChangeEvent) {
+  b.mu.Lock()
+  defer b.mu.Unlock()
+
+  if _, ok := b.subscribers[events]; ok {
+    delete(b.subscribers, events)
+    close(events)
+  }
+}
+
+// Subscribers returns the number of connected subscribers
+func (b *EventBroker) Subscribers() int {
+  b.mu.Lock()
+  defer b.mu.Unlock()
+
+  return len(b.subscribers)
+}
+
+// OnPublish registers fn to be called with every event as it is published,
+// in order. fn runs while the broker is locked, so it must not block.
+func (b *EventBroker) OnPublish(fn func(ChangeEvent)) {
+  b.mu.Lock()
+  defer b.mu.Unlock()
+
+  b.hooks = append(b.hooks, fn)
+}
+
+// Publish numbers the events, buffers them for replay and sends them to
+// every subscriber. It never blocks on a slow subscriber.
+func (b *EventBroker) Publish(events []ChangeEvent) {
+  b.mu.Lock()
+  defer b.mu.Unlock()
+
+  for _, event := range events {
+    b.lastID++
+    event.ID = b.lastID
+    if len(b.ring) > 0 {
+      b.ring[b.next] = event
+      b.next = (b.next + 1) % len(b.ring)
+      b.count = min(b.count+1, len(b.ring))
+    }
+    for _, hook := range b.hooks {
+      hook(event)
+    }
+
+    for subscriber := range b.subscribers {
+      select {
+      case subscriber <- event:
+      default:
+        delete(b.subscribers, subscriber)
+        close(subscriber)
+      }
+    }
+  }
+}
+
+// Close disconnects every subscriber, and later subscriptions are closed
+// straight away. Events published afterwards, by requests still finishing
+// during shutdown, are numbered and passed to the OnPublish hooks as before.
+func (b *EventBroker) Close() {
+  b.mu.Lock()
+  defer b.mu.Unlock()
+
+  b.closed = true
+  for subscriber := range b.subscribers {
+    delete(b.subscribers, subscriber)
+    close(subscriber)
+  }
+}
+
+// changeEvents turns the player entries of a committed transaction's audit
+// trail into change events. A restore is a create as far as readers are
+// concerned, and purged players were already gone, so purges are skipped.
+// The events carry trace, the span the transaction ran in.
+func changeEvents(entries []AuditEntry, trace SpanContext) []ChangeEvent {
+  var events []ChangeEvent
+  for _, entry := range entries {
+    if entry.Resource != ResourcePlayer {
+      continue
+    }
+    event := ChangeEvent{At: entry.At, Actor: entry.Actor, OnBehalfOf: entry.OnBehalfOf, PlayerID: entry.ResourceID, Player: entry.After, Trace: trace}
+    switch entry.Action {
+    case AuditCreate, AuditRestore:
+      event.Type = ChangeCreated
+    case AuditUpdate:
+      event.Type = ChangeUpdated
+    case AuditDelete:
+      event.Type, event.Player = ChangeDeleted, entry.Before
+    default:
+      continue
+    }
+    events = append(events, event)
+  }
+  return events
+}
+
+// Events returns the broker that streams the service's player changes
+func (s *PlayerService) Events() *EventBroker {
+  return s.events
+}
@@ -0,0 +1,245 @@
+package main
+
+import (
+  "bufio"
+  "context"
+  "encoding/json"
+  "net/http"
+  "net/http/httptest"
+  "strings"
+  "testing"
+  "time"
+)
+
+// receive reads the next event from a subscription, failing the test if none arrives
+func receive(t *testing.T, events chan ChangeEvent) ChangeEvent {
+  t.Helper()
+  select {
+  case event, ok := <-events:
+    if !ok {
+      t.Fatalf("Subscription closed while waiting for an event")
+    }
+    return event
+  case <-time.After(time.Second):
+    t.Fatalf("Timed out waiting for an event")
+  }
+  return ChangeEvent{}
+}
+
+func TestEventBroker_Replay(t *testing.T) {
+  broker := NewEventBroker(3)
+  for range 5 {
+    broker.Publish([]ChangeEvent{{Type: ChangeUpdated, PlayerID: "1"}})
+  }
+
+  tests := []struct {
+    name   string
+    lastID int64
+    want   []int64
+    reset  bool
+  }{
+    {"new client", NoReplay, nil, false},
+    {"buffered", 3, []int64{4, 5}, false},
+    {"oldest buffered", 2, []int64{3, 4, 5}, false},
+    {"up to date", 5, nil, false},
+    {"dropped from buffer", 1, []int64{5}, true},
+    {"from another run", 9, []int64{5}, true},
+  }
+  for _, tt := range tests {
+    t.Run(tt.name, func(t *testing.T) {
+      events, replay := broker.Subscribe(tt.lastID)
+      defer broker.Unsubscribe(events)
+
+      if len(replay) != len(tt.want) {
+        t.Fatalf("Expected %v, got %+v", tt.want, replay)
+      }
+      for i, event := range replay {
+        if event.ID != tt.want[i] || (event.Type == ChangeReset) != tt.reset {
+          t.Errorf("Expected %v (reset %v), got %+v", tt.want, tt.reset, replay)
+        }
+      }
+    })
+  }
+}
+
+func TestEventBroker_DropsSlowSubscribers(t *testing.T) {
+  broker := NewEventBroker(10)
+  broker.bufferSize = 1
+  events, _ := broker.Subscribe(NoReplay)
+
+  broker.Publish([]ChangeEvent{{Type: ChangeCreated}, {Type: ChangeDeleted}})
+  if event := receive(t, events); event.ID != 1 {
+    t.Errorf("Expected event 1, got %+v", event)
+  }
+  if _, ok := <-events; ok {
+    t.Errorf("Expected the subscription to be closed")
+  }
+  if n := broker.Subscribers(); n != 0 {
+    t.Errorf("Expected no subscribers, got %d", n)
+  }
+
+  // The dropped client resumes where it left off
+  events, replay := broker.Subscribe(1)
+  if len(replay) != 1 || replay[0].Type != ChangeDeleted {
+    t.Errorf("Expected the missed delete, got %+v", replay)
+  }
+  broker.Close()
+  if _, ok := <-events; ok {
+    t.Errorf("Expected Close to end the subscription")
+  }
+
+  // Writes finishing after Close still reach the hooks, e.g. webhooks
+  var hooked []ChangeEvent
+  broker.OnPublish(func(event ChangeEvent) { hooked = append(hooked, event) })
+  broker.Publish([]ChangeEvent{{Type: ChangeUpdated}})
+  if len(hooked) != 1 || hooked[0].ID != 3 {
+    t.Errorf("Expected event 3 to be published after Close, got %+v", hooked)
+  }
+  if events, _ := broker.Subscribe(NoReplay); len(events) != 0 {
+    t.Errorf("Expected no events for a subscription after Close")
+  } else if _, ok := <-events; ok {
+    t.Errorf("Expected a subscription after Close to be closed")
+  }
+}
+
+func TestPlayerService_PublishesChanges(t *testing.T) {
+  service := NewPlayerService()
+  events, _ := service.Events().Subscribe(NoReplay)
+  ctx := WithActor(t.Context(), "alice")
+
+  player, err := service.CreatePlayer(ctx, PlayerRequest{Name: "Pedri", JerseyNumber: 8, Rating: 86})
+  if err != nil {
+    t.Fatalf("Failed to create player: %v", err)
+  }
+  // Teams and failed writes publish nothing
+  if _, err := service.CreateTeam(ctx, TeamRequest{Name: "Barcelona"}); err != nil {
+    t.Fatalf("Failed to create team: %v", err)
+  }
+  if _, err := service.CreatePlayer(ctx, PlayerRequest{Name: "Pedri", JerseyNumber: 8, Rating: 86}); err == nil {
+    t.Fatalf("Expected a duplicate player to be rejected")
+  }
+  setRating(t, service, "alice", player.ID, 88)
+  if _, err := service.DeletePlayer(ctx, player.ID, AnyVersion); err != nil {
+    t.Fatalf("Failed to delete player: %v", err)
+  }
+  if _, err := service.RestorePlayer(ctx, player.ID); err != nil {
+    t.Fatalf("Failed to restore player: %v", err)
+  }
+
+  for i, want := range []string{ChangeCreated, ChangeUpdated, ChangeDeleted, ChangeCreated} {
+    event := receive(t, events)
+    var got Player
+    json.Unmarshal(event.Player, &got)
+    if event.ID != int64(i+1) || event.Type != want || event.Actor != "alice" || got.ID != player.ID {
+      t.Errorf("Expected event %d to be %s, got %+v", i+1, want, event)
+    }
+    if want == ChangeUpdated && got.Rating != 88 {
+      t.Errorf("Expected the updated player, got %+v", got)
+    }
+  }
+  select {
+  case event := <-events:
+    t.Errorf("Expected no more events, got %+v", event)
+  default:
+  }
+}
+
+func TestPlayerHandler_StreamEvents(t *testing.T) {
+  service := NewPlayerService()
+  handler := NewPlayerHandler(service)
+  handler.heartbeat = 10 * time.Millisecond
+  server := httptest.NewServer(http.HandlerFunc(handler.StreamEvents))
+  defer server.Close()
+
+  setRating(t, service, "alice", "1", 97)
+
+  // Resume after event 0, so the update above is replayed
+  req, _ := http.NewRequest("GET", server.URL, nil)
+  req.Header.Set("Last-Event-ID", "0")
+  resp, err := http.DefaultClient.Do(req)
+  if err != nil {
+    t.Fatalf("Failed to connect: %v", err)
+  }
+  defer resp.Body.Close()
+  if ct := resp.Header.Get("Content-Type"); ct != "text/event-stream" {
+    t.Fatalf("Expected text/event-stream, got %q", ct)
+  }
+
+  lines := make(chan string)
+  go func() {
+    defer close(lines)
+    scanner := bufio.NewScanner(resp.Body)
+    for scanner.Scan() {
+      lines <- scanner.Text()
+    }
+  }()
+  next := func() string {
+    t.Helper()
+    select {
+    case line := <-lines:
+      return line
+    case <-time.After(time.Second):
+      t.Fatalf("Timed out reading the stream")
+    }
+    return ""
+  }
+  // nextEvent skips heartbeats and returns the id, event and data lines
+  nextEvent := func() []string {
+    t.Helper()
+    var event []string
+    for {
+      line := next()
+      switch {
+      case line == "" && len(event) > 0:
+        return event
+      case line != "" && !strings.HasPrefix(line, ":"):
+        event = append(event, line)
+      }
+    }
+  }
+
+  if event := nextEvent(); event[0] != "id: 1" || event[1] != "event: updated" || !strings.Contains(event[2], `"rating":97`) {
+    t.Errorf("Expected the replayed update, got %q", event)
+  }
+  setRating(t, service, "alice", "2", 90)
+  if event := nextEvent(); event[0] != "id: 2" || !strings.HasPrefix(event[2], `data: {"id":2,"type":"updated"`) {
+    t.Errorf("Expected the live update, got %q", event)
+  }
+
+  sawHeartbeat := false
+  for !sawHeartbeat {
+    sawHeartbeat = next() == ": heartbeat"
+  }
+
+  // Shutting down ends the stream
+  service.Events().Close()
+  for range lines {
+  }
+}
+
+func TestPlayerHandler_StreamEventsCleanup(t *testing.T) {
+  service := NewPlayerService()
+  handler := NewPlayerHandler(service)
+
+  w := httptest.NewRecorder()
+  handler.StreamEvents(w, httptest.NewRequest("GET", "/players/events?last_event_id=abc", nil))
+  if w.Code != http.StatusBadRequest {
+    t.Errorf("Expected status 400, got %d", w.Code)
+  }
+
+  // A client going away unsubscribes it
+  ctx, cancel := context.WithCancel(t.Context())
+  done := make(chan struct{})
+  go func() {
+    defer close(done)
+    handler.StreamEvents(httptest.NewRecorder(), httptest.NewRequest("GET", "/players/events", nil).WithContext(ctx))
+  }()
+  for service.Events().Subscribers() == 0 {
+    time.Sleep(time.Millisecond)
+  }
+  cancel()
+  <-done
+  if n := service.Events().Subscribers(); n != 0 {
+    t.Errorf("Expected no subscribers after disconnect, got %d", n)
+  }
+}
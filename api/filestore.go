@@ -0,0 +1,355 @@
+package main
+
+import (
+  "bufio"
+  "bytes"
+  "cmp"
+  "encoding/json"
+  "errors"
+  "fmt"
+  "hash/crc32"
+  "io"
+  "log/slog"
+  "os"
+  "path/filepath"
+  "slices"
+)
+
+const (
+  snapshotFileName = "snapshot.json"
+  logFileName      = "players.log"
+
+  // DefaultSnapshotEvery is how many log records are written between snapshots
+  DefaultSnapshotEvery = 1000
+)
+
+// FileStoreOptions configures a FileStore
+type FileStoreOptions struct {
+  // SnapshotEvery is the number of log records after which the log is
+  // compacted into a new snapshot. Zero means DefaultSnapshotEvery.
+  SnapshotEvery int
+  // NoSync skips fsync after every write. Only useful for tests and benchmarks.
+  NoSync bool
+}
+
+// snapshot is the on-disk snapshot format
+type snapshot struct {
+  IDCounter      int             `json:"id_counter"`
+  Players        []Player        `json:"players"`
+  Trash          []DeletedPlayer `json:"trash,omitempty"`
+  TeamIDCounter  int             `json:"team_id_counter,omitempty"`
+  Teams          []Team          `json:"teams,omitempty"`
+  MatchIDCounter int             `json:"match_id_counter,omitempty"`
+  Matches        []Match         `json:"matches,omitempty"`
+
+  // History holds every player's rating changes in Seq order. RatingSeq is
+  // kept as well, since the latest changes may belong to purged players.
+  History   []RatingChange `json:"history,omitempty"`
+  RatingSeq int64          `json:"rating_seq,omitempty"`
+}
+
+// FileStore is a durable PlayerStore. Every batch is appended to a log file
+// before it is applied in memory, and the log is periodically compacted into
+// a snapshot. Each log line carries a CRC32 checksum so that a record torn by
+// a crash is detected and discarded on the next open.
+type FileStore struct {
+  dir     string
+  opts    FileStoreOptions
+  file    *os.File
+  size    int64
+  records int
+  storeData
+}
+
+// OpenFileStore opens (or creates) a file store in dir and recovers its state
+func OpenFileStore(dir string, opts FileStoreOptions) (*FileStore, error) {
+  if opts.SnapshotEvery <= 0 {
+    opts.SnapshotEvery = DefaultSnapshotEvery
+  }
+  if err := os.MkdirAll(dir, 0o755); err != nil {
+    return nil, fmt.Errorf("failed to create data directory: %w", err)
+  }
+
+  store := &FileStore{
+    dir:       dir,
+    opts:      opts,
+    storeData: newStoreData(),
+  }
+
+  if err := store.loadSnapshot(); err != nil {
+    return nil, err
+  }
+  if err := store.replayLog(); err != nil {
+    return nil, err
+  }
+  return store, nil
+}
+
+// loadSnapshot reads the latest snapshot, if there is one
+func (s *FileStore) loadSnapshot() error {
+  raw, err := os.ReadFile(filepath.Join(s.dir, snapshotFileName))
+  if errors.Is(err, os.ErrNotExist) {
+    return nil
+  }
+  if err != nil {
+    return fmt.Errorf("failed to read snapshot: %w", err)
+  }
+
+  var snap snapshot
+  if err := json.Unmarshal(raw, &snap); err != nil {
+    return fmt.Errorf("failed to decode snapshot: %w", err)
+  }
+  s.apply(StoreBatch{
+    Put:            snap.Players,
+    Trash:          snap.Trash,
+    IDCounter:      snap.IDCounter,
+    PutTeams:       snap.Teams,
+    TeamIDCounter:  snap.TeamIDCounter,
+    PutMatches:     snap.Matches,
+    MatchIDCounter: snap.MatchIDCounter,
+    RatingChanges:  snap.History,
+  })
+  s.ratingSeq = max(s.ratingSeq, snap.RatingSeq)
+  return nil
+}
+
+// replayLog applies every intact log record on top of the snapshot and
+// truncates the log after the last intact record
+func (s *FileStore) replayLog() error {
+  file, err := os.OpenFile(filepath.Join(s.dir, logFileName), os.O_RDWR|os.O_CREATE, 0o644)
+  if err != nil {
+    return fmt.Errorf("failed to open log: %w", err)
+  }
+
+  reader := bufio.NewReader(file)
+  var offset int64
+  for {
+    line, err := reader.ReadBytes('\n')
+    if errors.Is(err, io.EOF) {
+      if len(line) > 0 {
+        slog.Warn("Discarding incomplete log record", "bytes", len(line))
+      }
+      break
+    }
+    if err != nil {
+      file.Close()
+      return fmt.Errorf("failed to read log: %w", err)
+    }
+
+    batch, err := decodeLogRecord(line)
+    if err != nil {
+      slog.Warn("Discarding corrupt log", "offset", offset, LogKeyError, err)
+      break
+    }
+    s.apply(batch)
+    offset += int64(len(line))
+    s.records++
+  }
+
+  // Cut off whatever followed the last intact record so new records are
+  // appended to a clean log
+  if err := file.Truncate(offset); err != nil {
+    file.Close()
+    return fmt.Errorf("failed to truncate log: %w", err)
+  }
+  if _, err := file.Seek(offset, io.SeekStart); err != nil {
+    file.Close()
+    return fmt.Errorf("failed to seek log: %w", err)
+  }
+
+  s.file = file
+  s.size = offset
+  return nil
+}
+
+// encodeLogRecord formats a batch as "<crc32> <json>\n"
+func encodeLogRecord(batch StoreBatch) ([]byte, error) {
+  return encodeRecord(batch)
+}
+
+// decodeLogRecord parses and verifies a single log line
+func decodeLogRecord(line []byte) (StoreBatch, error) {
+  var batch StoreBatch
+  err := decodeRecord(line, &batch)
+  return batch, err
+}
+
+// encodeRecord formats v as a checksummed "<crc32> <json>\n" line
+func encodeRecord(v any) ([]byte, error) {
+  payload, err := json.Marshal(v)
+  if err != nil {
+    return nil, err
+  }
+  return fmt.Appendf(nil, "%08x %s\n", crc32.ChecksumIEEE(payload), payload), nil
+}
+
+// decodeRecord verifies a line written by encodeRecord and decodes it into v
+func decodeRecord(line []byte, v any) error {
+  line = bytes.TrimSuffix(line, []byte("\n"))
+  checksum, payload, found := bytes.Cut(line, []byte(" "))
+  if !found {
+    return errors.New("malformed log record")
+  }
+
+  var want uint32
+  if _, err := fmt.Sscanf(string(checksum), "%08x", &want); err != nil {
+    return fmt.Errorf("malformed log checksum: %w", err)
+  }
+  if got := crc32.ChecksumIEEE(payload); got != want {
+    return fmt.Errorf("log checksum mismatch: got %08x, want %08x", got, want)
+  }
+  if err := json.Unmarshal(payload, v); err != nil {
+    return fmt.Errorf("malformed log payload: %w", err)
+  }
+  return nil
+}
+
+// Apply appends the batch to the log and then applies it in memory.
+// The in-memory state is only changed once the record is safely on disk.
+func (s *FileStore) Apply(batch StoreBatch) error {
+  record, err := encodeLogRecord(batch)
+  if err != nil {
+    return fmt.Errorf("failed to encode log record: %w", err)
+  }
+
+  if _, err := s.file.Write(record); err != nil {
+    s.rollbackWrite()
+    return fmt.Errorf("failed to write log: %w", err)
+  }
+  if !s.opts.NoSync {
+    if err := s.file.Sync(); err != nil {
+      s.rollbackWrite()
+      return fmt.Errorf("failed to sync log: %w", err)
+    }
+  }
+
+  s.size += int64(len(record))
+  s.records++
+  s.apply(batch)
+
+  if s.records >= s.opts.SnapshotEvery {
+    if err := s.Snapshot(); err != nil {
+      // The batch itself is durable in the log, so this isn't fatal
+      slog.Error("Error writing snapshot", LogKeyError, err)
+    }
+  }
+  return nil
+}
+
+// rollbackWrite removes a partially written record from the end of the log
+func (s *FileStore) rollbackWrite() {
+  if err := s.file.Truncate(s.size); err != nil {
+    slog.Error("Error truncating log after failed write", LogKeyError, err)
+  }
+  if _, err := s.file.Seek(s.size, io.SeekStart); err != nil {
+    slog.Error("Error seeking log after failed write", LogKeyError, err)
+  }
+}
+
+// Snapshot writes the current state to a new snapshot and empties the log.
+// The snapshot is written to a temporary file and renamed into place, so a
+// crash leaves either the old or the new snapshot. If the process dies
+// between the rename and the log truncation, replaying the old log on top of
+// the new snapshot is harmless because log records are idempotent.
+func (s *FileStore) Snapshot() error {
+  snap := snapshot{
+    IDCounter:      s.idCounter,
+    Players:        make([]Player, 0, len(s.players)),
+    TeamIDCounter:  s.teamIDCounter,
+    Teams:          make([]Team, 0, len(s.teams)),
+    MatchIDCounter: s.matchIDCounter,
+    Matches:        make([]Match, 0, len(s.matches)),
+    RatingSeq:      s.ratingSeq,
+  }
+  for _, player := range s.players {
+    snap.Players = append(snap.Players, player)
+  }
+  for _, deleted := range s.trash {
+    snap.Trash = append(snap.Trash, deleted)
+  }
+  for _, team := range s.teams {
+    snap.Teams = append(snap.Teams, team)
+  }
+  for _, match := range s.matches {
+    snap.Matches = append(snap.Matches, match)
+  }
+  for _, changes := range s.history {
+    snap.History = append(snap.History, changes...)
+  }
+  slices.SortFunc(snap.History, func(a, b RatingChange) int {
+    return cmp.Compare(a.Seq, b.Seq)
+  })
+
+  raw, err := json.Marshal(snap)
+  if err != nil {
+    return fmt.Errorf("failed to encode snapshot: %w", err)
+  }
+  if err := writeFileAtomic(filepath.Join(s.dir, snapshotFileName), raw, 0o644, !s.opts.NoSync); err != nil {
+    return err
+  }
+
+  if err := s.file.Truncate(0); err != nil {
+    return fmt.Errorf("failed to truncate log: %w", err)
+  }
+  if _, err := s.file.Seek(0, io.SeekStart); err != nil {
+    return fmt.Errorf("failed to seek log: %w", err)
+  }
+  s.size = 0
+  s.records = 0
+  return nil
+}
+
+// Ping checks that the log file is still open and the data directory is
+// still there
+func (s *FileStore) Ping() error {
+  if _, err := s.file.Stat(); err != nil {
+    return fmt.Errorf("log file unavailable: %w", err)
+  }
+  if _, err := os.Stat(s.dir); err != nil {
+    return fmt.Errorf("data directory unavailable: %w", err)
+  }
+  return nil
+}
+
+// Close compacts the log into a snapshot and closes the log file
+func (s *FileStore) Close() error {
+  if s.records > 0 {
+    if err := s.Snapshot(); err != nil {
+      slog.Error("Error writing snapshot on close", LogKeyError, err)
+    }
+  }
+  return s.file.Close()
+}
+
+// writeFileAtomic writes data to path via a temporary file and a rename
+func writeFileAtomic(path string, data []byte, perm os.FileMode, sync bool) error {
+  tmp := path + ".tmp"
+  file, err := os.OpenFile(tmp, os.O_WRONLY|os.O_CREATE|os.O_TRUNC, perm)
+  if err != nil {
+    return fmt.Errorf("failed to create %s: %w", tmp, err)
+  }
+  if _, err := file.Write(data); err != nil {
+    file.Close()
+    return fmt.Errorf("failed to write %s: %w", tmp, err)
+  }
+  if sync {
+    if err := file.Sync(); err != nil {
+      file.Close()
+      return fmt.Errorf("failed to sync %s: %w", tmp, err)
+    }
+  }
+  if err := file.Close(); err != nil {
+    return fmt.Errorf("failed to close %s: %w", tmp, err)
+  }
+  if err := os.Rename(tmp, path); err != nil {
+    return fmt.Errorf("failed to rename %s: %w", tmp, err)
+  }
+  if sync {
+    // Make the rename itself durable
+    if dir, err := os.Open(filepath.Dir(path)); err == nil {
+      dir.Sync()
+      dir.Close()
+    }
+  }
+  return nil
+}
@@ -0,0 +1,132 @@
+package main
+
+import (
+  "os"
+  "path/filepath"
+  "testing"
+)
+
+func openTestFileStore(t *testing.T, dir string, snapshotEvery int) *FileStore {
+  t.Helper()
+  store, err := OpenFileStore(dir, FileStoreOptions{SnapshotEvery: snapshotEvery, NoSync: true})
+  if err != nil {
+    t.Fatalf("Failed to open file store: %v", err)
+  }
+  return store
+}
+
+func TestFileStore_PersistsAcrossRestart(t *testing.T) {
+  dir := t.TempDir()
+
+  store := openTestFileStore(t, dir, 0)
+  service := NewPlayerServiceWithStore(store)
+
+  created, err := service.CreatePlayer(t.Context(), PlayerRequest{Name: "Test Player", JerseyNumber: 15, Rating: 85})
+  if err != nil {
+    t.Fatalf("Failed to create player: %v", err)
+  }
+  if _, err := service.DeletePlayer(t.Context(), created.ID, AnyVersion); err != nil {
+    t.Fatalf("Failed to delete player: %v", err)
+  }
+  if err := service.Close(); err != nil {
+    t.Fatalf("Failed to close service: %v", err)
+  }
+
+  // Reopen and make sure the deleted player's ID isn't handed out again
+  service = NewPlayerServiceWithStore(openTestFileStore(t, dir, 0))
+  defer service.Close()
+
+  if service.PlayerExists(created.ID) {
+    t.Errorf("Expected player %s to stay deleted", created.ID)
+  }
+
+  next, err := service.CreatePlayer(t.Context(), PlayerRequest{Name: "Another Player", JerseyNumber: 16, Rating: 80})
+  if err != nil {
+    t.Fatalf("Failed to create player: %v", err)
+  }
+  if next.ID == created.ID {
+    t.Errorf("Expected a fresh ID, got reused ID %s", next.ID)
+  }
+}
+
+func TestFileStore_SnapshotAndReplay(t *testing.T) {
+  dir := t.TempDir()
+
+  // Snapshot every two records so both the snapshot and the log are exercised
+  store := openTestFileStore(t, dir, 2)
+  service := NewPlayerServiceWithStore(store)
+
+  for i, name := range []string{"A", "B", "C"} {
+    if _, err := service.CreatePlayer(t.Context(), PlayerRequest{Name: name, JerseyNumber: int8(i + 1), Rating: 80}); err != nil {
+      t.Fatalf("Failed to create player: %v", err)
+    }
+  }
+
+  if _, err := os.Stat(filepath.Join(dir, snapshotFileName)); err != nil {
+    t.Fatalf("Expected a snapshot to be written: %v", err)
+  }
+
+  // Simulate a crash: don't close the store, just open the directory again
+  reopened := openTestFileStore(t, dir, 2)
+  defer reopened.Close()
+
+  if reopened.Len() != 3 {
+    t.Errorf("Expected 3 players after recovery, got %d", reopened.Len())
+  }
+  if reopened.IDCounter() != 3 {
+    t.Errorf("Expected ID counter 3 after recovery, got %d", reopened.IDCounter())
+  }
+}
+
+func TestFileStore_RecoversFromTornWrite(t *testing.T) {
+  dir := t.TempDir()
+
+  store := openTestFileStore(t, dir, 0)
+  service := NewPlayerServiceWithStore(store)
+  if _, err := service.CreatePlayer(t.Context(), PlayerRequest{Name: "Survivor", JerseyNumber: 9, Rating: 90}); err != nil {
+    t.Fatalf("Failed to create player: %v", err)
+  }
+
+  // Simulate a crash in the middle of appending the next record
+  record, err := encodeLogRecord(StoreBatch{Put: []Player{{ID: "2", Name: "Lost", JerseyNumber: 1, Rating: 1}}, IDCounter: 2})
+  if err != nil {
+    t.Fatalf("Failed to encode record: %v", err)
+  }
+  if _, err := store.file.Write(record[:len(record)/2]); err != nil {
+    t.Fatalf("Failed to write partial record: %v", err)
+  }
+
+  reopened := openTestFileStore(t, dir, 0)
+  if reopened.Len() != 1 {
+    t.Errorf("Expected 1 player after recovery, got %d", reopened.Len())
+  }
+  if _, exists := reopened.Get("2"); exists {
+    t.Errorf("Expected torn record to be discarded")
+  }
+
+  // New records must land on a clean log and survive another restart
+  if err := reopened.Apply(StoreBatch{Put: []Player{{ID: "2", Name: "Kept", JerseyNumber: 2, Rating: 70}}, IDCounter: 2}); err != nil {
+    t.Fatalf("Failed to apply batch: %v", err)
+  }
+
+  again := openTestFileStore(t, dir, 0)
+  defer again.Close()
+  if player, exists := again.Get("2"); !exists || player.Name != "Kept" {
+    t.Errorf("Expected player 2 to be %q after restart, got %+v", "Kept", player)
+  }
+}
+
+func TestFileStore_DiscardsCorruptRecord(t *testing.T) {
+  dir := t.TempDir()
+
+  if err := os.WriteFile(filepath.Join(dir, logFileName), []byte("deadbeef {\"put\":[{\"id\":\"1\"}]}\n"), 0o644); err != nil {
+    t.Fatalf("Failed to write log: %v", err)
+  }
+
+  store := openTestFileStore(t, dir, 0)
+  defer store.Close()
+
+  if store.Len() != 0 {
+    t.Errorf("Expected corrupt record to be discarded, got %d players", store.Len())
+  }
+}
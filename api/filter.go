@@ -0,0 +1,336 @@
+package main
+
+import (
+  "fmt"
+  "strconv"
+  "strings"
+  "unicode"
+  "unicode/utf8"
+)
+
+// Limits that keep hostile filter expressions cheap to reject
+const (
+  maxFilterLength = 2000
+  maxFilterDepth  = 32
+)
+
+// FilterError reports a problem in a filter expression. Pos is the 1-based
+// character position the problem was found at.
+type FilterError struct {
+  Pos int
+  Msg string
+}
+
+func (e *FilterError) Error() string {
+  return fmt.Sprintf("invalid filter at position %d: %s", e.Pos, e.Msg)
+}
+
+// Unwrap lets handlers treat filter errors like any other invalid input
+func (e *FilterError) Unwrap() error {
+  return ErrInvalidInput
+}
+
+// tokenKind classifies filter tokens
+type tokenKind int
+
+const (
+  tokenEOF tokenKind = iota
+  tokenIdent
+  tokenNumber
+  tokenString
+  tokenOperator
+  tokenLParen
+  tokenRParen
+  tokenAnd
+  tokenOr
+  tokenNot
+)
+
+// filterToken is a lexical token with its position in the expression
+type filterToken struct {
+  kind tokenKind
+  text string
+  pos  int
+}
+
+// describe renders the token for error messages
+func (t filterToken) describe() string {
+  if t.kind == tokenEOF {
+    return "end of filter"
+  }
+  return strconv.Quote(t.text)
+}
+
+// tokenizeFilter splits a filter expression into tokens
+func tokenizeFilter(input string) ([]filterToken, error) {
+  var tokens []filterToken
+  runes := []rune(input)
+
+  for i := 0; i < len(runes); {
+    r := runes[i]
+    pos := i + 1
+
+    switch {
+    case unicode.IsSpace(r):
+      i++
+
+    case r == '(':
+      tokens = append(tokens, filterToken{kind: tokenLParen, text: "(", pos: pos})
+      i++
+
+    case r == ')':
+      tokens = append(tokens, filterToken{kind: tokenRParen, text: ")", pos: pos})
+      i++
+
+    case strings.ContainsRune("=!<>~", r):
+      op := string(r)
+      if i+1 < len(runes) && runes[i+1] == '=' && r != '=' && r != '~' {
+        op += "="
+      }
+      if op == "!" {
+        return nil, &FilterError{Pos: pos, Msg: `unexpected "!", did you mean "!="?`}
+      }
+      tokens = append(tokens, filterToken{kind: tokenOperator, text: op, pos: pos})
+      i += utf8.RuneCountInString(op)
+
+    case r == '"':
+      var sb strings.Builder
+      j := i + 1
+      for ; j < len(runes) && runes[j] != '"'; j++ {
+        if runes[j] == '\\' && j+1 < len(runes) {
+          j++
+        }
+        sb.WriteRune(runes[j])
+      }
+      if j >= len(runes) {
+        return nil, &FilterError{Pos: pos, Msg: "unterminated string"}
+      }
+      tokens = append(tokens, filterToken{kind: tokenString, text: sb.String(), pos: pos})
+      i = j + 1
+
+    case r == '-' || unicode.IsDigit(r):
+      j := i + 1
+      for j < len(runes) && unicode.IsDigit(runes[j]) {
+        j++
+      }
+      text := string(runes[i:j])
+      if text == "-" {
+        return nil, &FilterError{Pos: pos, Msg: `expected digits after "-"`}
+      }
+      tokens = append(tokens, filterToken{kind: tokenNumber, text: text, pos: pos})
+      i = j
+
+    case unicode.IsLetter(r) || r == '_':
+      j := i + 1
+      for j < len(runes) && (unicode.IsLetter(runes[j]) || unicode.IsDigit(runes[j]) || runes[j] == '_') {
+        j++
+      }
+      text := string(runes[i:j])
+      kind := tokenIdent
+      switch strings.ToLower(text) {
+      case "and":
+        kind = tokenAnd
+      case "or":
+        kind = tokenOr
+      case "not":
+        kind = tokenNot
+      }
+      tokens = append(tokens, filterToken{kind: kind, text: text, pos: pos})
+      i = j
+
+    default:
+      return nil, &FilterError{Pos: pos, Msg: fmt.Sprintf("unexpected character %q", r)}
+    }
+  }
+
+  tokens = append(tokens, filterToken{kind: tokenEOF, pos: len(runes) + 1})
+  return tokens, nil
+}
+
+// filterExpr is a node of a parsed filter expression
+type filterExpr interface {
+  eval(p *Player) bool
+}
+
+type andExpr struct{ left, right filterExpr }
+type orExpr struct{ left, right filterExpr }
+type notExpr struct{ inner filterExpr }
+
+// compareExpr compares a player field with a literal
+type compareExpr struct {
+  field playerField
+  op    string
+  value any
+}
+
+func (e andExpr) eval(p *Player) bool { return e.left.eval(p) && e.right.eval(p) }
+func (e orExpr) eval(p *Player) bool  { return e.left.eval(p) || e.right.eval(p) }
+func (e notExpr) eval(p *Player) bool { return !e.inner.eval(p) }
+
+func (e compareExpr) eval(p *Player) bool {
+  actual := e.field.value(p)
+  if e.op == "~" {
+    return strings.Contains(strings.ToLower(actual.(string)), e.value.(string))
+  }
+  return compareMatches(e.op, compareFieldValues(e.field.kind, actual, e.value))
+}
+
+// filterParser is a recursive descent parser for filter expressions:
+//
+//   or         := and ("or" and)*
+//   and        := unary ("and" unary)*
+//   unary      := "not" unary | "(" or ")" | comparison
+//   comparison := field ("=" | "!=" | ">" | ">=" | "<" | "<=" | "~") literal
+type filterParser struct {
+  tokens []filterToken
+  pos    int
+  depth  int
+}
+
+// ParseFilter parses a filter expression such as
+// `rating > 90 and (jersey_number = 10 or name ~ "Ron")`
+func ParseFilter(input string) (filterExpr, error) {
+  if utf8.RuneCountInString(input) > maxFilterLength {
+    return nil, &FilterError{Pos: maxFilterLength + 1, Msg: fmt.Sprintf("filter is longer than %d characters", maxFilterLength)}
+  }
+
+  tokens, err := tokenizeFilter(input)
+  if err != nil {
+    return nil, err
+  }
+
+  parser := &filterParser{tokens: tokens}
+  expr, err := parser.parseOr()
+  if err != nil {
+    return nil, err
+  }
+  if next := parser.peek(); next.kind != tokenEOF {
+    return nil, &FilterError{Pos: next.pos, Msg: fmt.Sprintf("unexpected %s, expected \"and\", \"or\" or end of filter", next.describe())}
+  }
+  return expr, nil
+}
+
+func (p *filterParser) peek() filterToken {
+  return p.tokens[p.pos]
+}
+
+func (p *filterParser) next() filterToken {
+  token := p.tokens[p.pos]
+  if token.kind != tokenEOF {
+    p.pos++
+  }
+  return token
+}
+
+func (p *filterParser) parseOr() (filterExpr, error) {
+  left, err := p.parseAnd()
+  if err != nil {
+    return nil, err
+  }
+  for p.peek().kind == tokenOr {
+    p.next()
+    right, err := p.parseAnd()
+    if err != nil {
+      return nil, err
+    }
+    left = orExpr{left, right}
+  }
+  return left, nil
+}
+
+func (p *filterParser) parseAnd() (filterExpr, error) {
+  left, err := p.parseUnary()
+  if err != nil {
+    return nil, err
+  }
+  for p.peek().kind == tokenAnd {
+    p.next()
+    right, err := p.parseUnary()
+    if err != nil {
+      return nil, err
+    }
+    left = andExpr{left, right}
+  }
+  return left, nil
+}
+
+func (p *filterParser) parseUnary() (filterExpr, error) {
+  p.depth++
+  defer func() { p.depth-- }()
+  if p.depth > maxFilterDepth {
+    return nil, &FilterError{Pos: p.peek().pos, Msg: fmt.Sprintf("filter is nested more than %d levels deep", maxFilterDepth)}
+  }
+
+  token := p.next()
+  switch token.kind {
+  case tokenNot:
+    inner, err := p.parseUnary()
+    if err != nil {
+      return nil, err
+    }
+    return notExpr{inner}, nil
+
+  case tokenLParen:
+    inner, err := p.parseOr()
+    if err != nil {
+      return nil, err
+    }
+    if closing := p.next(); closing.kind != tokenRParen {
+      return nil, &FilterError{Pos: closing.pos, Msg: fmt.Sprintf("expected \")\" to close \"(\" at position %d, found %s", token.pos, closing.describe())}
+    }
+    return inner, nil
+
+  case tokenIdent:
+    return p.parseComparison(token)
+  }
+
+  return nil, &FilterError{Pos: token.pos, Msg: fmt.Sprintf("expected a field name, \"not\" or \"(\", found %s", token.describe())}
+}
+
+func (p *filterParser) parseComparison(fieldToken filterToken) (filterExpr, error) {
+  field, ok := playerFields[fieldToken.text]
+  if !ok {
+    return nil, &FilterError{Pos: fieldToken.pos, Msg: fmt.Sprintf("unknown field %q", fieldToken.text)}
+  }
+
+  opToken := p.next()
+  if opToken.kind != tokenOperator {
+    return nil, &FilterError{Pos: opToken.pos, Msg: fmt.Sprintf("expected a comparison operator after %q, found %s", fieldToken.text, opToken.describe())}
+  }
+
+  valueToken := p.next()
+  expr := compareExpr{field: field, op: opToken.text}
+
+  switch {
+  case opToken.text == "~":
+    if field.kind == intField {
+      return nil, &FilterError{Pos: opToken.pos, Msg: fmt.Sprintf("\"~\" only works on text fields, %q is a number", fieldToken.text)}
+    }
+    if valueToken.kind != tokenString {
+      return nil, &FilterError{Pos: valueToken.pos, Msg: fmt.Sprintf("expected a quoted string, found %s", valueToken.describe())}
+    }
+    expr.value = strings.ToLower(valueToken.text)
+
+  case field.kind == intField:
+    if valueToken.kind != tokenNumber {
+      return nil, &FilterError{Pos: valueToken.pos, Msg: fmt.Sprintf("%q is a number field, found %s", fieldToken.text, valueToken.describe())}
+    }
+    n, err := strconv.ParseInt(valueToken.text, 10, 64)
+    if err != nil {
+      return nil, &FilterError{Pos: valueToken.pos, Msg: fmt.Sprintf("number %s is out of range", valueToken.text)}
+    }
+    expr.value = n
+
+  case field.kind == idField && valueToken.kind == tokenNumber:
+    // Unquoted IDs are fine since IDs look like numbers
+    expr.value = valueToken.text
+
+  default:
+    if valueToken.kind != tokenString {
+      return nil, &FilterError{Pos: valueToken.pos, Msg: fmt.Sprintf("%q is a text field, expected a quoted string, found %s", fieldToken.text, valueToken.describe())}
+    }
+    expr.value = valueToken.text
+  }
+
+  return expr, nil
+}
@@ -0,0 +1,75 @@
+package main
+
+import (
+  "errors"
+  "testing"
+)
+
+func TestParseFilter_Eval(t *testing.T) {
+  messi := Player{ID: "1", Name: "Messi", JerseyNumber: 10, Rating: 99}
+  ronaldo := Player{ID: "2", Name: "Ronaldo", JerseyNumber: 7, Rating: 98}
+  neymar := Player{ID: "3", Name: "Neymar", JerseyNumber: 10, Rating: 89}
+  
+  tests := []struct {
+    filter string
+    want   []bool // results for messi, ronaldo, neymar
+  }{
+    {`rating > 90`, []bool{true, true, false}},
+    {`rating > 90 and (jersey_number = 10 or name ~ "Ron")`, []bool{true, true, false}},
+    {`rating >= 89 and jersey_number != 7`, []bool{true, false, true}},
+    {`not name = "Messi"`, []bool{false, true, true}},
+    {`name ~ "NEY" or id = 1`, []bool{true, false, true}},
+    {`id = "2"`, []bool{false, true, false}},
+    {`rating < 99 AND NOT (jersey_number <= 7)`, []bool{false, false, true}},
+    {`jersey_number = 10 and rating > 95 or rating < 90`, []bool{true, false, true}},
+  }
+  
+  for _, tt := range tests {
+    t.Run(tt.filter, func(t *testing.T) {
+      expr, err := ParseFilter(tt.filter)
+      if err != nil {
+        t.Fatalf("Expected no error but got: %v", err)
+      }
+      for i, player := range []Player{messi, ronaldo, neymar} {
+        if got := expr.eval(&player); got != tt.want[i] {
+          t.Errorf("%s: expected %v, got %v", player.Name, tt.want[i], got)
+        }
+      }
+    })
+  }
+}
+
+func TestParseFilter_Errors(t *testing.T) {
+  tests := []struct {
+    filter  string
+    wantPos int
+  }{
+    {`rating >`, 9},
+    {`rating > "high"`, 10},
+    {`name = 5`, 8},
+    {`height > 180`, 1},
+    {`rating ~ "9"`, 8},
+    {`(rating > 90`, 13},
+    {`rating > 90 jersey_number = 10`, 13},
+    {`name = "Messi`, 8},
+    {`rating # 90`, 8},
+    {`rating ! 90`, 8},
+  }
+  
+  for _, tt := range tests {
+    t.Run(tt.filter, func(t *testing.T) {
+      _, err := ParseFilter(tt.filter)
+      
+      var filterErr *FilterError
+      if !errors.As(err, &filterErr) {
+        t.Fatalf("Expected a FilterError, got %v", err)
+      }
+      if !errors.Is(err, ErrInvalidInput) {
+        t.Errorf("Expected error to wrap ErrInvalidInput")
+      }
+      if filterErr.Pos != tt.wantPos {
+        t.Errorf("Expected error at position %d, got %d (%v)", tt.wantPos, filterErr.Pos, err)
+      }
+    })
+  }
+}
@@ -1,56 +1,261 @@
 package main
 
 import (
+  "context"
   "encoding/json"
   "errors"
-  "log"
+  "fmt"
+  "io"
+  "log/slog"
+  "mime"
   "net/http"
+  "slices"
+  "strconv"
+  "strings"
+  "time"
 )
 
 // PlayerHandler contains the player service and HTTP handlers
 type PlayerHandler struct {
-  service *PlayerService
+  service  *PlayerService
+  webhooks *WebhookDispatcher
+  keys     *APIKeyStore
+  jwt      *JWTVerifier
+  metrics  *Metrics
+  health   *Health
+  
+  // heartbeat is how often idle event streams send a comment to keep
+  // connections and proxies from timing out
+  heartbeat time.Duration
 }
 
-// NewPlayerHandler creates a new PlayerHandler
+// NewPlayerHandler creates a new PlayerHandler without webhook endpoints
 func NewPlayerHandler(service *PlayerService) *PlayerHandler {
-  return &PlayerHandler{service: service}
+  metrics := NewMetrics()
+  metrics.Gauge("players", "Number of players, not counting deleted ones.", func() float64 {
+    return float64(service.PlayerCount())
+  })
+  health := NewHealth(ReadBuildInfo())
+  health.AddCheck("store", service.Ping)
+  return &PlayerHandler{service: service, metrics: metrics, health: health, heartbeat: DefaultHeartbeatInterval}
+}
+
+// NewPlayerHandlerWithWebhooks creates a new PlayerHandler that also serves
+// the webhook endpoints
+func NewPlayerHandlerWithWebhooks(service *PlayerService, webhooks *WebhookDispatcher) *PlayerHandler {
+  h := NewPlayerHandler(service)
+  h.webhooks = webhooks
+  return h
+}
+
+// NewPlayerHandlerWithAuth creates a new PlayerHandler that serves the
+// webhook endpoints and checks API keys against keys and, when jwt isn't
+// nil, accepts JWTs it verifies
+func NewPlayerHandlerWithAuth(service *PlayerService, webhooks *WebhookDispatcher, keys *APIKeyStore, jwt *JWTVerifier) *PlayerHandler {
+  h := NewPlayerHandlerWithWebhooks(service, webhooks)
+  h.keys = keys
+  h.jwt = jwt
+  return h
 }
 
 // sendJSONResponse is a helper function to send JSON responses
 func (h *PlayerHandler) sendJSONResponse(w http.ResponseWriter, status int, response Response) {
+  writeJSONResponse(w, status, response)
+}
+
+// sendErrorResponse is a helper function to send error responses
+func (h *PlayerHandler) sendErrorResponse(w http.ResponseWriter, status int, message string, err error) {
+  writeErrorResponse(w, status, message, err)
+}
+
+// writeJSONResponse sends a JSON response; middleware uses it directly
+func writeJSONResponse(w http.ResponseWriter, status int, response Response) {
+  if response.Status == "error" && response.RequestID == "" {
+    // RequestIDMiddleware and the tracer set the headers before handlers run
+    response.RequestID = w.Header().Get(RequestIDHeader)
+    response.TraceID = w.Header().Get(TraceIDHeader)
+  }
   w.Header().Set("Content-Type", "application/json")
   w.WriteHeader(status)
   
   if err := json.NewEncoder(w).Encode(response); err != nil {
-    log.Printf("Error encoding JSON response: %v", err)
+    slog.Error("Error encoding JSON response", LogKeyError, err)
     http.Error(w, "Internal server error", http.StatusInternalServerError)
   }
 }
 
-// sendErrorResponse is a helper function to send error responses
-func (h *PlayerHandler) sendErrorResponse(w http.ResponseWriter, status int, message string, err error) {
+// writeErrorResponse sends an error response; middleware uses it directly
+func writeErrorResponse(w http.ResponseWriter, status int, message string, err error) {
   response := Response{
     Status:  "error",
     Message: message,
     Error:   err.Error(),
   }
   
-  log.Printf("Error: %s - %v", message, err)
-  h.sendJSONResponse(w, status, response)
+  level := slog.LevelWarn
+  if status >= http.StatusInternalServerError {
+    level = slog.LevelError
+  }
+  ctx := WithRequestID(context.Background(), w.Header().Get(RequestIDHeader))
+  args := []any{"status", status, LogKeyError, err}
+  if traceID := w.Header().Get(TraceIDHeader); traceID != "" {
+    args = append(args, LogKeyTraceID, traceID)
+  }
+  slog.Log(ctx, level, message, args...)
+  writeJSONResponse(w, status, response)
+}
+
+// formatETag renders a player version as a strong entity tag
+func formatETag(version int64) string {
+  return `"` + strconv.FormatInt(version, 10) + `"`
+}
+
+// parseIfMatch parses an If-Match header into the player versions it lists.
+// matchAny is true when the header is absent or "*". Weak tags never match
+// because If-Match requires strong comparison, so they are skipped, and so
+// are versions below 1: they were never issued, and 0 would mean AnyVersion.
+func parseIfMatch(header string) (versions []int64, matchAny bool, err error) {
+  header = strings.TrimSpace(header)
+  if header == "" || header == "*" {
+    return nil, true, nil
+  }
+  
+  for _, tag := range strings.Split(header, ",") {
+    tag = strings.TrimSpace(tag)
+    if strings.HasPrefix(tag, "W/") {
+      continue
+    }
+    if len(tag) < 2 || tag[0] != '"' || tag[len(tag)-1] != '"' {
+      return nil, false, fmt.Errorf("malformed entity tag %s", tag)
+    }
+    version, err := strconv.ParseInt(tag[1:len(tag)-1], 10, 64)
+    if err != nil || version < 1 {
+      // A well-formed tag we never issued simply doesn't match
+      continue
+    }
+    versions = append(versions, version)
+  }
+  return versions, false, nil
+}
+
+// expectedVersion turns the If-Match header into the version to pass to the
+// service. When several tags are listed the one matching the current version
+// is used, and the service then re-checks it under its write lock.
+func (h *PlayerHandler) expectedVersion(r *http.Request, id string) (int64, error) {
+  return matchVersion(r.Header.Get("If-Match"), func() (int64, error) {
+    player, err := h.service.GetPlayerByID(id)
+    return player.Version, err
+  })
+}
+
+// expectedTeamVersion is expectedVersion for teams
+func (h *PlayerHandler) expectedTeamVersion(r *http.Request, id string) (int64, error) {
+  return matchVersion(r.Header.Get("If-Match"), func() (int64, error) {
+    team, err := h.service.GetTeamByID(id)
+    return team.Version, err
+  })
+}
+
+// matchVersion resolves an If-Match header against the version returned by
+// current, which is only looked up when several tags are listed
+func matchVersion(header string, current func() (int64, error)) (int64, error) {
+  versions, matchAny, err := parseIfMatch(header)
+  if err != nil {
+    return 0, fmt.Errorf("%w: invalid If-Match header: %v", ErrInvalidInput, err)
+  }
+  if matchAny {
+    return AnyVersion, nil
+  }
+  if len(versions) == 1 {
+    return versions[0], nil
+  }
+  
+  version, err := current()
+  if err != nil {
+    return 0, err
+  }
+  if slices.Contains(versions, version) {
+    return version, nil
+  }
+  return 0, fmt.Errorf("%w: If-Match does not match current version %d", ErrVersionMismatch, version)
 }
 
-// GetPlayers handles GET /players - fetch all players
+// GetPlayers handles GET /players - fetch a page of players
 func (h *PlayerHandler) GetPlayers(w http.ResponseWriter, r *http.Request) {
-  players := h.service.GetAllPlayers()
+  query, err := parsePlayerQuery(r.URL.Query())
+  if err != nil {
+    h.sendErrorResponse(w, http.StatusBadRequest, "Invalid query", err)
+    return
+  }
   
+  page := h.service.QueryPlayers(query)
+  slog.InfoContext(r.Context(), "Listed players", "returned", len(page.Players), "total", page.Total)
+  h.sendPlayerPage(w, query, page)
+}
+
+// sendPlayerPage sends one page of a player listing
+func (h *PlayerHandler) sendPlayerPage(w http.ResponseWriter, query PlayerQuery, page PlayerPage) {
   response := Response{
     Status:  "success",
     Message: "Players fetched successfully",
-    Data:    players,
+    Data:    page.Players,
+    Meta: &PageMeta{
+      Total:      page.Total,
+      Limit:      query.Limit,
+      NextCursor: page.NextCursor,
+      PrevCursor: page.PrevCursor,
+    },
+  }
+  
+  h.sendJSONResponse(w, http.StatusOK, response)
+}
+
+// SearchPlayers handles GET /players/search - fuzzy search by name
+func (h *PlayerHandler) SearchPlayers(w http.ResponseWriter, r *http.Request) {
+  q := strings.TrimSpace(r.URL.Query().Get("q"))
+  if q == "" {
+    h.sendErrorResponse(w, http.StatusBadRequest, "Search query is required", errors.New("missing q parameter"))
+    return
+  }
+  limit, err := parseLimit(r.URL.Query().Get("limit"), DefaultSearchLimit, MaxSearchLimit)
+  if err != nil {
+    h.sendErrorResponse(w, http.StatusBadRequest, "Invalid query", err)
+    return
+  }
+  
+  matches := h.service.SearchPlayers(q, limit)
+  
+  response := Response{
+    Status:  "success",
+    Message: "Search completed successfully",
+    Data:    matches,
+  }
+  
+  slog.InfoContext(r.Context(), "Searched players", "query", q, "matches", len(matches))
+  h.sendJSONResponse(w, http.StatusOK, response)
+}
+
+// AutocompletePlayers handles GET /players/autocomplete - name suggestions for type-ahead
+func (h *PlayerHandler) AutocompletePlayers(w http.ResponseWriter, r *http.Request) {
+  q := r.URL.Query().Get("q")
+  if strings.TrimSpace(q) == "" {
+    h.sendErrorResponse(w, http.StatusBadRequest, "Search query is required", errors.New("missing q parameter"))
+    return
+  }
+  limit, err := parseLimit(r.URL.Query().Get("limit"), DefaultSearchLimit, MaxSearchLimit)
+  if err != nil {
+    h.sendErrorResponse(w, http.StatusBadRequest, "Invalid query", err)
+    return
+  }
+  
+  suggestions := h.service.SuggestPlayers(q, limit)
+  
+  response := Response{
+    Status:  "success",
+    Message: "Suggestions fetched successfully",
+    Data:    suggestions,
   }
   
-  log.Printf("GET /players - returned %d players", len(players))
   h.sendJSONResponse(w, http.StatusOK, response)
 }
 
@@ -78,7 +283,8 @@ func (h *PlayerHandler) GetPlayer(w http.ResponseWriter, r *http.Request) {
     Data:    player,
   }
   
-  log.Printf("GET /players/%s - returned player: %s", id, player.Name)
+  slog.InfoContext(r.Context(), "Returned player", LogKeyPlayerID, id, "name", player.Name)
+  w.Header().Set("ETag", formatETag(player.Version))
   h.sendJSONResponse(w, http.StatusOK, response)
 }
 
@@ -93,7 +299,7 @@ func (h *PlayerHandler) CreatePlayer(w http.ResponseWriter, r *http.Request) {
   }
   
   // Create the player
-  player, err := h.service.CreatePlayer(req)
+  player, err := h.service.CreatePlayer(r.Context(), req)
   if err != nil {
     if errors.Is(err, ErrInvalidInput) {
       h.sendErrorResponse(w, http.StatusBadRequest, "Invalid input", err)
@@ -113,7 +319,8 @@ func (h *PlayerHandler) CreatePlayer(w http.ResponseWriter, r *http.Request) {
     Data:    player,
   }
   
-  log.Printf("POST /players - created player: %s (ID: %s)", player.Name, player.ID)
+  slog.InfoContext(r.Context(), "Created player", LogKeyPlayerID, player.ID, "name", player.Name)
+  w.Header().Set("ETag", formatETag(player.Version))
   h.sendJSONResponse(w, http.StatusCreated, response)
 }
 
@@ -133,32 +340,102 @@ func (h *PlayerHandler) UpdatePlayer(w http.ResponseWriter, r *http.Request) {
     return
   }
   
-  // Update the player
-  player, err := h.service.UpdatePlayer(id, req)
+  // Update the player, honouring If-Match
+  version, err := h.expectedVersion(r, id)
   if err != nil {
-    if errors.Is(err, ErrPlayerNotFound) {
-      h.sendErrorResponse(w, http.StatusNotFound, "Player not found", err)
+    h.sendWriteError(w, "Failed to update player", err)
+    return
+  }
+  
+  player, err := h.service.UpdatePlayer(r.Context(), id, req, version)
+  if err != nil {
+    h.sendWriteError(w, "Failed to update player", err)
+    return
+  }
+  
+  response := Response{
+    Status:  "success",
+    Message: "Player updated successfully",
+    Data:    player,
+  }
+  
+  slog.InfoContext(r.Context(), "Updated player", LogKeyPlayerID, id, "name", player.Name)
+  w.Header().Set("ETag", formatETag(player.Version))
+  h.sendJSONResponse(w, http.StatusOK, response)
+}
+
+// PatchPlayer handles PATCH /players/{id} - partially update a player using
+// either a JSON Merge Patch or a JSON Patch document
+func (h *PlayerHandler) PatchPlayer(w http.ResponseWriter, r *http.Request) {
+  id := r.PathValue("id")
+  if id == "" {
+    h.sendErrorResponse(w, http.StatusBadRequest, "Player ID is required", errors.New("missing player ID"))
+    return
+  }
+  
+  var patch func(PlayerRequest) (PlayerRequest, error)
+  
+  mediaType, _, _ := mime.ParseMediaType(r.Header.Get("Content-Type"))
+  switch mediaType {
+  case mergePatchMediaType:
+    var mergePatch any
+    if err := json.NewDecoder(r.Body).Decode(&mergePatch); err != nil {
+      h.sendErrorResponse(w, http.StatusBadRequest, "Invalid JSON format", err)
       return
     }
-    if errors.Is(err, ErrInvalidInput) {
-      h.sendErrorResponse(w, http.StatusBadRequest, "Invalid input", err)
-      return
+    patch = func(req PlayerRequest) (PlayerRequest, error) {
+      doc, err := requestToDocument(req)
+      if err != nil {
+        return req, err
+      }
+      return documentToRequest(applyMergePatch(doc, mergePatch))
     }
-    if errors.Is(err, ErrPlayerExists) {
-      h.sendErrorResponse(w, http.StatusConflict, "Player conflict", err)
+    
+  case jsonPatchMediaType:
+    var ops []patchOperation
+    if err := json.NewDecoder(r.Body).Decode(&ops); err != nil {
+      h.sendErrorResponse(w, http.StatusBadRequest, "Invalid JSON format", err)
       return
     }
-    h.sendErrorResponse(w, http.StatusInternalServerError, "Failed to update player", err)
+    patch = func(req PlayerRequest) (PlayerRequest, error) {
+      doc, err := requestToDocument(req)
+      if err != nil {
+        return req, err
+      }
+      doc, err = applyJSONPatch(doc, ops)
+      if err != nil {
+        return req, err
+      }
+      return documentToRequest(doc)
+    }
+    
+  default:
+    w.Header().Set("Accept-Patch", mergePatchMediaType+", "+jsonPatchMediaType)
+    h.sendErrorResponse(w, http.StatusUnsupportedMediaType, "Unsupported patch format",
+      fmt.Errorf("content type must be %s or %s", mergePatchMediaType, jsonPatchMediaType))
+    return
+  }
+  
+  version, err := h.expectedVersion(r, id)
+  if err != nil {
+    h.sendWriteError(w, "Failed to patch player", err)
+    return
+  }
+  
+  player, err := h.service.PatchPlayer(r.Context(), id, version, patch)
+  if err != nil {
+    h.sendWriteError(w, "Failed to patch player", err)
     return
   }
   
   response := Response{
     Status:  "success",
-    Message: "Player updated successfully",
+    Message: "Player patched successfully",
     Data:    player,
   }
   
-  log.Printf("PUT /players/%s - updated player: %s", id, player.Name)
+  slog.InfoContext(r.Context(), "Patched player", LogKeyPlayerID, id, "name", player.Name)
+  w.Header().Set("ETag", formatETag(player.Version))
   h.sendJSONResponse(w, http.StatusOK, response)
 }
 
@@ -170,13 +447,15 @@ func (h *PlayerHandler) DeletePlayer(w http.ResponseWriter, r *http.Request) {
     return
   }
   
-  player, err := h.service.DeletePlayer(id)
+  version, err := h.expectedVersion(r, id)
   if err != nil {
-    if errors.Is(err, ErrPlayerNotFound) {
-      h.sendErrorResponse(w, http.StatusNotFound, "Player not found", err)
-      return
-    }
-    h.sendErrorResponse(w, http.StatusInternalServerError, "Failed to delete player", err)
+    h.sendWriteError(w, "Failed to delete player", err)
+    return
+  }
+  
+  player, err := h.service.DeletePlayer(r.Context(), id, version)
+  if err != nil {
+    h.sendWriteError(w, "Failed to delete player", err)
     return
   }
   
@@ -186,39 +465,1126 @@ func (h *PlayerHandler) DeletePlayer(w http.ResponseWriter, r *http.Request) {
     Data:    player,
   }
   
-  log.Printf("DELETE /players/%s - deleted player: %s", id, player.Name)
+  slog.InfoContext(r.Context(), "Deleted player", LogKeyPlayerID, id, "name", player.Name)
   h.sendJSONResponse(w, http.StatusOK, response)
 }
 
-// Legacy handlers for backward compatibility (keeping the original function signatures)
-// These use the global service instance
+// sendWriteError maps errors from PlayerService writes to HTTP responses
+func (h *PlayerHandler) sendWriteError(w http.ResponseWriter, fallback string, err error) {
+  status, message := writeErrorStatus(err)
+  if status == http.StatusInternalServerError {
+    message = fallback
+  }
+  h.sendErrorResponse(w, status, message, err)
+}
+
+// writeErrorStatus returns the HTTP status and message for a write error
+func writeErrorStatus(err error) (int, string) {
+  switch {
+  case errors.Is(err, ErrPlayerNotFound):
+    return http.StatusNotFound, "Player not found"
+  case errors.Is(err, ErrVersionMismatch):
+    return http.StatusPreconditionFailed, "Precondition failed"
+  case errors.Is(err, ErrInvalidInput):
+    return http.StatusBadRequest, "Invalid input"
+  case errors.Is(err, ErrInvalidPatch):
+    return http.StatusBadRequest, "Invalid patch"
+  case errors.Is(err, ErrPatchTestFailed):
+    return http.StatusConflict, "Patch test failed"
+  case errors.Is(err, ErrPlayerExists):
+    return http.StatusConflict, "Player conflict"
+  case errors.Is(err, ErrBatchAborted):
+    return http.StatusFailedDependency, "Not applied"
+  case errors.Is(err, ErrTeamNotFound):
+    return http.StatusNotFound, "Team not found"
+  case errors.Is(err, ErrTeamExists):
+    return http.StatusConflict, "Team conflict"
+  case errors.Is(err, ErrTeamHasPlayers):
+    return http.StatusConflict, "Team has players"
+  case errors.Is(err, ErrMatchNotFound):
+    return http.StatusNotFound, "Match not found"
+  case errors.Is(err, ErrWebhookNotFound):
+    return http.StatusNotFound, "Webhook not found"
+  case errors.Is(err, ErrAPIKeyNotFound):
+    return http.StatusNotFound, "API key not found"
+  }
+  return http.StatusInternalServerError, "Internal server error"
+}
+
+// BatchPlayers handles POST /players/batch - apply several writes at once
+func (h *PlayerHandler) BatchPlayers(w http.ResponseWriter, r *http.Request) {
+  var req BatchRequest
+  
+  // Parse JSON request body
+  if err := json.NewDecoder(r.Body).Decode(&req); err != nil {
+    h.sendErrorResponse(w, http.StatusBadRequest, "Invalid JSON format", err)
+    return
+  }
+  if err := req.Validate(); err != nil {
+    h.sendErrorResponse(w, http.StatusBadRequest, "Invalid input", err)
+    return
+  }
+  
+  atomic := req.Mode == BatchAtomic
+  results, err := h.service.ApplyBatch(r.Context(), req.Operations, atomic)
+  
+  items := make([]BatchItemResponse, len(results))
+  failed := 0
+  for i, result := range results {
+    items[i] = BatchItemResponse{Index: i, Op: result.Op, ID: result.ID, Player: result.Player}
+    switch {
+    case result.Err != nil:
+      items[i].Status, _ = writeErrorStatus(result.Err)
+      items[i].Error = result.Err.Error()
+      failed++
+    case result.Op == BatchCreate:
+      items[i].Status = http.StatusCreated
+    default:
+      items[i].Status = http.StatusOK
+    }
+  }
+  
+  if err != nil {
+    status, message := writeErrorStatus(err)
+    if status == http.StatusInternalServerError {
+      message = "Failed to apply batch"
+    }
+    slog.WarnContext(r.Context(), "Batch rolled back", LogKeyError, err)
+    h.sendJSONResponse(w, status, Response{
+      Status:  "error",
+      Message: message + ", batch rolled back",
+      Data:    items,
+      Error:   err.Error(),
+    })
+    return
+  }
+  
+  status := http.StatusOK
+  message := "Batch applied successfully"
+  if failed > 0 {
+    status = http.StatusMultiStatus
+    message = fmt.Sprintf("Batch applied with %d of %d operations failed", failed, len(items))
+  }
+  
+  slog.InfoContext(r.Context(), "Applied batch", "mode", req.Mode, "operations", len(items), "failed", failed)
+  h.sendJSONResponse(w, status, Response{
+    Status:  "success",
+    Message: message,
+    Data:    items,
+  })
+}
+
+// ExportPlayers handles GET /players/export - stream players as CSV or NDJSON.
+// It takes the same sort and filter parameters as GET /players, without paging.
+func (h *PlayerHandler) ExportPlayers(w http.ResponseWriter, r *http.Request) {
+  values := r.URL.Query()
+  format := values.Get("format")
+  if format == "" {
+    format = FormatCSV
+  }
+  if format != FormatCSV && format != FormatNDJSON {
+    h.sendErrorResponse(w, http.StatusBadRequest, "Invalid query",
+      fmt.Errorf("%w: format must be %q or %q", ErrInvalidInput, FormatCSV, FormatNDJSON))
+    return
+  }
+  if values.Has("limit") || values.Has("cursor") {
+    h.sendErrorResponse(w, http.StatusBadRequest, "Invalid query",
+      fmt.Errorf("%w: export returns all matching players and takes no limit or cursor", ErrInvalidInput))
+    return
+  }
+  values.Del("format")
+  
+  query, err := parsePlayerQuery(values)
+  if err != nil {
+    h.sendErrorResponse(w, http.StatusBadRequest, "Invalid query", err)
+    return
+  }
+  pages := h.service.ExportPages(query, exportPageSize)
+  
+  // Large exports can outlive the server's write timeout
+  rc := http.NewResponseController(w)
+  rc.SetWriteDeadline(time.Now().Add(transferTimeout))
+  flush := func() { rc.Flush() }
+  
+  w.Header().Set("Content-Disposition", fmt.Sprintf(`attachment; filename="players.%s"`, format))
+  var exported int
+  if format == FormatCSV {
+    w.Header().Set("Content-Type", "text/csv; charset=utf-8")
+    exported, err = writeCSVExport(w, pages, flush)
+  } else {
+    w.Header().Set("Content-Type", "application/x-ndjson")
+    exported, err = writeNDJSONExport(w, pages, flush)
+  }
+  if err != nil {
+    // The status line has already been sent, so the client sees a short body
+    slog.ErrorContext(r.Context(), "Export aborted", LogKeyError, err)
+    return
+  }
+  
+  slog.InfoContext(r.Context(), "Exported players", "players", exported, "format", format)
+}
+
+// ImportPlayers handles POST /players/import - create players from a CSV or
+// NDJSON upload. Each row is validated on its own and rejected rows are
+// reported by line number; valid rows are created regardless.
+func (h *PlayerHandler) ImportPlayers(w http.ResponseWriter, r *http.Request) {
+  values := r.URL.Query()
+  format := values.Get("format")
+  if format == "" {
+    format = formatForContentType(r.Header.Get("Content-Type"))
+  }
+  
+  // Large uploads can outlive the server's read and write timeouts
+  rc := http.NewResponseController(w)
+  rc.SetReadDeadline(time.Now().Add(transferTimeout))
+  rc.SetWriteDeadline(time.Now().Add(transferTimeout))
+  
+  var rows rowReader
+  switch format {
+  case FormatCSV:
+    columns, err := parseColumnMapping(values["map"])
+    if err != nil {
+      h.sendErrorResponse(w, http.StatusBadRequest, "Invalid query", err)
+      return
+    }
+    rows, err = newCSVRowReader(r.Body, columns)
+    if err != nil {
+      h.sendErrorResponse(w, http.StatusBadRequest, "Invalid CSV", err)
+      return
+    }
+  case FormatNDJSON:
+    if values.Has("map") {
+      h.sendErrorResponse(w, http.StatusBadRequest, "Invalid query",
+        fmt.Errorf("%w: column mapping only applies to CSV imports", ErrInvalidInput))
+      return
+    }
+    rows = newNDJSONRowReader(r.Body)
+  case "":
+    h.sendErrorResponse(w, http.StatusUnsupportedMediaType, "Unsupported import format",
+      fmt.Errorf("%w: send text/csv or application/x-ndjson, or set format", ErrInvalidInput))
+    return
+  default:
+    h.sendErrorResponse(w, http.StatusBadRequest, "Invalid query",
+      fmt.Errorf("%w: format must be %q or %q", ErrInvalidInput, FormatCSV, FormatNDJSON))
+    return
+  }
+  
+  report, err := h.service.ImportRows(r.Context(), rows)
+  if err != nil {
+    // Rows before the failure were imported, so the report is still returned
+    slog.WarnContext(r.Context(), "Import stopped", "rows", report.Rows, LogKeyError, err)
+    h.sendJSONResponse(w, http.StatusBadRequest, Response{
+      Status:  "error",
+      Message: fmt.Sprintf("Import stopped after %d rows", report.Rows),
+      Data:    report,
+      Error:   err.Error(),
+    })
+    return
+  }
+  
+  status := http.StatusOK
+  message := "Import completed successfully"
+  if report.Rejected > 0 {
+    status = http.StatusMultiStatus
+    message = fmt.Sprintf("Import completed with %d of %d rows rejected", report.Rejected, report.Rows)
+  }
+  
+  slog.InfoContext(r.Context(), "Imported players", "format", format, "rows", report.Rows, "created", report.Created, "rejected", report.Rejected)
+  h.sendJSONResponse(w, status, Response{
+    Status:  "success",
+    Message: message,
+    Data:    report,
+  })
+}
+
+// GetTeams handles GET /teams - fetch all teams
+func (h *PlayerHandler) GetTeams(w http.ResponseWriter, r *http.Request) {
+  teams := h.service.GetTeams()
+  
+  response := Response{
+    Status:  "success",
+    Message: "Teams fetched successfully",
+    Data:    teams,
+  }
+  
+  slog.InfoContext(r.Context(), "Listed teams", "returned", len(teams))
+  h.sendJSONResponse(w, http.StatusOK, response)
+}
+
+// GetTeam handles GET /teams/{id} - fetch a single team
+func (h *PlayerHandler) GetTeam(w http.ResponseWriter, r *http.Request) {
+  id := r.PathValue("id")
+  
+  team, err := h.service.GetTeamByID(id)
+  if err != nil {
+    h.sendWriteError(w, "Failed to get team", err)
+    return
+  }
+  
+  response := Response{
+    Status:  "success",
+    Message: "Team fetched successfully",
+    Data:    team,
+  }
+  
+  w.Header().Set("ETag", formatETag(team.Version))
+  h.sendJSONResponse(w, http.StatusOK, response)
+}
+
+// CreateTeam handles POST /teams - create a new team
+func (h *PlayerHandler) CreateTeam(w http.ResponseWriter, r *http.Request) {
+  var req TeamRequest
+  
+  // Parse JSON request body
+  if err := json.NewDecoder(r.Body).Decode(&req); err != nil {
+    h.sendErrorResponse(w, http.StatusBadRequest, "Invalid JSON format", err)
+    return
+  }
+  
+  team, err := h.service.CreateTeam(r.Context(), req)
+  if err != nil {
+    h.sendWriteError(w, "Failed to create team", err)
+    return
+  }
+  
+  response := Response{
+    Status:  "success",
+    Message: "Team created successfully",
+    Data:    team,
+  }
+  
+  slog.InfoContext(r.Context(), "Created team", LogKeyTeamID, team.ID, "name", team.Name)
+  w.Header().Set("ETag", formatETag(team.Version))
+  h.sendJSONResponse(w, http.StatusCreated, response)
+}
+
+// UpdateTeam handles PUT /teams/{id} - rename a team
+func (h *PlayerHandler) UpdateTeam(w http.ResponseWriter, r *http.Request) {
+  id := r.PathValue("id")
+  
+  var req TeamRequest
+  
+  // Parse JSON request body
+  if err := json.NewDecoder(r.Body).Decode(&req); err != nil {
+    h.sendErrorResponse(w, http.StatusBadRequest, "Invalid JSON format", err)
+    return
+  }
+  
+  // Update the team, honouring If-Match
+  version, err := h.expectedTeamVersion(r, id)
+  if err != nil {
+    h.sendWriteError(w, "Failed to update team", err)
+    return
+  }
+  
+  team, err := h.service.UpdateTeam(r.Context(), id, req, version)
+  if err != nil {
+    h.sendWriteError(w, "Failed to update team", err)
+    return
+  }
+  
+  response := Response{
+    Status:  "success",
+    Message: "Team updated successfully",
+    Data:    team,
+  }
+  
+  slog.InfoContext(r.Context(), "Updated team", LogKeyTeamID, id, "name", team.Name)
+  w.Header().Set("ETag", formatETag(team.Version))
+  h.sendJSONResponse(w, http.StatusOK, response)
+}
 
-var globalPlayerService *PlayerService
+// DeleteTeam handles DELETE /teams/{id} - delete a team. A team with players
+// can only be deleted with on_players=cascade, which deletes them too, or
+// on_players=reassign, which moves them to reassign_to or releases them.
+func (h *PlayerHandler) DeleteTeam(w http.ResponseWriter, r *http.Request) {
+  id := r.PathValue("id")
+  opts := TeamDeleteOptions{
+    OnPlayers:  r.URL.Query().Get("on_players"),
+    ReassignTo: r.URL.Query().Get("reassign_to"),
+  }
+  
+  version, err := h.expectedTeamVersion(r, id)
+  if err != nil {
+    h.sendWriteError(w, "Failed to delete team", err)
+    return
+  }
+  
+  deletion, err := h.service.DeleteTeam(r.Context(), id, version, opts)
+  if err != nil {
+    h.sendWriteError(w, "Failed to delete team", err)
+    return
+  }
+  
+  response := Response{
+    Status:  "success",
+    Message: "Team deleted successfully",
+    Data:    deletion,
+  }
+  
+  slog.InfoContext(r.Context(), "Deleted team", LogKeyTeamID, id, "name", deletion.Team.Name,
+    "players_deleted", len(deletion.DeletedPlayers), "players_reassigned", len(deletion.ReassignedPlayers))
+  h.sendJSONResponse(w, http.StatusOK, response)
+}
+
+// GetTeamPlayers handles GET /teams/{id}/players - fetch a page of a team's
+// players. It takes the same query parameters as GET /players.
+func (h *PlayerHandler) GetTeamPlayers(w http.ResponseWriter, r *http.Request) {
+  id := r.PathValue("id")
+  if _, err := h.service.GetTeamByID(id); err != nil {
+    h.sendWriteError(w, "Failed to get team", err)
+    return
+  }
+  
+  query, err := parsePlayerQuery(r.URL.Query())
+  if err != nil {
+    h.sendErrorResponse(w, http.StatusBadRequest, "Invalid query", err)
+    return
+  }
+  query.Filters = append(query.Filters, FieldFilter{Field: "team_id", Op: "=", Value: id})
+  
+  page := h.service.QueryPlayers(query)
+  slog.InfoContext(r.Context(), "Listed team players", LogKeyTeamID, id, "returned", len(page.Players), "total", page.Total)
+  h.sendPlayerPage(w, query, page)
+}
+
+// CreateTeamPlayer handles POST /teams/{id}/players - create a player on a team
+func (h *PlayerHandler) CreateTeamPlayer(w http.ResponseWriter, r *http.Request) {
+  id := r.PathValue("id")
+  
+  var req PlayerRequest
+  
+  // Parse JSON request body
+  if err := json.NewDecoder(r.Body).Decode(&req); err != nil {
+    h.sendErrorResponse(w, http.StatusBadRequest, "Invalid JSON format", err)
+    return
+  }
+  if req.TeamID != "" && req.TeamID != id {
+    h.sendErrorResponse(w, http.StatusBadRequest, "Invalid input",
+      fmt.Errorf("%w: team_id %s does not match team %s in the path", ErrInvalidInput, req.TeamID, id))
+    return
+  }
+  req.TeamID = id
+  
+  if _, err := h.service.GetTeamByID(id); err != nil {
+    h.sendWriteError(w, "Failed to get team", err)
+    return
+  }
+  
+  player, err := h.service.CreatePlayer(r.Context(), req)
+  if err != nil {
+    h.sendWriteError(w, "Failed to create player", err)
+    return
+  }
+  
+  response := Response{
+    Status:  "success",
+    Message: "Player created successfully",
+    Data:    player,
+  }
+  
+  slog.InfoContext(r.Context(), "Created player", LogKeyTeamID, id, LogKeyPlayerID, player.ID, "name", player.Name)
+  w.Header().Set("ETag", formatETag(player.Version))
+  h.sendJSONResponse(w, http.StatusCreated, response)
+}
+
+// expectedMatchVersion is expectedVersion for matches
+func (h *PlayerHandler) expectedMatchVersion(r *http.Request, id string) (int64, error) {
+  return matchVersion(r.Header.Get("If-Match"), func() (int64, error) {
+    match, err := h.service.GetMatchByID(id)
+    return match.Version, err
+  })
+}
+
+// GetMatches handles GET /matches - fetch matches, newest first. Matches can
+// be filtered by team_id, player_id and a from/to date range.
+func (h *PlayerHandler) GetMatches(w http.ResponseWriter, r *http.Request) {
+  query, err := parseMatchQuery(r.URL.Query())
+  if err != nil {
+    h.sendErrorResponse(w, http.StatusBadRequest, "Invalid query", err)
+    return
+  }
+  
+  matches, total := h.service.QueryMatches(query)
+  
+  response := Response{
+    Status:  "success",
+    Message: "Matches fetched successfully",
+    Data:    matches,
+    Meta:    &PageMeta{Total: total, Limit: query.Limit},
+  }
+  
+  slog.InfoContext(r.Context(), "Listed matches", "returned", len(matches), "total", total)
+  h.sendJSONResponse(w, http.StatusOK, response)
+}
+
+// GetMatch handles GET /matches/{id} - fetch a single match
+func (h *PlayerHandler) GetMatch(w http.ResponseWriter, r *http.Request) {
+  id := r.PathValue("id")
+  
+  match, err := h.service.GetMatchByID(id)
+  if err != nil {
+    h.sendWriteError(w, "Failed to get match", err)
+    return
+  }
+  
+  response := Response{
+    Status:  "success",
+    Message: "Match fetched successfully",
+    Data:    match,
+  }
+  
+  w.Header().Set("ETag", formatETag(match.Version))
+  h.sendJSONResponse(w, http.StatusOK, response)
+}
+
+// CreateMatch handles POST /matches - record a match
+func (h *PlayerHandler) CreateMatch(w http.ResponseWriter, r *http.Request) {
+  var req MatchRequest
+  
+  // Parse JSON request body
+  if err := json.NewDecoder(r.Body).Decode(&req); err != nil {
+    h.sendErrorResponse(w, http.StatusBadRequest, "Invalid JSON format", err)
+    return
+  }
+  
+  match, err := h.service.CreateMatch(r.Context(), req)
+  if err != nil {
+    h.sendWriteError(w, "Failed to create match", err)
+    return
+  }
+  
+  response := Response{
+    Status:  "success",
+    Message: "Match created successfully",
+    Data:    match,
+  }
+  
+  slog.InfoContext(r.Context(), "Created match", LogKeyMatchID, match.ID,
+    "home_team_id", match.HomeTeamID, "away_team_id", match.AwayTeamID, "date", match.Date)
+  w.Header().Set("ETag", formatETag(match.Version))
+  h.sendJSONResponse(w, http.StatusCreated, response)
+}
+
+// UpdateMatch handles PUT /matches/{id} - replace a match
+func (h *PlayerHandler) UpdateMatch(w http.ResponseWriter, r *http.Request) {
+  id := r.PathValue("id")
+  
+  var req MatchRequest
+  
+  // Parse JSON request body
+  if err := json.NewDecoder(r.Body).Decode(&req); err != nil {
+    h.sendErrorResponse(w, http.StatusBadRequest, "Invalid JSON format", err)
+    return
+  }
+  
+  // Update the match, honouring If-Match
+  version, err := h.expectedMatchVersion(r, id)
+  if err != nil {
+    h.sendWriteError(w, "Failed to update match", err)
+    return
+  }
+  
+  match, err := h.service.UpdateMatch(r.Context(), id, req, version)
+  if err != nil {
+    h.sendWriteError(w, "Failed to update match", err)
+    return
+  }
+  
+  response := Response{
+    Status:  "success",
+    Message: "Match updated successfully",
+    Data:    match,
+  }
+  
+  slog.InfoContext(r.Context(), "Updated match", LogKeyMatchID, id)
+  w.Header().Set("ETag", formatETag(match.Version))
+  h.sendJSONResponse(w, http.StatusOK, response)
+}
+
+// DeleteMatch handles DELETE /matches/{id} - delete a match
+func (h *PlayerHandler) DeleteMatch(w http.ResponseWriter, r *http.Request) {
+  id := r.PathValue("id")
+  
+  version, err := h.expectedMatchVersion(r, id)
+  if err != nil {
+    h.sendWriteError(w, "Failed to delete match", err)
+    return
+  }
+  
+  match, err := h.service.DeleteMatch(r.Context(), id, version)
+  if err != nil {
+    h.sendWriteError(w, "Failed to delete match", err)
+    return
+  }
+  
+  response := Response{
+    Status:  "success",
+    Message: "Match deleted successfully",
+    Data:    match,
+  }
+  
+  slog.InfoContext(r.Context(), "Deleted match", LogKeyMatchID, id)
+  h.sendJSONResponse(w, http.StatusOK, response)
+}
+
+// GetPlayerStats handles GET /players/{id}/stats - sum a player's matches
+// over an optional from/to date range
+func (h *PlayerHandler) GetPlayerStats(w http.ResponseWriter, r *http.Request) {
+  id := r.PathValue("id")
+  
+  dates, err := parseDateRange(r.URL.Query())
+  if err != nil {
+    h.sendErrorResponse(w, http.StatusBadRequest, "Invalid query", err)
+    return
+  }
+  
+  stats, err := h.service.PlayerStats(id, dates)
+  if err != nil {
+    h.sendWriteError(w, "Failed to get player stats", err)
+    return
+  }
+  
+  response := Response{
+    Status:  "success",
+    Message: "Player stats fetched successfully",
+    Data:    stats,
+  }
+  
+  slog.InfoContext(r.Context(), "Returned player stats", LogKeyPlayerID, id, "appearances", stats.Appearances)
+  h.sendJSONResponse(w, http.StatusOK, response)
+}
+
+// GetTeamStats handles GET /teams/{id}/stats - sum a team's results over an
+// optional from/to date range
+func (h *PlayerHandler) GetTeamStats(w http.ResponseWriter, r *http.Request) {
+  id := r.PathValue("id")
+  
+  dates, err := parseDateRange(r.URL.Query())
+  if err != nil {
+    h.sendErrorResponse(w, http.StatusBadRequest, "Invalid query", err)
+    return
+  }
+  
+  stats, err := h.service.TeamStats(id, dates)
+  if err != nil {
+    h.sendWriteError(w, "Failed to get team stats", err)
+    return
+  }
+  
+  response := Response{
+    Status:  "success",
+    Message: "Team stats fetched successfully",
+    Data:    stats,
+  }
+  
+  slog.InfoContext(r.Context(), "Returned team stats", LogKeyTeamID, id, "played", stats.Played)
+  h.sendJSONResponse(w, http.StatusOK, response)
+}
+
+// GetPlayerHistory handles GET /players/{id}/history - a player's rating
+// changes, optionally downsampled by day or week
+func (h *PlayerHandler) GetPlayerHistory(w http.ResponseWriter, r *http.Request) {
+  id := r.PathValue("id")
+  
+  query, err := parseHistoryQuery(r.URL.Query())
+  if err != nil {
+    h.sendErrorResponse(w, http.StatusBadRequest, "Invalid query", err)
+    return
+  }
+  
+  changes, err := h.service.RatingHistory(id, query.Range)
+  if err != nil {
+    h.sendWriteError(w, "Failed to get rating history", err)
+    return
+  }
+  
+  response := Response{
+    Status:  "success",
+    Message: "Rating history fetched successfully",
+    Data:    changes,
+  }
+  if query.Interval != "" {
+    response.Data = downsample(changes, query.Interval, query.Agg)
+  }
+  
+  slog.InfoContext(r.Context(), "Returned rating history", LogKeyPlayerID, id, "changes", len(changes))
+  h.sendJSONResponse(w, http.StatusOK, response)
+}
+
+// GetRatingMovers handles GET /players/movers - players ranked by how much
+// their rating changed over a window
+func (h *PlayerHandler) GetRatingMovers(w http.ResponseWriter, r *http.Request) {
+  query, err := parseMoversQuery(r.URL.Query())
+  if err != nil {
+    h.sendErrorResponse(w, http.StatusBadRequest, "Invalid query", err)
+    return
+  }
+  
+  report := h.service.RatingMovers(query)
+  
+  response := Response{
+    Status:  "success",
+    Message: "Rating movers fetched successfully",
+    Data:    report,
+  }
+  
+  slog.InfoContext(r.Context(), "Returned rating movers", "movers", len(report.Movers))
+  h.sendJSONResponse(w, http.StatusOK, response)
+}
+
+// GetAudit handles GET /audit - a page of the audit trail, newest first.
+// The trail is read-only; there is no way to change it through the API.
+func (h *PlayerHandler) GetAudit(w http.ResponseWriter, r *http.Request) {
+  query, err := parseAuditQuery(r.URL.Query())
+  if err != nil {
+    h.sendErrorResponse(w, http.StatusBadRequest, "Invalid query", err)
+    return
+  }
+  
+  page, err := h.service.AuditLog(query)
+  if err != nil {
+    h.sendErrorResponse(w, http.StatusInternalServerError, "Failed to read audit trail", err)
+    return
+  }
+  
+  response := Response{
+    Status:  "success",
+    Message: "Audit entries fetched successfully",
+    Data:    page.Entries,
+    Meta: &PageMeta{
+      Total:      page.Total,
+      Limit:      query.Limit,
+      NextCursor: page.Cursor,
+    },
+  }
+  
+  slog.InfoContext(r.Context(), "Listed audit entries", "returned", len(page.Entries), "total", page.Total)
+  h.sendJSONResponse(w, http.StatusOK, response)
+}
+
+// GetTrash handles GET /trash - list deleted players awaiting purge
+func (h *PlayerHandler) GetTrash(w http.ResponseWriter, r *http.Request) {
+  limit, err := parseLimit(r.URL.Query().Get("limit"), DefaultTrashLimit, MaxTrashLimit)
+  if err != nil {
+    h.sendErrorResponse(w, http.StatusBadRequest, "Invalid query", err)
+    return
+  }
+  
+  players, total := h.service.TrashedPlayers(limit)
+  
+  response := Response{
+    Status:  "success",
+    Message: "Deleted players fetched successfully",
+    Data:    players,
+    Meta:    &PageMeta{Total: total, Limit: limit},
+  }
+  
+  slog.InfoContext(r.Context(), "Listed deleted players", "returned", len(players), "total", total)
+  h.sendJSONResponse(w, http.StatusOK, response)
+}
+
+// RestorePlayer handles POST /players/{id}/restore - bring a player back from the trash
+func (h *PlayerHandler) RestorePlayer(w http.ResponseWriter, r *http.Request) {
+  id := r.PathValue("id")
+  
+  player, err := h.service.RestorePlayer(r.Context(), id)
+  if err != nil {
+    h.sendWriteError(w, "Failed to restore player", err)
+    return
+  }
+  
+  response := Response{
+    Status:  "success",
+    Message: "Player restored successfully",
+    Data:    player,
+  }
+  
+  slog.InfoContext(r.Context(), "Restored player", LogKeyPlayerID, id, "name", player.Name)
+  w.Header().Set("ETag", formatETag(player.Version))
+  h.sendJSONResponse(w, http.StatusOK, response)
+}
+
+// StreamEvents handles GET /players/events - push player changes as
+// Server-Sent Events. Clients resume with the Last-Event-ID header (sent
+// automatically by EventSource on reconnect) or the last_event_id parameter.
+func (h *PlayerHandler) StreamEvents(w http.ResponseWriter, r *http.Request) {
+  lastID := NoReplay
+  raw := r.Header.Get("Last-Event-ID")
+  if raw == "" {
+    raw = r.URL.Query().Get("last_event_id")
+  }
+  if raw != "" {
+    id, err := strconv.ParseInt(raw, 10, 64)
+    if err != nil || id < 0 {
+      h.sendErrorResponse(w, http.StatusBadRequest, "Invalid Last-Event-ID", fmt.Errorf("%w: last event ID must be a non-negative integer", ErrInvalidInput))
+      return
+    }
+    lastID = id
+  }
+  
+  // The stream outlives the server's write timeout
+  rc := http.NewResponseController(w)
+  if err := rc.SetWriteDeadline(time.Time{}); err != nil && !errors.Is(err, http.ErrNotSupported) {
+    h.sendErrorResponse(w, http.StatusInternalServerError, "Failed to open event stream", err)
+    return
+  }
+  
+  broker := h.service.Events()
+  events, replay := broker.Subscribe(lastID)
+  defer broker.Unsubscribe(events)
+  
+  w.Header().Set("Content-Type", "text/event-stream")
+  w.Header().Set("Cache-Control", "no-cache")
+  w.Header().Set("X-Accel-Buffering", "no")
+  w.WriteHeader(http.StatusOK)
+  
+  slog.InfoContext(r.Context(), "Event stream client connected", "replayed", len(replay))
+  defer slog.InfoContext(r.Context(), "Event stream client disconnected")
+  
+  for _, event := range replay {
+    if err := writeEvent(w, event); err != nil {
+      return
+    }
+  }
+  if err := rc.Flush(); err != nil {
+    return
+  }
+  
+  heartbeat := time.NewTicker(h.heartbeat)
+  defer heartbeat.Stop()
+  
+  for {
+    select {
+    case <-r.Context().Done():
+      return
+    case event, ok := <-events:
+      if !ok {
+        return
+      }
+      if err := writeEvent(w, event); err != nil {
+        return
+      }
+    case <-heartbeat.C:
+      if _, err := io.WriteString(w, ": heartbeat\n\n"); err != nil {
+        return
+      }
+    }
+    if err := rc.Flush(); err != nil {
+      return
+    }
+  }
+}
+
+// writeEvent writes one event in the text/event-stream format
+func writeEvent(w io.Writer, event ChangeEvent) error {
+  data, err := json.Marshal(event)
+  if err != nil {
+    return err
+  }
+  _, err = fmt.Fprintf(w, "id: %d\nevent: %s\ndata: %s\n\n", event.ID, event.Type, data)
+  return err
+}
+
+// GetWebhooks handles GET /webhooks - list webhook subscriptions
+func (h *PlayerHandler) GetWebhooks(w http.ResponseWriter, r *http.Request) {
+  webhooks := h.webhooks.Webhooks()
+  
+  response := Response{
+    Status:  "success",
+    Message: "Webhooks fetched successfully",
+    Data:    webhooks,
+  }
+  
+  slog.InfoContext(r.Context(), "Listed webhooks", "returned", len(webhooks))
+  h.sendJSONResponse(w, http.StatusOK, response)
+}
+
+// GetWebhook handles GET /webhooks/{id} - fetch a webhook subscription
+func (h *PlayerHandler) GetWebhook(w http.ResponseWriter, r *http.Request) {
+  id := r.PathValue("id")
+  
+  webhook, err := h.webhooks.GetWebhook(id)
+  if err != nil {
+    h.sendWriteError(w, "Failed to get webhook", err)
+    return
+  }
+  
+  response := Response{
+    Status:  "success",
+    Message: "Webhook fetched successfully",
+    Data:    webhook,
+  }
+  
+  h.sendJSONResponse(w, http.StatusOK, response)
+}
+
+// CreateWebhook handles POST /webhooks - subscribe a URL to player changes
+func (h *PlayerHandler) CreateWebhook(w http.ResponseWriter, r *http.Request) {
+  var req WebhookRequest
+  if err := json.NewDecoder(r.Body).Decode(&req); err != nil {
+    h.sendErrorResponse(w, http.StatusBadRequest, "Invalid JSON format", err)
+    return
+  }
+  
+  webhook, err := h.webhooks.CreateWebhook(req)
+  if err != nil {
+    h.sendWriteError(w, "Failed to create webhook", err)
+    return
+  }
+  
+  response := Response{
+    Status:  "success",
+    Message: "Webhook created successfully; store the secret, it is not shown again",
+    Data:    webhook,
+  }
+  
+  slog.InfoContext(r.Context(), "Created webhook", "webhook_id", webhook.ID, "url", webhook.URL)
+  h.sendJSONResponse(w, http.StatusCreated, response)
+}
+
+// DeleteWebhook handles DELETE /webhooks/{id} - unsubscribe a webhook
+func (h *PlayerHandler) DeleteWebhook(w http.ResponseWriter, r *http.Request) {
+  id := r.PathValue("id")
+  
+  webhook, err := h.webhooks.DeleteWebhook(id)
+  if err != nil {
+    h.sendWriteError(w, "Failed to delete webhook", err)
+    return
+  }
+  
+  response := Response{
+    Status:  "success",
+    Message: "Webhook deleted successfully",
+    Data:    webhook,
+  }
+  
+  slog.InfoContext(r.Context(), "Deleted webhook", "webhook_id", id, "url", webhook.URL)
+  h.sendJSONResponse(w, http.StatusOK, response)
+}
+
+// GetWebhookDeliveries handles GET /webhooks/{id}/deliveries - a webhook's
+// recent deliveries and their attempts, optionally filtered by status
+func (h *PlayerHandler) GetWebhookDeliveries(w http.ResponseWriter, r *http.Request) {
+  id := r.PathValue("id")
+  status := r.URL.Query().Get("status")
+  switch status {
+  case "", DeliveryPending, DeliveryDelivered, DeliveryDead:
+  default:
+    err := fmt.Errorf("%w: status must be %s, %s or %s", ErrInvalidInput, DeliveryPending, DeliveryDelivered, DeliveryDead)
+    h.sendErrorResponse(w, http.StatusBadRequest, "Invalid query", err)
+    return
+  }
+  
+  deliveries, err := h.webhooks.Deliveries(id, status)
+  if err != nil {
+    h.sendWriteError(w, "Failed to get deliveries", err)
+    return
+  }
+  
+  response := Response{
+    Status:  "success",
+    Message: "Deliveries fetched successfully",
+    Data:    deliveries,
+  }
+  
+  slog.InfoContext(r.Context(), "Listed webhook deliveries", "webhook_id", id, "returned", len(deliveries))
+  h.sendJSONResponse(w, http.StatusOK, response)
+}
+
+// RequireScope wraps next so it only runs for requests with an API key or
+// JWT that carries scope. Changes made by the request are attributed to the
+// key's name or the token's subject; an X-Actor header is only recorded
+// alongside, as who the caller says it acts for, and can't impersonate
+// another principal.
+func (h *PlayerHandler) RequireScope(scope string, next http.Handler) http.Handler {
+  if scope == ScopePublic {
+    return next
+  }
+  return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+    _, span := StartSpan(r.Context(), "authenticate")
+    principal, err := h.authenticate(r)
+    span.RecordError(err)
+    span.End()
+    if err != nil {
+      w.Header().Set("WWW-Authenticate", `Bearer realm="player-api"`)
+      h.sendErrorResponse(w, http.StatusUnauthorized, "Authentication required", err)
+      return
+    }
+    if !principal.Allows(scope) {
+      err := fmt.Errorf("%w: %s needs the %s scope", ErrForbidden, principal.Name, scope)
+      h.sendErrorResponse(w, http.StatusForbidden, "Forbidden", err)
+      return
+    }
+    
+    ctx := WithActor(WithPrincipal(r.Context(), principal), principal.Name)
+    if label := cleanActor(r.Header.Get(ActorHeader)); label != "" && label != principal.Name {
+      ctx = WithOnBehalfOf(ctx, label)
+    }
+    next.ServeHTTP(w, r.WithContext(ctx))
+  })
+}
+
+// authenticate returns who the request's credentials belong to
+func (h *PlayerHandler) authenticate(r *http.Request) (Principal, error) {
+  token, err := bearerToken(r)
+  if err != nil {
+    return Principal{}, err
+  }
+  if h.jwt != nil && looksLikeJWT(token) {
+    return h.jwt.Verify(token)
+  }
+  if h.keys == nil {
+    return Principal{}, fmt.Errorf("%w: invalid API key", ErrUnauthenticated)
+  }
+  return h.keys.Authenticate(token)
+}
+
+// GetAPIKeys handles GET /apikeys - list API keys
+func (h *PlayerHandler) GetAPIKeys(w http.ResponseWriter, r *http.Request) {
+  keys := h.keys.APIKeys()
+  
+  response := Response{
+    Status:  "success",
+    Message: "API keys fetched successfully",
+    Data:    keys,
+  }
+  
+  slog.InfoContext(r.Context(), "Listed API keys", "returned", len(keys))
+  h.sendJSONResponse(w, http.StatusOK, response)
+}
+
+// CreateAPIKey handles POST /apikeys - issue a new API key
+func (h *PlayerHandler) CreateAPIKey(w http.ResponseWriter, r *http.Request) {
+  var req APIKeyRequest
+  if err := json.NewDecoder(r.Body).Decode(&req); err != nil {
+    h.sendErrorResponse(w, http.StatusBadRequest, "Invalid JSON format", err)
+    return
+  }
+  
+  key, err := h.keys.CreateAPIKey(req)
+  if err != nil {
+    h.sendWriteError(w, "Failed to create API key", err)
+    return
+  }
+  
+  response := Response{
+    Status:  "success",
+    Message: "API key created successfully; store the key, it is not shown again",
+    Data:    key,
+  }
+  
+  slog.InfoContext(r.Context(), "Created API key", "key_id", key.ID, "name", key.Name, "scopes", key.Scopes)
+  h.sendJSONResponse(w, http.StatusCreated, response)
+}
+
+// DeleteAPIKey handles DELETE /apikeys/{id} - revoke an API key
+func (h *PlayerHandler) DeleteAPIKey(w http.ResponseWriter, r *http.Request) {
+  id := r.PathValue("id")
+  
+  key, err := h.keys.DeleteAPIKey(id)
+  if err != nil {
+    h.sendWriteError(w, "Failed to delete API key", err)
+    return
+  }
+  
+  response := Response{
+    Status:  "success",
+    Message: "API key revoked successfully",
+    Data:    key,
+  }
+  
+  slog.InfoContext(r.Context(), "Revoked API key", "key_id", id, "name", key.Name)
+  h.sendJSONResponse(w, http.StatusOK, response)
+}
+
+// GetMetrics handles GET /metrics - request, player and runtime metrics in
+// the Prometheus text format
+func (h *PlayerHandler) GetMetrics(w http.ResponseWriter, r *http.Request) {
+  w.Header().Set("Content-Type", MetricsContentType)
+  if _, err := h.metrics.WriteTo(w); err != nil {
+    slog.WarnContext(r.Context(), "Error writing metrics", LogKeyError, err)
+  }
+}
+
+// GetLivez handles GET /livez - the process is up; reports the build and
+// uptime
+func (h *PlayerHandler) GetLivez(w http.ResponseWriter, r *http.Request) {
+  h.sendJSONResponse(w, http.StatusOK, Response{
+    Status:  "success",
+    Message: "Service is healthy",
+    Data:    h.health.Live(),
+  })
+}
+
+// GetReadyz handles GET /readyz - whether the store and background workers
+// are usable and shutdown hasn't begun; 503 otherwise
+func (h *PlayerHandler) GetReadyz(w http.ResponseWriter, r *http.Request) {
+  readiness := h.health.Ready(r.Context())
+  if !readiness.Ready {
+    // Logged at debug: load balancers poll this, and a draining instance
+    // answers 503 on purpose
+    slog.DebugContext(r.Context(), "Not ready", "draining", readiness.Draining, "checks", readiness.Checks)
+    h.sendJSONResponse(w, http.StatusServiceUnavailable, Response{
+      Status:  "error",
+      Message: "Service is not ready",
+      Data:    readiness,
+      Error:   "not ready",
+    })
+    return
+  }
+  
+  h.sendJSONResponse(w, http.StatusOK, Response{
+    Status:  "success",
+    Message: "Service is ready",
+    Data:    readiness,
+  })
+}
+
+// GetOpenAPI handles GET /openapi.json - an OpenAPI 3 description of every
+// route, generated from the route table and the request and response types
+func (h *PlayerHandler) GetOpenAPI(w http.ResponseWriter, r *http.Request) {
+  document, err := OpenAPIDocument(routes(h))
+  if err != nil {
+    h.sendErrorResponse(w, http.StatusInternalServerError, "Failed to build the OpenAPI document", err)
+    return
+  }
+  
+  w.Header().Set("Content-Type", "application/json")
+  if err := json.NewEncoder(w).Encode(document); err != nil {
+    slog.WarnContext(r.Context(), "Error writing OpenAPI document", LogKeyError, err)
+  }
+}
+
+// Legacy handlers for backward compatibility (keeping the original function signatures)
+// These use the global service instance
+
+var (
+  globalPlayerService *PlayerService
+  globalPlayerHandler *PlayerHandler
+)
 
 func init() {
   globalPlayerService = NewPlayerService()
+  globalPlayerHandler = NewPlayerHandler(globalPlayerService)
 }
 
 // GetPlayers is the legacy handler for backward compatibility
 func GetPlayers(w http.ResponseWriter, r *http.Request) {
-  handler := NewPlayerHandler(globalPlayerService)
-  handler.GetPlayers(w, r)
+  globalPlayerHandler.GetPlayers(w, r)
 }
 
 // CreatePlayer is the legacy handler for backward compatibility
 func CreatePlayer(w http.ResponseWriter, r *http.Request) {
-  handler := NewPlayerHandler(globalPlayerService)
-  handler.CreatePlayer(w, r)
+  globalPlayerHandler.CreatePlayer(w, r)
 }
 
 // UpdatePlayer is the legacy handler for backward compatibility
 func UpdatePlayer(w http.ResponseWriter, r *http.Request) {
-  handler := NewPlayerHandler(globalPlayerService)
-  handler.UpdatePlayer(w, r)
+  globalPlayerHandler.UpdatePlayer(w, r)
 }
 
 // DeletePlayer is the legacy handler for backward compatibility
 func DeletePlayer(w http.ResponseWriter, r *http.Request) {
-  handler := NewPlayerHandler(globalPlayerService)
-  handler.DeletePlayer(w, r)
+  globalPlayerHandler.DeletePlayer(w, r)
 }
\ No newline at end of file
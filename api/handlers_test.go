@@ -4,8 +4,11 @@ import (
   "bytes"
   "encoding/json"
   "errors"
+  "fmt"
   "net/http"
   "net/http/httptest"
+  "net/url"
+  "strings"
   "testing"
 )
 
@@ -61,7 +64,7 @@ func TestPlayerService_CreatePlayer(t *testing.T) {
   
   for _, tt := range tests {
     t.Run(tt.name, func(t *testing.T) {
-      _, err := service.CreatePlayer(tt.request)
+      _, err := service.CreatePlayer(t.Context(), tt.request)
       
       if tt.wantError {
         if err == nil {
@@ -252,6 +255,517 @@ func TestPlayerHandler_DeletePlayer(t *testing.T) {
   }
 }
 
+func TestPlayerHandler_IfMatch(t *testing.T) {
+  service := NewPlayerService()
+  handler := NewPlayerHandler(service)
+  
+  // GET returns the current version as an ETag
+  req := httptest.NewRequest("GET", "/players/1", nil)
+  req.SetPathValue("id", "1")
+  w := httptest.NewRecorder()
+  handler.GetPlayer(w, req)
+  
+  etag := w.Header().Get("ETag")
+  if etag != `"1"` {
+    t.Fatalf("Expected ETag %q, got %q", `"1"`, etag)
+  }
+  
+  update := func(ifMatch string) *httptest.ResponseRecorder {
+    body, _ := json.Marshal(PlayerRequest{Name: "Messi", JerseyNumber: 10, Rating: 98})
+    req := httptest.NewRequest("PUT", "/players/1", bytes.NewBuffer(body))
+    req.Header.Set("If-Match", ifMatch)
+    req.SetPathValue("id", "1")
+    w := httptest.NewRecorder()
+    handler.UpdatePlayer(w, req)
+    return w
+  }
+  
+  // First writer wins and gets the new version back
+  w = update(etag)
+  if w.Code != http.StatusOK {
+    t.Fatalf("Expected status %d, got %d", http.StatusOK, w.Code)
+  }
+  if got := w.Header().Get("ETag"); got != `"2"` {
+    t.Errorf("Expected ETag %q after update, got %q", `"2"`, got)
+  }
+  
+  // Second writer with the stale ETag is rejected
+  w = update(etag)
+  if w.Code != http.StatusPreconditionFailed {
+    t.Errorf("Expected status %d, got %d", http.StatusPreconditionFailed, w.Code)
+  }
+  
+  // A list of tags matches if any of them is current
+  w = update(`"7", "2"`)
+  if w.Code != http.StatusOK {
+    t.Errorf("Expected status %d, got %d", http.StatusOK, w.Code)
+  }
+  
+  // Weak tags never satisfy If-Match, and neither do versions we never
+  // issued; "0" must not skip the check
+  for _, tag := range []string{`W/"3"`, `"0"`, `"-1"`, `"0", "-3"`} {
+    if w = update(tag); w.Code != http.StatusPreconditionFailed {
+      t.Errorf("If-Match %s: expected status %d, got %d", tag, http.StatusPreconditionFailed, w.Code)
+    }
+  }
+  
+  // Delete with a stale version is rejected, the current one succeeds
+  for _, tc := range []struct {
+    ifMatch string
+    status  int
+  }{
+    {`"1"`, http.StatusPreconditionFailed},
+    {`"3"`, http.StatusOK},
+  } {
+    req = httptest.NewRequest("DELETE", "/players/1", nil)
+    req.Header.Set("If-Match", tc.ifMatch)
+    req.SetPathValue("id", "1")
+    w = httptest.NewRecorder()
+    handler.DeletePlayer(w, req)
+    if w.Code != tc.status {
+      t.Errorf("DELETE with If-Match %s: expected status %d, got %d", tc.ifMatch, tc.status, w.Code)
+    }
+  }
+}
+
+func TestPlayerHandler_PatchPlayer(t *testing.T) {
+  tests := []struct {
+    name           string
+    contentType    string
+    body           string
+    expectedStatus int
+    expectedRating int8
+  }{
+    {
+      name:           "merge patch",
+      contentType:    "application/merge-patch+json",
+      body:           `{"rating": 97}`,
+      expectedStatus: http.StatusOK,
+      expectedRating: 97,
+    },
+    {
+      name:           "merge patch clearing a required field",
+      contentType:    "application/merge-patch+json",
+      body:           `{"name": null}`,
+      expectedStatus: http.StatusBadRequest,
+    },
+    {
+      name:           "merge patch with unknown field",
+      contentType:    "application/merge-patch+json",
+      body:           `{"nickname": "La Pulga"}`,
+      expectedStatus: http.StatusBadRequest,
+    },
+    {
+      name:           "json patch with passing test",
+      contentType:    "application/json-patch+json",
+      body:           `[{"op": "test", "path": "/rating", "value": 99}, {"op": "replace", "path": "/rating", "value": 90}]`,
+      expectedStatus: http.StatusOK,
+      expectedRating: 90,
+    },
+    {
+      name:           "json patch with failing test",
+      contentType:    "application/json-patch+json",
+      body:           `[{"op": "test", "path": "/rating", "value": 50}, {"op": "replace", "path": "/rating", "value": 90}]`,
+      expectedStatus: http.StatusConflict,
+    },
+    {
+      name:           "json patch out of range",
+      contentType:    "application/json-patch+json",
+      body:           `[{"op": "replace", "path": "/jersey_number", "value": 100}]`,
+      expectedStatus: http.StatusBadRequest,
+    },
+    {
+      name:           "json patch creating a duplicate",
+      contentType:    "application/json-patch+json",
+      body:           `[{"op": "replace", "path": "/name", "value": "Neymar"}]`,
+      expectedStatus: http.StatusConflict,
+    },
+    {
+      name:           "unsupported content type",
+      contentType:    "application/json",
+      body:           `{"rating": 97}`,
+      expectedStatus: http.StatusUnsupportedMediaType,
+    },
+  }
+  
+  for _, tt := range tests {
+    t.Run(tt.name, func(t *testing.T) {
+      service := NewPlayerService()
+      handler := NewPlayerHandler(service)
+      
+      req := httptest.NewRequest("PATCH", "/players/1", bytes.NewBufferString(tt.body))
+      req.Header.Set("Content-Type", tt.contentType)
+      req.SetPathValue("id", "1")
+      w := httptest.NewRecorder()
+      
+      handler.PatchPlayer(w, req)
+      
+      if w.Code != tt.expectedStatus {
+        t.Fatalf("Expected status %d, got %d: %s", tt.expectedStatus, w.Code, w.Body.String())
+      }
+      if tt.expectedRating == 0 {
+        return
+      }
+      
+      player, _ := service.GetPlayerByID("1")
+      if player.Rating != tt.expectedRating {
+        t.Errorf("Expected rating %d, got %d", tt.expectedRating, player.Rating)
+      }
+      if player.Name != "Messi" || player.JerseyNumber != 10 {
+        t.Errorf("Expected untouched fields to be kept, got %+v", player)
+      }
+    })
+  }
+}
+
+// fetchPlayersPage calls GET /players with the given query string
+func fetchPlayersPage(t *testing.T, handler *PlayerHandler, query string) (int, []Player, PageMeta) {
+  t.Helper()
+  
+  req := httptest.NewRequest("GET", "/players?"+query, nil)
+  w := httptest.NewRecorder()
+  handler.GetPlayers(w, req)
+  
+  var response struct {
+    Data []Player `json:"data"`
+    Meta PageMeta `json:"meta"`
+  }
+  if err := json.NewDecoder(w.Body).Decode(&response); err != nil {
+    t.Fatalf("Failed to decode response: %v", err)
+  }
+  return w.Code, response.Data, response.Meta
+}
+
+func TestPlayerHandler_GetPlayersPagination(t *testing.T) {
+  service := NewPlayerService()
+  handler := NewPlayerHandler(service)
+  
+  for i := 1; i <= 7; i++ {
+    req := PlayerRequest{Name: fmt.Sprintf("Player %d", i), JerseyNumber: int8(i), Rating: int8(80 + i%3)}
+    if _, err := service.CreatePlayer(t.Context(), req); err != nil {
+      t.Fatalf("Failed to create player: %v", err)
+    }
+  }
+  
+  // Walk forward through all pages, inserting a player mid-way
+  var seen []string
+  query := "limit=3&sort=-rating,name"
+  for page := 0; ; page++ {
+    status, players, meta := fetchPlayersPage(t, handler, query)
+    if status != http.StatusOK {
+      t.Fatalf("Expected status %d, got %d", http.StatusOK, status)
+    }
+    if page == 0 && meta.Total != 10 {
+      t.Errorf("Expected total 10, got %d", meta.Total)
+    }
+    for _, player := range players {
+      seen = append(seen, player.ID)
+    }
+    if page == 1 {
+      // A new top-rated player sorts before the cursor and must not shift later pages
+      if _, err := service.CreatePlayer(t.Context(), PlayerRequest{Name: "Late", JerseyNumber: 50, Rating: 99}); err != nil {
+        t.Fatalf("Failed to create player: %v", err)
+      }
+    }
+    if meta.NextCursor == "" {
+      break
+    }
+    query = "limit=3&sort=-rating,name&cursor=" + meta.NextCursor
+  }
+  
+  if len(seen) != 10 {
+    t.Fatalf("Expected to see 10 players exactly once, got %v", seen)
+  }
+  unique := make(map[string]bool)
+  for _, id := range seen {
+    if unique[id] {
+      t.Errorf("Player %s returned twice", id)
+    }
+    unique[id] = true
+  }
+  
+  // Ordered by rating descending, then name
+  _, players, meta := fetchPlayersPage(t, handler, "limit=2&sort=-rating,name")
+  if players[0].Name != "Late" || players[1].Name != "Messi" {
+    t.Errorf("Expected Late then Messi first, got %s then %s", players[0].Name, players[1].Name)
+  }
+  
+  // The previous cursor of the second page leads back to the first
+  _, _, second := fetchPlayersPage(t, handler, "limit=2&sort=-rating,name&cursor="+meta.NextCursor)
+  _, back, _ := fetchPlayersPage(t, handler, "limit=2&sort=-rating,name&cursor="+second.PrevCursor)
+  if len(back) != 2 || back[0].ID != players[0].ID || back[1].ID != players[1].ID {
+    t.Errorf("Expected previous page to equal the first page, got %v", back)
+  }
+}
+
+func TestPlayerHandler_GetPlayersFilters(t *testing.T) {
+  service := NewPlayerService()
+  handler := NewPlayerHandler(service)
+  
+  tests := []struct {
+    query          string
+    expectedStatus int
+    expectedIDs    []string
+  }{
+    {"jersey_number=10", http.StatusOK, []string{"1", "3"}},
+    {"rating_gte=98", http.StatusOK, []string{"1", "2"}},
+    {"jersey_number=10&rating_lt=99", http.StatusOK, []string{"3"}},
+    {"name_ne=Messi&sort=-id", http.StatusOK, []string{"3", "2"}},
+    {"filter=" + url.QueryEscape(`rating > 95 and (jersey_number = 10 or name ~ "ron")`), http.StatusOK, []string{"1", "2"}},
+    {"filter=" + url.QueryEscape(`rating > 95`) + "&jersey_number=7", http.StatusOK, []string{"2"}},
+    {"filter=" + url.QueryEscape(`rating >`), http.StatusBadRequest, nil},
+    {"rating_gte=high", http.StatusBadRequest, nil},
+    {"team=barca", http.StatusBadRequest, nil},
+    {"sort=height", http.StatusBadRequest, nil},
+    {"limit=0", http.StatusBadRequest, nil},
+    {"cursor=not-a-cursor", http.StatusBadRequest, nil},
+  }
+  
+  for _, tt := range tests {
+    t.Run(tt.query, func(t *testing.T) {
+      status, players, _ := fetchPlayersPage(t, handler, tt.query)
+      if status != tt.expectedStatus {
+        t.Fatalf("Expected status %d, got %d", tt.expectedStatus, status)
+      }
+      
+      var ids []string
+      for _, player := range players {
+        ids = append(ids, player.ID)
+      }
+      if fmt.Sprint(ids) != fmt.Sprint(tt.expectedIDs) {
+        t.Errorf("Expected players %v, got %v", tt.expectedIDs, ids)
+      }
+    })
+  }
+}
+
+func TestPlayerHandler_BatchPlayers(t *testing.T) {
+  runBatch := func(t *testing.T, handler *PlayerHandler, body string) (int, []BatchItemResponse) {
+    t.Helper()
+    req := httptest.NewRequest("POST", "/players/batch", bytes.NewBufferString(body))
+    w := httptest.NewRecorder()
+    handler.BatchPlayers(w, req)
+    
+    var response struct {
+      Data []BatchItemResponse `json:"data"`
+    }
+    if err := json.NewDecoder(w.Body).Decode(&response); err != nil {
+      t.Fatalf("Failed to decode response: %v", err)
+    }
+    return w.Code, response.Data
+  }
+  
+  t.Run("atomic success", func(t *testing.T) {
+    service := NewPlayerService()
+    handler := NewPlayerHandler(service)
+    
+    status, items := runBatch(t, handler, `{"operations": [
+      {"op": "create", "player": {"name": "Pedri", "jersey_number": 8, "rating": 86}},
+      {"op": "update", "id": "2", "version": 1, "player": {"name": "Ronaldo", "jersey_number": 7, "rating": 90}},
+      {"op": "delete", "id": "3"}
+    ]}`)
+    
+    if status != http.StatusOK {
+      t.Fatalf("Expected status %d, got %d", http.StatusOK, status)
+    }
+    wantStatuses := []int{http.StatusCreated, http.StatusOK, http.StatusOK}
+    for i, item := range items {
+      if item.Status != wantStatuses[i] {
+        t.Errorf("Item %d: expected status %d, got %d (%s)", i, wantStatuses[i], item.Status, item.Error)
+      }
+    }
+    if items[0].ID != "4" {
+      t.Errorf("Expected created player to get ID 4, got %q", items[0].ID)
+    }
+    if service.PlayerExists("3") {
+      t.Errorf("Expected player 3 to be deleted")
+    }
+  })
+  
+  t.Run("atomic failure rolls back", func(t *testing.T) {
+    service := NewPlayerService()
+    handler := NewPlayerHandler(service)
+    
+    status, items := runBatch(t, handler, `{"mode": "atomic", "operations": [
+      {"op": "create", "player": {"name": "Pedri", "jersey_number": 8, "rating": 86}},
+      {"op": "update", "id": "2", "player": {"name": "Ronaldo", "jersey_number": 7, "rating": 90}},
+      {"op": "create", "player": {"name": "Pedri", "jersey_number": 8, "rating": 80}},
+      {"op": "delete", "id": "3"}
+    ]}`)
+    
+    if status != http.StatusConflict {
+      t.Fatalf("Expected status %d, got %d", http.StatusConflict, status)
+    }
+    wantStatuses := []int{http.StatusFailedDependency, http.StatusFailedDependency, http.StatusConflict, http.StatusFailedDependency}
+    for i, item := range items {
+      if item.Status != wantStatuses[i] {
+        t.Errorf("Item %d: expected status %d, got %d", i, wantStatuses[i], item.Status)
+      }
+    }
+    
+    // Nothing was applied, and the rolled back create didn't burn an ID
+    if page := service.QueryPlayers(PlayerQuery{Limit: 10, Sort: []SortKey{{Field: "id"}}}); page.Total != 3 {
+      t.Errorf("Expected 3 players after rollback, got %d", page.Total)
+    }
+    if player, _ := service.GetPlayerByID("2"); player.Rating != 98 || player.Version != 1 {
+      t.Errorf("Expected player 2 to be untouched, got %+v", player)
+    }
+    created, err := service.CreatePlayer(t.Context(), PlayerRequest{Name: "Pedri", JerseyNumber: 8, Rating: 86})
+    if err != nil {
+      t.Fatalf("Expected rolled back name and jersey to be free, got %v", err)
+    }
+    if created.ID != "4" {
+      t.Errorf("Expected next ID 4, got %s", created.ID)
+    }
+  })
+  
+  t.Run("best effort", func(t *testing.T) {
+    service := NewPlayerService()
+    handler := NewPlayerHandler(service)
+    
+    status, items := runBatch(t, handler, `{"mode": "best_effort", "operations": [
+      {"op": "create", "player": {"name": "Pedri", "jersey_number": 8, "rating": 86}},
+      {"op": "create", "player": {"name": "", "jersey_number": 8, "rating": 86}},
+      {"op": "delete", "id": "999"},
+      {"op": "update", "id": "1", "version": 5, "player": {"name": "Messi", "jersey_number": 10, "rating": 90}},
+      {"op": "delete", "id": "3"}
+    ]}`)
+    
+    if status != http.StatusMultiStatus {
+      t.Fatalf("Expected status %d, got %d", http.StatusMultiStatus, status)
+    }
+    wantStatuses := []int{http.StatusCreated, http.StatusBadRequest, http.StatusNotFound, http.StatusPreconditionFailed, http.StatusOK}
+    for i, item := range items {
+      if item.Status != wantStatuses[i] {
+        t.Errorf("Item %d: expected status %d, got %d", i, wantStatuses[i], item.Status)
+      }
+    }
+    if !service.PlayerExists("4") || service.PlayerExists("3") {
+      t.Errorf("Expected successful operations to be applied")
+    }
+  })
+  
+  t.Run("invalid mode", func(t *testing.T) {
+    handler := NewPlayerHandler(NewPlayerService())
+    req := httptest.NewRequest("POST", "/players/batch", bytes.NewBufferString(`{"mode": "yolo", "operations": [{"op": "delete", "id": "1"}]}`))
+    w := httptest.NewRecorder()
+    handler.BatchPlayers(w, req)
+    if w.Code != http.StatusBadRequest {
+      t.Errorf("Expected status %d, got %d", http.StatusBadRequest, w.Code)
+    }
+  })
+}
+
+func TestPlayerHandler_ImportExport(t *testing.T) {
+  handler := NewPlayerHandler(NewPlayerService())
+  
+  t.Run("export csv", func(t *testing.T) {
+    req := httptest.NewRequest("GET", "/players/export?sort=-rating&rating_gte=98", nil)
+    w := httptest.NewRecorder()
+    handler.ExportPlayers(w, req)
+    
+    if w.Code != http.StatusOK {
+      t.Fatalf("Expected status %d, got %d: %s", http.StatusOK, w.Code, w.Body.String())
+    }
+    if ct := w.Header().Get("Content-Type"); !strings.HasPrefix(ct, "text/csv") {
+      t.Errorf("Expected CSV content type, got %q", ct)
+    }
+    want := "id,name,jersey_number,rating,team_id,version\n1,Messi,10,99,,1\n2,Ronaldo,7,98,,1\n"
+    if w.Body.String() != want {
+      t.Errorf("Expected body %q, got %q", want, w.Body.String())
+    }
+  })
+  
+  t.Run("export ndjson", func(t *testing.T) {
+    req := httptest.NewRequest("GET", "/players/export?format=ndjson", nil)
+    w := httptest.NewRecorder()
+    handler.ExportPlayers(w, req)
+    
+    if ct := w.Header().Get("Content-Type"); ct != "application/x-ndjson" {
+      t.Errorf("Expected NDJSON content type, got %q", ct)
+    }
+    if lines := strings.Count(w.Body.String(), "\n"); lines != 3 {
+      t.Errorf("Expected 3 lines, got %d", lines)
+    }
+  })
+  
+  t.Run("export rejects paging", func(t *testing.T) {
+    for _, target := range []string{"/players/export?format=xml", "/players/export?limit=10"} {
+      w := httptest.NewRecorder()
+      handler.ExportPlayers(w, httptest.NewRequest("GET", target, nil))
+      if w.Code != http.StatusBadRequest {
+        t.Errorf("%s: expected status %d, got %d", target, http.StatusBadRequest, w.Code)
+      }
+    }
+  })
+  
+  t.Run("import", func(t *testing.T) {
+    body := "Player,No,OVR\nPedri,8,86\nGavi,6,\n"
+    req := httptest.NewRequest("POST", "/players/import?map=name:Player&map=jersey_number:No&map=rating:OVR", strings.NewReader(body))
+    req.Header.Set("Content-Type", "text/csv")
+    w := httptest.NewRecorder()
+    handler.ImportPlayers(w, req)
+    
+    if w.Code != http.StatusMultiStatus {
+      t.Fatalf("Expected status %d, got %d: %s", http.StatusMultiStatus, w.Code, w.Body.String())
+    }
+    var response struct {
+      Data ImportReport `json:"data"`
+    }
+    if err := json.NewDecoder(w.Body).Decode(&response); err != nil {
+      t.Fatalf("Failed to decode response: %v", err)
+    }
+    report := response.Data
+    if report.Created != 1 || report.Rejected != 1 || len(report.Errors) != 1 || report.Errors[0].Line != 3 {
+      t.Errorf("Expected Gavi on line 3 to be rejected, got %+v", report)
+    }
+  })
+  
+  t.Run("import errors", func(t *testing.T) {
+    tests := []struct {
+      name        string
+      target      string
+      contentType string
+      body        string
+      want        int
+    }{
+      {"unknown content type", "/players/import", "text/plain", "name\n", http.StatusUnsupportedMediaType},
+      {"missing column", "/players/import", "text/csv", "name,rating\nPedri,86\n", http.StatusBadRequest},
+      {"mapping for ndjson", "/players/import?format=ndjson&map=name:Player", "", "{}\n", http.StatusBadRequest},
+    }
+    for _, tt := range tests {
+      t.Run(tt.name, func(t *testing.T) {
+        req := httptest.NewRequest("POST", tt.target, strings.NewReader(tt.body))
+        req.Header.Set("Content-Type", tt.contentType)
+        w := httptest.NewRecorder()
+        handler.ImportPlayers(w, req)
+        if w.Code != tt.want {
+          t.Errorf("Expected status %d, got %d: %s", tt.want, w.Code, w.Body.String())
+        }
+      })
+    }
+  })
+}
+
+func TestLegacyHandlers_ShareOneHandler(t *testing.T) {
+  req := httptest.NewRequest("POST", "/players", strings.NewReader(`{"name": "Legacy", "jersey_number": 42, "rating": 70}`))
+  req.Header.Set("Content-Type", "application/json")
+  w := httptest.NewRecorder()
+  CreatePlayer(w, req)
+  if w.Code != http.StatusCreated {
+    t.Fatalf("Expected status %d, got %d: %s", http.StatusCreated, w.Code, w.Body.String())
+  }
+
+  w = httptest.NewRecorder()
+  GetPlayers(w, httptest.NewRequest("GET", "/players?name=Legacy", nil))
+  if w.Code != http.StatusOK || !strings.Contains(w.Body.String(), `"name":"Legacy"`) {
+    t.Errorf("Expected the legacy list to see the created player, got %d %s", w.Code, w.Body.String())
+  }
+  if globalPlayerHandler == nil || globalPlayerHandler.service != globalPlayerService {
+    t.Errorf("Expected the legacy handlers to use one handler over the global service")
+  }
+}
+
 // Helper function to check error types (simple implementation)
 func ErrorIs(err, target error) bool {
   return err != nil && target != nil && err.Error() == target.Error()
@@ -0,0 +1,196 @@
+package main
+
+import (
+  "context"
+  "fmt"
+  "log/slog"
+  "runtime/debug"
+  "sync"
+  "sync/atomic"
+  "time"
+)
+
+const (
+  // DefaultCheckTimeout bounds how long a readiness check may take
+  DefaultCheckTimeout = 2 * time.Second
+  // DefaultDrainDelay is how long /readyz fails after a shutdown signal
+  // before the server stops accepting requests, long enough for load
+  // balancers polling every few seconds to notice
+  DefaultDrainDelay = 5 * time.Second
+)
+
+// version is set at build time with -ldflags "-X main.version=v1.4.0".
+// When it's empty the module version recorded in the binary is used.
+var version string
+
+// BuildInfo describes the running binary
+type BuildInfo struct {
+  Version   string `json:"version"`
+  Commit    string `json:"commit,omitempty"`
+  Modified  bool   `json:"modified,omitempty"`
+  GoVersion string `json:"go_version"`
+}
+
+// ReadBuildInfo reads the version, VCS revision and Go version embedded in
+// the binary by the Go toolchain
+func ReadBuildInfo() BuildInfo {
+  info := BuildInfo{Version: version}
+  build, ok := debug.ReadBuildInfo()
+  if !ok {
+    return info
+  }
+  info.GoVersion = build.GoVersion
+  if info.Version == "" && build.Main.Version != "(devel)" {
+    info.Version = build.Main.Version
+  }
+  for _, setting := range build.Settings {
+    switch setting.Key {
+    case "vcs.revision":
+      info.Commit = setting.Value
+    case "vcs.modified":
+      info.Modified = setting.Value == "true"
+    }
+  }
+  if info.Version == "" {
+    info.Version = "dev"
+  }
+  return info
+}
+
+// Liveness is reported by /livez
+type Liveness struct {
+  Service string `json:"service"`
+  BuildInfo
+  StartedAt time.Time `json:"started_at"`
+  Uptime    string    `json:"uptime"`
+}
+
+// Readiness is reported by /readyz. Checks maps each check to "ok" or the
+// reason it failed.
+type Readiness struct {
+  Ready    bool              `json:"ready"`
+  Draining bool              `json:"draining"`
+  Checks   map[string]string `json:"checks"`
+}
+
+// HealthCheck reports why a dependency can't serve traffic, or nil
+type HealthCheck func(ctx context.Context) error
+
+type namedCheck struct {
+  name  string
+  check HealthCheck
+}
+
+// Health tracks whether the service can take traffic: the checks added with
+// AddCheck, the background workers started with Go, and whether shutdown has
+// begun
+type Health struct {
+  build    BuildInfo
+  started  time.Time
+  draining atomic.Bool
+
+  mu     sync.Mutex
+  checks []namedCheck
+
+  // timeout bounds each check; replaced by tests
+  timeout time.Duration
+  // now is replaced by tests
+  now func() time.Time
+}
+
+// NewHealth creates a Health for the given binary with no checks
+func NewHealth(build BuildInfo) *Health {
+  return &Health{build: build, started: time.Now(), timeout: DefaultCheckTimeout, now: time.Now}
+}
+
+// AddCheck adds a readiness check
+func (h *Health) AddCheck(name string, check HealthCheck) {
+  h.mu.Lock()
+  defer h.mu.Unlock()
+
+  h.checks = append(h.checks, namedCheck{name, check})
+}
+
+// Go runs a background worker until it returns. A worker that panics is
+// logged and fails readiness instead of taking the process down, and one
+// that returns before draining has begun fails readiness too.
+func (h *Health) Go(wg *sync.WaitGroup, name string, run func()) {
+  var stopped atomic.Value
+  h.AddCheck(name, func(context.Context) error {
+    if reason, _ := stopped.Load().(string); reason != "" {
+      return fmt.Errorf("worker stopped: %s", reason)
+    }
+    return nil
+  })
+
+  wg.Add(1)
+  go func() {
+    defer wg.Done()
+    defer func() {
+      if r := recover(); r != nil {
+        slog.Error("Background worker panicked", "worker", name, LogKeyError, r, "stack", string(debug.Stack()))
+        stopped.Store(fmt.Sprintf("panic: %v", r))
+        return
+      }
+      if !h.Draining() {
+        slog.Error("Background worker stopped", "worker", name)
+        stopped.Store("returned")
+      }
+    }()
+    run()
+  }()
+}
+
+// Drain marks the service as shutting down, so readiness fails while
+// in-flight requests finish
+func (h *Health) Drain() {
+  h.draining.Store(true)
+}
+
+// Draining reports whether Drain has been called
+func (h *Health) Draining() bool {
+  return h.draining.Load()
+}
+
+// Live describes the running process
+func (h *Health) Live() Liveness {
+  return Liveness{
+    Service:   "player-api",
+    BuildInfo: h.build,
+    StartedAt: h.started.UTC(),
+    Uptime:    h.now().Sub(h.started).Round(time.Second).String(),
+  }
+}
+
+// Ready runs every check concurrently, each bounded by the check timeout
+func (h *Health) Ready(ctx context.Context) Readiness {
+  h.mu.Lock()
+  checks := append([]namedCheck(nil), h.checks...)
+  h.mu.Unlock()
+
+  results := make([]error, len(checks))
+  var wg sync.WaitGroup
+  for i, c := range checks {
+    wg.Add(1)
+    go func() {
+      defer wg.Done()
+      ctx, cancel := context.WithTimeout(ctx, h.timeout)
+      defer cancel()
+      results[i] = c.check(ctx)
+    }()
+  }
+  wg.Wait()
+
+  readiness := Readiness{Ready: true, Draining: h.Draining(), Checks: make(map[string]string, len(checks))}
+  for i, c := range checks {
+    readiness.Checks[c.name] = "ok"
+    if results[i] != nil {
+      readiness.Checks[c.name] = results[i].Error()
+      readiness.Ready = false
+    }
+  }
+  if readiness.Draining {
+    readiness.Ready = false
+  }
+  return readiness
+}
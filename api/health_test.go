@@ -0,0 +1,123 @@
+package main
+
+import (
+  "context"
+  "encoding/json"
+  "errors"
+  "net/http"
+  "net/http/httptest"
+  "runtime"
+  "sync"
+  "testing"
+  "time"
+)
+
+func TestReadBuildInfo(t *testing.T) {
+  info := ReadBuildInfo()
+  if info.GoVersion != runtime.Version() || info.Version == "" {
+    t.Errorf("Expected the toolchain's Go version and some version, got %+v", info)
+  }
+
+  version = "v1.4.0"
+  defer func() { version = "" }()
+  if info := ReadBuildInfo(); info.Version != "v1.4.0" {
+    t.Errorf("Expected the version set at build time, got %+v", info)
+  }
+}
+
+func TestHealth_Probes(t *testing.T) {
+  h := NewPlayerHandler(NewPlayerService())
+  h.health.timeout = 50 * time.Millisecond
+  started := h.health.started
+  h.health.now = func() time.Time { return started.Add(90 * time.Second) }
+  limiter := NewRateLimiter(map[string]RateLimit{
+    RateGroupIP:     {Requests: 1, Per: time.Minute},
+    RateGroupPublic: {Requests: 1, Per: time.Minute},
+  })
+  router := newRouter(h, limiter)
+  do := func(target string) (int, Response) {
+    w := httptest.NewRecorder()
+    router.ServeHTTP(w, httptest.NewRequest("GET", target, nil))
+    var response Response
+    json.NewDecoder(w.Body).Decode(&response)
+    return w.Code, response
+  }
+
+  // Probes, including the older /health, aren't rate limited
+  for range 3 {
+    if code, response := do("/readyz"); code != http.StatusOK {
+      t.Fatalf("Expected ready, got %d %+v", code, response)
+    }
+    if code, response := do("/health"); code != http.StatusOK {
+      t.Fatalf("Expected /health to stay up, got %d %+v", code, response)
+    }
+  }
+  code, response := do("/livez")
+  live, _ := response.Data.(map[string]any)
+  if code != http.StatusOK || live["uptime"] != "1m30s" || live["go_version"] != runtime.Version() || live["version"] == "" {
+    t.Errorf("Expected build info and uptime, got %d %+v", code, response)
+  }
+
+  // A failing or hanging check makes the service not ready
+  h.health.AddCheck("slow", func(ctx context.Context) error {
+    <-ctx.Done()
+    return ctx.Err()
+  })
+  code, response = do("/readyz")
+  checks, _ := response.Data.(map[string]any)["checks"].(map[string]any)
+  if code != http.StatusServiceUnavailable || response.Status != "error" || checks["store"] != "ok" || checks["slow"] != context.DeadlineExceeded.Error() {
+    t.Errorf("Expected the slow check to fail readiness, got %d %+v", code, response)
+  }
+}
+
+func TestHealth_DrainAndWorkers(t *testing.T) {
+  health := NewHealth(ReadBuildInfo())
+  var workers sync.WaitGroup
+  stop := make(chan struct{})
+  health.Go(&workers, "purger", func() { <-stop })
+  health.Go(&workers, "webhooks", func() { panic("boom") })
+
+  deadline := time.Now().Add(time.Second)
+  for health.Ready(t.Context()).Checks["webhooks"] == "ok" && time.Now().Before(deadline) {
+    time.Sleep(time.Millisecond)
+  }
+  readiness := health.Ready(t.Context())
+  if readiness.Ready || readiness.Checks["webhooks"] != "worker stopped: panic: boom" || readiness.Checks["purger"] != "ok" {
+    t.Errorf("Expected the panicked worker to fail readiness, got %+v", readiness)
+  }
+
+  // Workers stopped by shutdown aren't failures, but draining is
+  health = NewHealth(ReadBuildInfo())
+  health.Go(&workers, "purger", func() { <-stop })
+  health.Drain()
+  close(stop)
+  workers.Wait()
+  readiness = health.Ready(t.Context())
+  if readiness.Ready || !readiness.Draining || readiness.Checks["purger"] != "ok" {
+    t.Errorf("Expected a draining service with a cleanly stopped worker, got %+v", readiness)
+  }
+}
+
+func TestFileStore_Ping(t *testing.T) {
+  store, err := OpenFileStore(t.TempDir(), FileStoreOptions{NoSync: true})
+  if err != nil {
+    t.Fatalf("Failed to open store: %v", err)
+  }
+  if err := store.Ping(); err != nil {
+    t.Errorf("Expected an open store to be usable, got %v", err)
+  }
+  store.Close()
+  if err := store.Ping(); err == nil {
+    t.Errorf("Expected a closed store to fail")
+  }
+
+  // A service whose lock is held past the deadline isn't ready either
+  service := NewPlayerService()
+  service.mu.Lock()
+  defer service.mu.Unlock()
+  ctx, cancel := context.WithTimeout(t.Context(), 10*time.Millisecond)
+  defer cancel()
+  if err := service.Ping(ctx); !errors.Is(err, context.DeadlineExceeded) {
+    t.Errorf("Expected a timeout, got %v", err)
+  }
+}
@@ -0,0 +1,305 @@
+package main
+
+import (
+  "cmp"
+  "fmt"
+  "math"
+  "net/url"
+  "slices"
+  "time"
+)
+
+// Downsampling intervals and aggregations for rating history
+const (
+  IntervalDay  = "day"
+  IntervalWeek = "week"
+
+  AggLast = "last"
+  AggAvg  = "avg"
+)
+
+// Mover directions
+const (
+  MoversUp   = "up"
+  MoversDown = "down"
+)
+
+// Movers tuning
+const (
+  // DefaultMoversWindow is used when GET /players/movers has no from
+  DefaultMoversWindow = 7 * 24 * time.Hour
+
+  DefaultMoversLimit = 10
+  MaxMoversLimit     = 100
+)
+
+// RatingChange records one change to a player's rating. From is zero for
+// the rating a player was created with.
+type RatingChange struct {
+  Seq      int64     `json:"seq"`
+  PlayerID string    `json:"player_id"`
+  At       time.Time `json:"at"`
+  From     int8      `json:"from,omitempty"`
+  To       int8      `json:"to"`
+  Actor    string    `json:"actor"`
+}
+
+// RatingBucket is a player's rating over one day or week. Only buckets in
+// which the rating changed are reported.
+type RatingBucket struct {
+  Start   time.Time `json:"start"`
+  Rating  float64   `json:"rating"`
+  Changes int       `json:"changes"`
+}
+
+// TimeRange limits rating changes to those made between From and To
+// inclusive. A zero bound is open.
+type TimeRange struct {
+  From time.Time
+  To   time.Time
+}
+
+// contains reports whether t falls inside the range
+func (r TimeRange) contains(t time.Time) bool {
+  return (r.From.IsZero() || !t.Before(r.From)) && (r.To.IsZero() || !t.After(r.To))
+}
+
+// parseTimeRange reads the from and to query parameters. Each takes an
+// RFC 3339 timestamp or a date; a date in to covers the whole day.
+func parseTimeRange(values url.Values) (TimeRange, error) {
+  var r TimeRange
+  for _, bound := range []struct {
+    name string
+    dst  *time.Time
+    end  bool
+  }{
+    {"from", &r.From, false},
+    {"to", &r.To, true},
+  } {
+    raw := values.Get(bound.name)
+    if raw == "" {
+      continue
+    }
+    if t, err := time.Parse(time.RFC3339, raw); err == nil {
+      *bound.dst = t.UTC()
+      continue
+    }
+    day, err := time.Parse(MatchDateLayout, raw)
+    if err != nil {
+      return r, fmt.Errorf("%w: %s must be a date like 2025-05-31 or an RFC 3339 timestamp", ErrInvalidInput, bound.name)
+    }
+    if bound.end {
+      day = day.Add(24*time.Hour - time.Nanosecond)
+    }
+    *bound.dst = day
+  }
+  if !r.From.IsZero() && !r.To.IsZero() && r.From.After(r.To) {
+    return r, fmt.Errorf("%w: from is after to", ErrInvalidInput)
+  }
+  return r, nil
+}
+
+// HistoryQuery selects and optionally downsamples a player's rating history
+type HistoryQuery struct {
+  Range    TimeRange
+  Interval string
+  Agg      string
+}
+
+// parseHistoryQuery builds a HistoryQuery from query parameters
+func parseHistoryQuery(values url.Values) (HistoryQuery, error) {
+  query := HistoryQuery{Interval: values.Get("interval"), Agg: values.Get("agg")}
+
+  for param := range values {
+    switch param {
+    case "from", "to", "interval", "agg":
+    default:
+      return query, fmt.Errorf("%w: unknown query parameter %q", ErrInvalidInput, param)
+    }
+  }
+
+  switch query.Interval {
+  case "", IntervalDay, IntervalWeek:
+  default:
+    return query, fmt.Errorf("%w: interval must be %s or %s", ErrInvalidInput, IntervalDay, IntervalWeek)
+  }
+  switch {
+  case query.Agg == "" && query.Interval != "":
+    query.Agg = AggLast
+  case query.Agg != "" && query.Interval == "":
+    return query, fmt.Errorf("%w: agg needs an interval", ErrInvalidInput)
+  case query.Agg != "" && query.Agg != AggLast && query.Agg != AggAvg:
+    return query, fmt.Errorf("%w: agg must be %s or %s", ErrInvalidInput, AggLast, AggAvg)
+  }
+
+  var err error
+  query.Range, err = parseTimeRange(values)
+  return query, err
+}
+
+// bucketStart returns the start of the UTC day or ISO week (starting on
+// Monday) that t falls in
+func bucketStart(t time.Time, interval string) time.Time {
+  t = t.UTC()
+  day := time.Date(t.Year(), t.Month(), t.Day(), 0, 0, 0, 0, time.UTC)
+  if interval == IntervalWeek {
+    day = day.AddDate(0, 0, -(int(day.Weekday())+6)%7)
+  }
+  return day
+}
+
+// downsample groups rating changes by day or week. With AggLast a bucket
+// holds the rating at the end of the period, with AggAvg the mean of the
+// ratings set during it.
+func downsample(changes []RatingChange, interval, agg string) []RatingBucket {
+  buckets := make([]RatingBucket, 0)
+  sum := 0
+  for _, change := range changes {
+    start := bucketStart(change.At, interval)
+    if n := len(buckets); n == 0 || !buckets[n-1].Start.Equal(start) {
+      buckets = append(buckets, RatingBucket{Start: start})
+      sum = 0
+    }
+
+    bucket := &buckets[len(buckets)-1]
+    bucket.Changes++
+    sum += int(change.To)
+    if agg == AggAvg {
+      bucket.Rating = math.Round(float64(sum)/float64(bucket.Changes)*100) / 100
+    } else {
+      bucket.Rating = float64(change.To)
+    }
+  }
+  return buckets
+}
+
+// RatingHistory returns a player's rating changes made within r, oldest first
+func (s *PlayerService) RatingHistory(id string, r TimeRange) ([]RatingChange, error) {
+  s.mu.RLock()
+  defer s.mu.RUnlock()
+
+  if _, exists := s.store.Get(id); !exists {
+    return nil, fmt.Errorf("%w: %s", ErrPlayerNotFound, id)
+  }
+
+  changes := make([]RatingChange, 0)
+  for _, change := range s.store.History(id) {
+    if r.contains(change.At) {
+      changes = append(changes, change)
+    }
+  }
+  return changes, nil
+}
+
+// MoversQuery selects the players whose rating moved most over a window
+type MoversQuery struct {
+  Range     TimeRange
+  Direction string
+  Limit     int
+}
+
+// parseMoversQuery builds a MoversQuery from query parameters
+func parseMoversQuery(values url.Values) (MoversQuery, error) {
+  query := MoversQuery{Direction: values.Get("direction")}
+
+  for param := range values {
+    switch param {
+    case "from", "to", "direction", "limit":
+    default:
+      return query, fmt.Errorf("%w: unknown query parameter %q", ErrInvalidInput, param)
+    }
+  }
+
+  switch query.Direction {
+  case "", MoversUp, MoversDown:
+  default:
+    return query, fmt.Errorf("%w: direction must be %s or %s", ErrInvalidInput, MoversUp, MoversDown)
+  }
+
+  var err error
+  if query.Range, err = parseTimeRange(values); err != nil {
+    return query, err
+  }
+  query.Limit, err = parseLimit(values.Get("limit"), DefaultMoversLimit, MaxMoversLimit)
+  return query, err
+}
+
+// MoversReport lists the biggest movers over the window that was used
+type MoversReport struct {
+  From   time.Time     `json:"from"`
+  To     time.Time     `json:"to"`
+  Movers []RatingMover `json:"movers"`
+}
+
+// RatingMover is a player's rating change over a window
+type RatingMover struct {
+  Player Player `json:"player"`
+  From   int8   `json:"from"`
+  To     int8   `json:"to"`
+  Change int    `json:"change"`
+}
+
+// ratingMove compares a player's rating before and after a window. It
+// reports false if the rating didn't change during the window.
+func ratingMove(history []RatingChange, r TimeRange) (from, to int8, moved bool) {
+  for _, change := range history {
+    switch {
+    case !r.From.IsZero() && change.At.Before(r.From):
+      from, to = change.To, change.To
+    case r.contains(change.At):
+      if !moved {
+        moved = true
+        if change.From != 0 {
+          from = change.From
+        } else if from == 0 {
+          // Created during the window, so it moved from its first rating
+          from = change.To
+        }
+      }
+      to = change.To
+    }
+  }
+  return from, to, moved && from != to
+}
+
+// RatingMovers ranks players by how much their rating changed over the
+// window, biggest change first. A missing from defaults to
+// DefaultMoversWindow before to, and a missing to to now.
+func (s *PlayerService) RatingMovers(query MoversQuery) MoversReport {
+  s.mu.RLock()
+  defer s.mu.RUnlock()
+
+  r := query.Range
+  if r.To.IsZero() {
+    r.To = s.now().UTC()
+  }
+  if r.From.IsZero() {
+    r.From = r.To.Add(-DefaultMoversWindow)
+  }
+
+  movers := make([]RatingMover, 0)
+  s.store.Range(func(player Player) bool {
+    from, to, moved := ratingMove(s.store.History(player.ID), r)
+    change := int(to) - int(from)
+    if !moved || (query.Direction == MoversUp && change < 0) || (query.Direction == MoversDown && change > 0) {
+      return true
+    }
+    movers = append(movers, RatingMover{Player: player, From: from, To: to, Change: change})
+    return true
+  })
+
+  slices.SortFunc(movers, func(a, b RatingMover) int {
+    if c := cmp.Compare(abs(b.Change), abs(a.Change)); c != 0 {
+      return c
+    }
+    return compareIDs(a.Player.ID, b.Player.ID)
+  })
+  return MoversReport{From: r.From, To: r.To, Movers: movers[:min(query.Limit, len(movers))]}
+}
+
+func abs(n int) int {
+  if n < 0 {
+    return -n
+  }
+  return n
+}
@@ -0,0 +1,277 @@
+package main
+
+import (
+  "encoding/json"
+  "fmt"
+  "net/http"
+  "net/http/httptest"
+  "net/url"
+  "strings"
+  "testing"
+  "time"
+)
+
+// fakeClock replaces the service clock with one the test moves by hand
+func fakeClock(service *PlayerService, start time.Time) *time.Time {
+  now := start
+  service.now = func() time.Time { return now }
+  return &now
+}
+
+// setRating changes a player's rating and nothing else
+func setRating(t *testing.T, service *PlayerService, actor, id string, rating int8) {
+  t.Helper()
+  player, err := service.GetPlayerByID(id)
+  if err != nil {
+    t.Fatalf("Failed to get player: %v", err)
+  }
+  req := player.ToRequest()
+  req.Rating = rating
+  if _, err := service.UpdatePlayer(WithActor(t.Context(), actor), id, req, AnyVersion); err != nil {
+    t.Fatalf("Failed to update rating: %v", err)
+  }
+}
+
+func TestPlayerService_RatingHistory(t *testing.T) {
+  service := NewPlayerService()
+  now := fakeClock(service, time.Date(2025, 3, 3, 9, 0, 0, 0, time.UTC))
+
+  player, err := service.CreatePlayer(WithActor(t.Context(), "scout"), PlayerRequest{Name: "Pedri", JerseyNumber: 8, Rating: 80})
+  if err != nil {
+    t.Fatalf("Failed to create player: %v", err)
+  }
+  *now = now.Add(time.Hour)
+  setRating(t, service, "alice", player.ID, 84)
+
+  // Changes that leave the rating alone aren't recorded
+  if _, err := service.UpdatePlayer(t.Context(), player.ID, PlayerRequest{Name: "Pedri González", JerseyNumber: 8, Rating: 84}, AnyVersion); err != nil {
+    t.Fatalf("Failed to rename player: %v", err)
+  }
+  *now = now.Add(24 * time.Hour)
+  if _, err := service.PatchPlayer(t.Context(), player.ID, AnyVersion, func(req PlayerRequest) (PlayerRequest, error) {
+    req.Rating = 86
+    return req, nil
+  }); err != nil {
+    t.Fatalf("Failed to patch player: %v", err)
+  }
+
+  changes, err := service.RatingHistory(player.ID, TimeRange{})
+  if err != nil {
+    t.Fatalf("Failed to get history: %v", err)
+  }
+  want := []RatingChange{
+    {Seq: 1, PlayerID: player.ID, At: time.Date(2025, 3, 3, 9, 0, 0, 0, time.UTC), To: 80, Actor: "scout"},
+    {Seq: 2, PlayerID: player.ID, At: time.Date(2025, 3, 3, 10, 0, 0, 0, time.UTC), From: 80, To: 84, Actor: "alice"},
+    {Seq: 3, PlayerID: player.ID, At: time.Date(2025, 3, 4, 10, 0, 0, 0, time.UTC), From: 84, To: 86, Actor: AnonymousActor},
+  }
+  if len(changes) != len(want) {
+    t.Fatalf("Expected %d changes, got %+v", len(want), changes)
+  }
+  for i := range want {
+    if changes[i] != want[i] {
+      t.Errorf("Change %d: expected %+v, got %+v", i, want[i], changes[i])
+    }
+  }
+
+  ranged, _ := service.RatingHistory(player.ID, TimeRange{From: time.Date(2025, 3, 3, 10, 0, 0, 0, time.UTC), To: time.Date(2025, 3, 3, 23, 0, 0, 0, time.UTC)})
+  if len(ranged) != 1 || ranged[0].Seq != 2 {
+    t.Errorf("Expected only the change at 10:00 in range, got %+v", ranged)
+  }
+
+  // A failed write records nothing
+  if _, err := service.UpdatePlayer(t.Context(), player.ID, PlayerRequest{Name: "Pedri", JerseyNumber: 8, Rating: 90}, 1); err == nil {
+    t.Fatalf("Expected a version mismatch")
+  }
+  if changes, _ := service.RatingHistory(player.ID, TimeRange{}); len(changes) != 3 {
+    t.Errorf("Expected 3 changes after a failed update, got %d", len(changes))
+  }
+}
+
+func TestDownsample(t *testing.T) {
+  at := func(day, hour int) time.Time { return time.Date(2025, 3, day, hour, 0, 0, 0, time.UTC) }
+  // 2025-03-03 is a Monday
+  changes := []RatingChange{
+    {At: at(3, 9), To: 80},
+    {At: at(3, 18), To: 83},
+    {At: at(5, 12), To: 84},
+    {At: at(10, 8), To: 90},
+  }
+
+  tests := []struct {
+    interval string
+    agg      string
+    want     []RatingBucket
+  }{
+    {IntervalDay, AggLast, []RatingBucket{{at(3, 0), 83, 2}, {at(5, 0), 84, 1}, {at(10, 0), 90, 1}}},
+    {IntervalDay, AggAvg, []RatingBucket{{at(3, 0), 81.5, 2}, {at(5, 0), 84, 1}, {at(10, 0), 90, 1}}},
+    {IntervalWeek, AggLast, []RatingBucket{{at(3, 0), 84, 3}, {at(10, 0), 90, 1}}},
+    {IntervalWeek, AggAvg, []RatingBucket{{at(3, 0), 82.33, 3}, {at(10, 0), 90, 1}}},
+  }
+  for _, tt := range tests {
+    t.Run(tt.interval+"/"+tt.agg, func(t *testing.T) {
+      got := downsample(changes, tt.interval, tt.agg)
+      if len(got) != len(tt.want) {
+        t.Fatalf("Expected %+v, got %+v", tt.want, got)
+      }
+      for i := range got {
+        if !got[i].Start.Equal(tt.want[i].Start) || got[i].Rating != tt.want[i].Rating || got[i].Changes != tt.want[i].Changes {
+          t.Errorf("Bucket %d: expected %+v, got %+v", i, tt.want[i], got[i])
+        }
+      }
+    })
+  }
+}
+
+func TestPlayerService_RatingMovers(t *testing.T) {
+  service := NewPlayerService()
+  start := time.Date(2025, 3, 1, 12, 0, 0, 0, time.UTC)
+  now := fakeClock(service, start)
+
+  // Messi and Ronaldo move before and during the window, Neymar only before it
+  setRating(t, service, "", "1", 90)
+  setRating(t, service, "", "3", 80)
+  *now = start.AddDate(0, 0, 10)
+  setRating(t, service, "", "1", 94)
+  setRating(t, service, "", "2", 90)
+  setRating(t, service, "", "1", 96)
+  created, _ := service.CreatePlayer(t.Context(), PlayerRequest{Name: "Yamal", JerseyNumber: 19, Rating: 70})
+  setRating(t, service, "", created.ID, 72)
+
+  report := service.RatingMovers(MoversQuery{Limit: 10})
+  if !report.To.Equal(*now) || !report.From.Equal(now.Add(-DefaultMoversWindow)) {
+    t.Errorf("Expected the default window to end now, got %v to %v", report.From, report.To)
+  }
+
+  want := []struct {
+    id       string
+    from, to int8
+  }{{"2", 98, 90}, {"1", 90, 96}, {created.ID, 70, 72}}
+  if len(report.Movers) != len(want) {
+    t.Fatalf("Expected %d movers, got %+v", len(want), report.Movers)
+  }
+  for i, w := range want {
+    m := report.Movers[i]
+    if m.Player.ID != w.id || m.From != w.from || m.To != w.to || m.Change != int(w.to)-int(w.from) {
+      t.Errorf("Mover %d: expected %+v, got %+v", i, w, m)
+    }
+  }
+
+  if up := service.RatingMovers(MoversQuery{Direction: MoversUp, Limit: 1}); len(up.Movers) != 1 || up.Movers[0].Player.ID != "1" {
+    t.Errorf("Expected Messi as the biggest riser, got %+v", up.Movers)
+  }
+  all := service.RatingMovers(MoversQuery{Range: TimeRange{From: start.Add(-time.Hour)}, Direction: MoversDown, Limit: 10})
+  if len(all.Movers) != 3 || all.Movers[0].Player.ID != "3" || all.Movers[0].Change != -15 || all.Movers[2].Change != -3 {
+    t.Errorf("Expected Neymar, Ronaldo and Messi falling since the start, got %+v", all.Movers)
+  }
+}
+
+func TestFileStore_PersistsHistory(t *testing.T) {
+  dir := t.TempDir()
+
+  service := NewPlayerServiceWithStore(openTestFileStore(t, dir, 2))
+  player, err := service.CreatePlayer(WithActor(t.Context(), "scout"), PlayerRequest{Name: "Pedri", JerseyNumber: 8, Rating: 80})
+  if err != nil {
+    t.Fatalf("Failed to create player: %v", err)
+  }
+  for _, rating := range []int8{82, 84, 86} {
+    setRating(t, service, "alice", player.ID, rating)
+  }
+  if err := service.Close(); err != nil {
+    t.Fatalf("Failed to close service: %v", err)
+  }
+
+  service = NewPlayerServiceWithStore(openTestFileStore(t, dir, 2))
+  defer service.Close()
+
+  changes, err := service.RatingHistory(player.ID, TimeRange{})
+  if err != nil || len(changes) != 4 {
+    t.Fatalf("Expected 4 changes after a restart, got %+v (%v)", changes, err)
+  }
+  if changes[0].Actor != "scout" || changes[3].From != 84 || changes[3].To != 86 || changes[3].Seq != 4 {
+    t.Errorf("Unexpected history after restart: %+v", changes)
+  }
+
+  setRating(t, service, "alice", player.ID, 88)
+  if changes, _ := service.RatingHistory(player.ID, TimeRange{}); len(changes) != 5 || changes[4].Seq != 5 {
+    t.Errorf("Expected sequence numbers to continue after restart, got %+v", changes)
+  }
+}
+
+func TestStoreData_ApplyRatingChangesIdempotent(t *testing.T) {
+  data := newStoreData()
+  batch := StoreBatch{RatingChanges: []RatingChange{{Seq: 1, PlayerID: "1", To: 80}, {Seq: 2, PlayerID: "1", From: 80, To: 82}}}
+  data.apply(batch)
+  data.apply(batch)
+  if len(data.History("1")) != 2 || data.RatingSeq() != 2 {
+    t.Errorf("Expected replaying a batch to keep 2 changes, got %+v", data.History("1"))
+  }
+}
+
+func TestPlayerHandler_History(t *testing.T) {
+  service := NewPlayerService()
+  now := fakeClock(service, time.Date(2025, 3, 3, 9, 0, 0, 0, time.UTC))
+  handler := NewPlayerHandler(service)
+
+  mux := http.NewServeMux()
+  mux.HandleFunc("PUT /players/{id}", handler.UpdatePlayer)
+  mux.HandleFunc("GET /players/{id}/history", handler.GetPlayerHistory)
+  mux.HandleFunc("GET /players/movers", handler.GetRatingMovers)
+  server := ActorMiddleware(mux)
+
+  do := func(method, target, body string) *httptest.ResponseRecorder {
+    req := httptest.NewRequest(method, target, strings.NewReader(body))
+    req.Header.Set(ActorHeader, "  coach  ")
+    w := httptest.NewRecorder()
+    server.ServeHTTP(w, req)
+    return w
+  }
+
+  for _, rating := range []int{97, 96} {
+    body := fmt.Sprintf(`{"name": "Messi", "jersey_number": 10, "rating": %d}`, rating)
+    if w := do("PUT", "/players/1", body); w.Code != http.StatusOK {
+      t.Fatalf("Expected status %d, got %d: %s", http.StatusOK, w.Code, w.Body.String())
+    }
+    *now = now.Add(time.Hour)
+  }
+
+  w := do("GET", "/players/1/history?from=2025-03-03&to=2025-03-03", "")
+  var history struct {
+    Data []RatingChange `json:"data"`
+  }
+  if err := json.NewDecoder(w.Body).Decode(&history); err != nil {
+    t.Fatalf("Failed to decode response: %v", err)
+  }
+  if len(history.Data) != 2 || history.Data[0].Actor != "coach" || history.Data[1].To != 96 {
+    t.Errorf("Expected two changes by coach, got %+v", history.Data)
+  }
+
+  w = do("GET", "/players/1/history?interval=day&agg=avg", "")
+  var buckets struct {
+    Data []RatingBucket `json:"data"`
+  }
+  if err := json.NewDecoder(w.Body).Decode(&buckets); err != nil {
+    t.Fatalf("Failed to decode response: %v", err)
+  }
+  if len(buckets.Data) != 1 || buckets.Data[0].Rating != 96.5 {
+    t.Errorf("Expected one daily bucket averaging 96.5, got %+v", buckets.Data)
+  }
+
+  tests := []struct {
+    target string
+    want   int
+  }{
+    {"/players/99/history", http.StatusNotFound},
+    {"/players/1/history?interval=month", http.StatusBadRequest},
+    {"/players/1/history?agg=avg", http.StatusBadRequest},
+    {"/players/1/history?from=" + url.QueryEscape("2025-03-04T00:00:00Z") + "&to=2025-03-03", http.StatusBadRequest},
+    {"/players/movers?direction=sideways", http.StatusBadRequest},
+    {"/players/movers?from=2025-03-01", http.StatusOK},
+  }
+  for _, tt := range tests {
+    t.Run(tt.target, func(t *testing.T) {
+      if w := do("GET", tt.target, ""); w.Code != tt.want {
+        t.Errorf("Expected status %d, got %d: %s", tt.want, w.Code, w.Body.String())
+      }
+    })
+  }
+}
@@ -0,0 +1,443 @@
+package main
+
+import (
+  "bufio"
+  "bytes"
+  "context"
+  "encoding/csv"
+  "encoding/json"
+  "errors"
+  "fmt"
+  "io"
+  "iter"
+  "mime"
+  "strconv"
+  "strings"
+  "time"
+)
+
+// Import/export formats
+const (
+  FormatCSV    = "csv"
+  FormatNDJSON = "ndjson"
+)
+
+// Import tuning
+const (
+  // importChunkSize is the number of rows committed in one transaction
+  importChunkSize = 500
+
+  // MaxImportErrors caps the rejected rows listed in an import report
+  MaxImportErrors = 1000
+
+  // maxNDJSONLine is the longest line accepted in an NDJSON import
+  maxNDJSONLine = 1 << 20
+
+  // exportPageSize is the number of players an export fetches, writes and
+  // flushes at a time
+  exportPageSize = 1000
+
+  // transferTimeout replaces the server's read and write timeouts for
+  // imports and exports, which can take longer than a normal request
+  transferTimeout = 5 * time.Minute
+)
+
+// exportColumns are the CSV columns written by an export, in order
+var exportColumns = []string{"id", "name", "jersey_number", "rating", "team_id", "version"}
+
+// importColumns are the player fields read from each imported row
+var importColumns = []string{"name", "jersey_number", "rating", "team_id"}
+
+// optionalImportColumns may be left out of a CSV header unless they are mapped
+var optionalImportColumns = map[string]bool{"team_id": true}
+
+// formatForContentType maps an import Content-Type onto a format
+func formatForContentType(contentType string) string {
+  mediaType, _, _ := mime.ParseMediaType(contentType)
+  switch mediaType {
+  case "text/csv":
+    return FormatCSV
+  case "application/x-ndjson", "application/ndjson", "application/jsonl":
+    return FormatNDJSON
+  }
+  return ""
+}
+
+// parseColumnMapping parses map=field:Column parameters into the CSV header
+// used for each player field. Fields that aren't mapped use their own name.
+func parseColumnMapping(mappings []string) (map[string]string, error) {
+  columns := make(map[string]string, len(importColumns))
+  for _, field := range importColumns {
+    columns[field] = field
+  }
+
+  for _, mapping := range mappings {
+    field, column, ok := strings.Cut(mapping, ":")
+    field, column = strings.TrimSpace(field), strings.TrimSpace(column)
+    if !ok || column == "" {
+      return nil, fmt.Errorf("%w: column mapping %q must look like field:Column", ErrInvalidInput, mapping)
+    }
+    if _, known := columns[field]; !known {
+      return nil, fmt.Errorf("%w: cannot map column to unknown field %q", ErrInvalidInput, field)
+    }
+    columns[field] = column
+  }
+  return columns, nil
+}
+
+// importRow is one parsed row of an import. Err is set when the row could
+// not be turned into a player request.
+type importRow struct {
+  Line int
+  Req  PlayerRequest
+  Err  error
+}
+
+// rowReader yields import rows one at a time. It returns io.EOF at the end
+// of the input and any other error only when reading can't continue.
+type rowReader interface {
+  Next() (importRow, error)
+}
+
+// csvRowReader reads players from CSV with a header row
+type csvRowReader struct {
+  r       *csv.Reader
+  indexes map[string]int
+}
+
+// newCSVRowReader reads the header row and resolves the column mapping
+// against it. Header names are matched case-insensitively.
+func newCSVRowReader(body io.Reader, columns map[string]string) (*csvRowReader, error) {
+  r := csv.NewReader(body)
+  r.FieldsPerRecord = -1
+  r.ReuseRecord = true
+
+  header, err := r.Read()
+  if err == io.EOF {
+    return nil, fmt.Errorf("%w: CSV header row is missing", ErrInvalidInput)
+  }
+  if err != nil {
+    return nil, fmt.Errorf("%w: %v", ErrInvalidInput, err)
+  }
+
+  positions := make(map[string]int, len(header))
+  for i, name := range header {
+    if i == 0 {
+      // Spreadsheet exports often start with a byte order mark
+      name = strings.TrimPrefix(name, "\ufeff")
+    }
+    key := strings.ToLower(strings.TrimSpace(name))
+    if _, dup := positions[key]; !dup {
+      positions[key] = i
+    }
+  }
+
+  indexes := make(map[string]int, len(columns))
+  var missing []string
+  for _, field := range importColumns {
+    i, ok := positions[strings.ToLower(columns[field])]
+    if !ok && optionalImportColumns[field] && columns[field] == field {
+      continue
+    }
+    if !ok {
+      column := strconv.Quote(columns[field])
+      if columns[field] != field {
+        column += " for " + field
+      }
+      missing = append(missing, column)
+      continue
+    }
+    indexes[field] = i
+  }
+  if len(missing) > 0 {
+    return nil, fmt.Errorf("%w: CSV header is missing column %s", ErrInvalidInput, strings.Join(missing, ", "))
+  }
+
+  return &csvRowReader{r: r, indexes: indexes}, nil
+}
+
+func (cr *csvRowReader) Next() (importRow, error) {
+  record, err := cr.r.Read()
+  if err != nil {
+    var parseErr *csv.ParseError
+    if errors.As(err, &parseErr) {
+      // The reader resynchronises on the next line, so only this row is lost
+      return importRow{Line: parseErr.StartLine, Err: fmt.Errorf("%w: %v", ErrInvalidInput, parseErr.Err)}, nil
+    }
+    return importRow{}, err
+  }
+
+  line, _ := cr.r.FieldPos(0)
+  row := importRow{Line: line}
+
+  field := func(name string) string {
+    if i, ok := cr.indexes[name]; ok && i < len(record) {
+      return csvUnescape(strings.TrimSpace(record[i]))
+    }
+    return ""
+  }
+  row.Req.Name = field("name")
+  row.Req.TeamID = field("team_id")
+  for _, number := range []struct {
+    name string
+    dst  *int8
+  }{
+    {"jersey_number", &row.Req.JerseyNumber},
+    {"rating", &row.Req.Rating},
+  } {
+    raw := field(number.name)
+    n, err := strconv.ParseInt(raw, 10, 8)
+    if err != nil {
+      row.Err = fmt.Errorf("%w: %s %q is not a number between 1 and 99", ErrInvalidInput, number.name, raw)
+      return row, nil
+    }
+    *number.dst = int8(n)
+  }
+  return row, nil
+}
+
+// ndjsonRowReader reads one JSON player object per line. Blank lines are skipped.
+type ndjsonRowReader struct {
+  scanner *bufio.Scanner
+  line    int
+}
+
+func newNDJSONRowReader(body io.Reader) *ndjsonRowReader {
+  scanner := bufio.NewScanner(body)
+  scanner.Buffer(make([]byte, 0, 64*1024), maxNDJSONLine)
+  return &ndjsonRowReader{scanner: scanner}
+}
+
+func (nr *ndjsonRowReader) Next() (importRow, error) {
+  for nr.scanner.Scan() {
+    nr.line++
+    raw := bytes.TrimSpace(nr.scanner.Bytes())
+    if len(raw) == 0 {
+      continue
+    }
+
+    row := importRow{Line: nr.line}
+    if err := json.Unmarshal(raw, &row.Req); err != nil {
+      row.Err = fmt.Errorf("%w: %v", ErrInvalidInput, err)
+    }
+    return row, nil
+  }
+  if err := nr.scanner.Err(); err != nil {
+    if errors.Is(err, bufio.ErrTooLong) {
+      return importRow{}, fmt.Errorf("%w: line %d is longer than %d bytes", ErrInvalidInput, nr.line+1, maxNDJSONLine)
+    }
+    return importRow{}, err
+  }
+  return importRow{}, io.EOF
+}
+
+// ImportError reports a rejected import row
+type ImportError struct {
+  Line  int    `json:"line"`
+  Error string `json:"error"`
+}
+
+// ImportReport summarises an import
+type ImportReport struct {
+  Rows            int           `json:"rows"`
+  Created         int           `json:"created"`
+  Rejected        int           `json:"rejected"`
+  Errors          []ImportError `json:"errors"`
+  ErrorsTruncated bool          `json:"errors_truncated,omitempty"`
+}
+
+// reject records a rejected row in the report
+func (ir *ImportReport) reject(line int, err error) {
+  ir.Rejected++
+  if len(ir.Errors) == MaxImportErrors {
+    ir.ErrorsTruncated = true
+    return
+  }
+  ir.Errors = append(ir.Errors, ImportError{Line: line, Error: err.Error()})
+}
+
+// ImportRows reads rows until the end of the input and creates a player for
+// every valid one. Rows are committed in chunks, so a large import is not
+// held in memory and doesn't hold the write lock for its whole duration.
+// Rejected rows are listed in the report; the returned error is only set
+// when the input could not be read to the end, and the report then covers
+// the rows handled before that.
+func (s *PlayerService) ImportRows(ctx context.Context, rows rowReader) (ImportReport, error) {
+  report := ImportReport{Errors: make([]ImportError, 0)}
+  chunk := make([]importRow, 0, importChunkSize)
+
+  // Invalid rows stay in the chunk so the report lists errors in line order
+  flush := func() {
+    reqs := make([]PlayerRequest, 0, len(chunk))
+    for _, row := range chunk {
+      if row.Err == nil {
+        reqs = append(reqs, row.Req)
+      }
+    }
+    errs := s.ImportPlayers(ctx, reqs)
+    for _, row := range chunk {
+      if row.Err == nil {
+        row.Err, errs = errs[0], errs[1:]
+      }
+      if row.Err != nil {
+        report.reject(row.Line, row.Err)
+      } else {
+        report.Created++
+      }
+    }
+    chunk = chunk[:0]
+  }
+
+  for {
+    row, err := rows.Next()
+    if err == io.EOF {
+      break
+    }
+    if err != nil {
+      flush()
+      return report, err
+    }
+
+    report.Rows++
+    if row.Err == nil {
+      row.Err = row.Req.Validate()
+    }
+    chunk = append(chunk, row)
+    if len(chunk) == importChunkSize {
+      flush()
+    }
+  }
+  flush()
+  return report, nil
+}
+
+// ImportPlayers creates players in a single transaction. Requests that fail
+// validation or uniqueness are skipped and the rest are committed together.
+// The returned slice holds the error for each request, nil when it was created.
+func (s *PlayerService) ImportPlayers(ctx context.Context, reqs []PlayerRequest) []error {
+  if len(reqs) == 0 {
+    return nil
+  }
+  ctx, span := StartSpan(ctx, "PlayerService.ImportPlayers")
+  defer span.End()
+  span.SetAttribute("players", len(reqs))
+
+  s.mu.Lock()
+  defer s.mu.Unlock()
+
+  errs := make([]error, len(reqs))
+  tx := s.begin(ctx)
+  for i, req := range reqs {
+    // A failed create stages nothing, so the transaction stays usable
+    _, errs[i] = tx.create(req)
+  }
+
+  if err := tx.commit(); err != nil {
+    span.RecordError(err)
+    for i := range errs {
+      if errs[i] == nil {
+        errs[i] = err
+      }
+    }
+  }
+  return errs
+}
+
+// ExportPages yields the players matching query pageSize at a time,
+// following the keyset cursor, so an export never holds every player at
+// once. Players changed between pages are seen as they are when their page
+// is read.
+func (s *PlayerService) ExportPages(query PlayerQuery, pageSize int) iter.Seq[[]Player] {
+  return func(yield func([]Player) bool) {
+    query.Limit, query.Cursor = pageSize, nil
+    for {
+      page := s.QueryPlayers(query)
+      if len(page.Players) > 0 && !yield(page.Players) {
+        return
+      }
+      if page.next == nil {
+        return
+      }
+      query.Cursor = page.next
+    }
+  }
+}
+
+// formulaPrefixes start cells that spreadsheets evaluate as formulas
+const formulaPrefixes = "=+-@\t\r"
+
+// csvSafe prefixes text that a spreadsheet would run as a formula with a
+// quote, which spreadsheets show as text; csvUnescape undoes it on import
+func csvSafe(s string) string {
+  if s != "" && strings.ContainsRune(formulaPrefixes, rune(s[0])) {
+    return "'" + s
+  }
+  return s
+}
+
+// csvUnescape removes the quote csvSafe adds
+func csvUnescape(s string) string {
+  if len(s) > 1 && s[0] == '\'' && strings.ContainsRune(formulaPrefixes, rune(s[1])) {
+    return s[1:]
+  }
+  return s
+}
+
+// exportRow renders a player as CSV fields in exportColumns order
+func exportRow(p Player) []string {
+  return []string{
+    csvSafe(p.ID),
+    csvSafe(p.Name),
+    strconv.Itoa(int(p.JerseyNumber)),
+    strconv.Itoa(int(p.Rating)),
+    csvSafe(p.TeamID),
+    strconv.FormatInt(p.Version, 10),
+  }
+}
+
+// writeCSVExport writes players as CSV with a header row, calling flush
+// after every page so the client receives data as it goes. It returns the
+// number of players written.
+func writeCSVExport(w io.Writer, pages iter.Seq[[]Player], flush func()) (int, error) {
+  cw := csv.NewWriter(w)
+  if err := cw.Write(exportColumns); err != nil {
+    return 0, err
+  }
+  written := 0
+  for players := range pages {
+    for _, player := range players {
+      if err := cw.Write(exportRow(player)); err != nil {
+        return written, err
+      }
+    }
+    written += len(players)
+    cw.Flush()
+    if err := cw.Error(); err != nil {
+      return written, err
+    }
+    flush()
+  }
+  cw.Flush()
+  return written, cw.Error()
+}
+
+// writeNDJSONExport writes players as one JSON object per line, calling
+// flush after every page. It returns the number of players written.
+func writeNDJSONExport(w io.Writer, pages iter.Seq[[]Player], flush func()) (int, error) {
+  bw := bufio.NewWriter(w)
+  encoder := json.NewEncoder(bw)
+  written := 0
+  for players := range pages {
+    for _, player := range players {
+      if err := encoder.Encode(player); err != nil {
+        return written, err
+      }
+    }
+    written += len(players)
+    if err := bw.Flush(); err != nil {
+      return written, err
+    }
+    flush()
+  }
+  return written, bw.Flush()
+}
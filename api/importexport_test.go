@@ -0,0 +1,175 @@
+package main
+
+import (
+  "bytes"
+  "fmt"
+  "strings"
+  "testing"
+)
+
+func TestPlayerService_ImportCSV(t *testing.T) {
+  service := NewPlayerService()
+  columns, err := parseColumnMapping([]string{"name:Full Name", "jersey_number: Shirt"})
+  if err != nil {
+    t.Fatalf("Failed to parse mapping: %v", err)
+  }
+
+  input := "\ufeffFull Name,Club,SHIRT,rating\n" +
+    "Pedri,Barcelona,8,86\n" +
+    "Gavi,Barcelona,six,83\n" +
+    "\n" +
+    "Messi,Inter Miami,10,95\n" +
+    "\"Ga\"vi,Barcelona,6,83\n" +
+    "Lamine Yamal,Barcelona,19,100\n" +
+    "\"Frenkie de Jong\",Barcelona,21,85\n"
+
+  rows, err := newCSVRowReader(strings.NewReader(input), columns)
+  if err != nil {
+    t.Fatalf("Failed to read header: %v", err)
+  }
+  report, err := service.ImportRows(t.Context(), rows)
+  if err != nil {
+    t.Fatalf("Import failed: %v", err)
+  }
+
+  if report.Rows != 6 || report.Created != 2 || report.Rejected != 4 {
+    t.Errorf("Expected 6 rows, 2 created, 4 rejected, got %+v", report)
+  }
+
+  // Bad number, duplicate of a seeded player, bare quote, rating out of range
+  wantLines := []int{3, 5, 6, 7}
+  if len(report.Errors) != len(wantLines) {
+    t.Fatalf("Expected %d errors, got %v", len(wantLines), report.Errors)
+  }
+  for i, line := range wantLines {
+    if report.Errors[i].Line != line {
+      t.Errorf("Error %d: expected line %d, got %d (%s)", i, line, report.Errors[i].Line, report.Errors[i].Error)
+    }
+  }
+
+  page := service.QueryPlayers(PlayerQuery{Limit: 10, Sort: []SortKey{{Field: "id"}}})
+  if page.Total != 5 || page.Players[3].Name != "Pedri" || page.Players[4].Name != "Frenkie de Jong" {
+    t.Errorf("Expected Pedri and Frenkie de Jong to be imported, got %v", page.Players)
+  }
+}
+
+func TestNewCSVRowReader_MissingColumn(t *testing.T) {
+  columns, _ := parseColumnMapping([]string{"rating:OVR"})
+  _, err := newCSVRowReader(strings.NewReader("name,jersey_number,rating\n"), columns)
+  if err == nil || !strings.Contains(err.Error(), `"OVR"`) {
+    t.Errorf("Expected missing OVR column error, got %v", err)
+  }
+
+  if _, err := parseColumnMapping([]string{"club:Team"}); err == nil {
+    t.Errorf("Expected mapping to an unknown field to fail")
+  }
+}
+
+func TestPlayerService_ImportNDJSON(t *testing.T) {
+  service := NewPlayerService()
+  input := `{"name": "Pedri", "jersey_number": 8, "rating": 86}
+
+{"name": "Gavi", "jersey_number": 6
+{"name": "Gavi", "jersey_number": 6, "rating": 83}
+{"name": "Gavi", "jersey_number": 6, "rating": 83}
+{"name": "", "jersey_number": 9, "rating": 80}
+`
+
+  report, err := service.ImportRows(t.Context(), newNDJSONRowReader(strings.NewReader(input)))
+  if err != nil {
+    t.Fatalf("Import failed: %v", err)
+  }
+  if report.Rows != 5 || report.Created != 2 {
+    t.Errorf("Expected 5 rows and 2 created, got %+v", report)
+  }
+
+  wantLines := []int{3, 5, 6}
+  if len(report.Errors) != len(wantLines) {
+    t.Fatalf("Expected %d errors, got %v", len(wantLines), report.Errors)
+  }
+  for i, line := range wantLines {
+    if report.Errors[i].Line != line {
+      t.Errorf("Error %d: expected line %d, got %d (%s)", i, line, report.Errors[i].Line, report.Errors[i].Error)
+    }
+  }
+}
+
+func TestPlayerService_ImportLarge(t *testing.T) {
+  service := NewPlayerService()
+
+  // Spans several chunks, with a duplicate that is only caught across chunks
+  var sb strings.Builder
+  sb.WriteString("name,jersey_number,rating\n")
+  for i := 0; i < 2*importChunkSize; i++ {
+    fmt.Fprintf(&sb, "Player %d,%d,%d\n", i, i%99+1, 70)
+  }
+  sb.WriteString("Player 0,1,70\n")
+
+  columns, _ := parseColumnMapping(nil)
+  rows, err := newCSVRowReader(strings.NewReader(sb.String()), columns)
+  if err != nil {
+    t.Fatalf("Failed to read header: %v", err)
+  }
+  report, err := service.ImportRows(t.Context(), rows)
+  if err != nil {
+    t.Fatalf("Import failed: %v", err)
+  }
+  if report.Created != 2*importChunkSize || report.Rejected != 1 {
+    t.Errorf("Expected %d created and 1 rejected, got %+v", 2*importChunkSize, report)
+  }
+  if len(report.Errors) != 1 || report.Errors[0].Line != 2*importChunkSize+2 {
+    t.Errorf("Expected the duplicate on line %d, got %v", 2*importChunkSize+2, report.Errors)
+  }
+}
+
+func TestExport_RoundTrip(t *testing.T) {
+  source := NewPlayerService()
+  for _, name := range []string{`Frenkie "FdJ", de Jong`, `=HYPERLINK("http://evil.example")`, "@SUM(A1)"} {
+    if _, err := source.CreatePlayer(t.Context(), PlayerRequest{Name: name, JerseyNumber: 21, Rating: 85}); err != nil {
+      t.Fatalf("Failed to create player: %v", err)
+    }
+  }
+  query := PlayerQuery{Limit: 10, Sort: []SortKey{{Field: "id"}}}
+  players := source.QueryPlayers(query).Players
+  // Pages of two exercise the keyset cursor
+  pages := source.ExportPages(query, 2)
+
+  for _, format := range []string{FormatCSV, FormatNDJSON} {
+    t.Run(format, func(t *testing.T) {
+      var buf bytes.Buffer
+      var rows rowReader
+      if format == FormatCSV {
+        if n, err := writeCSVExport(&buf, pages, func() {}); err != nil || n != len(players) {
+          t.Fatalf("Expected %d players exported, got %d (%v)", len(players), n, err)
+        }
+        // Spreadsheets show formula-like cells as text
+        if !strings.Contains(buf.String(), `"'=HYPERLINK(""http://evil.example"")"`) || !strings.Contains(buf.String(), "'@SUM(A1)") {
+          t.Fatalf("Expected formula cells to be quoted, got:\n%s", buf.String())
+        }
+        columns, _ := parseColumnMapping(nil)
+        var err error
+        if rows, err = newCSVRowReader(&buf, columns); err != nil {
+          t.Fatalf("Failed to read exported header: %v", err)
+        }
+      } else {
+        if n, err := writeNDJSONExport(&buf, pages, func() {}); err != nil || n != len(players) {
+          t.Fatalf("Expected %d players exported, got %d (%v)", len(players), n, err)
+        }
+        rows = newNDJSONRowReader(&buf)
+      }
+
+      target := NewPlayerServiceWithStore(NewMemoryStore())
+      report, err := target.ImportRows(t.Context(), rows)
+      if err != nil || report.Created != len(players) {
+        t.Fatalf("Expected %d players imported, got %+v (%v)", len(players), report, err)
+      }
+
+      imported := target.QueryPlayers(query).Players
+      for i := range players {
+        if players[i].ToRequest() != imported[i].ToRequest() {
+          t.Errorf("Player %d: expected %+v, got %+v", i, players[i], imported[i])
+        }
+      }
+    })
+  }
+}
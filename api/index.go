@@ -0,0 +1,280 @@
+package main
+
+import (
+  "math"
+)
+
+// nameJerseyKey is the uniqueness key of a player without a team
+type nameJerseyKey struct {
+  name   string
+  jersey int8
+}
+
+// teamJerseyKey is the uniqueness key of a player on a team
+type teamJerseyKey struct {
+  teamID string
+  jersey int8
+}
+
+// playerIndexes are secondary indexes over the players, teams and matches
+// in the store. PlayerService updates them on every mutation while holding its
+// write lock.
+//
+// Ratings are int8, so the ordered rating index is an array with one bucket
+// per possible rating: updates are O(1) and a range lookup walks the buckets
+// in order.
+type playerIndexes struct {
+  byNameJersey  map[nameJerseyKey]string
+  byTeamJersey  map[teamJerseyKey]string
+  byTeam        map[string]map[string]struct{}
+  byJersey      map[int8]map[string]struct{}
+  byRating      [256]map[string]struct{}
+  names         *nameIndex
+  teamNames     map[string]string
+  playerMatches map[string]map[string]struct{}
+  teamMatches   map[string]map[string]struct{}
+}
+
+// newPlayerIndexes builds the indexes for the players currently in the store
+func newPlayerIndexes(store PlayerStore) *playerIndexes {
+  ix := &playerIndexes{
+    byNameJersey:  make(map[nameJerseyKey]string, store.Len()),
+    byTeamJersey:  make(map[teamJerseyKey]string),
+    byTeam:        make(map[string]map[string]struct{}),
+    byJersey:      make(map[int8]map[string]struct{}),
+    names:         newNameIndex(),
+    teamNames:     make(map[string]string),
+    playerMatches: make(map[string]map[string]struct{}),
+    teamMatches:   make(map[string]map[string]struct{}),
+  }
+
+  store.Range(func(player Player) bool {
+    ix.add(player)
+    return true
+  })
+  store.RangeTeams(func(team Team) bool {
+    ix.addTeam(team)
+    return true
+  })
+  store.RangeMatches(func(match Match) bool {
+    ix.addMatch(match)
+    return true
+  })
+
+  return ix
+}
+
+// add indexes a new or updated player
+func (ix *playerIndexes) add(player Player) {
+  if player.TeamID == "" {
+    ix.byNameJersey[nameJerseyKey{player.Name, player.JerseyNumber}] = player.ID
+  } else {
+    ix.byTeamJersey[teamJerseyKey{player.TeamID, player.JerseyNumber}] = player.ID
+    ix.byTeam[player.TeamID] = addToSet(ix.byTeam[player.TeamID], player.ID)
+  }
+  ix.byJersey[player.JerseyNumber] = addToSet(ix.byJersey[player.JerseyNumber], player.ID)
+
+  bucket := ratingBucket(player.Rating)
+  ix.byRating[bucket] = addToSet(ix.byRating[bucket], player.ID)
+
+  ix.names.add(player.ID, player.Name)
+}
+
+// remove drops a player from the indexes
+func (ix *playerIndexes) remove(player Player) {
+  if player.TeamID == "" {
+    key := nameJerseyKey{player.Name, player.JerseyNumber}
+    if ix.byNameJersey[key] == player.ID {
+      delete(ix.byNameJersey, key)
+    }
+  } else {
+    key := teamJerseyKey{player.TeamID, player.JerseyNumber}
+    if ix.byTeamJersey[key] == player.ID {
+      delete(ix.byTeamJersey, key)
+    }
+    if ids := ix.byTeam[player.TeamID]; ids != nil {
+      delete(ids, player.ID)
+      if len(ids) == 0 {
+        delete(ix.byTeam, player.TeamID)
+      }
+    }
+  }
+
+  if ids := ix.byJersey[player.JerseyNumber]; ids != nil {
+    delete(ids, player.ID)
+    if len(ids) == 0 {
+      delete(ix.byJersey, player.JerseyNumber)
+    }
+  }
+
+  delete(ix.byRating[ratingBucket(player.Rating)], player.ID)
+
+  ix.names.remove(player.ID)
+}
+
+// replace re-indexes a player whose fields changed
+func (ix *playerIndexes) replace(old, updated Player) {
+  ix.remove(old)
+  ix.add(updated)
+}
+
+// addToSet adds id to set, allocating the set if needed
+func addToSet(set map[string]struct{}, id string) map[string]struct{} {
+  if set == nil {
+    set = make(map[string]struct{})
+  }
+  set[id] = struct{}{}
+  return set
+}
+
+// removeFromSet removes id from the set under key, dropping the set once empty
+func removeFromSet(sets map[string]map[string]struct{}, key, id string) {
+  if set := sets[key]; set != nil {
+    delete(set, id)
+    if len(set) == 0 {
+      delete(sets, key)
+    }
+  }
+}
+
+// ratingBucket maps a rating onto its slot in the rating index
+func ratingBucket(rating int8) int {
+  return int(rating) - math.MinInt8
+}
+
+// addTeam indexes a new or renamed team
+func (ix *playerIndexes) addTeam(team Team) {
+  ix.teamNames[foldName(team.Name)] = team.ID
+}
+
+// removeTeam drops a team from the indexes
+func (ix *playerIndexes) removeTeam(team Team) {
+  key := foldName(team.Name)
+  if ix.teamNames[key] == team.ID {
+    delete(ix.teamNames, key)
+  }
+}
+
+// addMatch indexes a match under its teams and the players in its lineup
+func (ix *playerIndexes) addMatch(match Match) {
+  for _, teamID := range []string{match.HomeTeamID, match.AwayTeamID} {
+    ix.teamMatches[teamID] = addToSet(ix.teamMatches[teamID], match.ID)
+  }
+  for _, appearance := range match.Lineup {
+    ix.playerMatches[appearance.PlayerID] = addToSet(ix.playerMatches[appearance.PlayerID], match.ID)
+  }
+}
+
+// removeMatch drops a match from the indexes
+func (ix *playerIndexes) removeMatch(match Match) {
+  for _, teamID := range []string{match.HomeTeamID, match.AwayTeamID} {
+    removeFromSet(ix.teamMatches, teamID, match.ID)
+  }
+  for _, appearance := range match.Lineup {
+    removeFromSet(ix.playerMatches, appearance.PlayerID, match.ID)
+  }
+}
+
+// holderOf returns the ID of the player holding the uniqueness key of req:
+// the jersey number on the player's team, or the name and jersey number for
+// players without a team
+func (ix *playerIndexes) holderOf(req PlayerRequest) (string, bool) {
+  if req.TeamID != "" {
+    id, exists := ix.byTeamJersey[teamJerseyKey{req.TeamID, req.JerseyNumber}]
+    return id, exists
+  }
+  id, exists := ix.byNameJersey[nameJerseyKey{req.Name, req.JerseyNumber}]
+  return id, exists
+}
+
+// teamNamedAs returns the ID of the team using the given name, ignoring case
+// and accents
+func (ix *playerIndexes) teamNamedAs(name string) (string, bool) {
+  id, exists := ix.teamNames[foldName(name)]
+  return id, exists
+}
+
+// teamPlayerIDs returns the IDs of the players on a team
+func (ix *playerIndexes) teamPlayerIDs(teamID string) []string {
+  ids := make([]string, 0, len(ix.byTeam[teamID]))
+  for id := range ix.byTeam[teamID] {
+    ids = append(ids, id)
+  }
+  return ids
+}
+
+// jerseyIDs returns the IDs of the players wearing the given number
+func (ix *playerIndexes) jerseyIDs(jersey int8) []string {
+  ids := make([]string, 0, len(ix.byJersey[jersey]))
+  for id := range ix.byJersey[jersey] {
+    ids = append(ids, id)
+  }
+  return ids
+}
+
+// ratingIDs returns the IDs of players rated between lo and hi inclusive,
+// ordered by rating
+func (ix *playerIndexes) ratingIDs(lo, hi int64) []string {
+  lo, hi = max(lo, math.MinInt8), min(hi, math.MaxInt8)
+
+  var ids []string
+  for rating := lo; rating <= hi; rating++ {
+    for id := range ix.byRating[ratingBucket(int8(rating))] {
+      ids = append(ids, id)
+    }
+  }
+  return ids
+}
+
+// candidateIDs narrows a query down to the players an index can find for it.
+// ok is false when no index applies and the whole store has to be scanned.
+// The caller still applies every filter to the candidates.
+func (ix *playerIndexes) candidateIDs(filters []FieldFilter) (ids []string, ok bool) {
+  lo, hi := int64(math.MinInt64), int64(math.MaxInt64)
+  hasRating := false
+
+  for _, filter := range filters {
+    switch filter.Field {
+    case "team_id":
+      if filter.Op == "=" && filter.Value.(string) != "" {
+        return ix.teamPlayerIDs(filter.Value.(string)), true
+      }
+    case "jersey_number":
+      if filter.Op == "=" {
+        value := filter.Value.(int64)
+        if value < math.MinInt8 || value > math.MaxInt8 {
+          return nil, true
+        }
+        return ix.jerseyIDs(int8(value)), true
+      }
+    case "rating":
+      value := filter.Value.(int64)
+      switch filter.Op {
+      case "=":
+        lo, hi = max(lo, value), min(hi, value)
+      case ">":
+        if value == math.MaxInt64 {
+          return nil, true
+        }
+        lo = max(lo, value+1)
+      case ">=":
+        lo = max(lo, value)
+      case "<":
+        if value == math.MinInt64 {
+          return nil, true
+        }
+        hi = min(hi, value-1)
+      case "<=":
+        hi = min(hi, value)
+      default:
+        continue
+      }
+      hasRating = true
+    }
+  }
+
+  if !hasRating {
+    return nil, false
+  }
+  return ix.ratingIDs(lo, hi), true
+}
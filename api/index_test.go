@@ -0,0 +1,119 @@
+package main
+
+import (
+  "errors"
+  "fmt"
+  "testing"
+)
+
+// newServiceWithPlayers builds a service holding n generated players
+func newServiceWithPlayers(n int) *PlayerService {
+  store := NewMemoryStore()
+  batch := StoreBatch{IDCounter: n}
+  for i := 1; i <= n; i++ {
+    batch.Put = append(batch.Put, Player{
+      ID:           fmt.Sprint(i),
+      Name:         fmt.Sprintf("Player %d", i),
+      JerseyNumber: int8(i%99 + 1),
+      Rating:       int8(i%99 + 1),
+      Version:      1,
+    })
+  }
+  store.Apply(batch)
+  return NewPlayerServiceWithStore(store)
+}
+
+func TestPlayerIndexes_StayInSync(t *testing.T) {
+  service := NewPlayerService()
+  
+  // Renaming Messi frees his old name+jersey for someone else
+  if _, err := service.UpdatePlayer(t.Context(), "1", PlayerRequest{Name: "Leo", JerseyNumber: 30, Rating: 90}, AnyVersion); err != nil {
+    t.Fatalf("Failed to update player: %v", err)
+  }
+  if _, err := service.CreatePlayer(t.Context(), PlayerRequest{Name: "Messi", JerseyNumber: 10, Rating: 80}); err != nil {
+    t.Errorf("Expected old name and jersey to be free, got %v", err)
+  }
+  if _, err := service.CreatePlayer(t.Context(), PlayerRequest{Name: "Leo", JerseyNumber: 30, Rating: 80}); !errors.Is(err, ErrPlayerExists) {
+    t.Errorf("Expected new name and jersey to be taken, got %v", err)
+  }
+  
+  // Deleting a player frees their key
+  if _, err := service.DeletePlayer(t.Context(), "2", AnyVersion); err != nil {
+    t.Fatalf("Failed to delete player: %v", err)
+  }
+  if _, err := service.CreatePlayer(t.Context(), PlayerRequest{Name: "Ronaldo", JerseyNumber: 7, Rating: 80}); err != nil {
+    t.Errorf("Expected deleted player's key to be free, got %v", err)
+  }
+  
+  // Index-backed queries reflect the changes
+  page := service.QueryPlayers(PlayerQuery{
+    Limit:   DefaultPageLimit,
+    Sort:    []SortKey{{Field: "id"}},
+    Filters: []FieldFilter{{Field: "rating", Op: ">=", Value: int64(90)}},
+  })
+  var ids []string
+  for _, player := range page.Players {
+    ids = append(ids, player.ID)
+  }
+  if fmt.Sprint(ids) != "[1 3]" {
+    t.Errorf("Expected players [1 3] rated 90+, got %v", ids)
+  }
+  
+  page = service.QueryPlayers(PlayerQuery{
+    Limit:   DefaultPageLimit,
+    Sort:    []SortKey{{Field: "id"}},
+    Filters: []FieldFilter{{Field: "jersey_number", Op: "=", Value: int64(10)}},
+  })
+  ids = nil
+  for _, player := range page.Players {
+    ids = append(ids, player.ID)
+  }
+  if fmt.Sprint(ids) != "[3 4]" {
+    t.Errorf("Expected players [3 4] wearing 10, got %v", ids)
+  }
+}
+
+func BenchmarkCreatePlayer_DuplicateCheck(b *testing.B) {
+  for _, n := range []int{1_000, 10_000, 100_000} {
+    service := newServiceWithPlayers(n)
+    duplicate := PlayerRequest{Name: "Player 1", JerseyNumber: 2, Rating: 50}
+    
+    b.Run(fmt.Sprintf("players=%d", n), func(b *testing.B) {
+      for b.Loop() {
+        if _, err := service.CreatePlayer(b.Context(), duplicate); !errors.Is(err, ErrPlayerExists) {
+          b.Fatalf("Expected ErrPlayerExists, got %v", err)
+        }
+      }
+    })
+  }
+}
+
+func BenchmarkUpdatePlayer(b *testing.B) {
+  for _, n := range []int{1_000, 10_000, 100_000} {
+    service := newServiceWithPlayers(n)
+    
+    b.Run(fmt.Sprintf("players=%d", n), func(b *testing.B) {
+      i := 0
+      for b.Loop() {
+        i++
+        req := PlayerRequest{Name: "Player 1", JerseyNumber: 2, Rating: int8(i%99 + 1)}
+        if _, err := service.UpdatePlayer(b.Context(), "1", req, AnyVersion); err != nil {
+          b.Fatalf("Failed to update player: %v", err)
+        }
+      }
+    })
+  }
+}
+
+func BenchmarkQueryPlayers_RatingRange(b *testing.B) {
+  service := newServiceWithPlayers(100_000)
+  query := PlayerQuery{
+    Limit:   DefaultPageLimit,
+    Sort:    []SortKey{{Field: "id"}},
+    Filters: []FieldFilter{{Field: "rating", Op: ">=", Value: int64(98)}},
+  }
+  
+  for b.Loop() {
+    service.QueryPlayers(query)
+  }
+}
@@ -0,0 +1,312 @@
+package main
+
+import (
+  "bytes"
+  "encoding/json"
+  "fmt"
+  "reflect"
+  "strconv"
+  "strings"
+)
+
+// Patch media types accepted by PATCH /players/{id}
+const (
+  mergePatchMediaType = "application/merge-patch+json"
+  jsonPatchMediaType  = "application/json-patch+json"
+)
+
+// patchOperation is a single RFC 6902 JSON Patch operation
+type patchOperation struct {
+  Op    string          `json:"op"`
+  Path  *string         `json:"path"`
+  From  *string         `json:"from,omitempty"`
+  Value json.RawMessage `json:"value,omitempty"`
+}
+
+// applyMergePatch applies an RFC 7386 JSON Merge Patch to doc.
+// Object members set to null in the patch are removed from the target.
+func applyMergePatch(doc, patch any) any {
+  patchObject, ok := patch.(map[string]any)
+  if !ok {
+    return patch
+  }
+
+  target, ok := doc.(map[string]any)
+  if !ok {
+    target = make(map[string]any)
+  }
+
+  for key, value := range patchObject {
+    if value == nil {
+      delete(target, key)
+      continue
+    }
+    target[key] = applyMergePatch(target[key], value)
+  }
+  return target
+}
+
+// applyJSONPatch applies an RFC 6902 JSON Patch to doc. Operations are applied
+// in order and the first failure aborts the whole patch.
+func applyJSONPatch(doc any, ops []patchOperation) (any, error) {
+  for i, op := range ops {
+    var err error
+    doc, err = applyPatchOperation(doc, op)
+    if err != nil {
+      return nil, fmt.Errorf("operation %d (%s): %w", i, op.Op, err)
+    }
+  }
+  return doc, nil
+}
+
+// applyPatchOperation applies a single JSON Patch operation
+func applyPatchOperation(doc any, op patchOperation) (any, error) {
+  if op.Path == nil {
+    return nil, fmt.Errorf("%w: missing path", ErrInvalidPatch)
+  }
+  path, err := parseJSONPointer(*op.Path)
+  if err != nil {
+    return nil, err
+  }
+
+  switch op.Op {
+  case "add", "replace", "test":
+    value, err := op.value()
+    if err != nil {
+      return nil, err
+    }
+    if op.Op == "test" {
+      current, err := getAtPointer(doc, path)
+      if err != nil {
+        return nil, fmt.Errorf("%w: %v", ErrPatchTestFailed, err)
+      }
+      if !reflect.DeepEqual(current, value) {
+        return nil, fmt.Errorf("%w: value at %s does not match", ErrPatchTestFailed, *op.Path)
+      }
+      return doc, nil
+    }
+    return mutateAtPointer(doc, path, op.Op, value)
+
+  case "remove":
+    return mutateAtPointer(doc, path, "remove", nil)
+
+  case "move", "copy":
+    if op.From == nil {
+      return nil, fmt.Errorf("%w: missing from", ErrInvalidPatch)
+    }
+    from, err := parseJSONPointer(*op.From)
+    if err != nil {
+      return nil, err
+    }
+    value, err := getAtPointer(doc, from)
+    if err != nil {
+      return nil, err
+    }
+    if op.Op == "move" {
+      if len(from) < len(path) && reflect.DeepEqual(from, path[:len(from)]) {
+        return nil, fmt.Errorf("%w: cannot move a value into one of its children", ErrInvalidPatch)
+      }
+      if doc, err = mutateAtPointer(doc, from, "remove", nil); err != nil {
+        return nil, err
+      }
+    } else {
+      value = deepCopyJSON(value)
+    }
+    return mutateAtPointer(doc, path, "add", value)
+  }
+
+  return nil, fmt.Errorf("%w: unknown operation %q", ErrInvalidPatch, op.Op)
+}
+
+// value decodes the operation's value member, which may legitimately be null
+func (op patchOperation) value() (any, error) {
+  if len(op.Value) == 0 {
+    return nil, fmt.Errorf("%w: missing value", ErrInvalidPatch)
+  }
+  var value any
+  if err := json.Unmarshal(op.Value, &value); err != nil {
+    return nil, fmt.Errorf("%w: %v", ErrInvalidPatch, err)
+  }
+  return value, nil
+}
+
+// parseJSONPointer splits an RFC 6901 JSON Pointer into unescaped tokens
+func parseJSONPointer(pointer string) ([]string, error) {
+  if pointer == "" {
+    return nil, nil
+  }
+  if !strings.HasPrefix(pointer, "/") {
+    return nil, fmt.Errorf("%w: path %q must start with /", ErrInvalidPatch, pointer)
+  }
+
+  tokens := strings.Split(pointer[1:], "/")
+  for i, token := range tokens {
+    tokens[i] = strings.ReplaceAll(strings.ReplaceAll(token, "~1", "/"), "~0", "~")
+  }
+  return tokens, nil
+}
+
+// getAtPointer returns the value the pointer refers to
+func getAtPointer(doc any, path []string) (any, error) {
+  for _, token := range path {
+    switch container := doc.(type) {
+    case map[string]any:
+      value, exists := container[token]
+      if !exists {
+        return nil, fmt.Errorf("%w: member %q does not exist", ErrInvalidPatch, token)
+      }
+      doc = value
+    case []any:
+      index, err := arrayIndex(token, len(container)-1)
+      if err != nil {
+        return nil, err
+      }
+      doc = container[index]
+    default:
+      return nil, fmt.Errorf("%w: cannot index into %q", ErrInvalidPatch, token)
+    }
+  }
+  return doc, nil
+}
+
+// mutateAtPointer adds, replaces or removes the value at path and returns the
+// resulting document. Arrays may change length, so every level is rebuilt.
+func mutateAtPointer(doc any, path []string, op string, value any) (any, error) {
+  if len(path) == 0 {
+    if op == "remove" {
+      return nil, fmt.Errorf("%w: cannot remove the whole document", ErrInvalidPatch)
+    }
+    return value, nil
+  }
+
+  token, rest := path[0], path[1:]
+  switch container := doc.(type) {
+  case map[string]any:
+    current, exists := container[token]
+    if len(rest) > 0 {
+      if !exists {
+        return nil, fmt.Errorf("%w: member %q does not exist", ErrInvalidPatch, token)
+      }
+      child, err := mutateAtPointer(current, rest, op, value)
+      if err != nil {
+        return nil, err
+      }
+      container[token] = child
+      return container, nil
+    }
+    if !exists && op != "add" {
+      return nil, fmt.Errorf("%w: member %q does not exist", ErrInvalidPatch, token)
+    }
+    if op == "remove" {
+      delete(container, token)
+    } else {
+      container[token] = value
+    }
+    return container, nil
+
+  case []any:
+    if len(rest) > 0 {
+      index, err := arrayIndex(token, len(container)-1)
+      if err != nil {
+        return nil, err
+      }
+      child, err := mutateAtPointer(container[index], rest, op, value)
+      if err != nil {
+        return nil, err
+      }
+      container[index] = child
+      return container, nil
+    }
+
+    switch op {
+    case "add":
+      if token == "-" {
+        return append(container, value), nil
+      }
+      index, err := arrayIndex(token, len(container))
+      if err != nil {
+        return nil, err
+      }
+      container = append(container, nil)
+      copy(container[index+1:], container[index:])
+      container[index] = value
+      return container, nil
+    case "replace":
+      index, err := arrayIndex(token, len(container)-1)
+      if err != nil {
+        return nil, err
+      }
+      container[index] = value
+      return container, nil
+    default:
+      index, err := arrayIndex(token, len(container)-1)
+      if err != nil {
+        return nil, err
+      }
+      return append(container[:index], container[index+1:]...), nil
+    }
+  }
+
+  return nil, fmt.Errorf("%w: cannot index into %q", ErrInvalidPatch, token)
+}
+
+// arrayIndex parses an array index token and checks it against max
+func arrayIndex(token string, max int) (int, error) {
+  index, err := strconv.Atoi(token)
+  if err != nil || index < 0 || (len(token) > 1 && token[0] == '0') {
+    return 0, fmt.Errorf("%w: invalid array index %q", ErrInvalidPatch, token)
+  }
+  if index > max {
+    return 0, fmt.Errorf("%w: array index %d out of range", ErrInvalidPatch, index)
+  }
+  return index, nil
+}
+
+// deepCopyJSON copies a decoded JSON value so copies don't share containers
+func deepCopyJSON(value any) any {
+  switch v := value.(type) {
+  case map[string]any:
+    out := make(map[string]any, len(v))
+    for key, child := range v {
+      out[key] = deepCopyJSON(child)
+    }
+    return out
+  case []any:
+    out := make([]any, len(v))
+    for i, child := range v {
+      out[i] = deepCopyJSON(child)
+    }
+    return out
+  }
+  return value
+}
+
+// requestToDocument converts a player request into a generic JSON document
+func requestToDocument(req PlayerRequest) (any, error) {
+  raw, err := json.Marshal(req)
+  if err != nil {
+    return nil, err
+  }
+  var doc any
+  if err := json.Unmarshal(raw, &doc); err != nil {
+    return nil, err
+  }
+  return doc, nil
+}
+
+// documentToRequest converts a patched JSON document back into a player
+// request, rejecting members that aren't editable player fields
+func documentToRequest(doc any) (PlayerRequest, error) {
+  var req PlayerRequest
+  
+  raw, err := json.Marshal(doc)
+  if err != nil {
+    return req, fmt.Errorf("%w: %v", ErrInvalidInput, err)
+  }
+  decoder := json.NewDecoder(bytes.NewReader(raw))
+  decoder.DisallowUnknownFields()
+  if err := decoder.Decode(&req); err != nil {
+    return req, fmt.Errorf("%w: patched player is invalid: %v", ErrInvalidInput, err)
+  }
+  return req, nil
+}
@@ -0,0 +1,88 @@
+package main
+
+import (
+  "encoding/json"
+  "errors"
+  "reflect"
+  "testing"
+)
+
+func decodeJSON(t *testing.T, raw string) any {
+  t.Helper()
+  var v any
+  if err := json.Unmarshal([]byte(raw), &v); err != nil {
+    t.Fatalf("Failed to decode %s: %v", raw, err)
+  }
+  return v
+}
+
+func TestApplyMergePatch(t *testing.T) {
+  // Examples from RFC 7386 appendix A
+  tests := []struct {
+    doc, patch, want string
+  }{
+    {`{"a":"b"}`, `{"a":"c"}`, `{"a":"c"}`},
+    {`{"a":"b"}`, `{"b":"c"}`, `{"a":"b","b":"c"}`},
+    {`{"a":"b"}`, `{"a":null}`, `{}`},
+    {`{"a":{"b":"c"}}`, `{"a":{"b":"d","c":null}}`, `{"a":{"b":"d"}}`},
+    {`{"a":[{"b":"c"}]}`, `{"a":[1]}`, `{"a":[1]}`},
+    {`{"e":null}`, `{"a":1}`, `{"e":null,"a":1}`},
+    {`[1,2]`, `{"a":"b","c":null}`, `{"a":"b"}`},
+  }
+  
+  for _, tt := range tests {
+    got := applyMergePatch(decodeJSON(t, tt.doc), decodeJSON(t, tt.patch))
+    if want := decodeJSON(t, tt.want); !reflect.DeepEqual(got, want) {
+      t.Errorf("merge %s into %s: expected %v, got %v", tt.patch, tt.doc, want, got)
+    }
+  }
+}
+
+func TestApplyJSONPatch(t *testing.T) {
+  tests := []struct {
+    name    string
+    doc     string
+    patch   string
+    want    string
+    wantErr error
+  }{
+    {"add member", `{"foo":"bar"}`, `[{"op":"add","path":"/baz","value":"qux"}]`, `{"foo":"bar","baz":"qux"}`, nil},
+    {"add array element", `{"foo":["bar","baz"]}`, `[{"op":"add","path":"/foo/1","value":"qux"}]`, `{"foo":["bar","qux","baz"]}`, nil},
+    {"append array element", `{"foo":[1]}`, `[{"op":"add","path":"/foo/-","value":2}]`, `{"foo":[1,2]}`, nil},
+    {"remove member", `{"baz":"qux","foo":"bar"}`, `[{"op":"remove","path":"/baz"}]`, `{"foo":"bar"}`, nil},
+    {"replace member", `{"baz":"qux"}`, `[{"op":"replace","path":"/baz","value":"boo"}]`, `{"baz":"boo"}`, nil},
+    {"move member", `{"foo":{"bar":"baz"},"qux":{}}`, `[{"op":"move","from":"/foo/bar","path":"/qux/thud"}]`, `{"foo":{},"qux":{"thud":"baz"}}`, nil},
+    {"copy member", `{"a":{"b":1}}`, `[{"op":"copy","from":"/a","path":"/c"}]`, `{"a":{"b":1},"c":{"b":1}}`, nil},
+    {"escaped pointer", `{"a/b":1,"m~n":2}`, `[{"op":"replace","path":"/a~1b","value":3},{"op":"remove","path":"/m~0n"}]`, `{"a/b":3}`, nil},
+    {"test passes", `{"baz":"qux"}`, `[{"op":"test","path":"/baz","value":"qux"}]`, `{"baz":"qux"}`, nil},
+    {"test null value", `{"baz":null}`, `[{"op":"test","path":"/baz","value":null}]`, `{"baz":null}`, nil},
+    {"test fails", `{"baz":"qux"}`, `[{"op":"test","path":"/baz","value":"bar"}]`, "", ErrPatchTestFailed},
+    {"replace missing member", `{}`, `[{"op":"replace","path":"/baz","value":1}]`, "", ErrInvalidPatch},
+    {"unknown op", `{}`, `[{"op":"frobnicate","path":"/baz"}]`, "", ErrInvalidPatch},
+    {"missing value", `{}`, `[{"op":"add","path":"/baz"}]`, "", ErrInvalidPatch},
+    {"move into own child", `{"a":{}}`, `[{"op":"move","from":"/a","path":"/a/b"}]`, "", ErrInvalidPatch},
+  }
+  
+  for _, tt := range tests {
+    t.Run(tt.name, func(t *testing.T) {
+      var ops []patchOperation
+      if err := json.Unmarshal([]byte(tt.patch), &ops); err != nil {
+        t.Fatalf("Failed to decode patch: %v", err)
+      }
+      
+      got, err := applyJSONPatch(decodeJSON(t, tt.doc), ops)
+      if tt.wantErr != nil {
+        if !errors.Is(err, tt.wantErr) {
+          t.Errorf("Expected error %v, got %v", tt.wantErr, err)
+        }
+        return
+      }
+      if err != nil {
+        t.Fatalf("Expected no error but got: %v", err)
+      }
+      if want := decodeJSON(t, tt.want); !reflect.DeepEqual(got, want) {
+        t.Errorf("Expected %v, got %v", want, got)
+      }
+    })
+  }
+}
@@ -0,0 +1,377 @@
+package main
+
+import (
+  "crypto"
+  "crypto/hmac"
+  "crypto/rsa"
+  "crypto/sha256"
+  "crypto/x509"
+  "encoding/base64"
+  "encoding/json"
+  "encoding/pem"
+  "fmt"
+  "math"
+  "os"
+  "slices"
+  "strings"
+  "time"
+)
+
+// Supported JWT signing algorithms
+const (
+  AlgHS256 = "HS256"
+  AlgRS256 = "RS256"
+)
+
+// JWT defaults and limits
+const (
+  DefaultJWTLeeway     = time.Minute
+  MaxJWTLeeway         = time.Hour
+  DefaultJWTRolesClaim = "roles"
+  MinJWTSecretLength   = 32
+  MaxJWTLength         = 8192
+)
+
+// JWTKey is a key tokens can be signed with. Secret is set for HS256 keys
+// and PublicKey (PEM, a public key or certificate) for RS256 keys.
+type JWTKey struct {
+  ID        string `json:"kid"`
+  Algorithm string `json:"alg"`
+  Secret    string `json:"secret,omitempty"`
+  PublicKey string `json:"public_key,omitempty"`
+}
+
+// JWTConfig configures which tokens are accepted and what they may do.
+// Every key in Keys is active, so a new key can be added before tokens are
+// signed with it and the old one removed once its tokens have expired.
+type JWTConfig struct {
+  Issuer   string `json:"issuer"`
+  Audience string `json:"audience"`
+  // RolesClaim names the claim holding the caller's roles, either a list or
+  // a space-separated string
+  RolesClaim string `json:"roles_claim,omitempty"`
+  // Roles maps role names to the scope they grant; roles not listed here
+  // grant nothing. Empty means the scope names themselves are the roles.
+  Roles map[string]string `json:"roles,omitempty"`
+  // LeewaySeconds allows for clock skew when checking exp and nbf. Unset
+  // means DefaultJWTLeeway; 0 checks the times exactly.
+  LeewaySeconds *int     `json:"leeway_seconds,omitempty"`
+  Keys          []JWTKey `json:"keys"`
+}
+
+// LoadJWTConfig reads a JWTConfig from a JSON file
+func LoadJWTConfig(path string) (JWTConfig, error) {
+  var config JWTConfig
+  raw, err := os.ReadFile(path)
+  if err != nil {
+    return config, fmt.Errorf("failed to read JWT config: %w", err)
+  }
+  if err := json.Unmarshal(raw, &config); err != nil {
+    return config, fmt.Errorf("failed to decode JWT config: %w", err)
+  }
+  return config, nil
+}
+
+// jwtKey is a parsed JWTKey
+type jwtKey struct {
+  algorithm string
+  secret    []byte
+  publicKey *rsa.PublicKey
+}
+
+// JWTVerifier checks bearer JWTs and turns their role claims into scopes
+type JWTVerifier struct {
+  issuer     string
+  audience   string
+  rolesClaim string
+  roles      map[string]string
+  leeway     time.Duration
+  keys       map[string]jwtKey
+
+  // now is replaced by tests
+  now func() time.Time
+}
+
+// NewJWTVerifier validates a config and parses its keys
+func NewJWTVerifier(config JWTConfig) (*JWTVerifier, error) {
+  if config.Issuer == "" || config.Audience == "" {
+    return nil, fmt.Errorf("%w: issuer and audience are required", ErrInvalidInput)
+  }
+  if len(config.Keys) == 0 {
+    return nil, fmt.Errorf("%w: at least one key is required", ErrInvalidInput)
+  }
+
+  v := &JWTVerifier{
+    issuer:     config.Issuer,
+    audience:   config.Audience,
+    rolesClaim: config.RolesClaim,
+    roles:      config.Roles,
+    leeway:     DefaultJWTLeeway,
+    keys:       make(map[string]jwtKey, len(config.Keys)),
+    now:        time.Now,
+  }
+  if v.rolesClaim == "" {
+    v.rolesClaim = DefaultJWTRolesClaim
+  }
+  if v.roles == nil {
+    v.roles = map[string]string{ScopeRead: ScopeRead, ScopeWrite: ScopeWrite, ScopeAdmin: ScopeAdmin}
+  }
+  if seconds := config.LeewaySeconds; seconds != nil {
+    if *seconds < 0 || *seconds > int(MaxJWTLeeway/time.Second) {
+      return nil, fmt.Errorf("%w: leeway_seconds must be between 0 and %d", ErrInvalidInput, int(MaxJWTLeeway/time.Second))
+    }
+    v.leeway = time.Duration(*seconds) * time.Second
+  }
+  for role, scope := range v.roles {
+    if scopeRank(scope) == 0 {
+      return nil, fmt.Errorf("%w: role %q must map to %s, %s or %s", ErrInvalidInput, role, ScopeRead, ScopeWrite, ScopeAdmin)
+    }
+  }
+
+  for _, key := range config.Keys {
+    if key.ID == "" {
+      return nil, fmt.Errorf("%w: every key needs a kid", ErrInvalidInput)
+    }
+    if _, exists := v.keys[key.ID]; exists {
+      return nil, fmt.Errorf("%w: duplicate kid %q", ErrInvalidInput, key.ID)
+    }
+    parsed, err := parseJWTKey(key)
+    if err != nil {
+      return nil, fmt.Errorf("key %q: %w", key.ID, err)
+    }
+    v.keys[key.ID] = parsed
+  }
+  return v, nil
+}
+
+// parseJWTKey checks a configured key and decodes its key material
+func parseJWTKey(key JWTKey) (jwtKey, error) {
+  switch key.Algorithm {
+  case AlgHS256:
+    if len(key.Secret) < MinJWTSecretLength {
+      return jwtKey{}, fmt.Errorf("%w: HS256 secret must be at least %d bytes", ErrInvalidInput, MinJWTSecretLength)
+    }
+    return jwtKey{algorithm: AlgHS256, secret: []byte(key.Secret)}, nil
+  case AlgRS256:
+    block, _ := pem.Decode([]byte(key.PublicKey))
+    if block == nil {
+      return jwtKey{}, fmt.Errorf("%w: public_key must be PEM encoded", ErrInvalidInput)
+    }
+    var public any
+    var err error
+    if block.Type == "CERTIFICATE" {
+      var cert *x509.Certificate
+      if cert, err = x509.ParseCertificate(block.Bytes); err == nil {
+        public = cert.PublicKey
+      }
+    } else {
+      public, err = x509.ParsePKIXPublicKey(block.Bytes)
+    }
+    if err != nil {
+      return jwtKey{}, fmt.Errorf("%w: invalid public_key: %v", ErrInvalidInput, err)
+    }
+    rsaKey, ok := public.(*rsa.PublicKey)
+    if !ok {
+      return jwtKey{}, fmt.Errorf("%w: RS256 needs an RSA public key", ErrInvalidInput)
+    }
+    return jwtKey{algorithm: AlgRS256, publicKey: rsaKey}, nil
+  }
+  return jwtKey{}, fmt.Errorf("%w: alg must be %s or %s", ErrInvalidInput, AlgHS256, AlgRS256)
+}
+
+// KeyIDs returns the IDs of the active keys
+func (v *JWTVerifier) KeyIDs() []string {
+  ids := make([]string, 0, len(v.keys))
+  for id := range v.keys {
+    ids = append(ids, id)
+  }
+  slices.Sort(ids)
+  return ids
+}
+
+// jwtHeader is the JOSE header of a token
+type jwtHeader struct {
+  Algorithm string `json:"alg"`
+  KeyID     string `json:"kid"`
+}
+
+// jwtClaims holds the registered claims that are checked
+type jwtClaims struct {
+  Issuer    string      `json:"iss"`
+  Subject   string      `json:"sub"`
+  Audience  jwtAudience `json:"aud"`
+  ExpiresAt *float64    `json:"exp"`
+  NotBefore *float64    `json:"nbf"`
+}
+
+// jwtAudience accepts the aud claim as a single string or a list
+type jwtAudience []string
+
+func (a *jwtAudience) UnmarshalJSON(data []byte) error {
+  var single string
+  if err := json.Unmarshal(data, &single); err == nil {
+    *a = jwtAudience{single}
+    return nil
+  }
+  return json.Unmarshal(data, (*[]string)(a))
+}
+
+// Verify checks a token's signature, expiry, not-before, issuer and
+// audience, and returns the principal it was issued to
+func (v *JWTVerifier) Verify(token string) (Principal, error) {
+  if len(token) > MaxJWTLength {
+    return Principal{}, fmt.Errorf("%w: token too long", ErrUnauthenticated)
+  }
+  parts := strings.Split(token, ".")
+  if len(parts) != 3 {
+    return Principal{}, fmt.Errorf("%w: malformed token", ErrUnauthenticated)
+  }
+
+  var header jwtHeader
+  if err := decodeJWTPart(parts[0], &header); err != nil {
+    return Principal{}, err
+  }
+  key, err := v.key(header)
+  if err != nil {
+    return Principal{}, err
+  }
+  signature, err := base64.RawURLEncoding.DecodeString(parts[2])
+  if err != nil {
+    return Principal{}, fmt.Errorf("%w: malformed signature", ErrUnauthenticated)
+  }
+  if !key.verify(parts[0]+"."+parts[1], signature) {
+    return Principal{}, fmt.Errorf("%w: invalid signature", ErrUnauthenticated)
+  }
+
+  // Only look at the claims once the signature is known to be good
+  var claims jwtClaims
+  if err := decodeJWTPart(parts[1], &claims); err != nil {
+    return Principal{}, err
+  }
+  if err := v.checkClaims(claims); err != nil {
+    return Principal{}, err
+  }
+  var all map[string]json.RawMessage
+  if err := decodeJWTPart(parts[1], &all); err != nil {
+    return Principal{}, err
+  }
+  return Principal{ID: "jwt:" + claims.Subject, Name: claims.Subject, Scopes: v.scopes(all[v.rolesClaim])}, nil
+}
+
+// key picks the key a token says it was signed with. The algorithm must be
+// the key's own, so an RS256 public key can never be used as an HS256 secret.
+func (v *JWTVerifier) key(header jwtHeader) (jwtKey, error) {
+  id := header.KeyID
+  if id == "" && len(v.keys) == 1 {
+    // With a single key, tokens don't have to name it
+    for only := range v.keys {
+      id = only
+    }
+  }
+  key, exists := v.keys[id]
+  if !exists {
+    return jwtKey{}, fmt.Errorf("%w: unknown kid %q", ErrUnauthenticated, header.KeyID)
+  }
+  if key.algorithm != header.Algorithm {
+    return jwtKey{}, fmt.Errorf("%w: kid %q does not sign with %q", ErrUnauthenticated, id, header.Algorithm)
+  }
+  return key, nil
+}
+
+// checkClaims checks the registered claims against the config and the clock
+func (v *JWTVerifier) checkClaims(claims jwtClaims) error {
+  now := v.now()
+  if claims.ExpiresAt == nil {
+    return fmt.Errorf("%w: token has no exp", ErrUnauthenticated)
+  }
+  expiresAt, err := numericDate("exp", *claims.ExpiresAt)
+  if err != nil {
+    return err
+  }
+  if now.After(expiresAt.Add(v.leeway)) {
+    return fmt.Errorf("%w: token expired", ErrUnauthenticated)
+  }
+  if claims.NotBefore != nil {
+    notBefore, err := numericDate("nbf", *claims.NotBefore)
+    if err != nil {
+      return err
+    }
+    if now.Before(notBefore.Add(-v.leeway)) {
+      return fmt.Errorf("%w: token not valid yet", ErrUnauthenticated)
+    }
+  }
+  if claims.Issuer != v.issuer {
+    return fmt.Errorf("%w: unexpected issuer %q", ErrUnauthenticated, claims.Issuer)
+  }
+  if !slices.Contains(claims.Audience, v.audience) {
+    return fmt.Errorf("%w: token is not for %q", ErrUnauthenticated, v.audience)
+  }
+  if claims.Subject == "" {
+    return fmt.Errorf("%w: token has no sub", ErrUnauthenticated)
+  }
+  return nil
+}
+
+// scopes maps the roles claim to the scopes the roles grant
+func (v *JWTVerifier) scopes(raw json.RawMessage) []string {
+  var roles []string
+  var spaced string
+  if err := json.Unmarshal(raw, &spaced); err == nil {
+    roles = strings.Fields(spaced)
+  } else {
+    json.Unmarshal(raw, &roles)
+  }
+
+  scopes := make([]string, 0, len(roles))
+  for _, role := range roles {
+    if scope, ok := v.roles[role]; ok && !slices.Contains(scopes, scope) {
+      scopes = append(scopes, scope)
+    }
+  }
+  return scopes
+}
+
+// verify checks a signature over the token's signing input
+func (k jwtKey) verify(input string, signature []byte) bool {
+  switch k.algorithm {
+  case AlgHS256:
+    mac := hmac.New(sha256.New, k.secret)
+    mac.Write([]byte(input))
+    return hmac.Equal(mac.Sum(nil), signature)
+  case AlgRS256:
+    digest := sha256.Sum256([]byte(input))
+    return rsa.VerifyPKCS1v15(k.publicKey, crypto.SHA256, digest[:], signature) == nil
+  }
+  return false
+}
+
+// decodeJWTPart decodes a base64url JSON segment of a token
+func decodeJWTPart(part string, v any) error {
+  raw, err := base64.RawURLEncoding.DecodeString(part)
+  if err != nil {
+    return fmt.Errorf("%w: malformed token", ErrUnauthenticated)
+  }
+  if err := json.Unmarshal(raw, v); err != nil {
+    return fmt.Errorf("%w: malformed token: %v", ErrUnauthenticated, err)
+  }
+  return nil
+}
+
+// maxNumericDate is the last second of the year 9999
+const maxNumericDate = 253402300799
+
+// numericDate converts the JWT NumericDate (seconds since the epoch) in
+// claim to a time. Dates beyond the year 9999 either way are rejected rather
+// than wrapped around.
+func numericDate(claim string, seconds float64) (time.Time, error) {
+  if math.IsNaN(seconds) || seconds < -maxNumericDate || seconds > maxNumericDate {
+    return time.Time{}, fmt.Errorf("%w: %s out of range", ErrUnauthenticated, claim)
+  }
+  whole, fraction := math.Modf(seconds)
+  return time.Unix(int64(whole), int64(fraction*float64(time.Second))), nil
+}
+
+// looksLikeJWT tells JWTs apart from API keys, which never contain dots
+func looksLikeJWT(token string) bool {
+  return strings.Count(token, ".") == 2
+}
@@ -0,0 +1,256 @@
+package main
+
+import (
+  "crypto"
+  "crypto/hmac"
+  "crypto/rand"
+  "crypto/rsa"
+  "crypto/sha256"
+  "crypto/x509"
+  "encoding/base64"
+  "encoding/json"
+  "encoding/pem"
+  "net/http"
+  "net/http/httptest"
+  "os"
+  "path/filepath"
+  "strings"
+  "testing"
+  "time"
+)
+
+const (
+  testIssuer   = "https://sso.example"
+  testAudience = "player-api"
+  oldSecret    = "old-secret-0123456789abcdef012345"
+  newSecret    = "new-secret-0123456789abcdef012345"
+)
+
+// signJWT builds a token with the given header and claims, signed with an
+// HMAC secret ([]byte) or an RSA private key
+func signJWT(t *testing.T, header map[string]any, claims map[string]any, key any) string {
+  t.Helper()
+  encode := func(v any) string {
+    raw, err := json.Marshal(v)
+    if err != nil {
+      t.Fatalf("Failed to encode token: %v", err)
+    }
+    return base64.RawURLEncoding.EncodeToString(raw)
+  }
+  input := encode(header) + "." + encode(claims)
+
+  var signature []byte
+  switch key := key.(type) {
+  case []byte:
+    mac := hmac.New(sha256.New, key)
+    mac.Write([]byte(input))
+    signature = mac.Sum(nil)
+  case *rsa.PrivateKey:
+    digest := sha256.Sum256([]byte(input))
+    var err error
+    if signature, err = rsa.SignPKCS1v15(rand.Reader, key, crypto.SHA256, digest[:]); err != nil {
+      t.Fatalf("Failed to sign token: %v", err)
+    }
+  }
+  return input + "." + base64.RawURLEncoding.EncodeToString(signature)
+}
+
+// testJWTVerifier accepts two HS256 keys (mid-rotation) and one RS256 key
+func testJWTVerifier(t *testing.T) (*JWTVerifier, *rsa.PrivateKey, time.Time) {
+  t.Helper()
+  private, err := rsa.GenerateKey(rand.Reader, 2048)
+  if err != nil {
+    t.Fatalf("Failed to generate RSA key: %v", err)
+  }
+  der, _ := x509.MarshalPKIXPublicKey(&private.PublicKey)
+  public := pem.EncodeToMemory(&pem.Block{Type: "PUBLIC KEY", Bytes: der})
+
+  verifier, err := NewJWTVerifier(JWTConfig{
+    Issuer:   testIssuer,
+    Audience: testAudience,
+    Roles:    map[string]string{"viewer": ScopeRead, "editor": ScopeWrite, "ops": ScopeAdmin},
+    Keys: []JWTKey{
+      {ID: "2025-01", Algorithm: AlgHS256, Secret: oldSecret},
+      {ID: "2025-02", Algorithm: AlgHS256, Secret: newSecret},
+      {ID: "sso-rsa", Algorithm: AlgRS256, PublicKey: string(public)},
+    },
+  })
+  if err != nil {
+    t.Fatalf("Failed to create verifier: %v", err)
+  }
+  now := time.Date(2025, 3, 1, 12, 0, 0, 0, time.UTC)
+  verifier.now = func() time.Time { return now }
+  return verifier, private, now
+}
+
+func TestJWTVerifier_Verify(t *testing.T) {
+  verifier, private, now := testJWTVerifier(t)
+  claims := func(overrides map[string]any) map[string]any {
+    c := map[string]any{
+      "iss":   testIssuer,
+      "aud":   testAudience,
+      "sub":   "alice",
+      "exp":   now.Add(time.Hour).Unix(),
+      "roles": []string{"editor"},
+    }
+    for k, v := range overrides {
+      if v == nil {
+        delete(c, k)
+      } else {
+        c[k] = v
+      }
+    }
+    return c
+  }
+  hs := func(kid string) map[string]any { return map[string]any{"alg": AlgHS256, "typ": "JWT", "kid": kid} }
+  rs := map[string]any{"alg": AlgRS256, "typ": "JWT", "kid": "sso-rsa"}
+  der, _ := x509.MarshalPKIXPublicKey(&private.PublicKey)
+  public := pem.EncodeToMemory(&pem.Block{Type: "PUBLIC KEY", Bytes: der})
+
+  tests := []struct {
+    name   string
+    token  string
+    scopes []string
+    err    string
+  }{
+    {"old key", signJWT(t, hs("2025-01"), claims(nil), []byte(oldSecret)), []string{ScopeWrite}, ""},
+    {"new key", signJWT(t, hs("2025-02"), claims(nil), []byte(newSecret)), []string{ScopeWrite}, ""},
+    {"rs256", signJWT(t, rs, claims(nil), private), []string{ScopeWrite}, ""},
+    {"audience list", signJWT(t, hs("2025-02"), claims(map[string]any{"aud": []string{"other", testAudience}}), []byte(newSecret)), []string{ScopeWrite}, ""},
+    {"spaced roles", signJWT(t, hs("2025-02"), claims(map[string]any{"roles": "viewer ops unknown"}), []byte(newSecret)), []string{ScopeRead, ScopeAdmin}, ""},
+    {"no roles", signJWT(t, hs("2025-02"), claims(map[string]any{"roles": nil}), []byte(newSecret)), []string{}, ""},
+    {"within leeway", signJWT(t, hs("2025-02"), claims(map[string]any{"exp": now.Add(-30 * time.Second).Unix()}), []byte(newSecret)), []string{ScopeWrite}, ""},
+    {"far future exp", signJWT(t, hs("2025-02"), claims(map[string]any{"exp": 1e19}), []byte(newSecret)), nil, "exp out of range"},
+    {"far past nbf", signJWT(t, hs("2025-02"), claims(map[string]any{"nbf": -1e19}), []byte(newSecret)), nil, "nbf out of range"},
+    {"expired", signJWT(t, hs("2025-02"), claims(map[string]any{"exp": now.Add(-time.Hour).Unix()}), []byte(newSecret)), nil, "expired"},
+    {"no exp", signJWT(t, hs("2025-02"), claims(map[string]any{"exp": nil}), []byte(newSecret)), nil, "no exp"},
+    {"not yet valid", signJWT(t, hs("2025-02"), claims(map[string]any{"nbf": now.Add(time.Hour).Unix()}), []byte(newSecret)), nil, "not valid yet"},
+    {"wrong issuer", signJWT(t, hs("2025-02"), claims(map[string]any{"iss": "https://evil.example"}), []byte(newSecret)), nil, "issuer"},
+    {"wrong audience", signJWT(t, hs("2025-02"), claims(map[string]any{"aud": "billing"}), []byte(newSecret)), nil, "not for"},
+    {"retired key", signJWT(t, hs("2024-12"), claims(nil), []byte(oldSecret)), nil, "unknown kid"},
+    {"wrong secret", signJWT(t, hs("2025-01"), claims(nil), []byte(newSecret)), nil, "invalid signature"},
+    {"alg none", signJWT(t, map[string]any{"alg": "none", "kid": "2025-01"}, claims(nil), nil), nil, "does not sign"},
+    {"public key as hmac secret", signJWT(t, hs("sso-rsa"), claims(nil), public), nil, "does not sign"},
+    {"malformed", "a.b", nil, "malformed"},
+  }
+  for _, tt := range tests {
+    t.Run(tt.name, func(t *testing.T) {
+      principal, err := verifier.Verify(tt.token)
+      if tt.err != "" {
+        if err == nil || !strings.Contains(err.Error(), tt.err) {
+          t.Fatalf("Expected an error containing %q, got %v", tt.err, err)
+        }
+        return
+      }
+      if err != nil {
+        t.Fatalf("Expected the token to be accepted, got %v", err)
+      }
+      if principal.Name != "alice" || strings.Join(principal.Scopes, ",") != strings.Join(tt.scopes, ",") {
+        t.Errorf("Expected alice with %v, got %+v", tt.scopes, principal)
+      }
+    })
+  }
+
+  // Changing the payload breaks the signature
+  token := signJWT(t, hs("2025-02"), claims(nil), []byte(newSecret))
+  parts := strings.Split(token, ".")
+  parts[1] = base64.RawURLEncoding.EncodeToString([]byte(`{"iss":"https://sso.example","aud":"player-api","sub":"mallory","exp":9999999999,"roles":["ops"]}`))
+  if _, err := verifier.Verify(strings.Join(parts, ".")); err == nil {
+    t.Errorf("Expected a tampered token to be rejected")
+  }
+}
+
+func TestNewJWTVerifier_Config(t *testing.T) {
+  path := filepath.Join(t.TempDir(), "jwt.json")
+  os.WriteFile(path, []byte(`{
+    "issuer": "https://sso.example",
+    "audience": "player-api",
+    "leeway_seconds": 0,
+    "keys": [{"kid": "only", "alg": "HS256", "secret": "`+oldSecret+`"}]
+  }`), 0o600)
+  config, err := LoadJWTConfig(path)
+  if err != nil {
+    t.Fatalf("Failed to load config: %v", err)
+  }
+  verifier, err := NewJWTVerifier(config)
+  if err != nil {
+    t.Fatalf("Failed to create verifier: %v", err)
+  }
+
+  // With a single key the kid may be left out, and roles default to the scope names
+  token := signJWT(t, map[string]any{"alg": AlgHS256}, map[string]any{
+    "iss": testIssuer, "aud": testAudience, "sub": "svc", "exp": time.Now().Add(time.Minute).Unix(), "roles": []string{"read"},
+  }, []byte(oldSecret))
+  if principal, err := verifier.Verify(token); err != nil || len(principal.Scopes) != 1 || principal.Scopes[0] != ScopeRead {
+    t.Errorf("Expected svc with the read scope, got %+v %v", principal, err)
+  }
+
+  // A leeway of 0 is kept rather than replaced by the default
+  token = signJWT(t, map[string]any{"alg": AlgHS256}, map[string]any{
+    "iss": testIssuer, "aud": testAudience, "sub": "svc", "exp": time.Now().Add(-time.Second).Unix(), "roles": []string{"read"},
+  }, []byte(oldSecret))
+  if _, err := verifier.Verify(token); err == nil || !strings.Contains(err.Error(), "expired") {
+    t.Errorf("Expected a token expired a second ago to be rejected without leeway, got %v", err)
+  }
+
+  key := JWTKey{ID: "k", Algorithm: AlgHS256, Secret: oldSecret}
+  negative, huge := -1, 1<<40
+  tests := []struct {
+    name   string
+    config JWTConfig
+  }{
+    {"no issuer", JWTConfig{Audience: testAudience, Keys: []JWTKey{key}}},
+    {"no keys", JWTConfig{Issuer: testIssuer, Audience: testAudience}},
+    {"duplicate kid", JWTConfig{Issuer: testIssuer, Audience: testAudience, Keys: []JWTKey{key, key}}},
+    {"short secret", JWTConfig{Issuer: testIssuer, Audience: testAudience, Keys: []JWTKey{{ID: "k", Algorithm: AlgHS256, Secret: "short"}}}},
+    {"bad pem", JWTConfig{Issuer: testIssuer, Audience: testAudience, Keys: []JWTKey{{ID: "k", Algorithm: AlgRS256, PublicKey: "nope"}}}},
+    {"unsupported alg", JWTConfig{Issuer: testIssuer, Audience: testAudience, Keys: []JWTKey{{ID: "k", Algorithm: "ES256"}}}},
+    {"unknown scope", JWTConfig{Issuer: testIssuer, Audience: testAudience, Keys: []JWTKey{key}, Roles: map[string]string{"root": "superuser"}}},
+    {"negative leeway", JWTConfig{Issuer: testIssuer, Audience: testAudience, Keys: []JWTKey{key}, LeewaySeconds: &negative}},
+    {"huge leeway", JWTConfig{Issuer: testIssuer, Audience: testAudience, Keys: []JWTKey{key}, LeewaySeconds: &huge}},
+  }
+  for _, tt := range tests {
+    if _, err := NewJWTVerifier(tt.config); err == nil {
+      t.Errorf("%s: expected the config to be rejected", tt.name)
+    }
+  }
+}
+
+func TestRequireScope_JWT(t *testing.T) {
+  verifier, _, now := testJWTVerifier(t)
+  service := NewPlayerService()
+  router := ActorMiddleware(newRouter(NewPlayerHandlerWithAuth(service, nil, NewAPIKeyStore(), verifier), nil))
+  token := func(roles ...string) string {
+    return signJWT(t, map[string]any{"alg": AlgHS256, "kid": "2025-02"}, map[string]any{
+      "iss": testIssuer, "aud": testAudience, "sub": "alice", "exp": now.Add(time.Hour).Unix(), "roles": roles,
+    }, []byte(newSecret))
+  }
+  do := func(method, target, token string) int {
+    req := httptest.NewRequest(method, target, nil)
+    req.Header.Set("Authorization", "Bearer "+token)
+    w := httptest.NewRecorder()
+    router.ServeHTTP(w, req)
+    return w.Code
+  }
+
+  if code := do("GET", "/players", token("viewer")); code != http.StatusOK {
+    t.Errorf("Expected a viewer to read, got %d", code)
+  }
+  if code := do("DELETE", "/players/1", token("viewer")); code != http.StatusForbidden {
+    t.Errorf("Expected a viewer not to delete, got %d", code)
+  }
+  if code := do("GET", "/players", token()); code != http.StatusForbidden {
+    t.Errorf("Expected a token without roles to be forbidden, got %d", code)
+  }
+  if code := do("DELETE", "/players/1", token("editor")); code != http.StatusOK {
+    t.Errorf("Expected an editor to delete, got %d", code)
+  }
+  if code := do("GET", "/players", token("viewer")[1:]); code != http.StatusUnauthorized {
+    t.Errorf("Expected a mangled token to be unauthorized, got %d", code)
+  }
+
+  entries := auditLog(t, service, AuditQuery{Action: AuditDelete, Limit: 10}).Entries
+  if len(entries) != 1 || entries[0].Actor != "alice" {
+    t.Errorf("Expected the delete to be attributed to the token's subject, got %+v", entries)
+  }
+}
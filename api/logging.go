@@ -0,0 +1,81 @@
+package main
+
+import (
+  "context"
+  "fmt"
+  "io"
+  "log/slog"
+  "strings"
+)
+
+// Log formats
+const (
+  LogFormatText = "text"
+  LogFormatJSON = "json"
+)
+
+// Log field names shared across the package, so every line uses the same keys
+const (
+  LogKeyRequestID = "request_id"
+  LogKeyTraceID   = "trace_id"
+  LogKeySpanID    = "span_id"
+  LogKeyPlayerID  = "player_id"
+  LogKeyTeamID    = "team_id"
+  LogKeyMatchID   = "match_id"
+  LogKeyError     = "error"
+)
+
+// NewLogger creates a logger writing records at level or above to w in the
+// given format. Records logged with a request's context carry its request ID.
+func NewLogger(w io.Writer, format string, level slog.Level) (*slog.Logger, error) {
+  options := &slog.HandlerOptions{Level: level}
+  var handler slog.Handler
+  switch strings.ToLower(format) {
+  case "", LogFormatText:
+    handler = slog.NewTextHandler(w, options)
+  case LogFormatJSON:
+    handler = slog.NewJSONHandler(w, options)
+  default:
+    return nil, fmt.Errorf("%w: log format %q must be %s or %s", ErrInvalidInput, format, LogFormatText, LogFormatJSON)
+  }
+  return slog.New(contextHandler{handler}), nil
+}
+
+// ParseLogLevel reads a level such as "debug", "info", "warn" or "error".
+// An empty string means info.
+func ParseLogLevel(raw string) (slog.Level, error) {
+  var level slog.Level
+  if raw == "" {
+    return level, nil
+  }
+  if err := level.UnmarshalText([]byte(raw)); err != nil {
+    return level, fmt.Errorf("%w: unknown log level %q", ErrInvalidInput, raw)
+  }
+  return level, nil
+}
+
+// contextHandler adds the request ID and trace from the record's context, so
+// handlers only need to log with r.Context() to be correlated with their
+// request
+type contextHandler struct {
+  slog.Handler
+}
+
+func (h contextHandler) Handle(ctx context.Context, record slog.Record) error {
+  if id := RequestIDFrom(ctx); id != "" {
+    record.AddAttrs(slog.String(LogKeyRequestID, id))
+  }
+  if span := SpanFrom(ctx); span != nil {
+    sc := span.Context()
+    record.AddAttrs(slog.String(LogKeyTraceID, sc.TraceID), slog.String(LogKeySpanID, sc.SpanID))
+  }
+  return h.Handler.Handle(ctx, record)
+}
+
+func (h contextHandler) WithAttrs(attrs []slog.Attr) slog.Handler {
+  return contextHandler{h.Handler.WithAttrs(attrs)}
+}
+
+func (h contextHandler) WithGroup(name string) slog.Handler {
+  return contextHandler{h.Handler.WithGroup(name)}
+}
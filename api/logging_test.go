@@ -0,0 +1,126 @@
+package main
+
+import (
+  "bytes"
+  "encoding/json"
+  "log/slog"
+  "net/http"
+  "net/http/httptest"
+  "strings"
+  "testing"
+)
+
+// captureLogs sends the default logger's JSON output to the returned buffer
+// until the test ends
+func captureLogs(t *testing.T, level slog.Level) *bytes.Buffer {
+  t.Helper()
+  var buf bytes.Buffer
+  logger, err := NewLogger(&buf, LogFormatJSON, level)
+  if err != nil {
+    t.Fatalf("Failed to create logger: %v", err)
+  }
+  previous := slog.Default()
+  slog.SetDefault(logger)
+  t.Cleanup(func() { slog.SetDefault(previous) })
+  return &buf
+}
+
+// logLines decodes JSON log output, one record per line
+func logLines(t *testing.T, buf *bytes.Buffer) []map[string]any {
+  t.Helper()
+  var lines []map[string]any
+  for line := range strings.SplitSeq(strings.TrimSpace(buf.String()), "\n") {
+    var record map[string]any
+    if err := json.Unmarshal([]byte(line), &record); err != nil {
+      t.Fatalf("Expected a JSON log line, got %q", line)
+    }
+    lines = append(lines, record)
+  }
+  return lines
+}
+
+func TestNewLogger(t *testing.T) {
+  var buf bytes.Buffer
+  logger, err := NewLogger(&buf, LogFormatText, slog.LevelWarn)
+  if err != nil {
+    t.Fatalf("Failed to create logger: %v", err)
+  }
+  logger.Info("hidden")
+  logger.WarnContext(WithRequestID(t.Context(), "req-1"), "shown", LogKeyPlayerID, "7")
+  if out := buf.String(); strings.Contains(out, "hidden") || !strings.Contains(out, "request_id=req-1") || !strings.Contains(out, "player_id=7") {
+    t.Errorf("Expected only the warning, with its request ID, got %q", out)
+  }
+
+  if _, err := NewLogger(&buf, "xml", slog.LevelInfo); err == nil {
+    t.Errorf("Expected an unknown format to be rejected")
+  }
+  if level, err := ParseLogLevel("DEBUG"); err != nil || level != slog.LevelDebug {
+    t.Errorf("Expected debug, got %v %v", level, err)
+  }
+  if level, err := ParseLogLevel(""); err != nil || level != slog.LevelInfo {
+    t.Errorf("Expected info by default, got %v %v", level, err)
+  }
+  if _, err := ParseLogLevel("loud"); err == nil {
+    t.Errorf("Expected an unknown level to be rejected")
+  }
+}
+
+func TestLogging_RequestIDs(t *testing.T) {
+  buf := captureLogs(t, slog.LevelInfo)
+  keys := NewAPIKeyStore()
+  reader, _ := keys.CreateAPIKey(APIKeyRequest{Name: "dashboard", Scopes: []string{ScopeRead}})
+  router := newRouter(NewPlayerHandlerWithAuth(NewPlayerService(), nil, keys, nil), nil)
+  handler := RequestIDMiddleware(LoggingMiddleware(router))
+  get := func(target, requestID string) *httptest.ResponseRecorder {
+    req := httptest.NewRequest("GET", target, nil)
+    req.Header.Set("Authorization", "Bearer "+reader.Key)
+    if requestID != "" {
+      req.Header.Set(RequestIDHeader, requestID)
+    }
+    w := httptest.NewRecorder()
+    handler.ServeHTTP(w, req)
+    return w
+  }
+
+  w := get("/players/1", "trace-123")
+  if w.Code != http.StatusOK {
+    t.Fatalf("Expected status 200, got %d", w.Code)
+  }
+
+  lines := logLines(t, buf)
+  if len(lines) != 2 {
+    t.Fatalf("Expected a handler line and a request line, got %v", lines)
+  }
+  if lines[0][LogKeyPlayerID] != "1" || lines[0][LogKeyRequestID] != "trace-123" {
+    t.Errorf("Expected the player ID and request ID as fields, got %v", lines[0])
+  }
+  request := lines[1]
+  if request["method"] != "GET" || request["path"] != "/players/1" || request["status"] != float64(200) || request[LogKeyRequestID] != "trace-123" {
+    t.Errorf("Unexpected request line %v", request)
+  }
+  if _, ok := request["latency_ms"].(float64); !ok {
+    t.Errorf("Expected latency as a number, got %v", request)
+  }
+
+  // Errors carry the request ID in both the response and the log
+  buf.Reset()
+  w = get("/players/999", "")
+  var response Response
+  if err := json.NewDecoder(w.Body).Decode(&response); err != nil {
+    t.Fatalf("Failed to decode response: %v", err)
+  }
+  id := w.Header().Get(RequestIDHeader)
+  if w.Code != http.StatusNotFound || id == "" || response.RequestID != id {
+    t.Errorf("Expected a 404 with request ID %q, got %d %+v", id, w.Code, response)
+  }
+  lines = logLines(t, buf)
+  if lines[0]["level"] != "WARN" || lines[0][LogKeyRequestID] != id || lines[0]["status"] != float64(404) {
+    t.Errorf("Expected a warning with the request ID, got %v", lines[0])
+  }
+
+  // Successful responses don't need one
+  w = get("/players/1", "")
+  if strings.Contains(w.Body.String(), "request_id") {
+    t.Errorf("Expected no request ID in a successful response, got %s", w.Body.String())
+  }
+}
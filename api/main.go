@@ -1,17 +1,23 @@
 package main
 
 import (
+  "cmp"
   "context"
-  "encoding/json"
-  "log"
+  "fmt"
+  "log/slog"
   "net/http"
   "os"
   "os/signal"
+  "path/filepath"
+  "strconv"
+  "strings"
+  "sync"
   "syscall"
   "time"
 )
 
-// LoggingMiddleware logs HTTP requests
+// LoggingMiddleware logs each request with its status and latency. It must
+// run inside RequestIDMiddleware so the line carries the request ID.
 func LoggingMiddleware(next http.Handler) http.Handler {
   return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
     start := time.Now()
@@ -22,21 +28,37 @@ func LoggingMiddleware(next http.Handler) http.Handler {
     next.ServeHTTP(recorder, r)
     
     duration := time.Since(start)
-    log.Printf("%s %s %d %v", r.Method, r.URL.Path, recorder.statusCode, duration)
+    slog.LogAttrs(r.Context(), slog.LevelInfo, "Request",
+      slog.String("method", r.Method),
+      slog.String("path", r.URL.Path),
+      slog.Int("status", recorder.statusCode),
+      slog.Float64("latency_ms", float64(duration.Microseconds())/1000),
+      slog.String("remote_addr", r.RemoteAddr),
+    )
   })
 }
 
-// CORS middleware to handle Cross-Origin Resource Sharing
-func CORSMiddleware(next http.Handler) http.Handler {
+// RequestIDMiddleware gives every request an ID, taken from X-Request-ID
+// when the client sends a usable one, and echoes it in the response
+func RequestIDMiddleware(next http.Handler) http.Handler {
   return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
-    w.Header().Set("Access-Control-Allow-Origin", "*")
-    w.Header().Set("Access-Control-Allow-Methods", "GET, POST, PUT, DELETE, OPTIONS")
-    w.Header().Set("Access-Control-Allow-Headers", "Content-Type, Authorization")
+    id := r.Header.Get(RequestIDHeader)
+    if !validRequestID(id) {
+      id = newRequestID()
+    }
+    w.Header().Set(RequestIDHeader, id)
     
-    // Handle preflight requests
-    if r.Method == "OPTIONS" {
-      w.WriteHeader(http.StatusOK)
-      return
+    next.ServeHTTP(w, r.WithContext(WithRequestID(r.Context(), id)))
+  })
+}
+
+// ActorMiddleware records the X-Actor header in the request context, so
+// changes made by the request are attributed to that actor. Authenticated
+// requests are attributed to their principal instead (see RequireScope).
+func ActorMiddleware(next http.Handler) http.Handler {
+  return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+    if actor := cleanActor(r.Header.Get(ActorHeader)); actor != "" {
+      r = r.WithContext(WithActor(r.Context(), actor))
     }
     
     next.ServeHTTP(w, r)
@@ -54,35 +76,378 @@ func (r *responseRecorder) WriteHeader(statusCode int) {
   r.ResponseWriter.WriteHeader(statusCode)
 }
 
-func main() {
-  // Initialize service and handler
-  playerService := NewPlayerService()
-  playerHandler := NewPlayerHandler(playerService)
+// Unwrap lets http.ResponseController reach the underlying writer
+func (r *responseRecorder) Unwrap() http.ResponseWriter {
+  return r.ResponseWriter
+}
+
+// newLogger configures structured logging from LOG_FORMAT (text or json)
+// and LOG_LEVEL (debug, info, warn or error)
+func newLogger() (*slog.Logger, error) {
+  level, err := ParseLogLevel(os.Getenv("LOG_LEVEL"))
+  if err != nil {
+    return nil, fmt.Errorf("invalid LOG_LEVEL: %w", err)
+  }
+  logger, err := NewLogger(os.Stderr, os.Getenv("LOG_FORMAT"), level)
+  if err != nil {
+    return nil, fmt.Errorf("invalid LOG_FORMAT: %w", err)
+  }
+  return logger, nil
+}
+
+// fatal logs err and exits
+func fatal(message string, err error) {
+  slog.Error(message, LogKeyError, err)
+  os.Exit(1)
+}
+
+// newPlayerStore picks the storage backend. With DATA_DIR set players are kept
+// in a durable file store, otherwise they live in memory only.
+func newPlayerStore() (PlayerStore, error) {
+  dataDir := os.Getenv("DATA_DIR")
+  if dataDir == "" {
+    slog.Info("DATA_DIR not set, using in-memory storage")
+    return NewMemoryStore(), nil
+  }
   
-  // Create router
-  router := http.NewServeMux()
+  opts := FileStoreOptions{}
+  if every := os.Getenv("SNAPSHOT_EVERY"); every != "" {
+    n, err := strconv.Atoi(every)
+    if err != nil {
+      return nil, fmt.Errorf("invalid SNAPSHOT_EVERY %q: %w", every, err)
+    }
+    opts.SnapshotEvery = n
+  }
   
-  // Register routes with the improved handlers
-  router.HandleFunc("GET /players", playerHandler.GetPlayers)
-  router.HandleFunc("GET /players/{id}", playerHandler.GetPlayer)
-  router.HandleFunc("POST /players", playerHandler.CreatePlayer)
-  router.HandleFunc("PUT /players/{id}", playerHandler.UpdatePlayer)
-  router.HandleFunc("DELETE /players/{id}", playerHandler.DeletePlayer)
-  
-  // Add health check endpoint
-  router.HandleFunc("GET /health", func(w http.ResponseWriter, r *http.Request) {
-    response := Response{
-      Status:  "success",
-      Message: "Service is healthy",
-      Data:    map[string]string{"version": "1.0.0", "service": "player-api"},
+  slog.Info("Using file storage", "dir", dataDir)
+  return OpenFileStore(dataDir, opts)
+}
+
+// purgerConfig reads how long deleted players stay in the trash and how
+// often the purger looks for expired ones
+func purgerConfig() (retention, interval time.Duration, err error) {
+  retention, interval = DefaultTrashRetention, DefaultPurgeInterval
+  if raw := os.Getenv("TRASH_RETENTION"); raw != "" {
+    if retention, err = time.ParseDuration(raw); err != nil || retention <= 0 {
+      return 0, 0, fmt.Errorf("invalid TRASH_RETENTION %q: must be a positive duration", raw)
     }
-    
-    w.Header().Set("Content-Type", "application/json")
-    json.NewEncoder(w).Encode(response)
+  }
+  if raw := os.Getenv("PURGE_INTERVAL"); raw != "" {
+    if interval, err = time.ParseDuration(raw); err != nil || interval <= 0 {
+      return 0, 0, fmt.Errorf("invalid PURGE_INTERVAL %q: must be a positive duration", raw)
+    }
+  }
+  return retention, interval, nil
+}
+
+// drainDelay reads how long to report not-ready after a shutdown signal
+// before the server stops accepting requests
+func drainDelay() (time.Duration, error) {
+  raw := os.Getenv("DRAIN_DELAY")
+  if raw == "" {
+    return DefaultDrainDelay, nil
+  }
+  delay, err := time.ParseDuration(raw)
+  if err != nil || delay < 0 {
+    return 0, fmt.Errorf("invalid DRAIN_DELAY %q: must be a duration of 0 or more", raw)
+  }
+  return delay, nil
+}
+
+// newAuditTrail keeps the audit trail next to the player data when DATA_DIR
+// is set, and in memory otherwise
+func newAuditTrail() (*AuditTrail, error) {
+  dataDir := os.Getenv("DATA_DIR")
+  if dataDir == "" {
+    return NewMemoryAuditTrail(), nil
+  }
+  return OpenAuditTrail(filepath.Join(dataDir, auditFileName), AuditTrailOptions{})
+}
+
+// newWebhooks keeps webhook subscriptions next to the player data when
+// DATA_DIR is set, and in memory otherwise
+func newWebhooks(events *EventBroker) (*WebhookDispatcher, error) {
+  dataDir := os.Getenv("DATA_DIR")
+  if dataDir == "" {
+    return NewWebhookDispatcher(events, WebhookOptions{}), nil
+  }
+  return OpenWebhookDispatcher(filepath.Join(dataDir, webhooksFileName), events, WebhookOptions{})
+}
+
+// newAPIKeys keeps API keys next to the player data when DATA_DIR is set,
+// and in memory otherwise. ADMIN_API_KEY is accepted as an admin key on top
+// of the stored ones, to create the first keys with.
+func newAPIKeys() (*APIKeyStore, error) {
+  keys := NewAPIKeyStore()
+  if dataDir := os.Getenv("DATA_DIR"); dataDir != "" {
+    var err error
+    if keys, err = OpenAPIKeyStore(filepath.Join(dataDir, apiKeysFileName)); err != nil {
+      return nil, err
+    }
+  }
+  
+  if bootstrap := os.Getenv("ADMIN_API_KEY"); bootstrap != "" {
+    if err := keys.SetBootstrapKey(bootstrap); err != nil {
+      return nil, fmt.Errorf("invalid ADMIN_API_KEY: %w", err)
+    }
+    slog.Info("ADMIN_API_KEY accepted as an admin key")
+  } else if len(keys.APIKeys()) == 0 {
+    slog.Warn("No API keys yet; set ADMIN_API_KEY to create some")
+  }
+  return keys, nil
+}
+
+// newJWTVerifier accepts JWTs as configured in the JSON file named by
+// JWT_CONFIG. Without it only API keys are accepted.
+func newJWTVerifier() (*JWTVerifier, error) {
+  path := os.Getenv("JWT_CONFIG")
+  if path == "" {
+    return nil, nil
+  }
+  
+  config, err := LoadJWTConfig(path)
+  if err != nil {
+    return nil, err
+  }
+  verifier, err := NewJWTVerifier(config)
+  if err != nil {
+    return nil, fmt.Errorf("invalid JWT_CONFIG %s: %w", path, err)
+  }
+  slog.Info("Accepting JWTs", "issuer", config.Issuer, "kids", verifier.KeyIDs())
+  return verifier, nil
+}
+
+// newRateLimiter limits each client per route group, with RATE_LIMITS
+// overriding the defaults, e.g. "read=1000/1m,write=off"
+func newRateLimiter() (*RateLimiter, error) {
+  limits, err := ParseRateLimits(os.Getenv("RATE_LIMITS"))
+  if err != nil {
+    return nil, fmt.Errorf("invalid RATE_LIMITS: %w", err)
+  }
+  for _, group := range []string{RateGroupIP, RateGroupPublic, RateGroupRead, RateGroupWrite, RateGroupAdmin} {
+    if limit, limited := limits[group]; limited {
+      slog.Info("Rate limiting requests per client", "group", group, "limit", limit.String())
+    }
+  }
+  return NewRateLimiter(limits), nil
+}
+
+// newCORSPolicy reads which browser origins may call the API. Without
+// CORS_ALLOWED_ORIGINS no cross-origin requests are allowed.
+func newCORSPolicy() (*CORSPolicy, error) {
+  config := CORSConfig{
+    AllowedOrigins: ParseCORSList(os.Getenv("CORS_ALLOWED_ORIGINS")),
+    AllowedMethods: ParseCORSList(os.Getenv("CORS_ALLOWED_METHODS")),
+    AllowedHeaders: ParseCORSList(os.Getenv("CORS_ALLOWED_HEADERS")),
+  }
+  if raw := os.Getenv("CORS_ALLOW_CREDENTIALS"); raw != "" {
+    allow, err := strconv.ParseBool(raw)
+    if err != nil {
+      return nil, fmt.Errorf("invalid CORS_ALLOW_CREDENTIALS %q: %w", raw, err)
+    }
+    config.AllowCredentials = allow
+  }
+  if raw := os.Getenv("CORS_MAX_AGE"); raw != "" {
+    maxAge, err := time.ParseDuration(raw)
+    if err != nil || maxAge <= 0 {
+      return nil, fmt.Errorf("invalid CORS_MAX_AGE %q: must be a positive duration", raw)
+    }
+    config.MaxAge = maxAge
+  }
+  
+  policy, err := NewCORSPolicy(config)
+  if err != nil {
+    return nil, err
+  }
+  if len(config.AllowedOrigins) == 0 {
+    slog.Info("CORS_ALLOWED_ORIGINS not set, cross-origin requests are not allowed")
+  } else {
+    slog.Info("Allowing cross-origin requests", "origins", config.AllowedOrigins)
+  }
+  return policy, nil
+}
+
+// newTracer records spans as JSON lines in the file named by TRACE_FILE.
+// Without it requests still get trace IDs for propagation and logs, and the
+// returned exporter is nil.
+func newTracer() (*Tracer, *FileSpanExporter, error) {
+  path := os.Getenv("TRACE_FILE")
+  if path == "" {
+    return NewTracer(nil), nil, nil
+  }
+  
+  spans, err := OpenFileSpanExporter(path)
+  if err != nil {
+    return nil, nil, err
+  }
+  slog.Info("Recording spans", "file", path)
+  return NewTracer(spans), spans, nil
+}
+
+// route is an endpoint and the scope an API key needs to call it
+type route struct {
+  pattern string
+  scope   string
+  handler http.HandlerFunc
+}
+
+// routes lists every endpoint the server serves
+func routes(h *PlayerHandler) []route {
+  return []route{
+    {"GET /health", ScopePublic, h.GetLivez},
+    {"GET /livez", ScopePublic, h.GetLivez},
+    {"GET /readyz", ScopePublic, h.GetReadyz},
+    {"GET /metrics", ScopeRead, h.GetMetrics},
+    {"GET /openapi.json", ScopePublic, h.GetOpenAPI},
+    {"GET /players", ScopeRead, h.GetPlayers},
+    {"GET /players/search", ScopeRead, h.SearchPlayers},
+    {"GET /players/autocomplete", ScopeRead, h.AutocompletePlayers},
+    {"GET /players/export", ScopeRead, h.ExportPlayers},
+    {"GET /players/movers", ScopeRead, h.GetRatingMovers},
+    {"GET /players/events", ScopeRead, h.StreamEvents},
+    {"GET /players/{id}", ScopeRead, h.GetPlayer},
+    {"POST /players", ScopeWrite, h.CreatePlayer},
+    {"POST /players/batch", ScopeWrite, h.BatchPlayers},
+    {"POST /players/import", ScopeWrite, h.ImportPlayers},
+    {"PUT /players/{id}", ScopeWrite, h.UpdatePlayer},
+    {"PATCH /players/{id}", ScopeWrite, h.PatchPlayer},
+    {"DELETE /players/{id}", ScopeWrite, h.DeletePlayer},
+    {"POST /players/{id}/restore", ScopeWrite, h.RestorePlayer},
+    {"GET /trash", ScopeRead, h.GetTrash},
+    {"GET /teams", ScopeRead, h.GetTeams},
+    {"GET /teams/{id}", ScopeRead, h.GetTeam},
+    {"GET /teams/{id}/players", ScopeRead, h.GetTeamPlayers},
+    {"POST /teams", ScopeWrite, h.CreateTeam},
+    {"POST /teams/{id}/players", ScopeWrite, h.CreateTeamPlayer},
+    {"PUT /teams/{id}", ScopeWrite, h.UpdateTeam},
+    {"DELETE /teams/{id}", ScopeWrite, h.DeleteTeam},
+    {"GET /teams/{id}/stats", ScopeRead, h.GetTeamStats},
+    {"GET /players/{id}/stats", ScopeRead, h.GetPlayerStats},
+    {"GET /players/{id}/history", ScopeRead, h.GetPlayerHistory},
+    {"GET /matches", ScopeRead, h.GetMatches},
+    {"GET /matches/{id}", ScopeRead, h.GetMatch},
+    {"POST /matches", ScopeWrite, h.CreateMatch},
+    {"PUT /matches/{id}", ScopeWrite, h.UpdateMatch},
+    {"DELETE /matches/{id}", ScopeWrite, h.DeleteMatch},
+    {"GET /audit", ScopeAdmin, h.GetAudit},
+    {"GET /webhooks", ScopeAdmin, h.GetWebhooks},
+    {"GET /webhooks/{id}", ScopeAdmin, h.GetWebhook},
+    {"GET /webhooks/{id}/deliveries", ScopeAdmin, h.GetWebhookDeliveries},
+    {"POST /webhooks", ScopeAdmin, h.CreateWebhook},
+    {"DELETE /webhooks/{id}", ScopeAdmin, h.DeleteWebhook},
+    {"GET /apikeys", ScopeAdmin, h.GetAPIKeys},
+    {"POST /apikeys", ScopeAdmin, h.CreateAPIKey},
+    {"DELETE /apikeys/{id}", ScopeAdmin, h.DeleteAPIKey},
+  }
+}
+
+// probeRoutes are polled by orchestrators, load balancers and monitors, so
+// they aren't rate limited: a 429 would look like an outage
+var probeRoutes = map[string]bool{"GET /livez": true, "GET /readyz": true, "GET /health": true}
+
+// newRouter registers every route behind a check for the scope it needs
+// and, when limiter isn't nil, the per-IP rate limit before the check and
+// the rate limit for its scope after it
+func newRouter(h *PlayerHandler, limiter *RateLimiter) *http.ServeMux {
+  router := http.NewServeMux()
+  for _, rt := range routes(h) {
+    handler := tracedHandler(rt.handler)
+    limited := limiter != nil && !probeRoutes[rt.pattern]
+    if limited {
+      handler = limiter.Limit(cmp.Or(rt.scope, RateGroupPublic), handler)
+    }
+    handler = h.RequireScope(rt.scope, handler)
+    if limited {
+      handler = limiter.LimitIP(handler)
+    }
+    router.Handle(rt.pattern, handler)
+  }
+  return router
+}
+
+func main() {
+  logger, err := newLogger()
+  if err != nil {
+    fmt.Fprintln(os.Stderr, err)
+    os.Exit(1)
+  }
+  slog.SetDefault(logger)
+  
+  // Initialize storage, service and handler
+  store, err := newPlayerStore()
+  if err != nil {
+    fatal("Failed to open player store", err)
+  }
+  if err := SeedSamplePlayers(store); err != nil {
+    fatal("Failed to seed sample data", err)
+  }
+  
+  audit, err := newAuditTrail()
+  if err != nil {
+    fatal("Failed to open audit trail", err)
+  }
+  
+  playerService := NewPlayerServiceWithAudit(store, audit)
+  
+  webhooks, err := newWebhooks(playerService.Events())
+  if err != nil {
+    fatal("Failed to open webhooks", err)
+  }
+  keys, err := newAPIKeys()
+  if err != nil {
+    fatal("Failed to open API keys", err)
+  }
+  jwt, err := newJWTVerifier()
+  if err != nil {
+    fatal("Failed to configure JWT authentication", err)
+  }
+  playerHandler := NewPlayerHandlerWithAuth(playerService, webhooks, keys, jwt)
+  
+  retention, purgeInterval, err := purgerConfig()
+  if err != nil {
+    fatal("Failed to configure purger", err)
+  }
+  
+  drain, err := drainDelay()
+  if err != nil {
+    fatal("Failed to configure shutdown", err)
+  }
+  
+  // Background workers run until the server has shut down; /readyz fails if
+  // one stops early
+  health := playerHandler.health
+  background, stopBackground := context.WithCancel(context.Background())
+  var workers sync.WaitGroup
+  health.Go(&workers, "purger", func() {
+    RunPurger(background, playerService, retention, purgeInterval)
   })
+  health.Go(&workers, "webhooks", func() {
+    webhooks.Run(background)
+  })
+  slog.Info("Purging deleted players", "retention", retention.String())
+  
+  limiter, err := newRateLimiter()
+  if err != nil {
+    fatal("Failed to configure rate limits", err)
+  }
   
-  // Apply middleware
-  handler := LoggingMiddleware(CORSMiddleware(router))
+  // Create router; every route declares the API key scope it needs
+  router := newRouter(playerHandler, limiter)
+  
+  cors, err := newCORSPolicy()
+  if err != nil {
+    fatal("Failed to configure CORS", err)
+  }
+  
+  tracer, spans, err := newTracer()
+  if err != nil {
+    fatal("Failed to configure tracing", err)
+  }
+  
+  // Apply middleware; request IDs and the trace come first so every log line
+  // and error response, including CORS rejections, carries them
+  handler := RequestIDMiddleware(tracer.Middleware(router, LoggingMiddleware(cors.Middleware(ActorMiddleware(router)))))
+  handler = playerHandler.metrics.Middleware(router, handler)
   
   // Configure server
   port := os.Getenv("PORT")
@@ -98,19 +463,22 @@ func main() {
     IdleTimeout:  60 * time.Second,
   }
   
+  // Event streams never finish on their own, so end them when shutdown
+  // starts rather than waiting for the timeout below. Writes still in flight
+  // keep publishing to the webhooks, which stop after the server has.
+  server.RegisterOnShutdown(playerService.Events().Close)
+  
   // Start server in a goroutine
   go func() {
-    log.Printf("🚀 Server starting on port %s", port)
-    log.Printf("📋 Available endpoints:")
-    log.Printf("   GET    /health")
-    log.Printf("   GET    /players")
-    log.Printf("   GET    /players/{id}")
-    log.Printf("   POST   /players")
-    log.Printf("   PUT    /players/{id}")
-    log.Printf("   DELETE /players/{id}")
+    build := ReadBuildInfo()
+    slog.Info("Server starting", "port", port, "version", build.Version, "commit", build.Commit, "go_version", build.GoVersion)
+    for _, rt := range routes(playerHandler) {
+      method, path, _ := strings.Cut(rt.pattern, " ")
+      slog.Info("Route", "method", method, "path", path, "scope", cmp.Or(rt.scope, "public"))
+    }
     
     if err := server.ListenAndServe(); err != nil && err != http.ErrServerClosed {
-      log.Fatalf("Failed to start server: %v", err)
+      fatal("Failed to start server", err)
     }
   }()
   
@@ -119,16 +487,37 @@ func main() {
   signal.Notify(quit, syscall.SIGINT, syscall.SIGTERM)
   <-quit
   
-  log.Println("🛑 Shutting down server...")
+  // Fail readiness first so load balancers stop sending traffic while the
+  // server still accepts it; a second signal skips the wait
+  health.Drain()
+  slog.Info("Draining", "delay", drain.String())
+  select {
+  case <-time.After(drain):
+  case <-quit:
+  }
+  
+  slog.Info("Shutting down server")
   
   // Give outstanding requests 30 seconds to complete
   ctx, cancel := context.WithTimeout(context.Background(), 30*time.Second)
   defer cancel()
   
   if err := server.Shutdown(ctx); err != nil {
-    log.Fatalf("Server forced to shutdown: %v", err)
+    fatal("Server forced to shutdown", err)
+  }
+  
+  stopBackground()
+  workers.Wait()
+  
+  if err := playerService.Close(); err != nil {
+    slog.Error("Error closing player store", LogKeyError, err)
+  }
+  if spans != nil {
+    if err := spans.Close(); err != nil {
+      slog.Error("Error closing span file", LogKeyError, err)
+    }
   }
   
-  log.Println("✅ Server stopped gracefully")
+  slog.Info("Server stopped gracefully")
 }
 
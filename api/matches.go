@@ -0,0 +1,499 @@
+package main
+
+import (
+  "cmp"
+  "context"
+  "errors"
+  "fmt"
+  "net/url"
+  "slices"
+  "strconv"
+  "strings"
+  "time"
+)
+
+// Match limits
+const (
+  // MatchDateLayout is the format of match dates and date range bounds
+  MatchDateLayout = "2006-01-02"
+
+  // MaxMatchMinute leaves room for extra time and stoppage time
+  MaxMatchMinute = 150
+  MaxScore       = 99
+  MaxLineupSize  = 60
+  MaxMatchEvents = 200
+
+  DefaultMatchLimit = 100
+  MaxMatchLimit     = 1000
+)
+
+// Match event types
+const (
+  EventGoal       = "goal"
+  EventAssist     = "assist"
+  EventYellowCard = "yellow_card"
+  EventRedCard    = "red_card"
+)
+
+// ErrMatchNotFound is returned when a match doesn't exist
+var ErrMatchNotFound = errors.New("match not found")
+
+// Appearance is a player's entry in a match lineup
+type Appearance struct {
+  PlayerID string `json:"player_id"`
+  TeamID   string `json:"team_id"`
+  Starter  bool   `json:"starter"`
+  Minutes  int    `json:"minutes"`
+}
+
+// MatchEvent is something a player did during a match
+type MatchEvent struct {
+  Type     string `json:"type"`
+  PlayerID string `json:"player_id"`
+  Minute   int    `json:"minute,omitempty"`
+}
+
+// Match is a played match with its lineups and events
+type Match struct {
+  ID         string       `json:"id"`
+  HomeTeamID string       `json:"home_team_id"`
+  AwayTeamID string       `json:"away_team_id"`
+  Date       string       `json:"date"`
+  HomeScore  int          `json:"home_score"`
+  AwayScore  int          `json:"away_score"`
+  Lineup     []Appearance `json:"lineup"`
+  Events     []MatchEvent `json:"events"`
+  Version    int64        `json:"version"`
+}
+
+// MatchRequest represents the request structure for creating/updating matches
+type MatchRequest struct {
+  HomeTeamID string       `json:"home_team_id"`
+  AwayTeamID string       `json:"away_team_id"`
+  Date       string       `json:"date"`
+  HomeScore  int          `json:"home_score"`
+  AwayScore  int          `json:"away_score"`
+  Lineup     []Appearance `json:"lineup"`
+  Events     []MatchEvent `json:"events"`
+}
+
+// Validate checks the shape of the match. Whether the teams and players
+// exist is checked when the match is stored.
+func (mr *MatchRequest) Validate() error {
+  if mr.HomeTeamID == "" || mr.AwayTeamID == "" {
+    return fmt.Errorf("%w: home_team_id and away_team_id are required", ErrInvalidInput)
+  }
+  if mr.HomeTeamID == mr.AwayTeamID {
+    return fmt.Errorf("%w: a team can't play itself", ErrInvalidInput)
+  }
+  if _, err := time.Parse(MatchDateLayout, mr.Date); err != nil {
+    return fmt.Errorf("%w: date must look like 2025-05-31", ErrInvalidInput)
+  }
+  if mr.HomeScore < 0 || mr.HomeScore > MaxScore || mr.AwayScore < 0 || mr.AwayScore > MaxScore {
+    return fmt.Errorf("%w: scores must be between 0 and %d", ErrInvalidInput, MaxScore)
+  }
+  if len(mr.Lineup) > MaxLineupSize {
+    return fmt.Errorf("%w: at most %d players per lineup", ErrInvalidInput, MaxLineupSize)
+  }
+  if len(mr.Events) > MaxMatchEvents {
+    return fmt.Errorf("%w: at most %d events per match", ErrInvalidInput, MaxMatchEvents)
+  }
+
+  inLineup := make(map[string]bool, len(mr.Lineup))
+  for i, appearance := range mr.Lineup {
+    if appearance.PlayerID == "" {
+      return fmt.Errorf("%w: lineup[%d]: player_id is required", ErrInvalidInput, i)
+    }
+    if inLineup[appearance.PlayerID] {
+      return fmt.Errorf("%w: lineup[%d]: player %s is listed twice", ErrInvalidInput, i, appearance.PlayerID)
+    }
+    if appearance.Minutes < 0 || appearance.Minutes > MaxMatchMinute {
+      return fmt.Errorf("%w: lineup[%d]: minutes must be between 0 and %d", ErrInvalidInput, i, MaxMatchMinute)
+    }
+    inLineup[appearance.PlayerID] = true
+  }
+
+  for i, event := range mr.Events {
+    switch event.Type {
+    case EventGoal, EventAssist, EventYellowCard, EventRedCard:
+    default:
+      return fmt.Errorf("%w: events[%d]: type must be one of %s, %s, %s or %s",
+        ErrInvalidInput, i, EventGoal, EventAssist, EventYellowCard, EventRedCard)
+    }
+    if !inLineup[event.PlayerID] {
+      return fmt.Errorf("%w: events[%d]: player %q is not in the lineup", ErrInvalidInput, i, event.PlayerID)
+    }
+    if event.Minute < 0 || event.Minute > MaxMatchMinute {
+      return fmt.Errorf("%w: events[%d]: minute must be between 0 and %d", ErrInvalidInput, i, MaxMatchMinute)
+    }
+  }
+  return nil
+}
+
+// ToMatch converts MatchRequest to Match with given ID
+func (mr *MatchRequest) ToMatch(id string) Match {
+  match := Match{ID: id, Version: 1}
+  match.set(*mr)
+  return match
+}
+
+// Update replaces the match with new data
+func (m *Match) Update(req MatchRequest) {
+  m.set(req)
+  m.Version++
+}
+
+func (m *Match) set(req MatchRequest) {
+  m.HomeTeamID = req.HomeTeamID
+  m.AwayTeamID = req.AwayTeamID
+  m.Date = req.Date
+  m.HomeScore = req.HomeScore
+  m.AwayScore = req.AwayScore
+  m.Lineup = slices.Clone(req.Lineup)
+  m.Events = slices.Clone(req.Events)
+  if m.Lineup == nil {
+    m.Lineup = []Appearance{}
+  }
+  if m.Events == nil {
+    m.Events = []MatchEvent{}
+  }
+}
+
+// checkMatchRefs makes sure the teams and players of a match exist. Lineup
+// entries without a team get the player's current team, which must be one
+// of the two teams playing.
+func (tx *playerTx) checkMatchRefs(req *MatchRequest) error {
+  for _, teamID := range []string{req.HomeTeamID, req.AwayTeamID} {
+    if _, exists := tx.getTeam(teamID); !exists {
+      return fmt.Errorf("%w: team %s does not exist", ErrInvalidInput, teamID)
+    }
+  }
+
+  lineup := slices.Clone(req.Lineup)
+  for i, appearance := range lineup {
+    player, exists := tx.get(appearance.PlayerID)
+    if !exists {
+      return fmt.Errorf("%w: lineup[%d]: player %s does not exist", ErrInvalidInput, i, appearance.PlayerID)
+    }
+    if appearance.TeamID == "" {
+      lineup[i].TeamID = player.TeamID
+    }
+    if lineup[i].TeamID != req.HomeTeamID && lineup[i].TeamID != req.AwayTeamID {
+      return fmt.Errorf("%w: lineup[%d]: player %s must play for team %s or %s",
+        ErrInvalidInput, i, appearance.PlayerID, req.HomeTeamID, req.AwayTeamID)
+    }
+  }
+  req.Lineup = lineup
+  return nil
+}
+
+// existingMatch returns a match that must exist at the given version
+func (tx *playerTx) existingMatch(id string, version int64) (Match, error) {
+  match, exists := tx.getMatch(id)
+  if !exists {
+    return Match{}, fmt.Errorf("%w: %s", ErrMatchNotFound, id)
+  }
+  if version != AnyVersion && match.Version != version {
+    return Match{}, fmt.Errorf("%w: match %s is at version %d, not %d",
+      ErrVersionMismatch, match.ID, match.Version, version)
+  }
+  return match, nil
+}
+
+// createMatch stages a new match
+func (tx *playerTx) createMatch(req MatchRequest) (Match, error) {
+  if err := req.Validate(); err != nil {
+    return Match{}, err
+  }
+  if err := tx.checkMatchRefs(&req); err != nil {
+    return Match{}, err
+  }
+
+  tx.matchCounter++
+  match := req.ToMatch(strconv.Itoa(tx.matchCounter))
+  tx.putMatch(nil, match)
+  return match, nil
+}
+
+// updateMatch stages new data for an existing match
+func (tx *playerTx) updateMatch(id string, req MatchRequest, version int64) (Match, error) {
+  if err := req.Validate(); err != nil {
+    return Match{}, err
+  }
+  match, err := tx.existingMatch(id, version)
+  if err != nil {
+    return Match{}, err
+  }
+  if err := tx.checkMatchRefs(&req); err != nil {
+    return Match{}, err
+  }
+
+  old := match
+  match.Update(req)
+  tx.putMatch(&old, match)
+  return match, nil
+}
+
+// deleteMatch stages the removal of a match
+func (tx *playerTx) deleteMatch(id string, version int64) (Match, error) {
+  match, err := tx.existingMatch(id, version)
+  if err != nil {
+    return Match{}, err
+  }
+  tx.removeMatch(match)
+  return match, nil
+}
+
+// DateRange limits matches to those played between From and To inclusive.
+// Either bound may be empty.
+type DateRange struct {
+  From string `json:"from,omitempty"`
+  To   string `json:"to,omitempty"`
+}
+
+// contains reports whether a match date falls inside the range. Dates use
+// MatchDateLayout, so they compare correctly as strings.
+func (r DateRange) contains(date string) bool {
+  return (r.From == "" || date >= r.From) && (r.To == "" || date <= r.To)
+}
+
+// parseDateRange reads the from and to query parameters
+func parseDateRange(values url.Values) (DateRange, error) {
+  r := DateRange{From: values.Get("from"), To: values.Get("to")}
+  for name, value := range map[string]string{"from": r.From, "to": r.To} {
+    if value == "" {
+      continue
+    }
+    if _, err := time.Parse(MatchDateLayout, value); err != nil {
+      return r, fmt.Errorf("%w: %s must look like 2025-05-31", ErrInvalidInput, name)
+    }
+  }
+  if r.From != "" && r.To != "" && r.From > r.To {
+    return r, fmt.Errorf("%w: from is after to", ErrInvalidInput)
+  }
+  return r, nil
+}
+
+// MatchQuery selects matches for GET /matches
+type MatchQuery struct {
+  TeamID   string
+  PlayerID string
+  Range    DateRange
+  Limit    int
+}
+
+// parseMatchQuery builds a MatchQuery from query parameters
+func parseMatchQuery(values url.Values) (MatchQuery, error) {
+  query := MatchQuery{TeamID: values.Get("team_id"), PlayerID: values.Get("player_id")}
+
+  for param := range values {
+    switch param {
+    case "team_id", "player_id", "from", "to", "limit":
+    default:
+      return query, fmt.Errorf("%w: unknown query parameter %q", ErrInvalidInput, param)
+    }
+  }
+
+  var err error
+  if query.Range, err = parseDateRange(values); err != nil {
+    return query, err
+  }
+  query.Limit, err = parseLimit(values.Get("limit"), DefaultMatchLimit, MaxMatchLimit)
+  return query, err
+}
+
+// matchIDs returns the IDs of the matches a query can match, from an index
+// when the query names a team or player
+func (s *PlayerService) matchIDs(teamID, playerID string) []string {
+  var set map[string]struct{}
+  switch {
+  case playerID != "":
+    set = s.indexes.playerMatches[playerID]
+  case teamID != "":
+    set = s.indexes.teamMatches[teamID]
+  default:
+    ids := make([]string, 0)
+    s.store.RangeMatches(func(match Match) bool {
+      ids = append(ids, match.ID)
+      return true
+    })
+    return ids
+  }
+
+  ids := make([]string, 0, len(set))
+  for id := range set {
+    ids = append(ids, id)
+  }
+  return ids
+}
+
+// QueryMatches returns the latest matches matching the query, newest first,
+// and the total number of matches that matched
+func (s *PlayerService) QueryMatches(query MatchQuery) ([]Match, int) {
+  s.mu.RLock()
+  defer s.mu.RUnlock()
+
+  matches := make([]Match, 0)
+  for _, id := range s.matchIDs(query.TeamID, query.PlayerID) {
+    match, exists := s.store.GetMatch(id)
+    if !exists || !query.Range.contains(match.Date) {
+      continue
+    }
+    if query.TeamID != "" && match.HomeTeamID != query.TeamID && match.AwayTeamID != query.TeamID {
+      continue
+    }
+    matches = append(matches, match)
+  }
+
+  slices.SortFunc(matches, func(a, b Match) int {
+    if c := strings.Compare(b.Date, a.Date); c != 0 {
+      return c
+    }
+    return compareIDs(b.ID, a.ID)
+  })
+  total := len(matches)
+  return matches[:min(query.Limit, total)], total
+}
+
+// GetMatchByID returns a match by ID
+func (s *PlayerService) GetMatchByID(id string) (Match, error) {
+  s.mu.RLock()
+  defer s.mu.RUnlock()
+
+  match, exists := s.store.GetMatch(id)
+  if !exists {
+    return Match{}, fmt.Errorf("%w: %s", ErrMatchNotFound, id)
+  }
+  return match, nil
+}
+
+// CreateMatch records a new match
+func (s *PlayerService) CreateMatch(ctx context.Context, req MatchRequest) (Match, error) {
+  return writeTx(ctx, s, func(tx *playerTx) (Match, error) {
+    return tx.createMatch(req)
+  })
+}
+
+// UpdateMatch replaces a match. Unless version is AnyVersion the update only
+// succeeds if the match is still at that version.
+func (s *PlayerService) UpdateMatch(ctx context.Context, id string, req MatchRequest, version int64) (Match, error) {
+  return writeTx(ctx, s, func(tx *playerTx) (Match, error) {
+    return tx.updateMatch(id, req, version)
+  })
+}
+
+// DeleteMatch deletes a match. Unless version is AnyVersion the delete only
+// succeeds if the match is still at that version.
+func (s *PlayerService) DeleteMatch(ctx context.Context, id string, version int64) (Match, error) {
+  return writeTx(ctx, s, func(tx *playerTx) (Match, error) {
+    return tx.deleteMatch(id, version)
+  })
+}
+
+// PlayerStats are a player's totals over a date range
+type PlayerStats struct {
+  PlayerID string `json:"player_id"`
+  DateRange
+  Appearances int `json:"appearances"`
+  Starts      int `json:"starts"`
+  Minutes     int `json:"minutes"`
+  Goals       int `json:"goals"`
+  Assists     int `json:"assists"`
+  YellowCards int `json:"yellow_cards"`
+  RedCards    int `json:"red_cards"`
+}
+
+// TeamStats are a team's results over a date range
+type TeamStats struct {
+  TeamID string `json:"team_id"`
+  DateRange
+  Played       int `json:"played"`
+  Won          int `json:"won"`
+  Drawn        int `json:"drawn"`
+  Lost         int `json:"lost"`
+  GoalsFor     int `json:"goals_for"`
+  GoalsAgainst int `json:"goals_against"`
+  Points       int `json:"points"`
+}
+
+// PlayerStats sums a player's appearances and events over a date range
+func (s *PlayerService) PlayerStats(id string, r DateRange) (PlayerStats, error) {
+  s.mu.RLock()
+  defer s.mu.RUnlock()
+
+  if _, exists := s.store.Get(id); !exists {
+    return PlayerStats{}, fmt.Errorf("%w: %s", ErrPlayerNotFound, id)
+  }
+
+  stats := PlayerStats{PlayerID: id, DateRange: r}
+  for matchID := range s.indexes.playerMatches[id] {
+    match, exists := s.store.GetMatch(matchID)
+    if !exists || !r.contains(match.Date) {
+      continue
+    }
+
+    for _, appearance := range match.Lineup {
+      if appearance.PlayerID != id {
+        continue
+      }
+      stats.Appearances++
+      stats.Minutes += appearance.Minutes
+      if appearance.Starter {
+        stats.Starts++
+      }
+    }
+    for _, event := range match.Events {
+      if event.PlayerID != id {
+        continue
+      }
+      switch event.Type {
+      case EventGoal:
+        stats.Goals++
+      case EventAssist:
+        stats.Assists++
+      case EventYellowCard:
+        stats.YellowCards++
+      case EventRedCard:
+        stats.RedCards++
+      }
+    }
+  }
+  return stats, nil
+}
+
+// TeamStats sums a team's results over a date range. A win is worth three
+// points and a draw one.
+func (s *PlayerService) TeamStats(id string, r DateRange) (TeamStats, error) {
+  s.mu.RLock()
+  defer s.mu.RUnlock()
+
+  if _, exists := s.store.GetTeam(id); !exists {
+    return TeamStats{}, fmt.Errorf("%w: %s", ErrTeamNotFound, id)
+  }
+
+  stats := TeamStats{TeamID: id, DateRange: r}
+  for matchID := range s.indexes.teamMatches[id] {
+    match, exists := s.store.GetMatch(matchID)
+    if !exists || !r.contains(match.Date) {
+      continue
+    }
+
+    scored, conceded := match.HomeScore, match.AwayScore
+    if match.AwayTeamID == id {
+      scored, conceded = conceded, scored
+    }
+    stats.Played++
+    stats.GoalsFor += scored
+    stats.GoalsAgainst += conceded
+    switch cmp.Compare(scored, conceded) {
+    case 1:
+      stats.Won++
+    case 0:
+      stats.Drawn++
+    default:
+      stats.Lost++
+    }
+  }
+  stats.Points = 3*stats.Won + stats.Drawn
+  return stats, nil
+}
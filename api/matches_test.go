@@ -0,0 +1,307 @@
+package main
+
+import (
+  "encoding/json"
+  "errors"
+  "net/http"
+  "net/http/httptest"
+  "strings"
+  "testing"
+)
+
+// newServiceWithMatches signs Messi to Inter Miami and Neymar to Santos and
+// records two matches between them
+func newServiceWithMatches(t *testing.T) (*PlayerService, Team, Team) {
+  t.Helper()
+  service, miami, santos := newServiceWithTeams(t)
+  if _, err := service.UpdatePlayer(t.Context(), "1", PlayerRequest{Name: "Messi", JerseyNumber: 10, Rating: 99, TeamID: miami.ID}, AnyVersion); err != nil {
+    t.Fatalf("Failed to sign Messi: %v", err)
+  }
+  if _, err := service.UpdatePlayer(t.Context(), "3", PlayerRequest{Name: "Neymar", JerseyNumber: 10, Rating: 95, TeamID: santos.ID}, AnyVersion); err != nil {
+    t.Fatalf("Failed to sign Neymar: %v", err)
+  }
+
+  matches := []MatchRequest{
+    {
+      HomeTeamID: miami.ID, AwayTeamID: santos.ID, Date: "2025-03-01", HomeScore: 2, AwayScore: 1,
+      Lineup: []Appearance{{PlayerID: "1", Starter: true, Minutes: 90}, {PlayerID: "3", Starter: true, Minutes: 75}},
+      Events: []MatchEvent{
+        {Type: EventGoal, PlayerID: "1", Minute: 12},
+        {Type: EventGoal, PlayerID: "1", Minute: 80},
+        {Type: EventGoal, PlayerID: "3", Minute: 40},
+        {Type: EventYellowCard, PlayerID: "3", Minute: 60},
+      },
+    },
+    {
+      HomeTeamID: santos.ID, AwayTeamID: miami.ID, Date: "2025-04-12", HomeScore: 1, AwayScore: 1,
+      Lineup: []Appearance{{PlayerID: "1", Minutes: 30}, {PlayerID: "3", Starter: true, Minutes: 90}},
+      Events: []MatchEvent{
+        {Type: EventGoal, PlayerID: "3", Minute: 5},
+        {Type: EventAssist, PlayerID: "1", Minute: 88},
+      },
+    },
+  }
+  for _, req := range matches {
+    if _, err := service.CreateMatch(t.Context(), req); err != nil {
+      t.Fatalf("Failed to create match: %v", err)
+    }
+  }
+  return service, miami, santos
+}
+
+func TestMatchRequest_Validate(t *testing.T) {
+  valid := func() MatchRequest {
+    return MatchRequest{
+      HomeTeamID: "1", AwayTeamID: "2", Date: "2025-03-01",
+      Lineup: []Appearance{{PlayerID: "1", Minutes: 90}},
+      Events: []MatchEvent{{Type: EventGoal, PlayerID: "1"}},
+    }
+  }
+
+  tests := []struct {
+    name   string
+    modify func(*MatchRequest)
+  }{
+    {"missing team", func(mr *MatchRequest) { mr.AwayTeamID = "" }},
+    {"team plays itself", func(mr *MatchRequest) { mr.AwayTeamID = "1" }},
+    {"bad date", func(mr *MatchRequest) { mr.Date = "01/03/2025" }},
+    {"negative score", func(mr *MatchRequest) { mr.HomeScore = -1 }},
+    {"player listed twice", func(mr *MatchRequest) { mr.Lineup = append(mr.Lineup, Appearance{PlayerID: "1"}) }},
+    {"too many minutes", func(mr *MatchRequest) { mr.Lineup[0].Minutes = MaxMatchMinute + 1 }},
+    {"unknown event", func(mr *MatchRequest) { mr.Events[0].Type = "own_goal" }},
+    {"event player not in lineup", func(mr *MatchRequest) { mr.Events[0].PlayerID = "2" }},
+  }
+
+  req := valid()
+  if err := req.Validate(); err != nil {
+    t.Fatalf("Expected valid match, got %v", err)
+  }
+  for _, tt := range tests {
+    t.Run(tt.name, func(t *testing.T) {
+      req := valid()
+      tt.modify(&req)
+      if err := req.Validate(); !errors.Is(err, ErrInvalidInput) {
+        t.Errorf("Expected ErrInvalidInput, got %v", err)
+      }
+    })
+  }
+}
+
+func TestPlayerService_CreateMatchRefs(t *testing.T) {
+  service, miami, santos := newServiceWithMatches(t)
+
+  tests := []struct {
+    name string
+    req  MatchRequest
+  }{
+    {"unknown team", MatchRequest{HomeTeamID: miami.ID, AwayTeamID: "99", Date: "2025-05-01"}},
+    {"unknown player", MatchRequest{HomeTeamID: miami.ID, AwayTeamID: santos.ID, Date: "2025-05-01",
+      Lineup: []Appearance{{PlayerID: "99"}}}},
+    // Ronaldo is a free agent, so his team has to be given
+    {"player without a side", MatchRequest{HomeTeamID: miami.ID, AwayTeamID: santos.ID, Date: "2025-05-01",
+      Lineup: []Appearance{{PlayerID: "2"}}}},
+  }
+  for _, tt := range tests {
+    t.Run(tt.name, func(t *testing.T) {
+      if _, err := service.CreateMatch(t.Context(), tt.req); !errors.Is(err, ErrInvalidInput) {
+        t.Errorf("Expected ErrInvalidInput, got %v", err)
+      }
+    })
+  }
+
+  match, err := service.CreateMatch(t.Context(), MatchRequest{HomeTeamID: miami.ID, AwayTeamID: santos.ID, Date: "2025-05-01",
+    Lineup: []Appearance{{PlayerID: "2", TeamID: santos.ID, Minutes: 90}, {PlayerID: "1", Minutes: 90}}})
+  if err != nil {
+    t.Fatalf("Failed to create match: %v", err)
+  }
+  if match.ID != "3" || match.Lineup[0].TeamID != santos.ID || match.Lineup[1].TeamID != miami.ID {
+    t.Errorf("Expected lineup teams to be filled in, got %+v", match)
+  }
+}
+
+func TestPlayerService_PlayerStats(t *testing.T) {
+  service, _, _ := newServiceWithMatches(t)
+
+  tests := []struct {
+    name  string
+    id    string
+    dates DateRange
+    want  PlayerStats
+  }{
+    {"all time", "1", DateRange{}, PlayerStats{Appearances: 2, Starts: 1, Minutes: 120, Goals: 2, Assists: 1}},
+    {"from", "1", DateRange{From: "2025-04-01"}, PlayerStats{Appearances: 1, Minutes: 30, Assists: 1}},
+    {"to inclusive", "3", DateRange{To: "2025-03-01"}, PlayerStats{Appearances: 1, Starts: 1, Minutes: 75, Goals: 1, YellowCards: 1}},
+    {"no matches", "2", DateRange{}, PlayerStats{}},
+  }
+  for _, tt := range tests {
+    t.Run(tt.name, func(t *testing.T) {
+      stats, err := service.PlayerStats(tt.id, tt.dates)
+      if err != nil {
+        t.Fatalf("Failed to get stats: %v", err)
+      }
+      tt.want.PlayerID, tt.want.DateRange = tt.id, tt.dates
+      if stats != tt.want {
+        t.Errorf("Expected %+v, got %+v", tt.want, stats)
+      }
+    })
+  }
+
+  if _, err := service.PlayerStats("99", DateRange{}); !errors.Is(err, ErrPlayerNotFound) {
+    t.Errorf("Expected ErrPlayerNotFound, got %v", err)
+  }
+}
+
+func TestPlayerService_TeamStatsAndMatchUpdates(t *testing.T) {
+  service, miami, santos := newServiceWithMatches(t)
+
+  stats, err := service.TeamStats(miami.ID, DateRange{})
+  if err != nil {
+    t.Fatalf("Failed to get stats: %v", err)
+  }
+  want := TeamStats{TeamID: miami.ID, Played: 2, Won: 1, Drawn: 1, GoalsFor: 3, GoalsAgainst: 2, Points: 4}
+  if stats != want {
+    t.Errorf("Expected %+v, got %+v", want, stats)
+  }
+
+  // Dropping Messi from the second match takes it out of his stats and listings
+  if _, err := service.UpdateMatch(t.Context(), "2", MatchRequest{HomeTeamID: santos.ID, AwayTeamID: miami.ID, Date: "2025-04-12",
+    HomeScore: 3, AwayScore: 1, Lineup: []Appearance{{PlayerID: "3", Minutes: 90}}}, 2); !errors.Is(err, ErrVersionMismatch) {
+    t.Errorf("Expected ErrVersionMismatch, got %v", err)
+  }
+  if _, err := service.UpdateMatch(t.Context(), "2", MatchRequest{HomeTeamID: santos.ID, AwayTeamID: miami.ID, Date: "2025-04-12",
+    HomeScore: 3, AwayScore: 1, Lineup: []Appearance{{PlayerID: "3", Minutes: 90}}}, 1); err != nil {
+    t.Fatalf("Failed to update match: %v", err)
+  }
+  if matches, total := service.QueryMatches(MatchQuery{PlayerID: "1", Limit: 10}); total != 1 || matches[0].ID != "1" {
+    t.Errorf("Expected only match 1 for Messi, got %v", matches)
+  }
+  if stats, _ := service.TeamStats(santos.ID, DateRange{}); stats.Won != 1 || stats.Lost != 1 || stats.Points != 3 {
+    t.Errorf("Expected Santos to have a win and a loss, got %+v", stats)
+  }
+
+  if _, err := service.DeleteMatch(t.Context(), "1", AnyVersion); err != nil {
+    t.Fatalf("Failed to delete match: %v", err)
+  }
+  if stats, _ := service.PlayerStats("1", DateRange{}); stats.Appearances != 0 {
+    t.Errorf("Expected no appearances after delete, got %+v", stats)
+  }
+  if _, err := service.GetMatchByID("1"); !errors.Is(err, ErrMatchNotFound) {
+    t.Errorf("Expected ErrMatchNotFound, got %v", err)
+  }
+}
+
+func TestPlayerService_QueryMatches(t *testing.T) {
+  service, miami, _ := newServiceWithMatches(t)
+
+  tests := []struct {
+    name  string
+    query MatchQuery
+    want  []string
+  }{
+    {"newest first", MatchQuery{Limit: 10}, []string{"2", "1"}},
+    {"by team", MatchQuery{TeamID: miami.ID, Limit: 10}, []string{"2", "1"}},
+    {"by date", MatchQuery{Range: DateRange{From: "2025-03-02"}, Limit: 10}, []string{"2"}},
+    {"limit", MatchQuery{Limit: 1}, []string{"2"}},
+    {"unknown player", MatchQuery{PlayerID: "99", Limit: 10}, []string{}},
+  }
+  for _, tt := range tests {
+    t.Run(tt.name, func(t *testing.T) {
+      matches, _ := service.QueryMatches(tt.query)
+      ids := make([]string, 0, len(matches))
+      for _, match := range matches {
+        ids = append(ids, match.ID)
+      }
+      if strings.Join(ids, ",") != strings.Join(tt.want, ",") {
+        t.Errorf("Expected %v, got %v", tt.want, ids)
+      }
+    })
+  }
+}
+
+func TestFileStore_PersistsMatches(t *testing.T) {
+  dir := t.TempDir()
+
+  service := NewPlayerServiceWithStore(openTestFileStore(t, dir, 2))
+  home, _ := service.CreateTeam(t.Context(), TeamRequest{Name: "Santos"})
+  away, _ := service.CreateTeam(t.Context(), TeamRequest{Name: "Barcelona"})
+  player, err := service.CreatePlayer(t.Context(), PlayerRequest{Name: "Neymar", JerseyNumber: 10, Rating: 95, TeamID: home.ID})
+  if err != nil {
+    t.Fatalf("Failed to create player: %v", err)
+  }
+  if _, err := service.CreateMatch(t.Context(), MatchRequest{HomeTeamID: home.ID, AwayTeamID: away.ID, Date: "2025-03-01",
+    Lineup: []Appearance{{PlayerID: player.ID, Minutes: 90}},
+    Events: []MatchEvent{{Type: EventGoal, PlayerID: player.ID}}}); err != nil {
+    t.Fatalf("Failed to create match: %v", err)
+  }
+  if err := service.Close(); err != nil {
+    t.Fatalf("Failed to close service: %v", err)
+  }
+
+  service = NewPlayerServiceWithStore(openTestFileStore(t, dir, 2))
+  defer service.Close()
+
+  if stats, err := service.PlayerStats(player.ID, DateRange{}); err != nil || stats.Goals != 1 {
+    t.Errorf("Expected the goal to survive a restart, got %+v (%v)", stats, err)
+  }
+  next, err := service.CreateMatch(t.Context(), MatchRequest{HomeTeamID: away.ID, AwayTeamID: home.ID, Date: "2025-04-01"})
+  if err != nil || next.ID != "2" {
+    t.Errorf("Expected match ID 2 after restart, got %q (%v)", next.ID, err)
+  }
+}
+
+func TestPlayerHandler_Matches(t *testing.T) {
+  service, miami, _ := newServiceWithMatches(t)
+  handler := NewPlayerHandler(service)
+
+  mux := http.NewServeMux()
+  mux.HandleFunc("GET /matches", handler.GetMatches)
+  mux.HandleFunc("POST /matches", handler.CreateMatch)
+  mux.HandleFunc("DELETE /matches/{id}", handler.DeleteMatch)
+  mux.HandleFunc("GET /players/{id}/stats", handler.GetPlayerStats)
+  mux.HandleFunc("GET /teams/{id}/stats", handler.GetTeamStats)
+
+  do := func(method, target, body string) *httptest.ResponseRecorder {
+    req := httptest.NewRequest(method, target, strings.NewReader(body))
+    w := httptest.NewRecorder()
+    mux.ServeHTTP(w, req)
+    return w
+  }
+
+  w := do("GET", "/players/1/stats?from=2025-01-01&to=2025-03-31", "")
+  var stats struct {
+    Data PlayerStats `json:"data"`
+  }
+  if err := json.NewDecoder(w.Body).Decode(&stats); err != nil {
+    t.Fatalf("Failed to decode response: %v", err)
+  }
+  if w.Code != http.StatusOK || stats.Data.Goals != 2 || stats.Data.From != "2025-01-01" {
+    t.Errorf("Expected two goals in range, got %d %+v", w.Code, stats.Data)
+  }
+
+  tests := []struct {
+    name   string
+    method string
+    target string
+    body   string
+    want   int
+  }{
+    {"bad date", "GET", "/players/1/stats?from=March", "", http.StatusBadRequest},
+    {"reversed range", "GET", "/players/1/stats?from=2025-05-01&to=2025-01-01", "", http.StatusBadRequest},
+    {"unknown player", "GET", "/players/99/stats", "", http.StatusNotFound},
+    {"team stats", "GET", "/teams/" + miami.ID + "/stats", "", http.StatusOK},
+    {"unknown team", "GET", "/teams/99/stats", "", http.StatusNotFound},
+    {"list", "GET", "/matches?team_id=" + miami.ID, "", http.StatusOK},
+    {"unknown parameter", "GET", "/matches?season=2025", "", http.StatusBadRequest},
+    {"create", "POST", "/matches", `{"home_team_id": "1", "away_team_id": "2", "date": "2025-05-01"}`, http.StatusCreated},
+    {"create invalid", "POST", "/matches", `{"home_team_id": "1", "away_team_id": "1", "date": "2025-05-01"}`, http.StatusBadRequest},
+    {"delete", "DELETE", "/matches/1", "", http.StatusOK},
+    {"delete again", "DELETE", "/matches/1", "", http.StatusNotFound},
+  }
+  for _, tt := range tests {
+    t.Run(tt.name, func(t *testing.T) {
+      if w := do(tt.method, tt.target, tt.body); w.Code != tt.want {
+        t.Errorf("Expected status %d, got %d: %s", tt.want, w.Code, w.Body.String())
+      }
+    })
+  }
+}
@@ -0,0 +1,283 @@
+package main
+
+import (
+  "bufio"
+  "fmt"
+  "io"
+  "math"
+  "net/http"
+  "runtime"
+  "slices"
+  "strconv"
+  "strings"
+  "sync"
+  "sync/atomic"
+  "time"
+)
+
+// MetricsContentType is the Prometheus text exposition format
+const MetricsContentType = "text/plain; version=0.0.4; charset=utf-8"
+
+// DefaultLatencyBuckets are the upper bounds, in seconds, of the request
+// latency histogram buckets
+var DefaultLatencyBuckets = []float64{0.005, 0.01, 0.025, 0.05, 0.1, 0.25, 0.5, 1, 2.5, 5, 10}
+
+// unmatchedRoute labels requests that matched no route, so unknown paths
+// can't add label values
+const unmatchedRoute = "unmatched"
+
+// otherMethod labels requests with a method outside the standard ones, for
+// the same reason
+const otherMethod = "OTHER"
+
+// metricMethod returns the label for a request method
+func metricMethod(method string) string {
+  switch method {
+  case http.MethodGet, http.MethodHead, http.MethodPost, http.MethodPut, http.MethodPatch,
+    http.MethodDelete, http.MethodConnect, http.MethodOptions, http.MethodTrace:
+    return method
+  }
+  return otherMethod
+}
+
+type requestLabels struct {
+  route  string
+  method string
+  status int
+}
+
+type latencyLabels struct {
+  route  string
+  method string
+}
+
+// histogram counts observations per bucket; counts are not cumulative
+type histogram struct {
+  counts []uint64
+  sum    float64
+  count  uint64
+}
+
+// metricGauge is a gauge read when metrics are scraped
+type metricGauge struct {
+  name  string
+  help  string
+  value func() float64
+}
+
+// Metrics collects request counters and latency histograms and renders them,
+// along with registered gauges and Go runtime stats, in the Prometheus text
+// format
+type Metrics struct {
+  mu        sync.Mutex
+  requests  map[requestLabels]uint64
+  latencies map[latencyLabels]*histogram
+  gauges    []metricGauge
+  buckets   []float64
+  inFlight  atomic.Int64
+  started   time.Time
+}
+
+// NewMetrics creates an empty metrics registry
+func NewMetrics() *Metrics {
+  return &Metrics{
+    requests:  make(map[requestLabels]uint64),
+    latencies: make(map[latencyLabels]*histogram),
+    buckets:   DefaultLatencyBuckets,
+    started:   time.Now(),
+  }
+}
+
+// Gauge registers a gauge whose value is read on every scrape
+func (m *Metrics) Gauge(name, help string, value func() float64) {
+  m.mu.Lock()
+  defer m.mu.Unlock()
+
+  m.gauges = append(m.gauges, metricGauge{name, help, value})
+}
+
+// Observe records a finished request
+func (m *Metrics) Observe(route, method string, status int, latency time.Duration) {
+  seconds := latency.Seconds()
+
+  m.mu.Lock()
+  defer m.mu.Unlock()
+
+  m.requests[requestLabels{route, method, status}]++
+  key := latencyLabels{route, method}
+  h, exists := m.latencies[key]
+  if !exists {
+    h = &histogram{counts: make([]uint64, len(m.buckets))}
+    m.latencies[key] = h
+  }
+  // Observations above the last bound only count towards +Inf
+  if i, _ := slices.BinarySearch(m.buckets, seconds); i < len(m.buckets) {
+    h.counts[i]++
+  }
+  h.sum += seconds
+  h.count++
+}
+
+// Middleware counts and times every request. Requests are labelled with the
+// router pattern they match, such as "/players/{id}", rather than their path,
+// and non-standard methods are labelled OTHER, so the number of series stays
+// bounded.
+func (m *Metrics) Middleware(router *http.ServeMux, next http.Handler) http.Handler {
+  return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+    route := unmatchedRoute
+    if _, pattern := router.Handler(r); pattern != "" {
+      _, path, found := strings.Cut(pattern, " ")
+      if !found {
+        path = pattern
+      }
+      route = path
+    }
+
+    m.inFlight.Add(1)
+    defer m.inFlight.Add(-1)
+    start := time.Now()
+    recorder := &responseRecorder{ResponseWriter: w, statusCode: http.StatusOK}
+    next.ServeHTTP(recorder, r)
+    m.Observe(route, metricMethod(r.Method), recorder.statusCode, time.Since(start))
+  })
+}
+
+// WriteTo writes every metric in the Prometheus text format
+func (m *Metrics) WriteTo(w io.Writer) (int64, error) {
+  out := &metricsWriter{w: bufio.NewWriter(w)}
+
+  m.mu.Lock()
+  requests := make([]requestLabels, 0, len(m.requests))
+  for labels := range m.requests {
+    requests = append(requests, labels)
+  }
+  slices.SortFunc(requests, func(a, b requestLabels) int {
+    return cmpLabels(a.route, b.route, a.method, b.method, a.status-b.status)
+  })
+  out.header("http_requests_total", "counter", "Requests served, by route pattern, method and status.")
+  for _, labels := range requests {
+    out.sample("http_requests_total", fmt.Sprintf(`route="%s",method="%s",status="%d"`,
+      escapeLabel(labels.route), escapeLabel(labels.method), labels.status), float64(m.requests[labels]))
+  }
+
+  latencies := make([]latencyLabels, 0, len(m.latencies))
+  for labels := range m.latencies {
+    latencies = append(latencies, labels)
+  }
+  slices.SortFunc(latencies, func(a, b latencyLabels) int {
+    return cmpLabels(a.route, b.route, a.method, b.method, 0)
+  })
+  out.header("http_request_duration_seconds", "histogram", "Request latency, by route pattern and method.")
+  for _, labels := range latencies {
+    h := m.latencies[labels]
+    base := fmt.Sprintf(`route="%s",method="%s"`, escapeLabel(labels.route), escapeLabel(labels.method))
+    var cumulative uint64
+    for i, bound := range m.buckets {
+      cumulative += h.counts[i]
+      out.sample("http_request_duration_seconds_bucket", base+`,le="`+formatMetric(bound)+`"`, float64(cumulative))
+    }
+    out.sample("http_request_duration_seconds_bucket", base+`,le="+Inf"`, float64(h.count))
+    out.sample("http_request_duration_seconds_sum", base, h.sum)
+    out.sample("http_request_duration_seconds_count", base, float64(h.count))
+  }
+  gauges := slices.Clone(m.gauges)
+  m.mu.Unlock()
+
+  out.header("http_requests_in_flight", "gauge", "Requests currently being served.")
+  out.sample("http_requests_in_flight", "", float64(m.inFlight.Load()))
+
+  // Gauges are read without the lock, so they may take their own locks
+  for _, g := range gauges {
+    out.header(g.name, "gauge", g.help)
+    out.sample(g.name, "", g.value())
+  }
+
+  m.writeRuntime(out)
+  return out.n, out.flush()
+}
+
+// writeRuntime writes Go runtime and process stats
+func (m *Metrics) writeRuntime(out *metricsWriter) {
+  var stats runtime.MemStats
+  runtime.ReadMemStats(&stats)
+
+  out.header("go_info", "gauge", "Go version the server was built with.")
+  out.sample("go_info", `version="`+escapeLabel(runtime.Version())+`"`, 1)
+  out.header("go_goroutines", "gauge", "Number of goroutines.")
+  out.sample("go_goroutines", "", float64(runtime.NumGoroutine()))
+  out.header("go_memstats_alloc_bytes", "gauge", "Bytes of allocated heap objects.")
+  out.sample("go_memstats_alloc_bytes", "", float64(stats.HeapAlloc))
+  out.header("go_memstats_heap_inuse_bytes", "gauge", "Bytes in in-use heap spans.")
+  out.sample("go_memstats_heap_inuse_bytes", "", float64(stats.HeapInuse))
+  out.header("go_memstats_sys_bytes", "gauge", "Bytes of memory obtained from the OS.")
+  out.sample("go_memstats_sys_bytes", "", float64(stats.Sys))
+  out.header("go_memstats_mallocs_total", "counter", "Heap objects allocated.")
+  out.sample("go_memstats_mallocs_total", "", float64(stats.Mallocs))
+  out.header("go_gc_cycles_total", "counter", "Completed GC cycles.")
+  out.sample("go_gc_cycles_total", "", float64(stats.NumGC))
+  out.header("go_gc_pause_seconds_total", "counter", "Total time the world was stopped for GC.")
+  out.sample("go_gc_pause_seconds_total", "", float64(stats.PauseTotalNs)/1e9)
+  out.header("process_start_time_seconds", "gauge", "Start time of the process since the Unix epoch.")
+  out.sample("process_start_time_seconds", "", float64(m.started.UnixNano())/1e9)
+}
+
+// metricsWriter writes exposition lines, keeping the first error
+type metricsWriter struct {
+  w   *bufio.Writer
+  n   int64
+  err error
+}
+
+func (mw *metricsWriter) printf(format string, args ...any) {
+  if mw.err != nil {
+    return
+  }
+  n, err := fmt.Fprintf(mw.w, format, args...)
+  mw.n += int64(n)
+  mw.err = err
+}
+
+func (mw *metricsWriter) header(name, kind, help string) {
+  mw.printf("# HELP %s %s\n# TYPE %s %s\n", name, help, name, kind)
+}
+
+func (mw *metricsWriter) sample(name, labels string, value float64) {
+  if labels != "" {
+    labels = "{" + labels + "}"
+  }
+  mw.printf("%s%s %s\n", name, labels, formatMetric(value))
+}
+
+func (mw *metricsWriter) flush() error {
+  if mw.err != nil {
+    return mw.err
+  }
+  return mw.w.Flush()
+}
+
+// formatMetric formats a sample value the way Prometheus expects
+func formatMetric(v float64) string {
+  switch {
+  case math.IsInf(v, 1):
+    return "+Inf"
+  case math.IsInf(v, -1):
+    return "-Inf"
+  }
+  return strconv.FormatFloat(v, 'g', -1, 64)
+}
+
+// escapeLabel escapes a label value for the text format
+func escapeLabel(v string) string {
+  return strings.NewReplacer(`\`, `\\`, `"`, `\"`, "\n", `\n`).Replace(v)
+}
+
+// cmpLabels orders series by route, then method, then the tiebreak
+func cmpLabels(routeA, routeB, methodA, methodB string, tiebreak int) int {
+  if c := strings.Compare(routeA, routeB); c != 0 {
+    return c
+  }
+  if c := strings.Compare(methodA, methodB); c != 0 {
+    return c
+  }
+  return tiebreak
+}
@@ -0,0 +1,99 @@
+package main
+
+import (
+  "net/http"
+  "net/http/httptest"
+  "strings"
+  "testing"
+  "time"
+)
+
+func TestMetrics_Histogram(t *testing.T) {
+  metrics := NewMetrics()
+  metrics.Observe("/players", "GET", 200, 3*time.Millisecond)
+  metrics.Observe("/players", "GET", 200, 10*time.Millisecond)
+  metrics.Observe("/players", "GET", 500, 20*time.Second)
+  metrics.Observe(`/odd"route`, "GET", 404, time.Millisecond)
+
+  var out strings.Builder
+  if _, err := metrics.WriteTo(&out); err != nil {
+    t.Fatalf("Failed to write metrics: %v", err)
+  }
+  for _, want := range []string{
+    "# TYPE http_requests_total counter\n",
+    `http_requests_total{route="/players",method="GET",status="200"} 2` + "\n",
+    `http_requests_total{route="/players",method="GET",status="500"} 1` + "\n",
+    `http_requests_total{route="/odd\"route",method="GET",status="404"} 1` + "\n",
+    "# TYPE http_request_duration_seconds histogram\n",
+    `http_request_duration_seconds_bucket{route="/players",method="GET",le="0.005"} 1` + "\n",
+    `http_request_duration_seconds_bucket{route="/players",method="GET",le="0.01"} 2` + "\n",
+    `http_request_duration_seconds_bucket{route="/players",method="GET",le="10"} 2` + "\n",
+    `http_request_duration_seconds_bucket{route="/players",method="GET",le="+Inf"} 3` + "\n",
+    `http_request_duration_seconds_sum{route="/players",method="GET"} 20.013` + "\n",
+    `http_request_duration_seconds_count{route="/players",method="GET"} 3` + "\n",
+    "http_requests_in_flight 0\n",
+    "# TYPE go_goroutines gauge\n",
+    "go_memstats_alloc_bytes ",
+  } {
+    if !strings.Contains(out.String(), want) {
+      t.Errorf("Expected metrics to contain %q, got:\n%s", want, out.String())
+    }
+  }
+}
+
+func TestMetrics_Endpoint(t *testing.T) {
+  service := NewPlayerService()
+  keys := NewAPIKeyStore()
+  reader, _ := keys.CreateAPIKey(APIKeyRequest{Name: "prometheus", Scopes: []string{ScopeRead}})
+  h := NewPlayerHandlerWithAuth(service, nil, keys, nil)
+  router := newRouter(h, nil)
+  handler := h.metrics.Middleware(router, router)
+  do := func(method, target string) *httptest.ResponseRecorder {
+    req := httptest.NewRequest(method, target, nil)
+    req.Header.Set("Authorization", "Bearer "+reader.Key)
+    w := httptest.NewRecorder()
+    handler.ServeHTTP(w, req)
+    return w
+  }
+
+  do("GET", "/players/1")
+  do("GET", "/players/2")
+  do("GET", "/players/999")
+  do("GET", "/no/such/path")
+  do("GET", "/no/other/path")
+  do("FOO", "/players/1")
+  do("BAR", "/players/1")
+
+  w := do("GET", "/metrics")
+  if w.Code != http.StatusOK || w.Header().Get("Content-Type") != MetricsContentType {
+    t.Fatalf("Expected metrics, got %d %v", w.Code, w.Header())
+  }
+  body := w.Body.String()
+  for _, want := range []string{
+    `http_requests_total{route="/players/{id}",method="GET",status="200"} 2`,
+    `http_requests_total{route="/players/{id}",method="GET",status="404"} 1`,
+    `http_requests_total{route="unmatched",method="GET",status="404"} 2`,
+    `http_requests_total{route="unmatched",method="OTHER",status="405"} 2`,
+    // The scrape itself is still in flight
+    "http_requests_in_flight 1",
+    "players 3",
+  } {
+    if !strings.Contains(body, want+"\n") {
+      t.Errorf("Expected metrics to contain %q, got:\n%s", want, body)
+    }
+  }
+  if strings.Contains(body, "/players/1") {
+    t.Errorf("Expected route patterns rather than paths, got:\n%s", body)
+  }
+  if strings.Contains(body, "FOO") || strings.Contains(body, "BAR") {
+    t.Errorf("Expected non-standard methods to be labelled OTHER, got:\n%s", body)
+  }
+
+  // Scraping needs a read key like any other read
+  req := httptest.NewRequest("GET", "/metrics", nil)
+  w = httptest.NewRecorder()
+  handler.ServeHTTP(w, req)
+  if w.Code != http.StatusUnauthorized {
+    t.Errorf("Expected status 401, got %d", w.Code)
+  }
+}
@@ -0,0 +1,511 @@
+package main
+
+import (
+  "encoding/json"
+  "fmt"
+  "maps"
+  "net/http"
+  "reflect"
+  "regexp"
+  "slices"
+  "strings"
+  "time"
+  "unicode"
+)
+
+// OpenAPIVersion is the version of the OpenAPI specification the document
+// follows
+const OpenAPIVersion = "3.0.3"
+
+// apiParam documents a query parameter
+type apiParam struct {
+  name        string
+  schema      map[string]any
+  description string
+}
+
+// apiOneOf documents response data that takes one of several shapes
+type apiOneOf []any
+
+// apiOperation documents a route. Request and response types are given as
+// zero values and turned into schemas from their JSON tags, so the document
+// follows the types as they change.
+type apiOperation struct {
+  summary string
+  tag     string
+  query   []apiParam
+  // ifMatch marks routes that honour If-Match
+  ifMatch bool
+  // body maps request media types to a value of the body type, or nil for
+  // bodies that aren't JSON
+  body map[string]any
+  // status is the success status, 200 when zero
+  status int
+  // data is the type of Response.Data on success; nil when there is none
+  data  any
+  paged bool
+  // partial marks routes that answer 207 with the same data when some items
+  // failed
+  partial bool
+  // media lists the success media types of routes that don't answer with
+  // the JSON envelope
+  media []string
+  // errors are the error statuses the handler itself returns. 401, 403,
+  // 429 and 500 are added from the route's scope.
+  errors []int
+}
+
+// Query parameters shared by several routes
+var (
+  limitParam = func(def, max int) apiParam {
+    return apiParam{"limit", map[string]any{"type": "integer", "minimum": 1, "maximum": max, "default": def}, "Maximum number of results"}
+  }
+  playerQueryParams = []apiParam{
+    limitParam(DefaultPageLimit, MaxPageLimit),
+    {"cursor", stringSchema(), "Cursor from a previous page's meta"},
+    {"sort", stringSchema(), "Comma-separated fields, prefixed with - for descending, e.g. -rating,name"},
+    {"filter", stringSchema(), "Filter expression, e.g. rating >= 90 and team_id = '1'"},
+  }
+  dateRangeParams = []apiParam{
+    {"from", map[string]any{"type": "string", "format": "date"}, "First match date to include"},
+    {"to", map[string]any{"type": "string", "format": "date"}, "Last match date to include"},
+  }
+  timeRangeParams = []apiParam{
+    {"from", stringSchema(), "Start of the window, a date or an RFC 3339 timestamp"},
+    {"to", stringSchema(), "End of the window, a date or an RFC 3339 timestamp"},
+  }
+)
+
+// apiOperations documents every route in routes(). A route without an entry
+// fails the OpenAPI tests.
+var apiOperations = map[string]apiOperation{
+  "GET /health": {summary: "Check that the service is up; same as /livez", tag: "System",
+    data: Liveness{}},
+  "GET /livez": {summary: "Liveness probe with build info and uptime", tag: "System",
+    data: Liveness{}},
+  "GET /readyz": {summary: "Readiness probe; 503 while draining or when a check fails", tag: "System",
+    data: Readiness{}, errors: []int{503}},
+  "GET /metrics": {summary: "Prometheus metrics", tag: "System",
+    media: []string{MetricsContentType}},
+  "GET /openapi.json": {summary: "This OpenAPI document", tag: "System",
+    media: []string{"application/json"}},
+
+  "GET /players": {summary: "List players", tag: "Players",
+    query: playerQueryParams, data: []Player{}, paged: true, errors: []int{400}},
+  "GET /players/search": {summary: "Fuzzy search players by name", tag: "Players",
+    query: []apiParam{{"q", stringSchema(), "Name to search for (required)"}, limitParam(DefaultSearchLimit, MaxSearchLimit)},
+    data:  []SearchMatch{}, errors: []int{400}},
+  "GET /players/autocomplete": {summary: "Suggest player names for a prefix", tag: "Players",
+    query: []apiParam{{"q", stringSchema(), "Prefix to complete (required)"}, limitParam(DefaultSearchLimit, MaxSearchLimit)},
+    data:  []Suggestion{}, errors: []int{400}},
+  "GET /players/export": {summary: "Download players as CSV or NDJSON", tag: "Players",
+    query: append([]apiParam{{"format", enumSchema(FormatCSV, FormatNDJSON), "Export format, csv by default"}}, playerQueryParams[2:]...),
+    media: []string{"text/csv", "application/x-ndjson"}, errors: []int{400}},
+  "GET /players/movers": {summary: "Players ranked by rating change over a window", tag: "Players",
+    query: append([]apiParam{{"direction", enumSchema(MoversUp, MoversDown), "Only risers or fallers"}, limitParam(DefaultMoversLimit, MaxMoversLimit)}, timeRangeParams...),
+    data:  MoversReport{}, errors: []int{400}},
+  "GET /players/events": {summary: "Stream player changes as Server-Sent Events", tag: "Players",
+    query: []apiParam{{"last_event_id", map[string]any{"type": "integer", "minimum": 0}, "Resume after this event, like the Last-Event-ID header"}},
+    media: []string{"text/event-stream"}, errors: []int{400}},
+  "GET /players/{id}": {summary: "Get a player", tag: "Players",
+    data: Player{}, errors: []int{404}},
+  "POST /players": {summary: "Create a player", tag: "Players",
+    body: jsonBody(PlayerRequest{}), status: http.StatusCreated, data: Player{}, errors: []int{400, 409}},
+  "POST /players/batch": {summary: "Create, update and delete players in one request", tag: "Players",
+    body: jsonBody(BatchRequest{}), data: []BatchItemResponse{}, partial: true, errors: []int{400, 404, 409, 412, 424}},
+  "POST /players/import": {summary: "Import players from CSV or NDJSON", tag: "Players",
+    query: []apiParam{
+      {"format", enumSchema(FormatCSV, FormatNDJSON), "Upload format, taken from Content-Type when left out"},
+      {"map", stringSchema(), "CSV column mapping such as jersey_number:Number; repeatable"},
+    },
+    body: map[string]any{"text/csv": nil, "application/x-ndjson": nil}, data: ImportReport{}, partial: true, errors: []int{400, 415}},
+  "PUT /players/{id}": {summary: "Replace a player", tag: "Players",
+    ifMatch: true, body: jsonBody(PlayerRequest{}), data: Player{}, errors: []int{400, 404, 409, 412}},
+  "PATCH /players/{id}": {summary: "Patch a player with a merge patch or JSON Patch", tag: "Players",
+    ifMatch: true, body: map[string]any{mergePatchMediaType: map[string]any{}, jsonPatchMediaType: []patchOperation{}},
+    data: Player{}, errors: []int{400, 404, 409, 412, 415}},
+  "DELETE /players/{id}": {summary: "Move a player to the trash", tag: "Players",
+    ifMatch: true, data: Player{}, errors: []int{400, 404, 412}},
+  "POST /players/{id}/restore": {summary: "Restore a player from the trash", tag: "Trash",
+    data: Player{}, errors: []int{404, 409}},
+  "GET /players/{id}/stats": {summary: "A player's match totals", tag: "Matches",
+    query: dateRangeParams, data: PlayerStats{}, errors: []int{400, 404}},
+  "GET /players/{id}/history": {summary: "A player's rating changes", tag: "Players",
+    query: append([]apiParam{
+      {"interval", enumSchema(IntervalDay, IntervalWeek), "Downsample to one entry per day or week"},
+      {"agg", enumSchema(AggLast, AggAvg), "How downsampled ratings are combined"},
+    }, timeRangeParams...),
+    data: apiOneOf{[]RatingChange{}, []RatingBucket{}}, errors: []int{400, 404}},
+  "GET /trash": {summary: "List deleted players", tag: "Trash",
+    query: []apiParam{limitParam(DefaultTrashLimit, MaxTrashLimit)}, data: []DeletedPlayer{}, paged: true, errors: []int{400}},
+
+  "GET /teams":      {summary: "List teams", tag: "Teams", data: []Team{}},
+  "GET /teams/{id}": {summary: "Get a team", tag: "Teams", data: Team{}, errors: []int{404}},
+  "GET /teams/{id}/players": {summary: "List a team's players", tag: "Teams",
+    query: playerQueryParams, data: []Player{}, paged: true, errors: []int{400, 404}},
+  "POST /teams": {summary: "Create a team", tag: "Teams",
+    body: jsonBody(TeamRequest{}), status: http.StatusCreated, data: Team{}, errors: []int{400, 409}},
+  "POST /teams/{id}/players": {summary: "Create a player on a team", tag: "Teams",
+    body: jsonBody(PlayerRequest{}), status: http.StatusCreated, data: Player{}, errors: []int{400, 404, 409}},
+  "PUT /teams/{id}": {summary: "Rename a team", tag: "Teams",
+    ifMatch: true, body: jsonBody(TeamRequest{}), data: Team{}, errors: []int{400, 404, 409, 412}},
+  "DELETE /teams/{id}": {summary: "Delete a team", tag: "Teams",
+    ifMatch: true, query: []apiParam{
+      {"on_players", enumSchema(TeamCascade, TeamReassign), "What happens to the team's players; required if it has any"},
+      {"reassign_to", stringSchema(), "Team that takes the players with on_players=reassign"},
+    },
+    data: TeamDeletion{}, errors: []int{400, 404, 409, 412}},
+  "GET /teams/{id}/stats": {summary: "A team's results", tag: "Matches",
+    query: dateRangeParams, data: TeamStats{}, errors: []int{400, 404}},
+
+  "GET /matches": {summary: "List matches", tag: "Matches",
+    query: []apiParam{
+      {"team_id", stringSchema(), "Only matches this team played"},
+      {"player_id", stringSchema(), "Only matches this player appeared in"},
+      limitParam(DefaultMatchLimit, MaxMatchLimit),
+    },
+    data: []Match{}, paged: true, errors: []int{400}},
+  "GET /matches/{id}": {summary: "Get a match", tag: "Matches", data: Match{}, errors: []int{404}},
+  "POST /matches": {summary: "Record a match", tag: "Matches",
+    body: jsonBody(MatchRequest{}), status: http.StatusCreated, data: Match{}, errors: []int{400}},
+  "PUT /matches/{id}": {summary: "Replace a match", tag: "Matches",
+    ifMatch: true, body: jsonBody(MatchRequest{}), data: Match{}, errors: []int{400, 404, 412}},
+  "DELETE /matches/{id}": {summary: "Delete a match", tag: "Matches",
+    ifMatch: true, data: Match{}, errors: []int{404, 412}},
+
+  "GET /audit": {summary: "Query the audit trail", tag: "Admin",
+    query: []apiParam{
+      {"resource", stringSchema(), "Only entries for this resource type"},
+      {"resource_id", stringSchema(), "Only entries for this resource"},
+      {"player_id", stringSchema(), "Shorthand for resource=player&resource_id=..."},
+      {"actor", stringSchema(), "Only changes made by this actor"},
+      {"action", enumSchema(AuditCreate, AuditUpdate, AuditDelete, AuditRestore, AuditPurge, AuditFailed), "Only this kind of change"},
+      {"cursor", stringSchema(), "Cursor from a previous page's meta"},
+      limitParam(DefaultAuditLimit, MaxAuditLimit),
+      timeRangeParams[0], timeRangeParams[1],
+    },
+    data: []AuditEntry{}, paged: true, errors: []int{400}},
+  "GET /webhooks":      {summary: "List webhooks", tag: "Admin", data: []Webhook{}},
+  "GET /webhooks/{id}": {summary: "Get a webhook", tag: "Admin", data: Webhook{}, errors: []int{404}},
+  "GET /webhooks/{id}/deliveries": {summary: "List a webhook's deliveries", tag: "Admin",
+    query: []apiParam{{"status", enumSchema(DeliveryPending, DeliveryDelivered, DeliveryDead), "Only deliveries in this state"}},
+    data:  []WebhookDelivery{}, errors: []int{400, 404}},
+  "POST /webhooks": {summary: "Subscribe a webhook to player changes", tag: "Admin",
+    body: jsonBody(WebhookRequest{}), status: http.StatusCreated, data: Webhook{}, errors: []int{400}},
+  "DELETE /webhooks/{id}": {summary: "Delete a webhook", tag: "Admin", data: Webhook{}, errors: []int{404}},
+  "GET /apikeys":          {summary: "List API keys", tag: "Admin", data: []APIKey{}},
+  "POST /apikeys": {summary: "Create an API key; the key is only shown once", tag: "Admin",
+    body: jsonBody(APIKeyRequest{}), status: http.StatusCreated, data: APIKey{}, errors: []int{400}},
+  "DELETE /apikeys/{id}": {summary: "Revoke an API key", tag: "Admin", data: APIKey{}, errors: []int{404}},
+}
+
+// apiConstraints adds the validation rules checked in Validate methods to
+// the schemas of "Type.field"
+var apiConstraints = map[string]map[string]any{
+  "Player.jersey_number":        {"minimum": MinJerseyNumber, "maximum": MaxJerseyNumber},
+  "Player.rating":               {"minimum": MinRating, "maximum": MaxRating},
+  "PlayerRequest.name":          {"minLength": 1},
+  "PlayerRequest.jersey_number": {"minimum": MinJerseyNumber, "maximum": MaxJerseyNumber},
+  "PlayerRequest.rating":        {"minimum": MinRating, "maximum": MaxRating},
+  "TeamRequest.name":            {"minLength": 1},
+  "MatchRequest.date":           {"format": "date"},
+  "MatchEvent.type":             enumSchema(EventGoal, EventAssist, EventYellowCard, EventRedCard),
+  "BatchRequest.mode":           enumSchema(BatchAtomic, BatchBestEffort),
+  "BatchRequest.operations":     {"minItems": 1, "maxItems": MaxBatchOperations},
+  "BatchOperation.op":           enumSchema(BatchCreate, BatchUpdate, BatchDelete),
+  "WebhookRequest.url":          {"format": "uri"},
+  "WebhookRequest.secret":       {"minLength": MinWebhookSecretLength},
+  "WebhookRequest.events":       {"items": enumSchema(ChangeCreated, ChangeUpdated, ChangeDeleted)},
+  "APIKeyRequest.name":          {"minLength": 1, "maxLength": MaxAPIKeyNameLength},
+  "APIKeyRequest.scopes":        {"minItems": 1, "items": enumSchema(ScopeRead, ScopeWrite, ScopeAdmin)},
+}
+
+func stringSchema() map[string]any {
+  return map[string]any{"type": "string"}
+}
+
+func enumSchema(values ...string) map[string]any {
+  return map[string]any{"type": "string", "enum": values}
+}
+
+func jsonBody(v any) map[string]any {
+  return map[string]any{"application/json": v}
+}
+
+// pathParamPattern finds the wildcards in a route pattern
+var pathParamPattern = regexp.MustCompile(`\{([^}]+)\}`)
+
+// OpenAPIDocument describes the given routes as an OpenAPI document
+func OpenAPIDocument(rts []route) (map[string]any, error) {
+  g := &schemaGenerator{schemas: make(map[string]any)}
+  paths := make(map[string]map[string]any)
+  tags := make(map[string]bool)
+  for _, rt := range rts {
+    op, documented := apiOperations[rt.pattern]
+    if !documented {
+      return nil, fmt.Errorf("route %s has no OpenAPI operation", rt.pattern)
+    }
+    method, path, _ := strings.Cut(rt.pattern, " ")
+    if paths[path] == nil {
+      paths[path] = make(map[string]any)
+    }
+    paths[path][strings.ToLower(method)] = g.operation(rt, path, op)
+    tags[op.tag] = true
+  }
+
+  var tagList []map[string]any
+  for _, tag := range slices.Sorted(maps.Keys(tags)) {
+    tagList = append(tagList, map[string]any{"name": tag})
+  }
+  g.schema(reflect.TypeOf(Response{}))
+  g.schema(reflect.TypeOf(PageMeta{}))
+  return map[string]any{
+    "openapi": OpenAPIVersion,
+    "info": map[string]any{
+      "title":       "Player API",
+      "version":     "1.0.0",
+      "description": "Manage football players, teams and matches.",
+    },
+    "tags":  tagList,
+    "paths": paths,
+    "components": map[string]any{
+      "schemas": g.schemas,
+      "securitySchemes": map[string]any{
+        "bearer": map[string]any{
+          "type":        "http",
+          "scheme":      "bearer",
+          "description": "An API key (pk_...) or a JWT",
+        },
+      },
+    },
+  }, nil
+}
+
+// operation renders one route
+func (g *schemaGenerator) operation(rt route, path string, op apiOperation) map[string]any {
+  operation := map[string]any{
+    "summary":     op.summary,
+    "operationId": operationID(rt.pattern),
+    "tags":        []string{op.tag},
+  }
+
+  var params []map[string]any
+  for _, match := range pathParamPattern.FindAllStringSubmatch(path, -1) {
+    params = append(params, map[string]any{"name": match[1], "in": "path", "required": true, "schema": stringSchema()})
+  }
+  for _, p := range op.query {
+    params = append(params, map[string]any{"name": p.name, "in": "query", "schema": p.schema, "description": p.description})
+  }
+  if op.ifMatch {
+    params = append(params, map[string]any{
+      "name": "If-Match", "in": "header", "schema": stringSchema(),
+      "description": "Only apply the change if the resource is still at this ETag",
+    })
+  }
+  if params != nil {
+    operation["parameters"] = params
+  }
+
+  if op.body != nil {
+    content := make(map[string]any)
+    for media, v := range op.body {
+      schema := map[string]any{"type": "string"}
+      if v != nil {
+        schema = g.schema(reflect.TypeOf(v))
+      }
+      content[media] = map[string]any{"schema": schema}
+    }
+    operation["requestBody"] = map[string]any{"required": true, "content": content}
+  }
+
+  responses := make(map[string]any)
+  status := op.status
+  if status == 0 {
+    status = http.StatusOK
+  }
+  success := g.successResponse(op)
+  responses[fmt.Sprint(status)] = success
+  if op.partial {
+    responses[fmt.Sprint(http.StatusMultiStatus)] = success
+  }
+  errorStatuses := slices.Clone(op.errors)
+  if rt.scope != ScopePublic {
+    errorStatuses = append(errorStatuses, http.StatusUnauthorized, http.StatusForbidden)
+    operation["security"] = []map[string]any{{"bearer": []string{}}}
+    operation["x-scope"] = rt.scope
+    operation["description"] = fmt.Sprintf("Needs the %s scope.", rt.scope)
+  }
+  errorStatuses = append(errorStatuses, http.StatusTooManyRequests, http.StatusInternalServerError)
+  for _, status := range errorStatuses {
+    responses[fmt.Sprint(status)] = map[string]any{
+      "description": http.StatusText(status),
+      "content":     map[string]any{"application/json": map[string]any{"schema": schemaRef("Response")}},
+    }
+  }
+  operation["responses"] = responses
+  return operation
+}
+
+// successResponse renders the response a route answers with on success
+func (g *schemaGenerator) successResponse(op apiOperation) map[string]any {
+  content := make(map[string]any)
+  switch {
+  case op.media != nil:
+    for _, media := range op.media {
+      content[media] = map[string]any{"schema": stringSchema()}
+    }
+  case op.data == nil:
+    content["application/json"] = map[string]any{"schema": schemaRef("Response")}
+  default:
+    envelope := map[string]any{"data": g.dataSchema(op.data)}
+    if op.paged {
+      envelope["meta"] = schemaRef("PageMeta")
+    }
+    content["application/json"] = map[string]any{"schema": map[string]any{
+      "allOf": []any{schemaRef("Response"), map[string]any{"type": "object", "properties": envelope}},
+    }}
+  }
+  return map[string]any{"description": "Success", "content": content}
+}
+
+func (g *schemaGenerator) dataSchema(data any) map[string]any {
+  alternatives, isOneOf := data.(apiOneOf)
+  if !isOneOf {
+    return g.schema(reflect.TypeOf(data))
+  }
+  var schemas []any
+  for _, alternative := range alternatives {
+    schemas = append(schemas, g.schema(reflect.TypeOf(alternative)))
+  }
+  return map[string]any{"oneOf": schemas}
+}
+
+// operationID turns "GET /players/{id}/stats" into "getPlayersIdStats"
+func operationID(pattern string) string {
+  var b strings.Builder
+  upper := false
+  for i, r := range strings.ToLower(pattern) {
+    switch {
+    case unicode.IsLetter(r) || unicode.IsDigit(r):
+      if upper && i > 0 {
+        r = unicode.ToUpper(r)
+      }
+      b.WriteRune(r)
+      upper = false
+    default:
+      upper = true
+    }
+  }
+  return b.String()
+}
+
+func schemaRef(name string) map[string]any {
+  return map[string]any{"$ref": "#/components/schemas/" + name}
+}
+
+// schemaGenerator turns Go types into JSON schemas, collecting named structs
+// as components
+type schemaGenerator struct {
+  schemas map[string]any
+}
+
+var (
+  timeType       = reflect.TypeOf(time.Time{})
+  rawMessageType = reflect.TypeOf(json.RawMessage{})
+)
+
+// schema returns the schema for t, or a reference to it for named structs
+func (g *schemaGenerator) schema(t reflect.Type) map[string]any {
+  switch t {
+  case timeType:
+    return map[string]any{"type": "string", "format": "date-time"}
+  case rawMessageType:
+    return map[string]any{}
+  }
+
+  switch t.Kind() {
+  case reflect.Pointer:
+    return g.schema(t.Elem())
+  case reflect.String:
+    return stringSchema()
+  case reflect.Bool:
+    return map[string]any{"type": "boolean"}
+  case reflect.Int8, reflect.Int16, reflect.Int32, reflect.Uint8, reflect.Uint16:
+    return map[string]any{"type": "integer", "format": "int32"}
+  case reflect.Int, reflect.Int64, reflect.Uint, reflect.Uint32, reflect.Uint64:
+    return map[string]any{"type": "integer", "format": "int64"}
+  case reflect.Float32, reflect.Float64:
+    return map[string]any{"type": "number"}
+  case reflect.Slice, reflect.Array:
+    return map[string]any{"type": "array", "items": g.schema(t.Elem())}
+  case reflect.Map:
+    return map[string]any{"type": "object", "additionalProperties": g.schema(t.Elem())}
+  case reflect.Struct:
+    if t.Name() == "" {
+      return g.object(t)
+    }
+    name := exportedName(t.Name())
+    if _, seen := g.schemas[name]; !seen {
+      // Claim the name first so recursive types refer to themselves
+      g.schemas[name] = nil
+      g.schemas[name] = g.object(t)
+    }
+    return schemaRef(name)
+  }
+  // Interfaces and anything else can hold any JSON value
+  return map[string]any{}
+}
+
+// object returns the schema of a struct's JSON fields
+func (g *schemaGenerator) object(t reflect.Type) map[string]any {
+  properties := make(map[string]any)
+  var required []string
+  g.fields(t, t.Name(), properties, &required)
+
+  schema := map[string]any{"type": "object", "properties": properties}
+  if required != nil {
+    schema["required"] = required
+  }
+  return schema
+}
+
+// fields adds the JSON fields of t, including those of embedded structs,
+// to properties. Fields that aren't omitted when empty are required.
+func (g *schemaGenerator) fields(t reflect.Type, typeName string, properties map[string]any, required *[]string) {
+  for i := range t.NumField() {
+    field := t.Field(i)
+    tag := field.Tag.Get("json")
+    if tag == "-" || (!field.IsExported() && !field.Anonymous) {
+      continue
+    }
+    name, options, _ := strings.Cut(tag, ",")
+    if field.Anonymous && name == "" {
+      g.fields(field.Type, typeName, properties, required)
+      continue
+    }
+    if name == "" {
+      name = field.Name
+    }
+
+    schema := g.schema(field.Type)
+    if constraints, found := apiConstraints[typeName+"."+name]; found {
+      merged := make(map[string]any, len(schema)+len(constraints))
+      for k, v := range schema {
+        merged[k] = v
+      }
+      for k, v := range constraints {
+        merged[k] = v
+      }
+      schema = merged
+    }
+    properties[name] = schema
+    if !strings.Contains(options, "omitempty") && !strings.Contains(options, "omitzero") {
+      *required = append(*required, name)
+    }
+  }
+}
+
+// exportedName capitalises unexported type names for use as component names
+func exportedName(name string) string {
+  return strings.ToUpper(name[:1]) + name[1:]
+}
@@ -0,0 +1,96 @@
+package main
+
+import (
+  "encoding/json"
+  "net/http"
+  "net/http/httptest"
+  "regexp"
+  "strings"
+  "testing"
+)
+
+func TestOpenAPI_CoversRoutes(t *testing.T) {
+  rts := routes(NewPlayerHandler(NewPlayerService()))
+  registered := make(map[string]bool)
+  for _, rt := range rts {
+    registered[rt.pattern] = true
+    if _, documented := apiOperations[rt.pattern]; !documented {
+      t.Errorf("Route %s has no entry in apiOperations", rt.pattern)
+    }
+  }
+  for pattern := range apiOperations {
+    if !registered[pattern] {
+      t.Errorf("apiOperations documents %s, which isn't a route", pattern)
+    }
+  }
+
+  if _, err := OpenAPIDocument(append(rts, route{pattern: "GET /undocumented"})); err == nil {
+    t.Errorf("Expected an undocumented route to fail the document")
+  }
+}
+
+func TestOpenAPI_Endpoint(t *testing.T) {
+  router := newRouter(NewPlayerHandlerWithAuth(NewPlayerService(), nil, NewAPIKeyStore(), nil), nil)
+  w := httptest.NewRecorder()
+  router.ServeHTTP(w, httptest.NewRequest("GET", "/openapi.json", nil))
+  if w.Code != http.StatusOK || w.Header().Get("Content-Type") != "application/json" {
+    t.Fatalf("Expected the document without credentials, got %d %v", w.Code, w.Header())
+  }
+  raw := w.Body.String()
+
+  var document struct {
+    OpenAPI string `json:"openapi"`
+    Paths   map[string]map[string]struct {
+      Responses map[string]any `json:"responses"`
+      Scope     string         `json:"x-scope"`
+    } `json:"paths"`
+    Components struct {
+      Schemas map[string]struct {
+        Properties map[string]map[string]any `json:"properties"`
+        Required   []string                  `json:"required"`
+      } `json:"schemas"`
+    } `json:"components"`
+  }
+  if err := json.Unmarshal([]byte(raw), &document); err != nil {
+    t.Fatalf("Expected JSON, got %v", err)
+  }
+  if document.OpenAPI != OpenAPIVersion {
+    t.Errorf("Unexpected version %q", document.OpenAPI)
+  }
+
+  // The validation ranges of PlayerRequest are in its schema
+  request := document.Components.Schemas["PlayerRequest"]
+  if jersey := request.Properties["jersey_number"]; jersey["minimum"] != float64(MinJerseyNumber) || jersey["maximum"] != float64(MaxJerseyNumber) {
+    t.Errorf("Expected the jersey number range, got %v", jersey)
+  }
+  if rating := request.Properties["rating"]; rating["minimum"] != float64(MinRating) || rating["maximum"] != float64(MaxRating) {
+    t.Errorf("Expected the rating range, got %v", rating)
+  }
+  if strings.Join(request.Required, ",") != "name,jersey_number,rating" {
+    t.Errorf("Expected team_id to be optional, got %v", request.Required)
+  }
+
+  // Handlers' error statuses and the scope checks are documented
+  put := document.Paths["/players/{id}"]["put"]
+  for _, status := range []string{"200", "400", "401", "403", "404", "409", "412"} {
+    if put.Responses[status] == nil {
+      t.Errorf("Expected PUT /players/{id} to document %s, got %v", status, put.Responses)
+    }
+  }
+  if put.Scope != ScopeWrite {
+    t.Errorf("Expected the write scope, got %q", put.Scope)
+  }
+  if health := document.Paths["/health"]["get"]; health.Responses["401"] != nil {
+    t.Errorf("Expected public routes not to answer 401")
+  }
+  if document.Paths["/players"]["post"].Responses["201"] == nil {
+    t.Errorf("Expected POST /players to answer 201")
+  }
+
+  // Every reference resolves
+  for _, ref := range regexp.MustCompile(`"#/components/schemas/([^"]+)"`).FindAllStringSubmatch(raw, -1) {
+    if _, found := document.Components.Schemas[ref[1]]; !found {
+      t.Errorf("Dangling reference to %s", ref[1])
+    }
+  }
+}
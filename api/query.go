@@ -0,0 +1,357 @@
+package main
+
+import (
+  "bytes"
+  "cmp"
+  "encoding/base64"
+  "encoding/json"
+  "fmt"
+  "net/url"
+  "strconv"
+  "strings"
+)
+
+// Page size limits for GET /players
+const (
+  DefaultPageLimit = 100
+  MaxPageLimit     = 1000
+)
+
+// fieldKind is the type of a queryable player field
+type fieldKind int
+
+const (
+  stringField fieldKind = iota
+  intField
+  // idField holds strings that are compared numerically when possible,
+  // so player "10" sorts after player "9"
+  idField
+)
+
+// playerField describes a player field that can be sorted and filtered on
+type playerField struct {
+  kind  fieldKind
+  value func(p *Player) any
+}
+
+// playerFields lists the queryable fields by their JSON names
+var playerFields = map[string]playerField{
+  "id":            {kind: idField, value: func(p *Player) any { return p.ID }},
+  "name":          {kind: stringField, value: func(p *Player) any { return p.Name }},
+  "jersey_number": {kind: intField, value: func(p *Player) any { return int64(p.JerseyNumber) }},
+  "rating":        {kind: intField, value: func(p *Player) any { return int64(p.Rating) }},
+  "team_id":       {kind: idField, value: func(p *Player) any { return p.TeamID }},
+}
+
+// compareFieldValues orders two values of the same field
+func compareFieldValues(kind fieldKind, a, b any) int {
+  switch kind {
+  case intField:
+    return cmp.Compare(a.(int64), b.(int64))
+  case idField:
+    return compareIDs(a.(string), b.(string))
+  }
+  return strings.Compare(a.(string), b.(string))
+}
+
+// compareIDs orders numeric IDs by value and everything else lexically
+func compareIDs(a, b string) int {
+  x, errA := strconv.Atoi(a)
+  y, errB := strconv.Atoi(b)
+  if errA == nil && errB == nil {
+    return cmp.Compare(x, y)
+  }
+  return strings.Compare(a, b)
+}
+
+// parseFieldValue parses a query string value for the given field kind
+func parseFieldValue(kind fieldKind, raw string) (any, error) {
+  if kind == intField {
+    n, err := strconv.ParseInt(raw, 10, 64)
+    if err != nil {
+      return nil, fmt.Errorf("%q is not a whole number", raw)
+    }
+    return n, nil
+  }
+  return raw, nil
+}
+
+// SortKey orders query results by a single field
+type SortKey struct {
+  Field string
+  Desc  bool
+}
+
+// FieldFilter restricts query results by comparing a field with a value
+type FieldFilter struct {
+  Field string
+  Op    string
+  Value any
+}
+
+// filterSuffixes maps query parameter suffixes to comparison operators
+var filterSuffixes = []struct {
+  suffix string
+  op     string
+}{
+  {"_gte", ">="},
+  {"_lte", "<="},
+  {"_gt", ">"},
+  {"_lt", "<"},
+  {"_ne", "!="},
+}
+
+// matches reports whether the player passes the filter
+func (f FieldFilter) matches(p *Player) bool {
+  field := playerFields[f.Field]
+  return compareMatches(f.Op, compareFieldValues(field.kind, field.value(p), f.Value))
+}
+
+// compareMatches reports whether a comparison result satisfies op
+func compareMatches(op string, c int) bool {
+  switch op {
+  case "=":
+    return c == 0
+  case "!=":
+    return c != 0
+  case ">":
+    return c > 0
+  case ">=":
+    return c >= 0
+  case "<":
+    return c < 0
+  case "<=":
+    return c <= 0
+  }
+  return false
+}
+
+// PlayerQuery selects a page of players
+type PlayerQuery struct {
+  Limit   int
+  Sort    []SortKey
+  Filters []FieldFilter
+  Expr    filterExpr
+  Cursor  *pageCursor
+}
+
+// matches reports whether the player passes all of the query's filters
+func (q *PlayerQuery) matches(p *Player) bool {
+  for _, filter := range q.Filters {
+    if !filter.matches(p) {
+      return false
+    }
+  }
+  return q.Expr == nil || q.Expr.eval(p)
+}
+
+// keyedPlayer pairs a player with its precomputed sort values
+type keyedPlayer struct {
+  player Player
+  keys   []any
+}
+
+// PlayerPage is one page of query results
+type PlayerPage struct {
+  Players    []Player
+  Total      int
+  NextCursor string
+  PrevCursor string
+
+  // next is NextCursor decoded, for callers that page through every result
+  next *pageCursor
+}
+
+// pageCursor marks a position in a sorted result set. It holds the sort key
+// values of the player next to the page boundary, so inserts and deletes
+// elsewhere don't shift later pages.
+type pageCursor struct {
+  Sort   string `json:"s"`
+  Values []any  `json:"v"`
+  Before bool   `json:"b,omitempty"`
+}
+
+// sortSignature identifies a sort order so cursors can't be reused with another one
+func sortSignature(keys []SortKey) string {
+  parts := make([]string, len(keys))
+  for i, key := range keys {
+    parts[i] = key.Field
+    if key.Desc {
+      parts[i] = "-" + key.Field
+    }
+  }
+  return strings.Join(parts, ",")
+}
+
+// encodeCursor serialises a cursor into an opaque URL-safe token
+func encodeCursor(c pageCursor) string {
+  raw, _ := json.Marshal(c)
+  return base64.RawURLEncoding.EncodeToString(raw)
+}
+
+// decodeCursor parses a cursor token and checks it against the sort order
+func decodeCursor(token string, keys []SortKey) (*pageCursor, error) {
+  raw, err := base64.RawURLEncoding.DecodeString(token)
+  if err != nil {
+    return nil, fmt.Errorf("%w: malformed cursor", ErrInvalidInput)
+  }
+
+  var c pageCursor
+  decoder := json.NewDecoder(bytes.NewReader(raw))
+  decoder.UseNumber()
+  if err := decoder.Decode(&c); err != nil {
+    return nil, fmt.Errorf("%w: malformed cursor", ErrInvalidInput)
+  }
+  if c.Sort != sortSignature(keys) || len(c.Values) != len(keys) {
+    return nil, fmt.Errorf("%w: cursor does not match sort order %q", ErrInvalidInput, sortSignature(keys))
+  }
+
+  // JSON loses the Go types, so restore them from the sort fields
+  for i, key := range keys {
+    kind := playerFields[key.Field].kind
+    switch v := c.Values[i].(type) {
+    case json.Number:
+      n, err := v.Int64()
+      if err != nil || kind != intField {
+        return nil, fmt.Errorf("%w: malformed cursor", ErrInvalidInput)
+      }
+      c.Values[i] = n
+    case string:
+      if kind == intField {
+        return nil, fmt.Errorf("%w: malformed cursor", ErrInvalidInput)
+      }
+    default:
+      return nil, fmt.Errorf("%w: malformed cursor", ErrInvalidInput)
+    }
+  }
+  return &c, nil
+}
+
+// sortValues returns the player's values for the given sort keys
+func sortValues(p *Player, keys []SortKey) []any {
+  values := make([]any, len(keys))
+  for i, key := range keys {
+    values[i] = playerFields[key.Field].value(p)
+  }
+  return values
+}
+
+// compareByKeys orders two sets of sort values
+func compareByKeys(a, b []any, keys []SortKey) int {
+  for i, key := range keys {
+    c := compareFieldValues(playerFields[key.Field].kind, a[i], b[i])
+    if key.Desc {
+      c = -c
+    }
+    if c != 0 {
+      return c
+    }
+  }
+  return 0
+}
+
+// parseSort parses "rating,-name" into sort keys. The ID is always appended
+// as a final tie-breaker so the order is total and cursors are unambiguous.
+func parseSort(raw string) ([]SortKey, error) {
+  var keys []SortKey
+  seen := make(map[string]bool)
+
+  if raw != "" {
+    for _, part := range strings.Split(raw, ",") {
+      part = strings.TrimSpace(part)
+      key := SortKey{Field: part}
+      if strings.HasPrefix(part, "-") {
+        key = SortKey{Field: part[1:], Desc: true}
+      } else if strings.HasPrefix(part, "+") {
+        key.Field = part[1:]
+      }
+      if _, ok := playerFields[key.Field]; !ok {
+        return nil, fmt.Errorf("%w: cannot sort by %q", ErrInvalidInput, key.Field)
+      }
+      if seen[key.Field] {
+        return nil, fmt.Errorf("%w: %q appears twice in sort", ErrInvalidInput, key.Field)
+      }
+      seen[key.Field] = true
+      keys = append(keys, key)
+    }
+  }
+
+  if !seen["id"] {
+    keys = append(keys, SortKey{Field: "id"})
+  }
+  return keys, nil
+}
+
+// parseLimit parses a limit query parameter, falling back to def when empty
+func parseLimit(raw string, def, max int) (int, error) {
+  if raw == "" {
+    return def, nil
+  }
+  limit, err := strconv.Atoi(raw)
+  if err != nil || limit < 1 || limit > max {
+    return 0, fmt.Errorf("%w: limit must be between 1 and %d", ErrInvalidInput, max)
+  }
+  return limit, nil
+}
+
+// parsePlayerQuery builds a PlayerQuery from GET /players query parameters:
+// limit, cursor, sort, field filters such as rating_gte=90 and a filter
+// expression in filter=
+func parsePlayerQuery(values url.Values) (PlayerQuery, error) {
+  query := PlayerQuery{Limit: DefaultPageLimit}
+
+  limit, err := parseLimit(values.Get("limit"), DefaultPageLimit, MaxPageLimit)
+  if err != nil {
+    return query, err
+  }
+  query.Limit = limit
+
+  sortKeys, err := parseSort(values.Get("sort"))
+  if err != nil {
+    return query, err
+  }
+  query.Sort = sortKeys
+
+  if token := values.Get("cursor"); token != "" {
+    query.Cursor, err = decodeCursor(token, sortKeys)
+    if err != nil {
+      return query, err
+    }
+  }
+
+  if raw := values.Get("filter"); raw != "" {
+    query.Expr, err = ParseFilter(raw)
+    if err != nil {
+      return query, err
+    }
+  }
+  
+  for param, raws := range values {
+    switch param {
+    case "limit", "sort", "cursor", "filter":
+      continue
+    }
+
+    name, op := param, "="
+    for _, s := range filterSuffixes {
+      if trimmed, ok := strings.CutSuffix(param, s.suffix); ok {
+        name, op = trimmed, s.op
+        break
+      }
+    }
+
+    field, ok := playerFields[name]
+    if !ok {
+      return query, fmt.Errorf("%w: unknown query parameter %q", ErrInvalidInput, param)
+    }
+    for _, raw := range raws {
+      value, err := parseFieldValue(field.kind, raw)
+      if err != nil {
+        return query, fmt.Errorf("%w: %s: %v", ErrInvalidInput, param, err)
+      }
+      query.Filters = append(query.Filters, FieldFilter{Field: name, Op: op, Value: value})
+    }
+  }
+
+  return query, nil
+}
@@ -0,0 +1,285 @@
+package main
+
+import (
+  "container/list"
+  "fmt"
+  "math"
+  "net"
+  "net/http"
+  "strconv"
+  "strings"
+  "sync"
+  "time"
+)
+
+// Rate limit groups. Routes are grouped by the scope they need, so reads,
+// writes and admin calls each have their own budget. RateGroupIP is checked
+// per client IP before authentication, so requests with bad or stolen
+// credentials are limited too.
+const (
+  RateGroupIP     = "ip"
+  RateGroupPublic = "public"
+  RateGroupRead   = ScopeRead
+  RateGroupWrite  = ScopeWrite
+  RateGroupAdmin  = ScopeAdmin
+)
+
+// Rate limiter defaults
+const (
+  // DefaultRateLimitSweep is how often idle buckets are evicted
+  DefaultRateLimitSweep = time.Minute
+  // MaxRateLimitBuckets caps the number of clients tracked at once
+  MaxRateLimitBuckets = 100_000
+)
+
+// Rate limit headers
+const (
+  RateLimitLimitHeader     = "X-RateLimit-Limit"
+  RateLimitRemainingHeader = "X-RateLimit-Remaining"
+  RateLimitResetHeader     = "X-RateLimit-Reset"
+)
+
+// RateLimit allows bursts of up to Requests requests, refilled evenly over Per
+type RateLimit struct {
+  Requests int
+  Per      time.Duration
+}
+
+// String formats a limit the way ParseRateLimits reads it
+func (rl RateLimit) String() string {
+  return fmt.Sprintf("%d/%v", rl.Requests, rl.Per)
+}
+
+// DefaultRateLimits are the per-client limits for each route group
+func DefaultRateLimits() map[string]RateLimit {
+  return map[string]RateLimit{
+    RateGroupIP:     {Requests: 1200, Per: time.Minute},
+    RateGroupPublic: {Requests: 60, Per: time.Minute},
+    RateGroupRead:   {Requests: 600, Per: time.Minute},
+    RateGroupWrite:  {Requests: 120, Per: time.Minute},
+    RateGroupAdmin:  {Requests: 60, Per: time.Minute},
+  }
+}
+
+// ParseRateLimits reads limits like "read=600/1m,write=off" on top of the
+// defaults. "off" removes a group's limit.
+func ParseRateLimits(raw string) (map[string]RateLimit, error) {
+  limits := DefaultRateLimits()
+  for entry := range strings.SplitSeq(raw, ",") {
+    entry = strings.TrimSpace(entry)
+    if entry == "" {
+      continue
+    }
+    group, value, found := strings.Cut(entry, "=")
+    if !found {
+      return nil, fmt.Errorf("%w: rate limit %q must look like group=requests/duration", ErrInvalidInput, entry)
+    }
+    switch group {
+    case RateGroupIP, RateGroupPublic, RateGroupRead, RateGroupWrite, RateGroupAdmin:
+    default:
+      return nil, fmt.Errorf("%w: unknown rate limit group %q", ErrInvalidInput, group)
+    }
+    if value == "off" {
+      delete(limits, group)
+      continue
+    }
+
+    requests, per, _ := strings.Cut(value, "/")
+    n, err := strconv.Atoi(requests)
+    if err != nil || n <= 0 {
+      return nil, fmt.Errorf("%w: rate limit %q needs a positive request count", ErrInvalidInput, entry)
+    }
+    d, err := time.ParseDuration(per)
+    if err != nil || d <= 0 {
+      return nil, fmt.Errorf("%w: rate limit %q needs a positive duration", ErrInvalidInput, entry)
+    }
+    limits[group] = RateLimit{Requests: n, Per: d}
+  }
+  return limits, nil
+}
+
+// tokenBucket holds a client's remaining requests as of updated
+type tokenBucket struct {
+  key     bucketKey
+  tokens  float64
+  updated time.Time
+}
+
+type bucketKey struct {
+  group  string
+  client string
+}
+
+// RateDecision is the outcome of a rate limit check
+type RateDecision struct {
+  Allowed   bool
+  Limit     int
+  Remaining int
+  // Reset is how long until the bucket is full again
+  Reset time.Duration
+  // RetryAfter is how long until the next request is allowed
+  RetryAfter time.Duration
+}
+
+// RateLimiter limits each client to a token bucket per route group. Buckets
+// that have refilled are no different from new ones, so they are evicted
+// periodically and memory only grows with the clients active recently.
+type RateLimiter struct {
+  mu      sync.Mutex
+  limits  map[string]RateLimit
+  buckets map[bucketKey]*list.Element
+  // recent holds the *tokenBucket values, most recently used first
+  recent *list.List
+  // longest is the longest refill period; buckets idle for that long are
+  // full whatever their group
+  longest    time.Duration
+  maxBuckets int
+  lastSweep  time.Time
+
+  // now is replaced by tests
+  now func() time.Time
+}
+
+// NewRateLimiter creates a limiter with the given limit per group. Groups
+// without a limit are not limited.
+func NewRateLimiter(limits map[string]RateLimit) *RateLimiter {
+  l := &RateLimiter{
+    limits:     limits,
+    buckets:    make(map[bucketKey]*list.Element),
+    recent:     list.New(),
+    maxBuckets: MaxRateLimitBuckets,
+    now:        time.Now,
+  }
+  for _, limit := range limits {
+    l.longest = max(l.longest, limit.Per)
+  }
+  return l
+}
+
+// Allow takes a token from the client's bucket for group, if there is one
+func (l *RateLimiter) Allow(group, client string) RateDecision {
+  limit, limited := l.limits[group]
+  if !limited {
+    return RateDecision{Allowed: true}
+  }
+  rate := float64(limit.Requests) / limit.Per.Seconds()
+
+  l.mu.Lock()
+  defer l.mu.Unlock()
+
+  now := l.now()
+  l.sweep(now)
+  key := bucketKey{group, client}
+  elem, exists := l.buckets[key]
+  if exists {
+    l.recent.MoveToFront(elem)
+  } else {
+    if len(l.buckets) >= l.maxBuckets {
+      l.evict(l.recent.Back())
+    }
+    elem = l.recent.PushFront(&tokenBucket{key: key, tokens: float64(limit.Requests), updated: now})
+    l.buckets[key] = elem
+  }
+  bucket := elem.Value.(*tokenBucket)
+  bucket.tokens = min(float64(limit.Requests), bucket.tokens+now.Sub(bucket.updated).Seconds()*rate)
+  bucket.updated = now
+
+  decision := RateDecision{Limit: limit.Requests}
+  if bucket.tokens >= 1 {
+    bucket.tokens--
+    decision.Allowed = true
+  } else {
+    decision.RetryAfter = secondsToDuration((1 - bucket.tokens) / rate)
+  }
+  decision.Remaining = int(bucket.tokens)
+  decision.Reset = secondsToDuration((float64(limit.Requests) - bucket.tokens) / rate)
+  return decision
+}
+
+// sweep evicts the least recently used buckets that have had time to
+// refill, stopping at the first one that may not have. The caller must hold
+// the lock.
+func (l *RateLimiter) sweep(now time.Time) {
+  if now.Sub(l.lastSweep) < DefaultRateLimitSweep {
+    return
+  }
+  l.lastSweep = now
+  for elem := l.recent.Back(); elem != nil && now.Sub(elem.Value.(*tokenBucket).updated) >= l.longest; elem = l.recent.Back() {
+    l.evict(elem)
+  }
+}
+
+// evict drops a bucket. The caller must hold the lock.
+func (l *RateLimiter) evict(elem *list.Element) {
+  delete(l.buckets, l.recent.Remove(elem).(*tokenBucket).key)
+}
+
+// Buckets returns the number of clients currently tracked
+func (l *RateLimiter) Buckets() int {
+  l.mu.Lock()
+  defer l.mu.Unlock()
+
+  return len(l.buckets)
+}
+
+// Limit wraps next with the group's limit. Authenticated requests are
+// limited per credential and the rest per client IP, so it must run after
+// RequireScope.
+func (l *RateLimiter) Limit(group string, next http.Handler) http.Handler {
+  return l.limit(group, rateLimitClient, next)
+}
+
+// LimitIP wraps next with the RateGroupIP limit, keyed by client IP alone.
+// It runs before RequireScope, so it also covers requests that fail to
+// authenticate.
+func (l *RateLimiter) LimitIP(next http.Handler) http.Handler {
+  return l.limit(RateGroupIP, clientIP, next)
+}
+
+func (l *RateLimiter) limit(group string, client func(*http.Request) string, next http.Handler) http.Handler {
+  if _, limited := l.limits[group]; !limited {
+    return next
+  }
+  return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+    decision := l.Allow(group, client(r))
+    w.Header().Set(RateLimitLimitHeader, strconv.Itoa(decision.Limit))
+    w.Header().Set(RateLimitRemainingHeader, strconv.Itoa(decision.Remaining))
+    w.Header().Set(RateLimitResetHeader, strconv.Itoa(ceilSeconds(decision.Reset)))
+    if decision.Allowed {
+      next.ServeHTTP(w, r)
+      return
+    }
+
+    retryAfter := ceilSeconds(decision.RetryAfter)
+    w.Header().Set("Retry-After", strconv.Itoa(retryAfter))
+    err := fmt.Errorf("%s rate limit of %v exceeded, retry in %ds", group, l.limits[group], retryAfter)
+    writeErrorResponse(w, http.StatusTooManyRequests, "Too many requests", err)
+  })
+}
+
+// rateLimitClient identifies the client a request counts against: its
+// credential when authenticated, otherwise the address it connected from
+func rateLimitClient(r *http.Request) string {
+  if principal, ok := PrincipalFrom(r.Context()); ok {
+    return principal.ID
+  }
+  return clientIP(r)
+}
+
+// clientIP identifies the address a request connected from
+func clientIP(r *http.Request) string {
+  host, _, err := net.SplitHostPort(r.RemoteAddr)
+  if err != nil {
+    host = r.RemoteAddr
+  }
+  return "ip:" + host
+}
+
+func secondsToDuration(seconds float64) time.Duration {
+  return time.Duration(seconds * float64(time.Second))
+}
+
+// ceilSeconds rounds a duration up to whole seconds, for headers
+func ceilSeconds(d time.Duration) int {
+  return int(math.Ceil(d.Seconds()))
+}
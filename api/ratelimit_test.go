@@ -0,0 +1,201 @@
+package main
+
+import (
+  "encoding/json"
+  "net/http"
+  "net/http/httptest"
+  "testing"
+  "time"
+)
+
+// fakeLimiterClock makes the limiter read the returned time
+func fakeLimiterClock(limiter *RateLimiter) *time.Time {
+  now := time.Date(2025, 3, 1, 12, 0, 0, 0, time.UTC)
+  limiter.now = func() time.Time { return now }
+  return &now
+}
+
+func TestRateLimiter_TokenBucket(t *testing.T) {
+  limiter := NewRateLimiter(map[string]RateLimit{RateGroupRead: {Requests: 3, Per: 3 * time.Second}})
+  now := fakeLimiterClock(limiter)
+
+  for i := range 3 {
+    if d := limiter.Allow(RateGroupRead, "alice"); !d.Allowed || d.Remaining != 2-i {
+      t.Fatalf("Expected request %d to be allowed with %d left, got %+v", i+1, 2-i, d)
+    }
+  }
+  d := limiter.Allow(RateGroupRead, "alice")
+  if d.Allowed || d.Limit != 3 || d.RetryAfter != time.Second || d.Reset != 3*time.Second {
+    t.Errorf("Expected the burst to be used up, got %+v", d)
+  }
+
+  // Other clients and unlimited groups are unaffected
+  if !limiter.Allow(RateGroupRead, "bob").Allowed || !limiter.Allow(RateGroupWrite, "alice").Allowed {
+    t.Errorf("Expected other buckets to be untouched")
+  }
+
+  // Tokens come back at 1 per second, up to the burst
+  *now = now.Add(time.Second)
+  if d := limiter.Allow(RateGroupRead, "alice"); !d.Allowed || d.Remaining != 0 {
+    t.Errorf("Expected a refilled token, got %+v", d)
+  }
+  *now = now.Add(time.Hour)
+  if d := limiter.Allow(RateGroupRead, "alice"); d.Remaining != 2 {
+    t.Errorf("Expected the bucket to refill only up to the burst, got %+v", d)
+  }
+}
+
+func TestRateLimiter_EvictsIdleBuckets(t *testing.T) {
+  limiter := NewRateLimiter(map[string]RateLimit{RateGroupRead: {Requests: 10, Per: 10 * time.Second}})
+  now := fakeLimiterClock(limiter)
+
+  limiter.Allow(RateGroupRead, "alice")
+  limiter.Allow(RateGroupRead, "bob")
+  *now = now.Add(DefaultRateLimitSweep)
+  limiter.Allow(RateGroupRead, "carol")
+  if n := limiter.Buckets(); n != 1 {
+    t.Errorf("Expected idle buckets to be evicted, got %d buckets", n)
+  }
+
+  // At the cap, the least recently used client makes room
+  limiter.maxBuckets = 2
+  *now = now.Add(time.Second)
+  limiter.Allow(RateGroupRead, "dave")
+  *now = now.Add(time.Second)
+  for range 10 {
+    limiter.Allow(RateGroupRead, "erin")
+  }
+  if n := limiter.Buckets(); n != 2 {
+    t.Errorf("Expected at most 2 buckets, got %d", n)
+  }
+  if d := limiter.Allow(RateGroupRead, "erin"); d.Allowed {
+    t.Errorf("Expected erin's bucket to be kept, got %+v", d)
+  }
+}
+
+func TestParseRateLimits(t *testing.T) {
+  limits, err := ParseRateLimits("read=1000/1m, write=off,public=5/1s")
+  if err != nil {
+    t.Fatalf("Failed to parse limits: %v", err)
+  }
+  if limits[RateGroupRead] != (RateLimit{1000, time.Minute}) || limits[RateGroupPublic] != (RateLimit{5, time.Second}) {
+    t.Errorf("Unexpected limits %v", limits)
+  }
+  if _, limited := limits[RateGroupWrite]; limited {
+    t.Errorf("Expected writes not to be limited")
+  }
+  if limits[RateGroupAdmin] != DefaultRateLimits()[RateGroupAdmin] {
+    t.Errorf("Expected the admin default to be kept")
+  }
+
+  for _, raw := range []string{"read", "reads=1/1m", "read=0/1m", "read=10", "read=10/-1s", "read=x/1m"} {
+    if _, err := ParseRateLimits(raw); err == nil {
+      t.Errorf("Expected %q to be rejected", raw)
+    }
+  }
+}
+
+func TestRateLimiter_Middleware(t *testing.T) {
+  service := NewPlayerService()
+  keys := NewAPIKeyStore()
+  reader, _ := keys.CreateAPIKey(APIKeyRequest{Name: "dashboard", Scopes: []string{ScopeRead}})
+  other, _ := keys.CreateAPIKey(APIKeyRequest{Name: "other", Scopes: []string{ScopeRead}})
+  limiter := NewRateLimiter(map[string]RateLimit{
+    RateGroupPublic: {Requests: 1, Per: time.Minute},
+    RateGroupRead:   {Requests: 2, Per: time.Minute},
+  })
+  router := newRouter(NewPlayerHandlerWithAuth(service, nil, keys, nil), limiter)
+
+  do := func(target, key, addr string) *httptest.ResponseRecorder {
+    req := httptest.NewRequest("GET", target, nil)
+    req.RemoteAddr = addr
+    if key != "" {
+      req.Header.Set("Authorization", "Bearer "+key)
+    }
+    w := httptest.NewRecorder()
+    router.ServeHTTP(w, req)
+    return w
+  }
+
+  // Keyed by API key, whatever address the requests come from
+  do("/players", reader.Key, "10.0.0.1:1000")
+  w := do("/players/1", reader.Key, "10.0.0.2:1000")
+  if w.Code != http.StatusOK || w.Header().Get(RateLimitLimitHeader) != "2" || w.Header().Get(RateLimitRemainingHeader) != "0" {
+    t.Errorf("Expected the last request of the burst, got %d %v", w.Code, w.Header())
+  }
+  w = do("/players", reader.Key, "10.0.0.3:1000")
+  if w.Code != http.StatusTooManyRequests || w.Header().Get("Retry-After") != "30" || w.Header().Get(RateLimitResetHeader) != "60" {
+    t.Errorf("Expected 429 with Retry-After, got %d %v", w.Code, w.Header())
+  }
+  var response Response
+  if err := json.NewDecoder(w.Body).Decode(&response); err != nil || response.Status != "error" || response.Error == "" {
+    t.Errorf("Expected a standard error response, got %+v %v", response, err)
+  }
+  if w := do("/players", other.Key, "10.0.0.3:1000"); w.Code != http.StatusOK {
+    t.Errorf("Expected another key to have its own budget, got %d", w.Code)
+  }
+
+  // Public routes are keyed by client IP
+  if w := do("/openapi.json", "", "10.0.0.1:1000"); w.Code != http.StatusOK {
+    t.Errorf("Expected status 200, got %d", w.Code)
+  }
+  if w := do("/openapi.json", "", "10.0.0.1:2000"); w.Code != http.StatusTooManyRequests {
+    t.Errorf("Expected the same IP to be limited, got %d", w.Code)
+  }
+  if w := do("/openapi.json", "", "10.0.0.2:1000"); w.Code != http.StatusOK {
+    t.Errorf("Expected another IP to have its own budget, got %d", w.Code)
+  }
+
+  // Writes have no limit configured, so no headers either
+  writer, _ := keys.CreateAPIKey(APIKeyRequest{Name: "importer", Scopes: []string{ScopeWrite}})
+  req := httptest.NewRequest("DELETE", "/players/1", nil)
+  req.Header.Set("Authorization", "Bearer "+writer.Key)
+  w = httptest.NewRecorder()
+  router.ServeHTTP(w, req)
+  if w.Code != http.StatusOK || w.Header().Get(RateLimitLimitHeader) != "" {
+    t.Errorf("Expected an unlimited write, got %d %v", w.Code, w.Header())
+  }
+}
+
+func TestRateLimiter_LimitsIPBeforeAuth(t *testing.T) {
+  keys := NewAPIKeyStore()
+  reader, _ := keys.CreateAPIKey(APIKeyRequest{Name: "dashboard", Scopes: []string{ScopeRead}})
+  limiter := NewRateLimiter(map[string]RateLimit{
+    RateGroupIP:   {Requests: 3, Per: time.Minute},
+    RateGroupRead: {Requests: 2, Per: time.Minute},
+  })
+  router := newRouter(NewPlayerHandlerWithAuth(NewPlayerService(), nil, keys, nil), limiter)
+
+  do := func(key, addr string) int {
+    req := httptest.NewRequest("GET", "/players", nil)
+    req.RemoteAddr = addr
+    req.Header.Set("Authorization", "Bearer "+key)
+    w := httptest.NewRecorder()
+    router.ServeHTTP(w, req)
+    return w.Code
+  }
+
+  // Guessed keys use up the address's budget before they are checked
+  steps := []struct {
+    key  string
+    want int
+  }{
+    {"pk_guess1", http.StatusUnauthorized},
+    {"pk_guess2", http.StatusUnauthorized},
+    {reader.Key, http.StatusOK},
+    {reader.Key, http.StatusTooManyRequests},
+  }
+  for i, step := range steps {
+    if code := do(step.key, "10.0.0.1:1000"); code != step.want {
+      t.Errorf("Request %d: expected status %d, got %d", i+1, step.want, code)
+    }
+  }
+
+  // The key's own bucket still applies from other addresses
+  if code := do(reader.Key, "10.0.0.2:1000"); code != http.StatusOK {
+    t.Errorf("Expected another address to have its own budget, got %d", code)
+  }
+  if code := do(reader.Key, "10.0.0.3:1000"); code != http.StatusTooManyRequests {
+    t.Errorf("Expected the key's budget to be used up, got %d", code)
+  }
+}
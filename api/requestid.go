@@ -0,0 +1,47 @@
+package main
+
+import (
+  "context"
+  "crypto/rand"
+  "encoding/hex"
+)
+
+// RequestIDHeader carries the ID that ties a request to its log and audit entries
+const RequestIDHeader = "X-Request-ID"
+
+// MaxRequestIDLength caps request IDs supplied by clients
+const MaxRequestIDLength = 128
+
+type requestIDKey struct{}
+
+// WithRequestID returns a context that carries a request ID
+func WithRequestID(ctx context.Context, id string) context.Context {
+  return context.WithValue(ctx, requestIDKey{}, id)
+}
+
+// RequestIDFrom returns the request ID carried by ctx, if any
+func RequestIDFrom(ctx context.Context) string {
+  id, _ := ctx.Value(requestIDKey{}).(string)
+  return id
+}
+
+// newRequestID returns a random 128-bit ID in hex
+func newRequestID() string {
+  var b [16]byte
+  rand.Read(b[:])
+  return hex.EncodeToString(b[:])
+}
+
+// validRequestID reports whether a client-supplied request ID is safe to
+// log and echo back: short, and only visible ASCII characters
+func validRequestID(id string) bool {
+  if id == "" || len(id) > MaxRequestIDLength {
+    return false
+  }
+  for i := 0; i < len(id); i++ {
+    if id[i] <= ' ' || id[i] > '~' {
+      return false
+    }
+  }
+  return true
+}
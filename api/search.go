@@ -0,0 +1,333 @@
+package main
+
+import (
+  "cmp"
+  "slices"
+  "strings"
+  "unicode"
+)
+
+// Search tuning
+const (
+  DefaultSearchLimit = 10
+  MaxSearchLimit     = 50
+
+  // minSearchScore is the lowest similarity a fuzzy match may have
+  minSearchScore = 0.3
+)
+
+// accentFolds maps accented Latin letters to their unaccented spelling.
+// Only lowercase letters are listed because names are lowercased first.
+var accentFolds = func() map[rune]string {
+  groups := map[string]string{
+    "a":  "àáâãäåāăąǎǻ",
+    "ae": "æǽ",
+    "c":  "çćĉċč",
+    "d":  "ďđð",
+    "e":  "èéêëēĕėęě",
+    "g":  "ĝğġģ",
+    "h":  "ĥħ",
+    "i":  "ìíîïĩīĭįı",
+    "j":  "ĵ",
+    "k":  "ķ",
+    "l":  "ĺļľŀł",
+    "n":  "ñńņňŉ",
+    "o":  "òóôõöøōŏőǒ",
+    "oe": "œ",
+    "r":  "ŕŗř",
+    "s":  "śŝşšș",
+    "ss": "ß",
+    "t":  "ţťŧț",
+    "th": "þ",
+    "u":  "ùúûüũūŭůűųǔ",
+    "w":  "ŵ",
+    "y":  "ýÿŷ",
+    "z":  "źżž",
+  }
+
+  folds := make(map[rune]string)
+  for plain, accented := range groups {
+    for _, r := range accented {
+      folds[r] = plain
+    }
+  }
+  return folds
+}()
+
+// foldName normalises a name for matching: lowercase, accents removed and
+// anything that isn't a letter or digit turned into a single space.
+// "Kylian Mbappé" and "kylian  MBAPPE" both fold to "kylian mbappe".
+func foldName(name string) string {
+  var sb strings.Builder
+  space := true
+  for _, r := range strings.ToLower(name) {
+    if folded, ok := accentFolds[r]; ok {
+      sb.WriteString(folded)
+      space = false
+      continue
+    }
+    if unicode.IsLetter(r) || unicode.IsDigit(r) {
+      sb.WriteRune(r)
+      space = false
+      continue
+    }
+    // Combining marks left over from decomposed input are dropped
+    if unicode.Is(unicode.Mn, r) {
+      continue
+    }
+    if !space {
+      sb.WriteByte(' ')
+      space = true
+    }
+  }
+  return strings.TrimSuffix(sb.String(), " ")
+}
+
+// trigrams returns the set of padded three-character grams of each word, so
+// that word starts weigh more than word middles
+func trigrams(folded string) map[string]struct{} {
+  grams := make(map[string]struct{})
+  for _, word := range strings.Fields(folded) {
+    runes := []rune("  " + word + " ")
+    for i := 0; i+3 <= len(runes); i++ {
+      grams[string(runes[i:i+3])] = struct{}{}
+    }
+  }
+  return grams
+}
+
+// diceSimilarity compares two gram sets: 1 means identical, 0 nothing shared
+func diceSimilarity(a, b map[string]struct{}) float64 {
+  if len(a) == 0 || len(b) == 0 {
+    return 0
+  }
+  common := 0
+  for gram := range a {
+    if _, ok := b[gram]; ok {
+      common++
+    }
+  }
+  return 2 * float64(common) / float64(len(a)+len(b))
+}
+
+// indexedName is the searchable form of one player's name
+type indexedName struct {
+  folded string
+  grams  map[string]struct{}
+  words  []map[string]struct{}
+}
+
+// prefixNode is a node of the trie used for autocomplete
+type prefixNode struct {
+  children map[rune]*prefixNode
+  ids      map[string]struct{}
+}
+
+// nameIndex is a trigram index over player names for fuzzy search, plus a
+// prefix trie over every word of every name for autocomplete
+type nameIndex struct {
+  names    map[string]indexedName
+  postings map[string]map[string]struct{}
+  prefixes *prefixNode
+}
+
+func newNameIndex() *nameIndex {
+  return &nameIndex{
+    names:    make(map[string]indexedName),
+    postings: make(map[string]map[string]struct{}),
+    prefixes: &prefixNode{},
+  }
+}
+
+// add indexes a player's name
+func (ix *nameIndex) add(id, name string) {
+  folded := foldName(name)
+  entry := indexedName{folded: folded, grams: trigrams(folded)}
+  for _, word := range strings.Fields(folded) {
+    entry.words = append(entry.words, trigrams(word))
+  }
+  ix.names[id] = entry
+
+  for gram := range entry.grams {
+    ix.postings[gram] = addToSet(ix.postings[gram], id)
+  }
+  for _, key := range prefixKeys(folded) {
+    ix.prefixes.insert(key, id)
+  }
+}
+
+// remove drops a player's name from the index
+func (ix *nameIndex) remove(id string) {
+  entry, exists := ix.names[id]
+  if !exists {
+    return
+  }
+  delete(ix.names, id)
+
+  for gram := range entry.grams {
+    if ids := ix.postings[gram]; ids != nil {
+      delete(ids, id)
+      if len(ids) == 0 {
+        delete(ix.postings, gram)
+      }
+    }
+  }
+  for _, key := range prefixKeys(entry.folded) {
+    ix.prefixes.remove([]rune(key), id)
+  }
+}
+
+// prefixKeys lists the strings a name can be completed from: the full name
+// and the tail starting at each later word, so "mba" finds "Kylian Mbappé"
+func prefixKeys(folded string) []string {
+  keys := []string{folded}
+  for i, r := range folded {
+    if r == ' ' {
+      keys = append(keys, folded[i+1:])
+    }
+  }
+  return keys
+}
+
+func (n *prefixNode) insert(key, id string) {
+  node := n
+  for _, r := range key {
+    if node.children == nil {
+      node.children = make(map[rune]*prefixNode)
+    }
+    child := node.children[r]
+    if child == nil {
+      child = &prefixNode{}
+      node.children[r] = child
+    }
+    node = child
+  }
+  node.ids = addToSet(node.ids, id)
+}
+
+// remove deletes id under key and prunes nodes that became empty.
+// It reports whether n itself is now empty.
+func (n *prefixNode) remove(key []rune, id string) bool {
+  if len(key) == 0 {
+    delete(n.ids, id)
+  } else if child := n.children[key[0]]; child != nil && child.remove(key[1:], id) {
+    delete(n.children, key[0])
+  }
+  return len(n.ids) == 0 && len(n.children) == 0
+}
+
+// SearchMatch is a fuzzy search result
+type SearchMatch struct {
+  Player Player  `json:"player"`
+  Score  float64 `json:"score"`
+}
+
+// search returns the IDs and scores of names similar to the query, best first
+func (ix *nameIndex) search(query string, limit int) []scoredID {
+  folded := foldName(query)
+  queryGrams := trigrams(folded)
+  if len(queryGrams) == 0 {
+    return nil
+  }
+
+  // Only names sharing at least one trigram with the query are scored
+  candidates := make(map[string]struct{})
+  for gram := range queryGrams {
+    for id := range ix.postings[gram] {
+      candidates[id] = struct{}{}
+    }
+  }
+
+  var results []scoredID
+  for id := range candidates {
+    entry := ix.names[id]
+
+    // Score against the whole name and against each word, so a query for
+    // just the surname isn't penalised for the length of the full name
+    score := diceSimilarity(queryGrams, entry.grams)
+    for _, word := range entry.words {
+      score = max(score, diceSimilarity(queryGrams, word))
+    }
+    if entry.folded == folded {
+      score = 1
+    }
+
+    if score >= minSearchScore {
+      results = append(results, scoredID{id: id, score: score})
+    }
+  }
+
+  slices.SortFunc(results, func(a, b scoredID) int {
+    if c := cmp.Compare(b.score, a.score); c != 0 {
+      return c
+    }
+    return compareIDs(a.id, b.id)
+  })
+  if len(results) > limit {
+    results = results[:limit]
+  }
+  return results
+}
+
+// scoredID is a search hit before the player is looked up
+type scoredID struct {
+  id    string
+  score float64
+}
+
+// Suggestion is an autocomplete entry
+type Suggestion struct {
+  ID   string `json:"id"`
+  Name string `json:"name"`
+}
+
+// complete returns the IDs of players whose name, or a later word of it,
+// starts with prefix. Shorter completions come first.
+func (ix *nameIndex) complete(prefix string, limit int) []string {
+  folded := foldName(prefix)
+  if folded == "" {
+    return nil
+  }
+
+  node := ix.prefixes
+  for _, r := range folded {
+    node = node.children[r]
+    if node == nil {
+      return nil
+    }
+  }
+
+  // Breadth-first, so the closest completions are found first
+  var ids []string
+  seen := make(map[string]bool)
+  queue := []*prefixNode{node}
+  for len(queue) > 0 && len(ids) < limit {
+    current := queue[0]
+    queue = queue[1:]
+
+    level := make([]string, 0, len(current.ids))
+    for id := range current.ids {
+      if !seen[id] {
+        level = append(level, id)
+      }
+    }
+    slices.SortFunc(level, compareIDs)
+    for _, id := range level {
+      if len(ids) == limit {
+        break
+      }
+      seen[id] = true
+      ids = append(ids, id)
+    }
+
+    keys := make([]rune, 0, len(current.children))
+    for r := range current.children {
+      keys = append(keys, r)
+    }
+    slices.Sort(keys)
+    for _, r := range keys {
+      queue = append(queue, current.children[r])
+    }
+  }
+  return ids
+}
@@ -0,0 +1,103 @@
+package main
+
+import (
+  "testing"
+)
+
+func TestFoldName(t *testing.T) {
+  tests := map[string]string{
+    "Kylian Mbappé":     "kylian mbappe",
+    "  ÖZIL  ":          "ozil",
+    "Ødegaard":          "odegaard",
+    "Müller-Wohlfahrt":  "muller wohlfahrt",
+    "Łukasz Piszczek":   "lukasz piszczek",
+    "Gündoğan":          "gundogan",
+    "Mbappé":      "mbappe",
+    "Straße":            "strasse",
+  }
+  
+  for input, want := range tests {
+    if got := foldName(input); got != want {
+      t.Errorf("foldName(%q) = %q, want %q", input, got, want)
+    }
+  }
+}
+
+func TestPlayerService_SearchPlayers(t *testing.T) {
+  service := NewPlayerService()
+  for _, req := range []PlayerRequest{
+    {Name: "Kylian Mbappé", JerseyNumber: 7, Rating: 91},
+    {Name: "Ronaldinho", JerseyNumber: 10, Rating: 94},
+    {Name: "Mesut Özil", JerseyNumber: 10, Rating: 88},
+  } {
+    if _, err := service.CreatePlayer(t.Context(), req); err != nil {
+      t.Fatalf("Failed to create player: %v", err)
+    }
+  }
+  
+  tests := []struct {
+    query     string
+    wantFirst string
+  }{
+    {"mbappe", "Kylian Mbappé"},
+    {"MBAPPÉ", "Kylian Mbappé"},
+    {"kylian mbape", "Kylian Mbappé"},
+    {"mesi", "Messi"},
+    {"ronalod", "Ronaldo"},
+    {"ozil", "Mesut Özil"},
+  }
+  
+  for _, tt := range tests {
+    t.Run(tt.query, func(t *testing.T) {
+      matches := service.SearchPlayers(tt.query, DefaultSearchLimit)
+      if len(matches) == 0 {
+        t.Fatalf("Expected matches for %q", tt.query)
+      }
+      if matches[0].Player.Name != tt.wantFirst {
+        t.Errorf("Expected %q first, got %q", tt.wantFirst, matches[0].Player.Name)
+      }
+      for i := 1; i < len(matches); i++ {
+        if matches[i].Score > matches[i-1].Score {
+          t.Errorf("Expected results ordered by score, got %v", matches)
+        }
+      }
+    })
+  }
+  
+  if matches := service.SearchPlayers("zzzz", DefaultSearchLimit); len(matches) != 0 {
+    t.Errorf("Expected no matches for unrelated query, got %v", matches)
+  }
+}
+
+func TestPlayerService_SuggestPlayers(t *testing.T) {
+  service := NewPlayerService()
+  if _, err := service.CreatePlayer(t.Context(), PlayerRequest{Name: "Kylian Mbappé", JerseyNumber: 7, Rating: 91}); err != nil {
+    t.Fatalf("Failed to create player: %v", err)
+  }
+  
+  names := func(suggestions []Suggestion) []string {
+    var out []string
+    for _, s := range suggestions {
+      out = append(out, s.Name)
+    }
+    return out
+  }
+  
+  if got := names(service.SuggestPlayers("mba", 5)); len(got) != 1 || got[0] != "Kylian Mbappé" {
+    t.Errorf("Expected surname prefix to suggest Kylian Mbappé, got %v", got)
+  }
+  if got := names(service.SuggestPlayers("Ne", 5)); len(got) != 1 || got[0] != "Neymar" {
+    t.Errorf("Expected Neymar, got %v", got)
+  }
+  
+  // Renamed players are only suggested under their new name
+  if _, err := service.UpdatePlayer(t.Context(), "3", PlayerRequest{Name: "Junior", JerseyNumber: 10, Rating: 95}, AnyVersion); err != nil {
+    t.Fatalf("Failed to update player: %v", err)
+  }
+  if got := names(service.SuggestPlayers("ne", 5)); len(got) != 0 {
+    t.Errorf("Expected no suggestions for old name, got %v", got)
+  }
+  if got := names(service.SuggestPlayers("jun", 5)); len(got) != 1 {
+    t.Errorf("Expected a suggestion for new name, got %v", got)
+  }
+}
@@ -1,40 +1,63 @@
 package main
 
 import (
+  "context"
+  "errors"
   "fmt"
-  "strconv"
+  "slices"
+  "sort"
   "sync"
+  "time"
 )
 
 // PlayerService handles player-related operations with thread safety
 type PlayerService struct {
-  mu   sync.RWMutex
-  data map[string]Player
-  idCounter int
+  mu      sync.RWMutex
+  store   PlayerStore
+  indexes *playerIndexes
+  audit   *AuditTrail
+  events  *EventBroker
+
+  // now stamps rating changes; tests replace it to control time
+  now func() time.Time
 }
 
-// NewPlayerService creates a new PlayerService with sample data
+// NewPlayerService creates a new PlayerService with sample data kept in memory
 func NewPlayerService() *PlayerService {
-  service := &PlayerService{
-    data: make(map[string]Player),
-    idCounter: 0,
-  }
+  store := NewMemoryStore()
   
-  // Add sample data
-  samplePlayers := []Player{
-    {ID: "1", Name: "Messi", JerseyNumber: 10, Rating: 99},
-    {ID: "2", Name: "Ronaldo", JerseyNumber: 7, Rating: 98},
-    {ID: "3", Name: "Neymar", JerseyNumber: 10, Rating: 95},
-  }
+  // Seeding an empty memory store can't fail
+  SeedSamplePlayers(store)
   
-  for _, player := range samplePlayers {
-    service.data[player.ID] = player
-    if id, err := strconv.Atoi(player.ID); err == nil && id > service.idCounter {
-      service.idCounter = id
-    }
+  return NewPlayerServiceWithStore(store)
+}
+
+// NewPlayerServiceWithStore creates a new PlayerService backed by the given
+// store, keeping its audit trail in memory
+func NewPlayerServiceWithStore(store PlayerStore) *PlayerService {
+  return NewPlayerServiceWithAudit(store, NewMemoryAuditTrail())
+}
+
+// NewPlayerServiceWithAudit creates a new PlayerService backed by the given
+// store that records every change in the given audit trail
+func NewPlayerServiceWithAudit(store PlayerStore, audit *AuditTrail) *PlayerService {
+  return &PlayerService{
+    store:   store,
+    indexes: newPlayerIndexes(store),
+    audit:   audit,
+    events:  NewEventBroker(DefaultReplaySize),
+    now:     time.Now,
   }
+}
+
+// Close disconnects event subscribers and releases the underlying store and
+// audit trail
+func (s *PlayerService) Close() error {
+  s.mu.Lock()
+  defer s.mu.Unlock()
   
-  return service
+  s.events.Close()
+  return errors.Join(s.store.Close(), s.audit.Close())
 }
 
 // GetAllPlayers returns all players
@@ -42,90 +65,184 @@ func (s *PlayerService) GetAllPlayers() []Player {
   s.mu.RLock()
   defer s.mu.RUnlock()
   
-  players := make([]Player, 0, len(s.data))
-  for _, player := range s.data {
+  players := make([]Player, 0, s.store.Len())
+  s.store.Range(func(player Player) bool {
     players = append(players, player)
-  }
+    return true
+  })
   return players
 }
 
-// GetPlayerByID returns a player by ID
-func (s *PlayerService) GetPlayerByID(id string) (Player, error) {
+// QueryPlayers returns one page of players matching the query. Pages are
+// positioned by the sort values at the cursor rather than by offset, so
+// concurrent writes never make a client skip or repeat players.
+func (s *PlayerService) QueryPlayers(query PlayerQuery) PlayerPage {
   s.mu.RLock()
   defer s.mu.RUnlock()
   
-  player, exists := s.data[id]
-  if !exists {
-    return Player{}, ErrPlayerNotFound
+  // Filters are evaluated in place, so only matching players are copied.
+  // When an index covers one of the filters only its candidates are visited.
+  matches := make([]Player, 0)
+  collect := func(player Player) bool {
+    if query.matches(&player) {
+      matches = append(matches, player)
+    }
+    return true
   }
-  return player, nil
-}
-
-// CreatePlayer creates a new player
-func (s *PlayerService) CreatePlayer(req PlayerRequest) (Player, error) {
-  if err := req.Validate(); err != nil {
-    return Player{}, err
+  if ids, ok := s.indexes.candidateIDs(query.Filters); ok {
+    for _, id := range ids {
+      if player, exists := s.store.Get(id); exists {
+        collect(player)
+      }
+    }
+  } else {
+    s.store.Range(collect)
   }
   
-  s.mu.Lock()
-  defer s.mu.Unlock()
+  // Compute each player's sort values once rather than on every comparison
+  keyed := make([]keyedPlayer, len(matches))
+  for i := range matches {
+    keyed[i] = keyedPlayer{player: matches[i], keys: sortValues(&matches[i], query.Sort)}
+  }
+  slices.SortFunc(keyed, func(a, b keyedPlayer) int {
+    return compareByKeys(a.keys, b.keys, query.Sort)
+  })
+  for i := range keyed {
+    matches[i] = keyed[i].player
+  }
   
-  // Check if a player with same name and jersey number already exists
-  for _, player := range s.data {
-    if player.Name == req.Name && player.JerseyNumber == req.JerseyNumber {
-      return Player{}, fmt.Errorf("%w: player with name %s and jersey number %d already exists", 
-        ErrPlayerExists, req.Name, req.JerseyNumber)
+  // Find the page boundaries relative to the cursor
+  start, end := 0, min(query.Limit, len(matches))
+  if c := query.Cursor; c != nil {
+    if c.Before {
+      end = sort.Search(len(keyed), func(i int) bool {
+        return compareByKeys(keyed[i].keys, c.Values, query.Sort) >= 0
+      })
+      start = max(0, end-query.Limit)
+    } else {
+      start = sort.Search(len(keyed), func(i int) bool {
+        return compareByKeys(keyed[i].keys, c.Values, query.Sort) > 0
+      })
+      end = min(start+query.Limit, len(matches))
     }
   }
   
-  s.idCounter++
-  id := strconv.Itoa(s.idCounter)
-  player := req.ToPlayer(id)
-  s.data[id] = player
-  
-  return player, nil
+  page := PlayerPage{
+    Players: matches[start:end],
+    Total:   len(matches),
+  }
+  signature := sortSignature(query.Sort)
+  if end < len(matches) && end > 0 {
+    page.next = &pageCursor{Sort: signature, Values: keyed[end-1].keys}
+    page.NextCursor = encodeCursor(*page.next)
+  }
+  if start > 0 {
+    page.PrevCursor = encodeCursor(pageCursor{Sort: signature, Values: keyed[start].keys, Before: true})
+  }
+  return page
 }
 
-// UpdatePlayer updates an existing player
-func (s *PlayerService) UpdatePlayer(id string, req PlayerRequest) (Player, error) {
-  if err := req.Validate(); err != nil {
-    return Player{}, err
-  }
-  
-  s.mu.Lock()
-  defer s.mu.Unlock()
+// SearchPlayers finds players whose name is similar to the query, ignoring
+// case and accents, best match first
+func (s *PlayerService) SearchPlayers(query string, limit int) []SearchMatch {
+  s.mu.RLock()
+  defer s.mu.RUnlock()
   
-  player, exists := s.data[id]
-  if !exists {
-    return Player{}, ErrPlayerNotFound
+  hits := s.indexes.names.search(query, limit)
+  matches := make([]SearchMatch, 0, len(hits))
+  for _, hit := range hits {
+    if player, exists := s.store.Get(hit.id); exists {
+      matches = append(matches, SearchMatch{Player: player, Score: hit.score})
+    }
   }
+  return matches
+}
+
+// SuggestPlayers returns players whose name, or any word of it, starts with prefix
+func (s *PlayerService) SuggestPlayers(prefix string, limit int) []Suggestion {
+  s.mu.RLock()
+  defer s.mu.RUnlock()
   
-  // Check if another player has the same name and jersey number
-  for existingID, existingPlayer := range s.data {
-    if existingID != id && existingPlayer.Name == req.Name && existingPlayer.JerseyNumber == req.JerseyNumber {
-      return Player{}, fmt.Errorf("%w: another player with name %s and jersey number %d already exists", 
-        ErrPlayerExists, req.Name, req.JerseyNumber)
+  ids := s.indexes.names.complete(prefix, limit)
+  suggestions := make([]Suggestion, 0, len(ids))
+  for _, id := range ids {
+    if player, exists := s.store.Get(id); exists {
+      suggestions = append(suggestions, Suggestion{ID: player.ID, Name: player.Name})
     }
   }
+  return suggestions
+}
+
+// GetPlayerByID returns a player by ID
+func (s *PlayerService) GetPlayerByID(id string) (Player, error) {
+  s.mu.RLock()
+  defer s.mu.RUnlock()
   
-  player.Update(req)
-  s.data[id] = player
-  
+  player, exists := s.store.Get(id)
+  if !exists {
+    return Player{}, ErrPlayerNotFound
+  }
   return player, nil
 }
 
-// DeletePlayer deletes a player by ID
-func (s *PlayerService) DeletePlayer(id string) (Player, error) {
+// CreatePlayer creates a new player
+func (s *PlayerService) CreatePlayer(ctx context.Context, req PlayerRequest) (Player, error) {
+  return s.write(ctx, func(tx *playerTx) (Player, error) {
+    return tx.create(req)
+  })
+}
+
+// UpdatePlayer updates an existing player. Unless version is AnyVersion the
+// update only succeeds if the player is still at that version.
+func (s *PlayerService) UpdatePlayer(ctx context.Context, id string, req PlayerRequest, version int64) (Player, error) {
+  return s.write(ctx, func(tx *playerTx) (Player, error) {
+    return tx.update(id, req, version)
+  })
+}
+
+// PatchPlayer applies patch to the editable fields of an existing player.
+// The patch runs under the write lock, so it always sees the latest data,
+// and its result goes through the same checks as UpdatePlayer.
+func (s *PlayerService) PatchPlayer(ctx context.Context, id string, version int64, patch func(PlayerRequest) (PlayerRequest, error)) (Player, error) {
+  return s.write(ctx, func(tx *playerTx) (Player, error) {
+    return tx.patch(id, version, patch)
+  })
+}
+
+// DeletePlayer deletes a player by ID. Unless version is AnyVersion the
+// delete only succeeds if the player is still at that version.
+func (s *PlayerService) DeletePlayer(ctx context.Context, id string, version int64) (Player, error) {
+  return s.write(ctx, func(tx *playerTx) (Player, error) {
+    return tx.delete(id, version)
+  })
+}
+
+// write runs fn in a transaction under the write lock and commits it
+func (s *PlayerService) write(ctx context.Context, fn func(tx *playerTx) (Player, error)) (Player, error) {
+  return writeTx(ctx, s, fn)
+}
+
+// writeTx is write for transactions that return something other than a player
+func writeTx[T any](ctx context.Context, s *PlayerService, fn func(tx *playerTx) (T, error)) (T, error) {
+  ctx, span := StartSpan(ctx, "PlayerService.write")
+  defer span.End()
+  
   s.mu.Lock()
   defer s.mu.Unlock()
   
-  player, exists := s.data[id]
-  if !exists {
-    return Player{}, ErrPlayerNotFound
+  var zero T
+  tx := s.begin(ctx)
+  result, err := fn(tx)
+  if err != nil {
+    tx.rollback()
+    span.RecordError(err)
+    return zero, err
   }
-  
-  delete(s.data, id)
-  return player, nil
+  if err := tx.commit(); err != nil {
+    span.RecordError(err)
+    return zero, err
+  }
+  return result, nil
 }
 
 // PlayerExists checks if a player exists by ID
@@ -133,6 +250,42 @@ func (s *PlayerService) PlayerExists(id string) bool {
   s.mu.RLock()
   defer s.mu.RUnlock()
   
-  _, exists := s.data[id]
+  _, exists := s.store.Get(id)
   return exists
-} 
\ No newline at end of file
+}
+
+// Ping checks that the store is usable. It also fails when the service lock
+// can't be taken before ctx is done, so a stuck write shows up too.
+func (s *PlayerService) Ping(ctx context.Context) error {
+  done := make(chan error, 1)
+  go func() {
+    s.mu.RLock()
+    defer s.mu.RUnlock()
+    
+    done <- s.store.Ping()
+  }()
+  
+  select {
+  case err := <-done:
+    return err
+  case <-ctx.Done():
+    return fmt.Errorf("waiting for the store: %w", ctx.Err())
+  }
+}
+
+// PlayerCount returns the number of players, not counting deleted ones
+func (s *PlayerService) PlayerCount() int {
+  s.mu.RLock()
+  defer s.mu.RUnlock()
+  
+  return s.store.Len()
+}
+
+// checkVersion verifies that a player is at the expected version
+func checkVersion(player Player, version int64) error {
+  if version != AnyVersion && player.Version != version {
+    return fmt.Errorf("%w: player %s is at version %d, not %d",
+      ErrVersionMismatch, player.ID, player.Version, version)
+  }
+  return nil
+}
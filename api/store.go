@@ -0,0 +1,271 @@
+package main
+
+import (
+  "strconv"
+)
+
+// PlayerStore is the persistence backend behind PlayerService. It holds
+// players, deleted players awaiting purge, the teams they play for, their
+// matches and their rating history, so that related changes can be applied
+// in a single batch.
+// Implementations don't need to be safe for concurrent use because
+// PlayerService serialises all access with its own RWMutex.
+type PlayerStore interface {
+  // Get returns the player with the given ID
+  Get(id string) (Player, bool)
+  // Range calls fn for every stored player until fn returns false
+  Range(fn func(Player) bool)
+  // Len returns the number of stored players
+  Len() int
+  // GetDeleted returns the player with the given ID from the trash
+  GetDeleted(id string) (DeletedPlayer, bool)
+  // RangeDeleted calls fn for every player in the trash until fn returns false
+  RangeDeleted(fn func(DeletedPlayer) bool)
+  // IDCounter returns the highest player ID handed out so far
+  IDCounter() int
+  // GetTeam returns the team with the given ID
+  GetTeam(id string) (Team, bool)
+  // RangeTeams calls fn for every stored team until fn returns false
+  RangeTeams(fn func(Team) bool)
+  // TeamIDCounter returns the highest team ID handed out so far
+  TeamIDCounter() int
+  // GetMatch returns the match with the given ID
+  GetMatch(id string) (Match, bool)
+  // RangeMatches calls fn for every stored match until fn returns false
+  RangeMatches(fn func(Match) bool)
+  // MatchIDCounter returns the highest match ID handed out so far
+  MatchIDCounter() int
+  // History returns a player's rating changes, oldest first
+  History(playerID string) []RatingChange
+  // RatingSeq returns the sequence number of the latest rating change
+  RatingSeq() int64
+  // Apply persists a batch of changes atomically
+  Apply(batch StoreBatch) error
+  // Ping reports whether the store can still persist changes
+  Ping() error
+  // Close flushes and releases any resources held by the store
+  Close() error
+}
+
+// StoreBatch is a set of changes that a PlayerStore applies all-or-nothing.
+// Put takes a player out of the trash if it was there, Trash moves a live
+// player to the trash, and Delete removes a player, with its rating
+// history, for good.
+type StoreBatch struct {
+  Put            []Player        `json:"put,omitempty"`
+  Delete         []string        `json:"delete,omitempty"`
+  Trash          []DeletedPlayer `json:"trash,omitempty"`
+  IDCounter      int             `json:"id_counter,omitempty"`
+  PutTeams       []Team          `json:"put_teams,omitempty"`
+  DeleteTeams    []string        `json:"delete_teams,omitempty"`
+  TeamIDCounter  int             `json:"team_id_counter,omitempty"`
+  PutMatches     []Match         `json:"put_matches,omitempty"`
+  DeleteMatches  []string        `json:"delete_matches,omitempty"`
+  MatchIDCounter int             `json:"match_id_counter,omitempty"`
+
+  // RatingChanges are appended to the players' history in Seq order
+  RatingChanges []RatingChange `json:"rating_changes,omitempty"`
+}
+
+// storeData is the in-memory state shared by the store implementations
+type storeData struct {
+  players        map[string]Player
+  trash          map[string]DeletedPlayer
+  idCounter      int
+  teams          map[string]Team
+  teamIDCounter  int
+  matches        map[string]Match
+  matchIDCounter int
+  history        map[string][]RatingChange
+  ratingSeq      int64
+}
+
+func newStoreData() storeData {
+  return storeData{
+    players: make(map[string]Player),
+    trash:   make(map[string]DeletedPlayer),
+    teams:   make(map[string]Team),
+    matches: make(map[string]Match),
+    history: make(map[string][]RatingChange),
+  }
+}
+
+// Get returns the player with the given ID
+func (d *storeData) Get(id string) (Player, bool) {
+  player, exists := d.players[id]
+  return player, exists
+}
+
+// Range calls fn for every stored player until fn returns false
+func (d *storeData) Range(fn func(Player) bool) {
+  for _, player := range d.players {
+    if !fn(player) {
+      return
+    }
+  }
+}
+
+// Len returns the number of stored players
+func (d *storeData) Len() int {
+  return len(d.players)
+}
+
+// GetDeleted returns the player with the given ID from the trash
+func (d *storeData) GetDeleted(id string) (DeletedPlayer, bool) {
+  player, exists := d.trash[id]
+  return player, exists
+}
+
+// RangeDeleted calls fn for every player in the trash until fn returns false
+func (d *storeData) RangeDeleted(fn func(DeletedPlayer) bool) {
+  for _, player := range d.trash {
+    if !fn(player) {
+      return
+    }
+  }
+}
+
+// IDCounter returns the highest player ID handed out so far
+func (d *storeData) IDCounter() int {
+  return d.idCounter
+}
+
+// GetTeam returns the team with the given ID
+func (d *storeData) GetTeam(id string) (Team, bool) {
+  team, exists := d.teams[id]
+  return team, exists
+}
+
+// RangeTeams calls fn for every stored team until fn returns false
+func (d *storeData) RangeTeams(fn func(Team) bool) {
+  for _, team := range d.teams {
+    if !fn(team) {
+      return
+    }
+  }
+}
+
+// TeamIDCounter returns the highest team ID handed out so far
+func (d *storeData) TeamIDCounter() int {
+  return d.teamIDCounter
+}
+
+// GetMatch returns the match with the given ID
+func (d *storeData) GetMatch(id string) (Match, bool) {
+  match, exists := d.matches[id]
+  return match, exists
+}
+
+// RangeMatches calls fn for every stored match until fn returns false
+func (d *storeData) RangeMatches(fn func(Match) bool) {
+  for _, match := range d.matches {
+    if !fn(match) {
+      return
+    }
+  }
+}
+
+// MatchIDCounter returns the highest match ID handed out so far
+func (d *storeData) MatchIDCounter() int {
+  return d.matchIDCounter
+}
+
+// History returns a player's rating changes, oldest first
+func (d *storeData) History(playerID string) []RatingChange {
+  return d.history[playerID]
+}
+
+// RatingSeq returns the sequence number of the latest rating change
+func (d *storeData) RatingSeq() int64 {
+  return d.ratingSeq
+}
+
+// apply applies a batch. Applying the same batch twice leaves the data in
+// the same state, which lets the file store replay its log on top of a
+// snapshot that may already contain some of the records.
+func (d *storeData) apply(batch StoreBatch) {
+  for _, player := range batch.Put {
+    d.players[player.ID] = player
+    delete(d.trash, player.ID)
+  }
+  for _, id := range batch.Delete {
+    delete(d.players, id)
+    delete(d.trash, id)
+    delete(d.history, id)
+  }
+  for _, deleted := range batch.Trash {
+    delete(d.players, deleted.ID)
+    d.trash[deleted.ID] = deleted
+  }
+  for _, team := range batch.PutTeams {
+    d.teams[team.ID] = team
+  }
+  for _, id := range batch.DeleteTeams {
+    delete(d.teams, id)
+  }
+  for _, match := range batch.PutMatches {
+    d.matches[match.ID] = match
+  }
+  for _, id := range batch.DeleteMatches {
+    delete(d.matches, id)
+  }
+  for _, change := range batch.RatingChanges {
+    // Changes at or below the sequence number are already in the history
+    if change.Seq <= d.ratingSeq {
+      continue
+    }
+    d.history[change.PlayerID] = append(d.history[change.PlayerID], change)
+    d.ratingSeq = change.Seq
+  }
+  d.idCounter = max(d.idCounter, batch.IDCounter)
+  d.teamIDCounter = max(d.teamIDCounter, batch.TeamIDCounter)
+  d.matchIDCounter = max(d.matchIDCounter, batch.MatchIDCounter)
+}
+
+// MemoryStore is an in-memory PlayerStore. Data is lost on restart.
+type MemoryStore struct {
+  storeData
+}
+
+// NewMemoryStore creates an empty in-memory store
+func NewMemoryStore() *MemoryStore {
+  return &MemoryStore{storeData: newStoreData()}
+}
+
+// Apply applies a batch of changes
+func (m *MemoryStore) Apply(batch StoreBatch) error {
+  m.apply(batch)
+  return nil
+}
+
+// Ping always succeeds for the in-memory store
+func (m *MemoryStore) Ping() error {
+  return nil
+}
+
+// Close is a no-op for the in-memory store
+func (m *MemoryStore) Close() error {
+  return nil
+}
+
+// SeedSamplePlayers adds the sample players to an empty store.
+// Stores that already hold data, or have handed out IDs before, are left alone.
+func SeedSamplePlayers(store PlayerStore) error {
+  if store.Len() > 0 || store.IDCounter() > 0 {
+    return nil
+  }
+
+  samplePlayers := []Player{
+    {ID: "1", Name: "Messi", JerseyNumber: 10, Rating: 99, Version: 1},
+    {ID: "2", Name: "Ronaldo", JerseyNumber: 7, Rating: 98, Version: 1},
+    {ID: "3", Name: "Neymar", JerseyNumber: 10, Rating: 95, Version: 1},
+  }
+
+  batch := StoreBatch{Put: samplePlayers}
+  for _, player := range samplePlayers {
+    if id, err := strconv.Atoi(player.ID); err == nil && id > batch.IDCounter {
+      batch.IDCounter = id
+    }
+  }
+  return store.Apply(batch)
+}
@@ -0,0 +1,94 @@
+package main
+
+import (
+  "context"
+  "fmt"
+  "slices"
+)
+
+// What happens to a team's players when the team is deleted
+const (
+  TeamCascade  = "cascade"
+  TeamReassign = "reassign"
+)
+
+// TeamDeleteOptions chooses what happens to the players of a deleted team.
+// OnPlayers may be left empty only if the team has no players. With
+// TeamReassign the players move to ReassignTo, or become free agents when
+// it is empty.
+type TeamDeleteOptions struct {
+  OnPlayers  string
+  ReassignTo string
+}
+
+// Validate checks the options before anything is changed
+func (o TeamDeleteOptions) Validate() error {
+  switch o.OnPlayers {
+  case "", TeamCascade, TeamReassign:
+  default:
+    return fmt.Errorf("%w: on_players must be %q or %q", ErrInvalidInput, TeamCascade, TeamReassign)
+  }
+  if o.ReassignTo != "" && o.OnPlayers != TeamReassign {
+    return fmt.Errorf("%w: reassign_to needs on_players=%s", ErrInvalidInput, TeamReassign)
+  }
+  return nil
+}
+
+// TeamDeletion reports a deleted team and what happened to its players
+type TeamDeletion struct {
+  Team              Team     `json:"team"`
+  DeletedPlayers    []string `json:"deleted_players,omitempty"`
+  ReassignedPlayers []string `json:"reassigned_players,omitempty"`
+}
+
+// GetTeams returns all teams ordered by ID
+func (s *PlayerService) GetTeams() []Team {
+  s.mu.RLock()
+  defer s.mu.RUnlock()
+
+  teams := make([]Team, 0)
+  s.store.RangeTeams(func(team Team) bool {
+    teams = append(teams, team)
+    return true
+  })
+  slices.SortFunc(teams, func(a, b Team) int { return compareIDs(a.ID, b.ID) })
+  return teams
+}
+
+// GetTeamByID returns a team by ID
+func (s *PlayerService) GetTeamByID(id string) (Team, error) {
+  s.mu.RLock()
+  defer s.mu.RUnlock()
+
+  team, exists := s.store.GetTeam(id)
+  if !exists {
+    return Team{}, fmt.Errorf("%w: %s", ErrTeamNotFound, id)
+  }
+  return team, nil
+}
+
+// CreateTeam creates a new team
+func (s *PlayerService) CreateTeam(ctx context.Context, req TeamRequest) (Team, error) {
+  return writeTx(ctx, s, func(tx *playerTx) (Team, error) {
+    return tx.createTeam(req)
+  })
+}
+
+// UpdateTeam renames a team. Unless version is AnyVersion the update only
+// succeeds if the team is still at that version.
+func (s *PlayerService) UpdateTeam(ctx context.Context, id string, req TeamRequest, version int64) (Team, error) {
+  return writeTx(ctx, s, func(tx *playerTx) (Team, error) {
+    return tx.updateTeam(id, req, version)
+  })
+}
+
+// DeleteTeam deletes a team, and deletes or moves its players as opts says,
+// in a single transaction
+func (s *PlayerService) DeleteTeam(ctx context.Context, id string, version int64, opts TeamDeleteOptions) (TeamDeletion, error) {
+  if err := opts.Validate(); err != nil {
+    return TeamDeletion{}, err
+  }
+  return writeTx(ctx, s, func(tx *playerTx) (TeamDeletion, error) {
+    return tx.deleteTeam(id, version, opts)
+  })
+}
@@ -0,0 +1,304 @@
+package main
+
+import (
+  "encoding/json"
+  "errors"
+  "net/http"
+  "net/http/httptest"
+  "strings"
+  "testing"
+)
+
+// newServiceWithTeams returns the sample service plus two teams
+func newServiceWithTeams(t *testing.T) (*PlayerService, Team, Team) {
+  t.Helper()
+  service := NewPlayerService()
+  miami, err := service.CreateTeam(t.Context(), TeamRequest{Name: "Inter Miami"})
+  if err != nil {
+    t.Fatalf("Failed to create team: %v", err)
+  }
+  santos, err := service.CreateTeam(t.Context(), TeamRequest{Name: "Santos"})
+  if err != nil {
+    t.Fatalf("Failed to create team: %v", err)
+  }
+  return service, miami, santos
+}
+
+func TestPlayerService_TeamJerseyUniqueness(t *testing.T) {
+  service, miami, santos := newServiceWithTeams(t)
+
+  // Messi and Neymar can both wear 10 once they're on different teams
+  if _, err := service.UpdatePlayer(t.Context(), "1", PlayerRequest{Name: "Messi", JerseyNumber: 10, Rating: 99, TeamID: miami.ID}, AnyVersion); err != nil {
+    t.Fatalf("Failed to sign Messi: %v", err)
+  }
+  if _, err := service.UpdatePlayer(t.Context(), "3", PlayerRequest{Name: "Neymar", JerseyNumber: 10, Rating: 95, TeamID: santos.ID}, AnyVersion); err != nil {
+    t.Fatalf("Failed to sign Neymar: %v", err)
+  }
+
+  tests := []struct {
+    name    string
+    req     PlayerRequest
+    wantErr error
+  }{
+    {"jersey taken on team", PlayerRequest{Name: "Suárez", JerseyNumber: 10, Rating: 88, TeamID: miami.ID}, ErrPlayerExists},
+    {"same jersey on other team", PlayerRequest{Name: "Suárez", JerseyNumber: 9, Rating: 88, TeamID: santos.ID}, nil},
+    {"same name and jersey on another team", PlayerRequest{Name: "Suárez", JerseyNumber: 9, Rating: 88, TeamID: miami.ID}, nil},
+    {"free agent may reuse a rostered name and jersey", PlayerRequest{Name: "Messi", JerseyNumber: 10, Rating: 80}, nil},
+    {"free agents keep name and jersey unique", PlayerRequest{Name: "Messi", JerseyNumber: 10, Rating: 70}, ErrPlayerExists},
+    {"unknown team", PlayerRequest{Name: "Busquets", JerseyNumber: 5, Rating: 85, TeamID: "99"}, ErrInvalidInput},
+  }
+
+  for _, tt := range tests {
+    t.Run(tt.name, func(t *testing.T) {
+      _, err := service.CreatePlayer(t.Context(), tt.req)
+      if tt.wantErr == nil && err != nil {
+        t.Errorf("Expected no error, got %v", err)
+      }
+      if tt.wantErr != nil && !errors.Is(err, tt.wantErr) {
+        t.Errorf("Expected %v, got %v", tt.wantErr, err)
+      }
+    })
+  }
+
+  // Releasing a player frees their number on the team
+  if _, err := service.PatchPlayer(t.Context(), "1", AnyVersion, func(req PlayerRequest) (PlayerRequest, error) {
+    req.TeamID = ""
+    req.Name = "Leo Messi"
+    return req, nil
+  }); err != nil {
+    t.Fatalf("Failed to release Messi: %v", err)
+  }
+  if _, err := service.CreatePlayer(t.Context(), PlayerRequest{Name: "Suárez", JerseyNumber: 10, Rating: 88, TeamID: miami.ID}); err != nil {
+    t.Errorf("Expected jersey 10 to be free after release, got %v", err)
+  }
+}
+
+func TestPlayerService_TeamNames(t *testing.T) {
+  service, miami, _ := newServiceWithTeams(t)
+
+  if _, err := service.CreateTeam(t.Context(), TeamRequest{Name: "inter  miami"}); !errors.Is(err, ErrTeamExists) {
+    t.Errorf("Expected names to be compared ignoring case and spacing, got %v", err)
+  }
+  if _, err := service.CreateTeam(t.Context(), TeamRequest{Name: " "}); !errors.Is(err, ErrInvalidInput) {
+    t.Errorf("Expected blank name to be rejected, got %v", err)
+  }
+
+  renamed, err := service.UpdateTeam(t.Context(), miami.ID, TeamRequest{Name: "Inter Miami CF"}, miami.Version)
+  if err != nil {
+    t.Fatalf("Failed to rename team: %v", err)
+  }
+  if renamed.Version != miami.Version+1 {
+    t.Errorf("Expected version %d, got %d", miami.Version+1, renamed.Version)
+  }
+  if _, err := service.UpdateTeam(t.Context(), miami.ID, TeamRequest{Name: "Miami"}, miami.Version); !errors.Is(err, ErrVersionMismatch) {
+    t.Errorf("Expected stale version to be rejected, got %v", err)
+  }
+  if _, err := service.CreateTeam(t.Context(), TeamRequest{Name: "Inter Miami"}); err != nil {
+    t.Errorf("Expected old name to be free after rename, got %v", err)
+  }
+}
+
+func TestPlayerService_DeleteTeam(t *testing.T) {
+  setup := func(t *testing.T) (*PlayerService, Team, Team) {
+    service, miami, santos := newServiceWithTeams(t)
+    for _, req := range []PlayerRequest{
+      {Name: "Messi", JerseyNumber: 10, Rating: 99, TeamID: miami.ID},
+      {Name: "Busquets", JerseyNumber: 5, Rating: 85, TeamID: miami.ID},
+      {Name: "Neymar", JerseyNumber: 10, Rating: 95, TeamID: santos.ID},
+    } {
+      if _, err := service.CreatePlayer(t.Context(), req); err != nil {
+        t.Fatalf("Failed to create player: %v", err)
+      }
+    }
+    return service, miami, santos
+  }
+  rostered := func(service *PlayerService, teamID string) int {
+    return service.QueryPlayers(PlayerQuery{
+      Limit:   DefaultPageLimit,
+      Sort:    []SortKey{{Field: "id"}},
+      Filters: []FieldFilter{{Field: "team_id", Op: "=", Value: teamID}},
+    }).Total
+  }
+
+  t.Run("needs a choice", func(t *testing.T) {
+    service, miami, _ := setup(t)
+    if _, err := service.DeleteTeam(t.Context(), miami.ID, AnyVersion, TeamDeleteOptions{}); !errors.Is(err, ErrTeamHasPlayers) {
+      t.Errorf("Expected ErrTeamHasPlayers, got %v", err)
+    }
+    if _, err := service.GetTeamByID(miami.ID); err != nil {
+      t.Errorf("Expected team to survive, got %v", err)
+    }
+  })
+
+  t.Run("cascade", func(t *testing.T) {
+    service, miami, _ := setup(t)
+    deletion, err := service.DeleteTeam(t.Context(), miami.ID, AnyVersion, TeamDeleteOptions{OnPlayers: TeamCascade})
+    if err != nil {
+      t.Fatalf("Failed to delete team: %v", err)
+    }
+    if len(deletion.DeletedPlayers) != 2 || service.PlayerExists(deletion.DeletedPlayers[0]) {
+      t.Errorf("Expected both players to be deleted, got %+v", deletion)
+    }
+    if _, err := service.GetTeamByID(miami.ID); !errors.Is(err, ErrTeamNotFound) {
+      t.Errorf("Expected team to be gone, got %v", err)
+    }
+  })
+
+  t.Run("reassign conflict rolls back", func(t *testing.T) {
+    service, miami, santos := setup(t)
+    _, err := service.DeleteTeam(t.Context(), miami.ID, AnyVersion, TeamDeleteOptions{OnPlayers: TeamReassign, ReassignTo: santos.ID})
+    if !errors.Is(err, ErrPlayerExists) {
+      t.Fatalf("Expected jersey 10 clash on Santos, got %v", err)
+    }
+    if rostered(service, miami.ID) != 2 || rostered(service, santos.ID) != 1 {
+      t.Errorf("Expected rosters to be untouched after rollback")
+    }
+  })
+
+  t.Run("reassign to free agency", func(t *testing.T) {
+    service, miami, _ := setup(t)
+
+    // The seeded free agent Messi already holds name Messi and jersey 10
+    opts := TeamDeleteOptions{OnPlayers: TeamReassign}
+    if _, err := service.DeleteTeam(t.Context(), miami.ID, AnyVersion, opts); !errors.Is(err, ErrPlayerExists) {
+      t.Fatalf("Expected released Messi to clash with the free agent, got %v", err)
+    }
+    if _, err := service.DeletePlayer(t.Context(), "1", AnyVersion); err != nil {
+      t.Fatalf("Failed to delete player: %v", err)
+    }
+
+    deletion, err := service.DeleteTeam(t.Context(), miami.ID, AnyVersion, opts)
+    if err != nil {
+      t.Fatalf("Failed to delete team: %v", err)
+    }
+    if len(deletion.ReassignedPlayers) != 2 {
+      t.Errorf("Expected 2 reassigned players, got %+v", deletion)
+    }
+    player, _ := service.GetPlayerByID(deletion.ReassignedPlayers[0])
+    if player.TeamID != "" {
+      t.Errorf("Expected player to be a free agent, got team %q", player.TeamID)
+    }
+  })
+
+  t.Run("invalid options", func(t *testing.T) {
+    service, miami, _ := setup(t)
+    for _, opts := range []TeamDeleteOptions{
+      {OnPlayers: "archive"},
+      {OnPlayers: TeamCascade, ReassignTo: "2"},
+      {OnPlayers: TeamReassign, ReassignTo: miami.ID},
+      {OnPlayers: TeamReassign, ReassignTo: "99"},
+    } {
+      if _, err := service.DeleteTeam(t.Context(), miami.ID, AnyVersion, opts); !errors.Is(err, ErrInvalidInput) {
+        t.Errorf("%+v: expected ErrInvalidInput, got %v", opts, err)
+      }
+    }
+  })
+}
+
+func TestFileStore_PersistsTeams(t *testing.T) {
+  dir := t.TempDir()
+
+  service := NewPlayerServiceWithStore(openTestFileStore(t, dir, 2))
+  team, err := service.CreateTeam(t.Context(), TeamRequest{Name: "Santos"})
+  if err != nil {
+    t.Fatalf("Failed to create team: %v", err)
+  }
+  if _, err := service.CreatePlayer(t.Context(), PlayerRequest{Name: "Neymar", JerseyNumber: 10, Rating: 95, TeamID: team.ID}); err != nil {
+    t.Fatalf("Failed to create player: %v", err)
+  }
+  if _, err := service.CreateTeam(t.Context(), TeamRequest{Name: "Barcelona"}); err != nil {
+    t.Fatalf("Failed to create team: %v", err)
+  }
+  if err := service.Close(); err != nil {
+    t.Fatalf("Failed to close service: %v", err)
+  }
+
+  service = NewPlayerServiceWithStore(openTestFileStore(t, dir, 2))
+  defer service.Close()
+
+  if teams := service.GetTeams(); len(teams) != 2 || teams[0].Name != "Santos" {
+    t.Fatalf("Expected both teams to survive a restart, got %v", teams)
+  }
+  if _, err := service.CreatePlayer(t.Context(), PlayerRequest{Name: "Pelé", JerseyNumber: 10, Rating: 99, TeamID: team.ID}); !errors.Is(err, ErrPlayerExists) {
+    t.Errorf("Expected rebuilt index to keep jersey 10 taken, got %v", err)
+  }
+  next, err := service.CreateTeam(t.Context(), TeamRequest{Name: "Flamengo"})
+  if err != nil || next.ID != "3" {
+    t.Errorf("Expected team ID 3 after restart, got %q (%v)", next.ID, err)
+  }
+}
+
+func TestPlayerHandler_Teams(t *testing.T) {
+  service, miami, _ := newServiceWithTeams(t)
+  handler := NewPlayerHandler(service)
+
+  mux := http.NewServeMux()
+  mux.HandleFunc("GET /teams/{id}/players", handler.GetTeamPlayers)
+  mux.HandleFunc("POST /teams/{id}/players", handler.CreateTeamPlayer)
+  mux.HandleFunc("PUT /players/{id}", handler.UpdatePlayer)
+  mux.HandleFunc("DELETE /teams/{id}", handler.DeleteTeam)
+
+  do := func(method, target, body string) *httptest.ResponseRecorder {
+    req := httptest.NewRequest(method, target, strings.NewReader(body))
+    w := httptest.NewRecorder()
+    mux.ServeHTTP(w, req)
+    return w
+  }
+
+  if w := do("POST", "/teams/1/players", `{"name": "Messi", "jersey_number": 10, "rating": 99}`); w.Code != http.StatusCreated {
+    t.Fatalf("Expected status %d, got %d: %s", http.StatusCreated, w.Code, w.Body.String())
+  }
+  if w := do("POST", "/teams/1/players", `{"name": "Suárez", "jersey_number": 10, "rating": 88}`); w.Code != http.StatusConflict {
+    t.Errorf("Expected status %d for taken jersey, got %d", http.StatusConflict, w.Code)
+  }
+  if w := do("POST", "/teams/1/players", `{"name": "Suárez", "jersey_number": 9, "rating": 88, "team_id": "2"}`); w.Code != http.StatusBadRequest {
+    t.Errorf("Expected status %d for mismatched team_id, got %d", http.StatusBadRequest, w.Code)
+  }
+  if w := do("POST", "/teams/99/players", `{"name": "Suárez", "jersey_number": 9, "rating": 88}`); w.Code != http.StatusNotFound {
+    t.Errorf("Expected status %d for unknown team, got %d", http.StatusNotFound, w.Code)
+  }
+
+  w := do("GET", "/teams/"+miami.ID+"/players?sort=-rating", "")
+  var list struct {
+    Data []Player  `json:"data"`
+    Meta *PageMeta `json:"meta"`
+  }
+  if err := json.NewDecoder(w.Body).Decode(&list); err != nil {
+    t.Fatalf("Failed to decode response: %v", err)
+  }
+  if list.Meta == nil || list.Meta.Total != 1 || list.Data[0].Name != "Messi" || list.Data[0].TeamID != miami.ID {
+    t.Errorf("Expected only Messi on Inter Miami, got %+v", list.Data)
+  }
+  if w := do("GET", "/teams/99/players", ""); w.Code != http.StatusNotFound {
+    t.Errorf("Expected status %d for unknown team, got %d", http.StatusNotFound, w.Code)
+  }
+
+  // PUT replaces membership: keeping the team means sending it, and leaving
+  // team_id out releases the player
+  messi := list.Data[0]
+  var updated struct {
+    Data Player `json:"data"`
+  }
+  w = do("PUT", "/players/"+messi.ID, `{"name": "Messi", "jersey_number": 30, "rating": 98, "team_id": "`+miami.ID+`"}`)
+  json.NewDecoder(w.Body).Decode(&updated)
+  if w.Code != http.StatusOK || updated.Data.TeamID != miami.ID {
+    t.Errorf("Expected Messi to stay on Inter Miami, got %d %+v", w.Code, updated.Data)
+  }
+  w = do("PUT", "/players/"+messi.ID, `{"name": "Messi", "jersey_number": 30, "rating": 98}`)
+  updated.Data = Player{}
+  json.NewDecoder(w.Body).Decode(&updated)
+  if w.Code != http.StatusOK || updated.Data.TeamID != "" {
+    t.Errorf("Expected a PUT without team_id to release Messi, got %d %+v", w.Code, updated.Data)
+  }
+  w = do("PUT", "/players/"+messi.ID, `{"name": "Messi", "jersey_number": 30, "rating": 98, "team_id": "`+miami.ID+`"}`)
+  if w.Code != http.StatusOK {
+    t.Fatalf("Expected Messi to re-sign, got %d", w.Code)
+  }
+
+  if w := do("DELETE", "/teams/1", ""); w.Code != http.StatusConflict {
+    t.Errorf("Expected status %d without on_players, got %d", http.StatusConflict, w.Code)
+  }
+  if w := do("DELETE", "/teams/1?on_players=cascade", ""); w.Code != http.StatusOK {
+    t.Errorf("Expected status %d with cascade, got %d: %s", http.StatusOK, w.Code, w.Body.String())
+  }
+}
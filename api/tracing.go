@@ -0,0 +1,366 @@
+package main
+
+import (
+  "context"
+  "crypto/rand"
+  "encoding/hex"
+  "encoding/json"
+  "fmt"
+  "log/slog"
+  "net/http"
+  "os"
+  "strings"
+  "sync"
+  "time"
+)
+
+// W3C trace context headers, and the header that tells clients which trace
+// their request was recorded under
+const (
+  TraceparentHeader = "traceparent"
+  TracestateHeader  = "tracestate"
+  TraceIDHeader     = "X-Trace-ID"
+)
+
+// MaxTracestateLength caps the tracestate carried along; longer values are
+// dropped, as the spec allows
+const MaxTracestateLength = 512
+
+// Span kinds
+const (
+  SpanKindServer   = "server"
+  SpanKindInternal = "internal"
+)
+
+// Span statuses
+const (
+  SpanStatusOK    = "ok"
+  SpanStatusError = "error"
+)
+
+// SpanContext is the part of a span that crosses process boundaries
+type SpanContext struct {
+  TraceID string
+  SpanID  string
+  Sampled bool
+  // State is the vendor-specific tracestate, passed along unchanged
+  State string
+}
+
+// ParseTraceparent reads a traceparent header such as
+// "00-4bf92f3577b34da6a3ce929d0e0e4736-00f067aa0ba902b7-01"
+func ParseTraceparent(value string) (SpanContext, error) {
+  invalid := fmt.Errorf("%w: malformed traceparent %q", ErrInvalidInput, value)
+  if len(value) < 55 || value[2] != '-' || value[35] != '-' || value[52] != '-' {
+    return SpanContext{}, invalid
+  }
+  version, traceID, spanID, flags := value[:2], value[3:35], value[36:52], value[53:55]
+  if !isLowerHex(version) || version == "ff" || !isLowerHex(traceID) || !isLowerHex(spanID) || !isLowerHex(flags) {
+    return SpanContext{}, invalid
+  }
+  // Version 00 has exactly four fields; later versions may append more
+  if (version == "00" && len(value) != 55) || (len(value) > 55 && value[55] != '-') {
+    return SpanContext{}, invalid
+  }
+  if strings.Trim(traceID, "0") == "" || strings.Trim(spanID, "0") == "" {
+    return SpanContext{}, invalid
+  }
+  bits, _ := hex.DecodeString(flags)
+  return SpanContext{TraceID: traceID, SpanID: spanID, Sampled: bits[0]&1 == 1}, nil
+}
+
+// Traceparent formats the span context as a version 00 traceparent header
+func (sc SpanContext) Traceparent() string {
+  flags := "00"
+  if sc.Sampled {
+    flags = "01"
+  }
+  return "00-" + sc.TraceID + "-" + sc.SpanID + "-" + flags
+}
+
+func isLowerHex(s string) bool {
+  for i := 0; i < len(s); i++ {
+    if (s[i] < '0' || s[i] > '9') && (s[i] < 'a' || s[i] > 'f') {
+      return false
+    }
+  }
+  return true
+}
+
+// validTracestate reports whether a tracestate header is short and printable
+// enough to carry along
+func validTracestate(state string) bool {
+  if len(state) > MaxTracestateLength {
+    return false
+  }
+  for i := 0; i < len(state); i++ {
+    if state[i] < ' ' || state[i] > '~' {
+      return false
+    }
+  }
+  return true
+}
+
+// Span is a finished span, as handed to exporters
+type Span struct {
+  TraceID    string         `json:"trace_id"`
+  SpanID     string         `json:"span_id"`
+  ParentID   string         `json:"parent_id,omitempty"`
+  Name       string         `json:"name"`
+  Kind       string         `json:"kind"`
+  Start      time.Time      `json:"start"`
+  End        time.Time      `json:"end"`
+  Attributes map[string]any `json:"attributes,omitempty"`
+  Status     string         `json:"status"`
+  Error      string         `json:"error,omitempty"`
+  Tracestate string         `json:"tracestate,omitempty"`
+}
+
+// SpanExporter receives every sampled span when it ends
+type SpanExporter interface {
+  ExportSpan(span Span) error
+}
+
+// Tracer starts server spans for incoming requests. Child spans are started
+// with StartSpan and reach the same exporter through their parent.
+type Tracer struct {
+  // exporter is nil when spans only need IDs for propagation and logs
+  exporter SpanExporter
+
+  // now is replaced by tests
+  now func() time.Time
+}
+
+// NewTracer creates a tracer exporting to exporter, which may be nil
+func NewTracer(exporter SpanExporter) *Tracer {
+  return &Tracer{exporter: exporter, now: time.Now}
+}
+
+// ActiveSpan is a span that hasn't ended yet. A nil *ActiveSpan is a no-op,
+// so code can trace unconditionally.
+type ActiveSpan struct {
+  tracer  *Tracer
+  sampled bool
+
+  mu    sync.Mutex
+  span  Span
+  ended bool
+}
+
+type spanKey struct{}
+
+// Start begins a span. It continues the trace in parent when parent has a
+// trace ID, and starts a new sampled trace otherwise.
+func (t *Tracer) Start(ctx context.Context, name, kind string, parent SpanContext) (context.Context, *ActiveSpan) {
+  span := &ActiveSpan{
+    tracer:  t,
+    sampled: true,
+    span: Span{
+      TraceID: parent.TraceID,
+      SpanID:  newTraceID(8),
+      Name:    name,
+      Kind:    kind,
+      Start:   t.now(),
+      Status:  SpanStatusOK,
+    },
+  }
+  if parent.TraceID != "" {
+    span.span.ParentID = parent.SpanID
+    span.span.Tracestate = parent.State
+    span.sampled = parent.Sampled
+  } else {
+    span.span.TraceID = newTraceID(16)
+  }
+  return context.WithValue(ctx, spanKey{}, span), span
+}
+
+// StartSpan begins a child of the span in ctx. Without one it returns ctx and
+// a nil span, so untraced calls cost nothing.
+func StartSpan(ctx context.Context, name string) (context.Context, *ActiveSpan) {
+  parent := SpanFrom(ctx)
+  if parent == nil {
+    return ctx, nil
+  }
+  return parent.tracer.Start(ctx, name, SpanKindInternal, parent.Context())
+}
+
+// SpanFrom returns the active span carried by ctx, if any
+func SpanFrom(ctx context.Context) *ActiveSpan {
+  span, _ := ctx.Value(spanKey{}).(*ActiveSpan)
+  return span
+}
+
+// Context returns the span's IDs for propagation
+func (s *ActiveSpan) Context() SpanContext {
+  if s == nil {
+    return SpanContext{}
+  }
+  return SpanContext{TraceID: s.span.TraceID, SpanID: s.span.SpanID, Sampled: s.sampled, State: s.span.Tracestate}
+}
+
+// SetAttribute records a key/value pair on the span
+func (s *ActiveSpan) SetAttribute(key string, value any) {
+  if s == nil {
+    return
+  }
+  s.mu.Lock()
+  defer s.mu.Unlock()
+
+  if s.span.Attributes == nil {
+    s.span.Attributes = make(map[string]any)
+  }
+  s.span.Attributes[key] = value
+}
+
+// RecordError marks the span as failed
+func (s *ActiveSpan) RecordError(err error) {
+  if s == nil || err == nil {
+    return
+  }
+  s.mu.Lock()
+  defer s.mu.Unlock()
+
+  s.span.Status = SpanStatusError
+  s.span.Error = err.Error()
+}
+
+// End finishes the span and exports it if it is sampled. Only the first call
+// has any effect.
+func (s *ActiveSpan) End() {
+  if s == nil {
+    return
+  }
+  s.mu.Lock()
+  if s.ended {
+    s.mu.Unlock()
+    return
+  }
+  s.ended = true
+  s.span.End = s.tracer.now()
+  span := s.span
+  s.mu.Unlock()
+
+  if !s.sampled || s.tracer.exporter == nil {
+    return
+  }
+  if err := s.tracer.exporter.ExportSpan(span); err != nil {
+    slog.Warn("Error exporting span", LogKeyTraceID, span.TraceID, LogKeySpanID, span.SpanID, LogKeyError, err)
+  }
+}
+
+// Middleware starts a server span for every request, continuing the caller's
+// trace when it sends a valid traceparent. Spans are named after the router
+// pattern the request matches, such as "GET /players/{id}".
+func (t *Tracer) Middleware(router *http.ServeMux, next http.Handler) http.Handler {
+  return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+    parent, err := ParseTraceparent(r.Header.Get(TraceparentHeader))
+    if err == nil {
+      if state := r.Header.Get(TracestateHeader); validTracestate(state) {
+        parent.State = state
+      }
+    }
+    _, pattern := router.Handler(r)
+    name := pattern
+    if name == "" {
+      name = r.Method + " " + unmatchedRoute
+    }
+
+    ctx, span := t.Start(r.Context(), name, SpanKindServer, parent)
+    defer span.End()
+    span.SetAttribute("http.method", r.Method)
+    span.SetAttribute("http.route", pattern)
+    span.SetAttribute("url.path", r.URL.Path)
+    if id := RequestIDFrom(ctx); id != "" {
+      span.SetAttribute(LogKeyRequestID, id)
+    }
+    w.Header().Set(TraceIDHeader, span.Context().TraceID)
+
+    recorder := &responseRecorder{ResponseWriter: w, statusCode: http.StatusOK}
+    next.ServeHTTP(recorder, r.WithContext(ctx))
+    span.SetAttribute("http.status_code", recorder.statusCode)
+    if recorder.statusCode >= http.StatusInternalServerError {
+      span.RecordError(fmt.Errorf("status %d", recorder.statusCode))
+    }
+  })
+}
+
+// tracedHandler runs next in a "handler" child span, so the handler's time
+// is told apart from authentication and rate limiting. The server span
+// already carries the route.
+func tracedHandler(next http.Handler) http.Handler {
+  return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+    ctx, span := StartSpan(r.Context(), "handler")
+    defer span.End()
+
+    next.ServeHTTP(w, r.WithContext(ctx))
+  })
+}
+
+// newTraceID returns n random bytes in hex
+func newTraceID(n int) string {
+  b := make([]byte, n)
+  rand.Read(b)
+  return hex.EncodeToString(b)
+}
+
+// MemorySpanExporter keeps spans in memory, for tests
+type MemorySpanExporter struct {
+  mu    sync.Mutex
+  spans []Span
+}
+
+// NewMemorySpanExporter creates an empty in-memory exporter
+func NewMemorySpanExporter() *MemorySpanExporter {
+  return &MemorySpanExporter{}
+}
+
+// ExportSpan stores span
+func (e *MemorySpanExporter) ExportSpan(span Span) error {
+  e.mu.Lock()
+  defer e.mu.Unlock()
+
+  e.spans = append(e.spans, span)
+  return nil
+}
+
+// Spans returns the exported spans in the order they ended
+func (e *MemorySpanExporter) Spans() []Span {
+  e.mu.Lock()
+  defer e.mu.Unlock()
+
+  spans := make([]Span, len(e.spans))
+  copy(spans, e.spans)
+  return spans
+}
+
+// FileSpanExporter appends spans to a file as JSON lines
+type FileSpanExporter struct {
+  mu   sync.Mutex
+  file *os.File
+  enc  *json.Encoder
+}
+
+// OpenFileSpanExporter opens, or creates, a JSON-lines span file
+func OpenFileSpanExporter(path string) (*FileSpanExporter, error) {
+  file, err := os.OpenFile(path, os.O_CREATE|os.O_WRONLY|os.O_APPEND, 0o644)
+  if err != nil {
+    return nil, fmt.Errorf("opening span file: %w", err)
+  }
+  return &FileSpanExporter{file: file, enc: json.NewEncoder(file)}, nil
+}
+
+// ExportSpan writes span as one line
+func (e *FileSpanExporter) ExportSpan(span Span) error {
+  e.mu.Lock()
+  defer e.mu.Unlock()
+
+  return e.enc.Encode(span)
+}
+
+// Close closes the span file
+func (e *FileSpanExporter) Close() error {
+  e.mu.Lock()
+  defer e.mu.Unlock()
+
+  return e.file.Close()
+}
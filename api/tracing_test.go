@@ -0,0 +1,171 @@
+package main
+
+import (
+  "bufio"
+  "encoding/json"
+  "log/slog"
+  "net/http"
+  "net/http/httptest"
+  "os"
+  "path/filepath"
+  "strings"
+  "testing"
+)
+
+const (
+  testTraceID = "4bf92f3577b34da6a3ce929d0e0e4736"
+  testSpanID  = "00f067aa0ba902b7"
+)
+
+func TestParseTraceparent(t *testing.T) {
+  tests := []struct {
+    value   string
+    valid   bool
+    sampled bool
+  }{
+    {"00-" + testTraceID + "-" + testSpanID + "-01", true, true},
+    {"00-" + testTraceID + "-" + testSpanID + "-00", true, false},
+    {"00-" + testTraceID + "-" + testSpanID + "-03", true, true},
+    {"01-" + testTraceID + "-" + testSpanID + "-01-future", true, true},
+    {"00-" + testTraceID + "-" + testSpanID + "-01-extra", false, false},
+    {"01-" + testTraceID + "-" + testSpanID + "-01extra", false, false},
+    {"ff-" + testTraceID + "-" + testSpanID + "-01", false, false},
+    {"00-" + strings.ToUpper(testTraceID) + "-" + testSpanID + "-01", false, false},
+    {"00-00000000000000000000000000000000-" + testSpanID + "-01", false, false},
+    {"00-" + testTraceID + "-0000000000000000-01", false, false},
+    {"00-" + testTraceID + "-" + testSpanID, false, false},
+    {"", false, false},
+  }
+  for _, tt := range tests {
+    sc, err := ParseTraceparent(tt.value)
+    if !tt.valid {
+      if err == nil {
+        t.Errorf("%q: expected an error", tt.value)
+      }
+      continue
+    }
+    if err != nil || sc.TraceID != testTraceID || sc.SpanID != testSpanID || sc.Sampled != tt.sampled {
+      t.Errorf("%q: got %+v %v", tt.value, sc, err)
+    }
+  }
+
+  sc := SpanContext{TraceID: testTraceID, SpanID: testSpanID, Sampled: true}
+  if got := sc.Traceparent(); got != "00-"+testTraceID+"-"+testSpanID+"-01" {
+    t.Errorf("Unexpected traceparent %q", got)
+  }
+}
+
+func TestTracer_Middleware(t *testing.T) {
+  logs := captureLogs(t, slog.LevelInfo)
+  exporter := NewMemorySpanExporter()
+  tracer := NewTracer(exporter)
+  keys := NewAPIKeyStore()
+  writer, _ := keys.CreateAPIKey(APIKeyRequest{Name: "importer", Scopes: []string{ScopeWrite}})
+  router := newRouter(NewPlayerHandlerWithAuth(NewPlayerService(), nil, keys, nil), nil)
+  handler := RequestIDMiddleware(tracer.Middleware(router, router))
+  do := func(method, target, traceparent, body string) *httptest.ResponseRecorder {
+    req := httptest.NewRequest(method, target, strings.NewReader(body))
+    req.Header.Set("Authorization", "Bearer "+writer.Key)
+    if traceparent != "" {
+      req.Header.Set(TraceparentHeader, traceparent)
+      req.Header.Set(TracestateHeader, "vendor=abc")
+    }
+    w := httptest.NewRecorder()
+    handler.ServeHTTP(w, req)
+    return w
+  }
+
+  w := do("PUT", "/players/1", "00-"+testTraceID+"-"+testSpanID+"-01", `{"name": "Messi", "jersey_number": 10, "rating": 97}`)
+  if w.Code != http.StatusOK || w.Header().Get(TraceIDHeader) != testTraceID {
+    t.Fatalf("Expected the caller's trace, got %d %v", w.Code, w.Header())
+  }
+
+  // Spans end innermost first
+  spans := exporter.Spans()
+  names := make([]string, len(spans))
+  for i, span := range spans {
+    names[i] = span.Name
+    if span.TraceID != testTraceID || span.Tracestate != "vendor=abc" {
+      t.Errorf("Expected %s to continue the trace, got %+v", span.Name, span)
+    }
+  }
+  if strings.Join(names, ",") != "authenticate,PlayerService.write,handler,PUT /players/{id}" {
+    t.Fatalf("Unexpected spans %v", names)
+  }
+  auth, write, handlerSpan, server := spans[0], spans[1], spans[2], spans[3]
+  if server.ParentID != testSpanID || server.Kind != SpanKindServer || server.Attributes["http.status_code"] != 200 {
+    t.Errorf("Expected a server span under the caller's span, got %+v", server)
+  }
+  if auth.ParentID != server.SpanID || handlerSpan.ParentID != server.SpanID || write.ParentID != handlerSpan.SpanID {
+    t.Errorf("Expected auth and handler spans under the server span and the write under the handler, got %+v", spans)
+  }
+  if server.Attributes[LogKeyRequestID] != w.Header().Get(RequestIDHeader) {
+    t.Errorf("Expected the request ID on the server span, got %+v", server.Attributes)
+  }
+
+  // Log lines carry the trace and the span they were written in
+  lines := logLines(t, logs)
+  if lines[0][LogKeyTraceID] != testTraceID || lines[0][LogKeySpanID] != handlerSpan.SpanID {
+    t.Errorf("Expected the handler's log line to carry its span, got %v", lines[0])
+  }
+
+  // Failed writes mark their span, and error responses carry the trace ID
+  w = do("PUT", "/players/999", "", `{"name": "Nobody", "jersey_number": 1, "rating": 50}`)
+  var response Response
+  json.NewDecoder(w.Body).Decode(&response)
+  if w.Code != http.StatusNotFound || response.TraceID == "" || response.TraceID != w.Header().Get(TraceIDHeader) {
+    t.Errorf("Expected a 404 with a new trace ID, got %d %+v", w.Code, response)
+  }
+  spans = exporter.Spans()
+  if write := spans[5]; write.Name != "PlayerService.write" || write.Status != SpanStatusError || write.TraceID != response.TraceID {
+    t.Errorf("Expected a failed write span in the new trace, got %+v", write)
+  }
+
+  // Unsampled traces are propagated but not recorded
+  before := len(exporter.Spans())
+  w = do("GET", "/players/1", "00-"+testTraceID+"-"+testSpanID+"-00", "")
+  if w.Header().Get(TraceIDHeader) != testTraceID || len(exporter.Spans()) != before {
+    t.Errorf("Expected the unsampled trace to be kept but not exported")
+  }
+}
+
+func TestFileSpanExporter(t *testing.T) {
+  path := filepath.Join(t.TempDir(), "spans.jsonl")
+  exporter, err := OpenFileSpanExporter(path)
+  if err != nil {
+    t.Fatalf("Failed to open span file: %v", err)
+  }
+  tracer := NewTracer(exporter)
+  ctx, root := tracer.Start(t.Context(), "root", SpanKindServer, SpanContext{})
+  _, child := StartSpan(ctx, "child")
+  child.SetAttribute("players", 3)
+  child.End()
+  child.End()
+  root.End()
+  if err := exporter.Close(); err != nil {
+    t.Fatalf("Failed to close span file: %v", err)
+  }
+
+  file, _ := os.Open(path)
+  defer file.Close()
+  var spans []Span
+  scanner := bufio.NewScanner(file)
+  for scanner.Scan() {
+    var span Span
+    if err := json.Unmarshal(scanner.Bytes(), &span); err != nil {
+      t.Fatalf("Expected a JSON line, got %q", scanner.Text())
+    }
+    spans = append(spans, span)
+  }
+  if len(spans) != 2 || spans[0].Name != "child" || spans[0].ParentID != spans[1].SpanID || spans[0].Attributes["players"] != float64(3) {
+    t.Errorf("Unexpected spans %+v", spans)
+  }
+  if len(spans[1].TraceID) != 32 || len(spans[1].SpanID) != 16 || spans[1].ParentID != "" {
+    t.Errorf("Expected a new root span, got %+v", spans[1])
+  }
+
+  // Without a span in the context nothing is traced
+  if ctx, span := StartSpan(t.Context(), "orphan"); span != nil || SpanFrom(ctx) != nil {
+    t.Errorf("Expected no span without a parent")
+  }
+}
@@ -0,0 +1,98 @@
+package main
+
+import (
+  "cmp"
+  "context"
+  "log/slog"
+  "slices"
+  "time"
+)
+
+// Trash defaults
+const (
+  DefaultTrashRetention = 30 * 24 * time.Hour
+  DefaultPurgeInterval  = time.Hour
+  DefaultTrashLimit     = 100
+  MaxTrashLimit         = 1000
+)
+
+// PurgerActor is the actor recorded for purges made by the background purger
+const PurgerActor = "purger"
+
+// DeletedPlayer is a player in the trash. Deleted players are hidden from
+// every read, and don't hold their name or jersey number, until they are
+// restored or purged.
+type DeletedPlayer struct {
+  Player
+  DeletedAt time.Time `json:"deleted_at"`
+  DeletedBy string    `json:"deleted_by"`
+}
+
+// TrashedPlayers returns up to limit players in the trash, most recently
+// deleted first, and the number of players in the trash
+func (s *PlayerService) TrashedPlayers(limit int) ([]DeletedPlayer, int) {
+  s.mu.RLock()
+  defer s.mu.RUnlock()
+
+  players := make([]DeletedPlayer, 0)
+  s.store.RangeDeleted(func(player DeletedPlayer) bool {
+    players = append(players, player)
+    return true
+  })
+  slices.SortFunc(players, func(a, b DeletedPlayer) int {
+    return cmp.Or(b.DeletedAt.Compare(a.DeletedAt), compareIDs(b.ID, a.ID))
+  })
+  return players[:min(limit, len(players))], len(players)
+}
+
+// RestorePlayer brings a player back from the trash. It fails with
+// ErrPlayerExists if another player has taken its name and jersey number, or
+// its jersey number on its team, in the meantime.
+func (s *PlayerService) RestorePlayer(ctx context.Context, id string) (Player, error) {
+  return s.write(ctx, func(tx *playerTx) (Player, error) {
+    return tx.restore(id)
+  })
+}
+
+// PurgeTrash permanently removes players deleted before cutoff and returns them
+func (s *PlayerService) PurgeTrash(ctx context.Context, cutoff time.Time) ([]DeletedPlayer, error) {
+  return writeTx(ctx, s, func(tx *playerTx) ([]DeletedPlayer, error) {
+    purged := make([]DeletedPlayer, 0)
+    tx.s.store.RangeDeleted(func(player DeletedPlayer) bool {
+      if player.DeletedAt.Before(cutoff) {
+        purged = append(purged, player)
+      }
+      return true
+    })
+    slices.SortFunc(purged, func(a, b DeletedPlayer) int {
+      return cmp.Or(a.DeletedAt.Compare(b.DeletedAt), compareIDs(a.ID, b.ID))
+    })
+    for _, player := range purged {
+      tx.purge(player)
+    }
+    return purged, nil
+  })
+}
+
+// RunPurger purges players that have been in the trash for longer than
+// retention, once at start and then every interval, until ctx is done
+func RunPurger(ctx context.Context, s *PlayerService, retention, interval time.Duration) {
+  ticker := time.NewTicker(interval)
+  defer ticker.Stop()
+
+  for {
+    cutoff := s.now().Add(-retention)
+    purged, err := s.PurgeTrash(WithActor(ctx, PurgerActor), cutoff)
+    if err != nil {
+      slog.Error("Error purging trash", LogKeyError, err)
+    } else if len(purged) > 0 {
+      slog.Info("Purged deleted players", "players", len(purged), "deleted_before", cutoff.Format(time.RFC3339))
+    }
+
+    select {
+    case <-ctx.Done():
+      return
+    case <-ticker.C:
+    }
+  }
+}
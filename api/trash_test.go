@@ -0,0 +1,245 @@
+package main
+
+import (
+  "context"
+  "encoding/json"
+  "errors"
+  "net/http"
+  "net/http/httptest"
+  "testing"
+  "time"
+)
+
+func TestPlayerService_SoftDelete(t *testing.T) {
+  service := NewPlayerService()
+  fakeClock(service, time.Date(2025, 3, 3, 9, 0, 0, 0, time.UTC))
+
+  if _, err := service.DeletePlayer(WithActor(t.Context(), "alice"), "1", AnyVersion); err != nil {
+    t.Fatalf("Failed to delete player: %v", err)
+  }
+  if service.PlayerExists("1") {
+    t.Errorf("Expected the deleted player to be hidden")
+  }
+  if page := service.QueryPlayers(PlayerQuery{Limit: 10}); page.Total != 2 {
+    t.Errorf("Expected 2 players to be listed, got %d", page.Total)
+  }
+  if _, err := service.DeletePlayer(t.Context(), "1", AnyVersion); !errors.Is(err, ErrPlayerNotFound) {
+    t.Errorf("Expected deleting twice to fail with ErrPlayerNotFound, got %v", err)
+  }
+
+  trash, total := service.TrashedPlayers(10)
+  if total != 1 || trash[0].ID != "1" || trash[0].Name != "Messi" || trash[0].DeletedBy != "alice" ||
+    !trash[0].DeletedAt.Equal(time.Date(2025, 3, 3, 9, 0, 0, 0, time.UTC)) {
+    t.Fatalf("Expected Messi in the trash, got %+v", trash)
+  }
+
+  player, err := service.RestorePlayer(t.Context(), "1")
+  if err != nil {
+    t.Fatalf("Failed to restore player: %v", err)
+  }
+  if player.Name != "Messi" || player.Version != 2 || !service.PlayerExists("1") {
+    t.Errorf("Expected Messi back at version 2, got %+v", player)
+  }
+  if _, total := service.TrashedPlayers(10); total != 0 {
+    t.Errorf("Expected an empty trash, got %d players", total)
+  }
+  if _, err := service.RestorePlayer(t.Context(), "1"); !errors.Is(err, ErrPlayerNotFound) {
+    t.Errorf("Expected restoring a live player to fail with ErrPlayerNotFound, got %v", err)
+  }
+  if len(service.SuggestPlayers("Mes", 10)) != 1 {
+    t.Errorf("Expected the restored player to be indexed again")
+  }
+}
+
+func TestPlayerService_RestoreUniqueness(t *testing.T) {
+  service, miami, _ := newServiceWithTeams(t)
+
+  // A deleted player's name and jersey number are free for others to take
+  if _, err := service.DeletePlayer(t.Context(), "1", AnyVersion); err != nil {
+    t.Fatalf("Failed to delete player: %v", err)
+  }
+  impostor, err := service.CreatePlayer(t.Context(), PlayerRequest{Name: "Messi", JerseyNumber: 10, Rating: 70})
+  if err != nil {
+    t.Fatalf("Expected the name and jersey number to be free, got %v", err)
+  }
+  if _, err := service.RestorePlayer(t.Context(), "1"); !errors.Is(err, ErrPlayerExists) {
+    t.Fatalf("Expected ErrPlayerExists while the slot is taken, got %v", err)
+  }
+  if _, err := service.DeletePlayer(t.Context(), impostor.ID, AnyVersion); err != nil {
+    t.Fatalf("Failed to delete player: %v", err)
+  }
+  if _, err := service.RestorePlayer(t.Context(), "1"); err != nil {
+    t.Fatalf("Failed to restore player: %v", err)
+  }
+
+  // Players deleted with their team come back as free agents
+  setTeam := PlayerRequest{Name: "Ronaldo", JerseyNumber: 7, Rating: 98, TeamID: miami.ID}
+  if _, err := service.UpdatePlayer(t.Context(), "2", setTeam, AnyVersion); err != nil {
+    t.Fatalf("Failed to sign player: %v", err)
+  }
+  deletion, err := service.DeleteTeam(t.Context(), miami.ID, AnyVersion, TeamDeleteOptions{OnPlayers: TeamCascade})
+  if err != nil || len(deletion.DeletedPlayers) != 1 {
+    t.Fatalf("Failed to delete team: %v", err)
+  }
+  player, err := service.RestorePlayer(t.Context(), "2")
+  if err != nil {
+    t.Fatalf("Failed to restore player: %v", err)
+  }
+  if player.TeamID != "" {
+    t.Errorf("Expected the player to be released, got team %s", player.TeamID)
+  }
+}
+
+func TestPlayerService_PurgeTrash(t *testing.T) {
+  service := NewPlayerService()
+  now := fakeClock(service, time.Date(2025, 3, 1, 0, 0, 0, 0, time.UTC))
+
+  setRating(t, service, "alice", "1", 97)
+  setRating(t, service, "alice", "2", 90)
+  for _, id := range []string{"1", "2"} {
+    if _, err := service.DeletePlayer(t.Context(), id, AnyVersion); err != nil {
+      t.Fatalf("Failed to delete player: %v", err)
+    }
+    *now = now.Add(48 * time.Hour)
+  }
+
+  // Only Messi has been in the trash for three days or more
+  ctx, cancel := context.WithCancel(t.Context())
+  cancel()
+  RunPurger(ctx, service, 72*time.Hour, time.Hour)
+
+  trash, _ := service.TrashedPlayers(10)
+  if len(trash) != 1 || trash[0].ID != "2" {
+    t.Fatalf("Expected only Ronaldo left in the trash, got %+v", trash)
+  }
+  if _, err := service.RestorePlayer(t.Context(), "1"); !errors.Is(err, ErrPlayerNotFound) {
+    t.Errorf("Expected a purged player to be gone, got %v", err)
+  }
+  if history := service.store.History("1"); len(history) != 0 {
+    t.Errorf("Expected Messi's rating history to be purged, got %+v", history)
+  }
+  if history := service.store.History("2"); len(history) == 0 {
+    t.Errorf("Expected Ronaldo's rating history to be kept while he is in the trash")
+  }
+
+  page := auditLog(t, service, AuditQuery{Action: AuditPurge, Limit: 10})
+  if page.Total != 1 || page.Entries[0].ResourceID != "1" || page.Entries[0].Actor != PurgerActor {
+    t.Errorf("Expected the purge to be audited, got %+v", page.Entries)
+  }
+
+  // A new player never gets a purged player's ID
+  player, err := service.CreatePlayer(t.Context(), PlayerRequest{Name: "Pedri", JerseyNumber: 8, Rating: 86})
+  if err != nil || player.ID != "4" {
+    t.Errorf("Expected ID 4, got %+v %v", player, err)
+  }
+}
+
+func TestFileStore_PersistsTrash(t *testing.T) {
+  dir := t.TempDir()
+  for _, reopened := range []bool{false, true} {
+    store := openTestFileStore(t, dir, 0)
+    service := NewPlayerServiceWithStore(store)
+    if !reopened {
+      for _, name := range []string{"Pedri", "Gavi", "Xavi"} {
+        if _, err := service.CreatePlayer(t.Context(), PlayerRequest{Name: name, JerseyNumber: 8, Rating: 85}); err != nil {
+          t.Fatalf("Failed to create player: %v", err)
+        }
+      }
+      for _, id := range []string{"1", "2", "3"} {
+        if _, err := service.DeletePlayer(t.Context(), id, AnyVersion); err != nil {
+          t.Fatalf("Failed to delete player: %v", err)
+        }
+      }
+      if _, err := service.RestorePlayer(t.Context(), "2"); err != nil {
+        t.Fatalf("Failed to restore player: %v", err)
+      }
+      if _, err := service.PurgeTrash(t.Context(), time.Now().Add(time.Hour)); err != nil {
+        t.Fatalf("Failed to purge trash: %v", err)
+      }
+      if _, err := service.DeletePlayer(t.Context(), "2", AnyVersion); err != nil {
+        t.Fatalf("Failed to delete player: %v", err)
+      }
+      if err := store.Snapshot(); err != nil {
+        t.Fatalf("Failed to snapshot: %v", err)
+      }
+    }
+
+    // The trash looks the same before and after a restart
+    trash, _ := service.TrashedPlayers(10)
+    if len(trash) != 1 || trash[0].ID != "2" || trash[0].Name != "Gavi" {
+      t.Errorf("Expected only Gavi in the trash, got %+v", trash)
+    }
+    if service.PlayerExists("2") {
+      t.Errorf("Expected Gavi to stay deleted")
+    }
+
+    // Purged players' rating history is gone too, but its numbering isn't reused
+    if len(store.History("1")) != 0 || len(store.History("3")) != 0 || len(store.History("2")) != 1 || store.RatingSeq() != 3 {
+      t.Errorf("Expected only Gavi's history up to change 3, got %v %v %v (seq %d)",
+        store.History("1"), store.History("2"), store.History("3"), store.RatingSeq())
+    }
+    if err := service.Close(); err != nil {
+      t.Fatalf("Failed to close service: %v", err)
+    }
+  }
+}
+
+func TestPlayerHandler_Trash(t *testing.T) {
+  service := NewPlayerService()
+  handler := NewPlayerHandler(service)
+
+  mux := http.NewServeMux()
+  mux.HandleFunc("GET /players/{id}", handler.GetPlayer)
+  mux.HandleFunc("POST /players", handler.CreatePlayer)
+  mux.HandleFunc("DELETE /players/{id}", handler.DeletePlayer)
+  mux.HandleFunc("POST /players/{id}/restore", handler.RestorePlayer)
+  mux.HandleFunc("GET /trash", handler.GetTrash)
+
+  do := func(method, target string) *httptest.ResponseRecorder {
+    w := httptest.NewRecorder()
+    mux.ServeHTTP(w, httptest.NewRequest(method, target, nil))
+    return w
+  }
+
+  now := fakeClock(service, time.Date(2025, 3, 3, 9, 0, 0, 0, time.UTC))
+  for _, id := range []string{"3", "1"} {
+    if w := do("DELETE", "/players/"+id); w.Code != http.StatusOK {
+      t.Fatalf("Expected status 200, got %d", w.Code)
+    }
+    *now = now.Add(time.Minute)
+  }
+  if w := do("GET", "/players/1"); w.Code != http.StatusNotFound {
+    t.Errorf("Expected a deleted player to be 404, got %d", w.Code)
+  }
+
+  w := do("GET", "/trash?limit=1")
+  var response struct {
+    Data []DeletedPlayer `json:"data"`
+    Meta PageMeta        `json:"meta"`
+  }
+  if err := json.NewDecoder(w.Body).Decode(&response); err != nil {
+    t.Fatalf("Failed to decode response: %v", err)
+  }
+  if response.Meta.Total != 2 || len(response.Data) != 1 || response.Data[0].ID != "1" || response.Data[0].DeletedBy != AnonymousActor {
+    t.Errorf("Expected the most recently deleted player first, got %+v", response)
+  }
+  if w := do("GET", "/trash?limit=0"); w.Code != http.StatusBadRequest {
+    t.Errorf("Expected status 400, got %d", w.Code)
+  }
+
+  w = do("POST", "/players/1/restore")
+  if w.Code != http.StatusOK || w.Header().Get("ETag") != `"2"` {
+    t.Errorf("Expected 200 with ETag \"2\", got %d %q", w.Code, w.Header().Get("ETag"))
+  }
+  if w := do("POST", "/players/1/restore"); w.Code != http.StatusNotFound {
+    t.Errorf("Expected restoring twice to be 404, got %d", w.Code)
+  }
+
+  // Neymar's name and jersey number were taken while he was in the trash
+  if _, err := service.CreatePlayer(t.Context(), PlayerRequest{Name: "Neymar", JerseyNumber: 10, Rating: 80}); err != nil {
+    t.Fatalf("Failed to create player: %v", err)
+  }
+  if w := do("POST", "/players/3/restore"); w.Code != http.StatusConflict {
+    t.Errorf("Expected status 409, got %d", w.Code)
+  }
+}
@@ -0,0 +1,567 @@
+package main
+
+import (
+  "context"
+  "fmt"
+  "log/slog"
+  "slices"
+  "strconv"
+  "time"
+)
+
+// playerTx stages player, trash, team and match changes while PlayerService holds its write lock.
+// Reads through the transaction see its own staged changes, and the indexes
+// are updated as it goes so uniqueness checks account for earlier steps.
+// Nothing reaches the store until commit; rollback undoes the index changes.
+// Rating changes and audit entries are recorded against the actor and
+// request ID found in the context, and change events against its span.
+type playerTx struct {
+  s             *PlayerService
+  staged        map[string]*Player
+  stagedTrash   map[string]*DeletedPlayer
+  counter       int
+  stagedTeams   map[string]*Team
+  teamCounter   int
+  stagedMatches map[string]*Match
+  matchCounter  int
+  ratings       []RatingChange
+  audit         []AuditEntry
+  actor         string
+  onBehalfOf    string
+  requestID     string
+  trace         SpanContext
+  now           time.Time
+  undo          []func()
+}
+
+// begin starts a transaction. The caller must hold the write lock.
+func (s *PlayerService) begin(ctx context.Context) *playerTx {
+  return &playerTx{
+    s:             s,
+    actor:         ActorFrom(ctx),
+    onBehalfOf:    OnBehalfOfFrom(ctx),
+    requestID:     RequestIDFrom(ctx),
+    trace:         SpanFrom(ctx).Context(),
+    now:           s.now().UTC(),
+    staged:        make(map[string]*Player),
+    stagedTrash:   make(map[string]*DeletedPlayer),
+    counter:       s.store.IDCounter(),
+    stagedTeams:   make(map[string]*Team),
+    teamCounter:   s.store.TeamIDCounter(),
+    stagedMatches: make(map[string]*Match),
+    matchCounter:  s.store.MatchIDCounter(),
+  }
+}
+
+// get returns a player as seen by the transaction
+func (tx *playerTx) get(id string) (Player, bool) {
+  if player, staged := tx.staged[id]; staged {
+    if player == nil {
+      return Player{}, false
+    }
+    return *player, true
+  }
+  return tx.s.store.Get(id)
+}
+
+// put stages a new or updated player and re-indexes it
+func (tx *playerTx) put(old *Player, player Player) {
+  ix := tx.s.indexes
+  if old != nil {
+    previous := *old
+    ix.replace(previous, player)
+    tx.undo = append(tx.undo, func() { ix.replace(player, previous) })
+  } else {
+    ix.add(player)
+    tx.undo = append(tx.undo, func() { ix.remove(player) })
+  }
+  tx.staged[player.ID] = &player
+  if old != nil {
+    tx.record(AuditUpdate, ResourcePlayer, player.ID, *old, player)
+  } else {
+    tx.record(AuditCreate, ResourcePlayer, player.ID, nil, player)
+  }
+
+  if old == nil || old.Rating != player.Rating {
+    change := RatingChange{PlayerID: player.ID, At: tx.now, To: player.Rating, Actor: tx.actor}
+    if old != nil {
+      change.From = old.Rating
+    }
+    tx.ratings = append(tx.ratings, change)
+  }
+}
+
+// remove stages moving a player to the trash and drops it from the indexes
+func (tx *playerTx) remove(player Player) {
+  ix := tx.s.indexes
+  ix.remove(player)
+  tx.undo = append(tx.undo, func() { ix.add(player) })
+  tx.staged[player.ID] = nil
+  tx.stagedTrash[player.ID] = &DeletedPlayer{Player: player, DeletedAt: tx.now, DeletedBy: tx.actor}
+  tx.record(AuditDelete, ResourcePlayer, player.ID, player, nil)
+}
+
+// getDeleted returns a player in the trash as seen by the transaction
+func (tx *playerTx) getDeleted(id string) (DeletedPlayer, bool) {
+  if deleted, staged := tx.stagedTrash[id]; staged {
+    if deleted == nil {
+      return DeletedPlayer{}, false
+    }
+    return *deleted, true
+  }
+  return tx.s.store.GetDeleted(id)
+}
+
+// restore stages bringing a player back from the trash. A player whose team
+// has been deleted since comes back without a team.
+func (tx *playerTx) restore(id string) (Player, error) {
+  deleted, exists := tx.getDeleted(id)
+  if !exists {
+    return Player{}, fmt.Errorf("%w: player %s is not in the trash", ErrPlayerNotFound, id)
+  }
+  req := deleted.ToRequest()
+  if _, exists := tx.getTeam(req.TeamID); req.TeamID != "" && !exists {
+    req.TeamID = ""
+  }
+  if err := tx.checkUnique(id, req); err != nil {
+    return Player{}, err
+  }
+
+  player := deleted.Player
+  player.Update(req)
+  ix := tx.s.indexes
+  ix.add(player)
+  tx.undo = append(tx.undo, func() { ix.remove(player) })
+  tx.staged[id] = &player
+  tx.stagedTrash[id] = nil
+  tx.record(AuditRestore, ResourcePlayer, id, deleted, player)
+  return player, nil
+}
+
+// purge stages removing a player from the trash for good
+func (tx *playerTx) purge(deleted DeletedPlayer) {
+  tx.stagedTrash[deleted.ID] = nil
+  tx.record(AuditPurge, ResourcePlayer, deleted.ID, deleted, nil)
+}
+
+// checkUnique makes sure no other player holds the request's jersey number
+// on its team, or for players without a team its name and jersey number
+func (tx *playerTx) checkUnique(id string, req PlayerRequest) error {
+  holder, taken := tx.s.indexes.holderOf(req)
+  if !taken || holder == id {
+    return nil
+  }
+  if req.TeamID != "" {
+    return fmt.Errorf("%w: jersey number %d is already taken on team %s by player %s",
+      ErrPlayerExists, req.JerseyNumber, req.TeamID, holder)
+  }
+  if id == "" {
+    return fmt.Errorf("%w: player with name %s and jersey number %d already exists",
+      ErrPlayerExists, req.Name, req.JerseyNumber)
+  }
+  return fmt.Errorf("%w: another player with name %s and jersey number %d already exists",
+    ErrPlayerExists, req.Name, req.JerseyNumber)
+}
+
+// checkTeam makes sure the team a player is signed to exists
+func (tx *playerTx) checkTeam(req PlayerRequest) error {
+  if req.TeamID == "" {
+    return nil
+  }
+  if _, exists := tx.getTeam(req.TeamID); !exists {
+    return fmt.Errorf("%w: team %s does not exist", ErrInvalidInput, req.TeamID)
+  }
+  return nil
+}
+
+// existing returns a player that must exist at the given version
+func (tx *playerTx) existing(id string, version int64) (Player, error) {
+  player, exists := tx.get(id)
+  if !exists {
+    return Player{}, fmt.Errorf("%w: %s", ErrPlayerNotFound, id)
+  }
+  if err := checkVersion(player, version); err != nil {
+    return Player{}, err
+  }
+  return player, nil
+}
+
+// create stages a new player
+func (tx *playerTx) create(req PlayerRequest) (Player, error) {
+  if err := req.Validate(); err != nil {
+    return Player{}, err
+  }
+  if err := tx.checkTeam(req); err != nil {
+    return Player{}, err
+  }
+  if err := tx.checkUnique("", req); err != nil {
+    return Player{}, err
+  }
+
+  // The counter is persisted together with the player so IDs are never reused
+  tx.counter++
+  player := req.ToPlayer(strconv.Itoa(tx.counter))
+  tx.put(nil, player)
+  return player, nil
+}
+
+// update stages new values for an existing player
+func (tx *playerTx) update(id string, req PlayerRequest, version int64) (Player, error) {
+  if err := req.Validate(); err != nil {
+    return Player{}, err
+  }
+  player, err := tx.existing(id, version)
+  if err != nil {
+    return Player{}, err
+  }
+  return tx.apply(player, req)
+}
+
+// patch stages the result of applying patch to an existing player
+func (tx *playerTx) patch(id string, version int64, patch func(PlayerRequest) (PlayerRequest, error)) (Player, error) {
+  player, err := tx.existing(id, version)
+  if err != nil {
+    return Player{}, err
+  }
+  req, err := patch(player.ToRequest())
+  if err != nil {
+    return Player{}, err
+  }
+  if err := req.Validate(); err != nil {
+    return Player{}, err
+  }
+  return tx.apply(player, req)
+}
+
+// apply checks the team and uniqueness and stages the updated player
+func (tx *playerTx) apply(player Player, req PlayerRequest) (Player, error) {
+  if err := tx.checkTeam(req); err != nil {
+    return Player{}, err
+  }
+  if err := tx.checkUnique(player.ID, req); err != nil {
+    return Player{}, err
+  }
+  old := player
+  player.Update(req)
+  tx.put(&old, player)
+  return player, nil
+}
+
+// delete stages the removal of an existing player
+func (tx *playerTx) delete(id string, version int64) (Player, error) {
+  player, err := tx.existing(id, version)
+  if err != nil {
+    return Player{}, err
+  }
+  tx.remove(player)
+  return player, nil
+}
+
+// getTeam returns a team as seen by the transaction
+func (tx *playerTx) getTeam(id string) (Team, bool) {
+  if team, staged := tx.stagedTeams[id]; staged {
+    if team == nil {
+      return Team{}, false
+    }
+    return *team, true
+  }
+  return tx.s.store.GetTeam(id)
+}
+
+// putTeam stages a new or updated team and re-indexes it
+func (tx *playerTx) putTeam(old *Team, team Team) {
+  ix := tx.s.indexes
+  if old != nil {
+    previous := *old
+    ix.removeTeam(previous)
+    tx.undo = append(tx.undo, func() { ix.addTeam(previous) })
+  }
+  ix.addTeam(team)
+  tx.undo = append(tx.undo, func() { ix.removeTeam(team) })
+  tx.stagedTeams[team.ID] = &team
+  if old != nil {
+    tx.record(AuditUpdate, ResourceTeam, team.ID, *old, team)
+  } else {
+    tx.record(AuditCreate, ResourceTeam, team.ID, nil, team)
+  }
+}
+
+// removeTeam stages a team deletion and drops it from the indexes
+func (tx *playerTx) removeTeam(team Team) {
+  ix := tx.s.indexes
+  ix.removeTeam(team)
+  tx.undo = append(tx.undo, func() { ix.addTeam(team) })
+  tx.stagedTeams[team.ID] = nil
+  tx.record(AuditDelete, ResourceTeam, team.ID, team, nil)
+}
+
+// checkTeamName makes sure no other team uses the name
+func (tx *playerTx) checkTeamName(id string, req TeamRequest) error {
+  if holder, taken := tx.s.indexes.teamNamedAs(req.Name); taken && holder != id {
+    return fmt.Errorf("%w: team %s is already called %s", ErrTeamExists, holder, req.Name)
+  }
+  return nil
+}
+
+// existingTeam returns a team that must exist at the given version
+func (tx *playerTx) existingTeam(id string, version int64) (Team, error) {
+  team, exists := tx.getTeam(id)
+  if !exists {
+    return Team{}, fmt.Errorf("%w: %s", ErrTeamNotFound, id)
+  }
+  if version != AnyVersion && team.Version != version {
+    return Team{}, fmt.Errorf("%w: team %s is at version %d, not %d",
+      ErrVersionMismatch, team.ID, team.Version, version)
+  }
+  return team, nil
+}
+
+// createTeam stages a new team
+func (tx *playerTx) createTeam(req TeamRequest) (Team, error) {
+  if err := req.Validate(); err != nil {
+    return Team{}, err
+  }
+  if err := tx.checkTeamName("", req); err != nil {
+    return Team{}, err
+  }
+
+  tx.teamCounter++
+  team := req.ToTeam(strconv.Itoa(tx.teamCounter))
+  tx.putTeam(nil, team)
+  return team, nil
+}
+
+// updateTeam stages new values for an existing team
+func (tx *playerTx) updateTeam(id string, req TeamRequest, version int64) (Team, error) {
+  if err := req.Validate(); err != nil {
+    return Team{}, err
+  }
+  team, err := tx.existingTeam(id, version)
+  if err != nil {
+    return Team{}, err
+  }
+  if err := tx.checkTeamName(id, req); err != nil {
+    return Team{}, err
+  }
+  old := team
+  team.Update(req)
+  tx.putTeam(&old, team)
+  return team, nil
+}
+
+// deleteTeam stages the removal of a team. Players still on the team are
+// deleted or moved according to opts; without a choice the delete fails.
+func (tx *playerTx) deleteTeam(id string, version int64, opts TeamDeleteOptions) (TeamDeletion, error) {
+  team, err := tx.existingTeam(id, version)
+  if err != nil {
+    return TeamDeletion{}, err
+  }
+  if opts.ReassignTo == id {
+    return TeamDeletion{}, fmt.Errorf("%w: cannot reassign players to the team being deleted", ErrInvalidInput)
+  }
+
+  ids := tx.s.indexes.teamPlayerIDs(id)
+  slices.SortFunc(ids, compareIDs)
+  deletion := TeamDeletion{Team: team}
+
+  if len(ids) > 0 {
+    switch opts.OnPlayers {
+    case TeamCascade:
+      for _, playerID := range ids {
+        player, _ := tx.get(playerID)
+        tx.remove(player)
+      }
+      deletion.DeletedPlayers = ids
+    case TeamReassign:
+      for _, playerID := range ids {
+        player, _ := tx.get(playerID)
+        req := player.ToRequest()
+        req.TeamID = opts.ReassignTo
+        if _, err := tx.apply(player, req); err != nil {
+          return TeamDeletion{}, fmt.Errorf("cannot reassign player %s: %w", playerID, err)
+        }
+      }
+      deletion.ReassignedPlayers = ids
+    default:
+      return TeamDeletion{}, fmt.Errorf("%w: team %s has %d players, choose on_players=%s or %s",
+        ErrTeamHasPlayers, id, len(ids), TeamCascade, TeamReassign)
+    }
+  }
+
+  tx.removeTeam(team)
+  return deletion, nil
+}
+
+// getMatch returns a match as seen by the transaction
+func (tx *playerTx) getMatch(id string) (Match, bool) {
+  if match, staged := tx.stagedMatches[id]; staged {
+    if match == nil {
+      return Match{}, false
+    }
+    return *match, true
+  }
+  return tx.s.store.GetMatch(id)
+}
+
+// putMatch stages a new or updated match and re-indexes it
+func (tx *playerTx) putMatch(old *Match, match Match) {
+  ix := tx.s.indexes
+  if old != nil {
+    previous := *old
+    ix.removeMatch(previous)
+    tx.undo = append(tx.undo, func() { ix.addMatch(previous) })
+  }
+  ix.addMatch(match)
+  tx.undo = append(tx.undo, func() { ix.removeMatch(match) })
+  tx.stagedMatches[match.ID] = &match
+  if old != nil {
+    tx.record(AuditUpdate, ResourceMatch, match.ID, *old, match)
+  } else {
+    tx.record(AuditCreate, ResourceMatch, match.ID, nil, match)
+  }
+}
+
+// removeMatch stages a match deletion and drops it from the indexes
+func (tx *playerTx) removeMatch(match Match) {
+  ix := tx.s.indexes
+  ix.removeMatch(match)
+  tx.undo = append(tx.undo, func() { ix.addMatch(match) })
+  tx.stagedMatches[match.ID] = nil
+  tx.record(AuditDelete, ResourceMatch, match.ID, match, nil)
+}
+
+// record stages an audit entry for a change. before is nil for creates and
+// after for deletes.
+func (tx *playerTx) record(action, resource, id string, before, after any) {
+  entry := AuditEntry{
+    At:         tx.now,
+    Actor:      tx.actor,
+    OnBehalfOf: tx.onBehalfOf,
+    RequestID:  tx.requestID,
+    Action:     action,
+    Resource:   resource,
+    ResourceID: id,
+  }
+  if before != nil {
+    entry.Before = auditSnapshot(before)
+  }
+  if after != nil {
+    entry.After = auditSnapshot(after)
+  }
+  tx.audit = append(tx.audit, entry)
+}
+
+// commit writes all staged changes to the store in a single batch
+func (tx *playerTx) commit() error {
+  if len(tx.staged) == 0 && len(tx.stagedTrash) == 0 && len(tx.stagedTeams) == 0 && len(tx.stagedMatches) == 0 && len(tx.ratings) == 0 {
+    return nil
+  }
+
+  batch := StoreBatch{}
+  if tx.counter > tx.s.store.IDCounter() {
+    batch.IDCounter = tx.counter
+  }
+  if tx.teamCounter > tx.s.store.TeamIDCounter() {
+    batch.TeamIDCounter = tx.teamCounter
+  }
+  if tx.matchCounter > tx.s.store.MatchIDCounter() {
+    batch.MatchIDCounter = tx.matchCounter
+  }
+
+  ids := make([]string, 0, len(tx.staged))
+  for id := range tx.staged {
+    ids = append(ids, id)
+  }
+  slices.SortFunc(ids, compareIDs)
+  for _, id := range ids {
+    // Removed players are moved to the trash below
+    if player := tx.staged[id]; player != nil {
+      batch.Put = append(batch.Put, *player)
+    }
+  }
+
+  trashIDs := make([]string, 0, len(tx.stagedTrash))
+  for id := range tx.stagedTrash {
+    trashIDs = append(trashIDs, id)
+  }
+  slices.SortFunc(trashIDs, compareIDs)
+  for _, id := range trashIDs {
+    if deleted := tx.stagedTrash[id]; deleted != nil {
+      batch.Trash = append(batch.Trash, *deleted)
+    } else if _, restored := tx.staged[id]; !restored {
+      batch.Delete = append(batch.Delete, id)
+    }
+  }
+
+  teamIDs := make([]string, 0, len(tx.stagedTeams))
+  for id := range tx.stagedTeams {
+    teamIDs = append(teamIDs, id)
+  }
+  slices.SortFunc(teamIDs, compareIDs)
+  for _, id := range teamIDs {
+    if team := tx.stagedTeams[id]; team != nil {
+      batch.PutTeams = append(batch.PutTeams, *team)
+    } else {
+      batch.DeleteTeams = append(batch.DeleteTeams, id)
+    }
+  }
+
+  matchIDs := make([]string, 0, len(tx.stagedMatches))
+  for id := range tx.stagedMatches {
+    matchIDs = append(matchIDs, id)
+  }
+  slices.SortFunc(matchIDs, compareIDs)
+  for _, id := range matchIDs {
+    if match := tx.stagedMatches[id]; match != nil {
+      batch.PutMatches = append(batch.PutMatches, *match)
+    } else {
+      batch.DeleteMatches = append(batch.DeleteMatches, id)
+    }
+  }
+
+  seq := tx.s.store.RatingSeq()
+  for _, change := range tx.ratings {
+    seq++
+    change.Seq = seq
+    batch.RatingChanges = append(batch.RatingChanges, change)
+  }
+
+  // The audit trail is written first, so no change reaches the store
+  // without an audit entry. If the store then fails, a "failed" entry is
+  // added for each of them so the trail only shows changes that happened.
+  appended, err := tx.s.audit.Append(tx.audit)
+  if err != nil {
+    tx.rollback()
+    return fmt.Errorf("failed to save players: %w", err)
+  }
+  if err := tx.s.store.Apply(batch); err != nil {
+    if _, auditErr := tx.s.audit.Append(failedEntries(appended, err)); auditErr != nil {
+      slog.Error("Error recording failed write in audit trail", LogKeyError, auditErr, "entries", len(appended))
+    }
+    tx.rollback()
+    return fmt.Errorf("failed to save players: %w", err)
+  }
+  tx.s.events.Publish(changeEvents(tx.audit, tx.trace))
+  tx.staged = nil
+  tx.stagedTrash = nil
+  tx.stagedTeams = nil
+  tx.stagedMatches = nil
+  tx.ratings = nil
+  tx.audit = nil
+  tx.undo = nil
+  return nil
+}
+
+// rollback discards staged changes and restores the indexes
+func (tx *playerTx) rollback() {
+  for i := len(tx.undo) - 1; i >= 0; i-- {
+    tx.undo[i]()
+  }
+  tx.staged = nil
+  tx.stagedTrash = nil
+  tx.stagedTeams = nil
+  tx.stagedMatches = nil
+  tx.ratings = nil
+  tx.audit = nil
+  tx.undo = nil
+}
@@ -3,6 +3,7 @@ package main
 import (
   "errors"
   "fmt"
+  "strings"
 )
 
 // Response represents the standard API response structure
@@ -10,7 +11,19 @@ type Response struct {
   Message string      `json:"message"`
   Status  string      `json:"status"`
   Data    interface{} `json:"data,omitempty"`
+  Meta    *PageMeta   `json:"meta,omitempty"`
   Error   string      `json:"error,omitempty"`
+  // RequestID is set on errors so clients can quote it when reporting them
+  RequestID string `json:"request_id,omitempty"`
+  TraceID   string `json:"trace_id,omitempty"`
+}
+
+// PageMeta describes the position of a paginated response
+type PageMeta struct {
+  Total      int    `json:"total"`
+  Limit      int    `json:"limit"`
+  NextCursor string `json:"next_cursor,omitempty"`
+  PrevCursor string `json:"prev_cursor,omitempty"`
 }
 
 // Player represents a football player
@@ -19,21 +32,53 @@ type Player struct {
   Name         string `json:"name"`
   JerseyNumber int8   `json:"jersey_number"`
   Rating       int8   `json:"rating"`
+  TeamID       string `json:"team_id,omitempty"`
+  Version      int64  `json:"version"`
 }
 
+// AnyVersion skips the version check in PlayerService writes
+const AnyVersion int64 = 0
+
 // PlayerRequest represents the request structure for creating/updating players
 type PlayerRequest struct {
   Name         string `json:"name"`
   JerseyNumber int8   `json:"jersey_number"`
   Rating       int8   `json:"rating"`
+  TeamID       string `json:"team_id,omitempty"`
 }
 
+// Team represents a club that players can be signed to
+type Team struct {
+  ID      string `json:"id"`
+  Name    string `json:"name"`
+  Version int64  `json:"version"`
+}
+
+// TeamRequest represents the request structure for creating/updating teams
+type TeamRequest struct {
+  Name string `json:"name"`
+}
+
+// Ranges accepted by PlayerRequest.Validate
+const (
+  MinJerseyNumber = 1
+  MaxJerseyNumber = 99
+  MinRating       = 1
+  MaxRating       = 99
+)
+
 // Custom errors
 var (
   ErrPlayerNotFound    = errors.New("player not found")
   ErrInvalidInput      = errors.New("invalid input")
   ErrPlayerExists      = errors.New("player already exists")
   ErrInvalidJSONFormat = errors.New("invalid JSON format")
+  ErrVersionMismatch   = errors.New("version mismatch")
+  ErrInvalidPatch      = errors.New("invalid patch")
+  ErrPatchTestFailed   = errors.New("patch test failed")
+  ErrTeamNotFound      = errors.New("team not found")
+  ErrTeamExists        = errors.New("team already exists")
+  ErrTeamHasPlayers    = errors.New("team has players")
 )
 
 // Validate validates the player request data
@@ -41,11 +86,11 @@ func (pr *PlayerRequest) Validate() error {
   if pr.Name == "" {
     return fmt.Errorf("%w: name is required", ErrInvalidInput)
   }
-  if pr.JerseyNumber < 1 || pr.JerseyNumber > 99 {
-    return fmt.Errorf("%w: jersey number must be between 1 and 99", ErrInvalidInput)
+  if pr.JerseyNumber < MinJerseyNumber || pr.JerseyNumber > MaxJerseyNumber {
+    return fmt.Errorf("%w: jersey number must be between %d and %d", ErrInvalidInput, MinJerseyNumber, MaxJerseyNumber)
   }
-  if pr.Rating < 1 || pr.Rating > 99 {
-    return fmt.Errorf("%w: rating must be between 1 and 99", ErrInvalidInput)
+  if pr.Rating < MinRating || pr.Rating > MaxRating {
+    return fmt.Errorf("%w: rating must be between %d and %d", ErrInvalidInput, MinRating, MaxRating)
   }
   return nil
 }
@@ -57,10 +102,24 @@ func (pr *PlayerRequest) ToPlayer(id string) Player {
     Name:         pr.Name,
     JerseyNumber: pr.JerseyNumber,
     Rating:       pr.Rating,
+    TeamID:       pr.TeamID,
+    Version:      1,
   }
 }
 
-// Update updates the player with new data
+// ToRequest returns the editable fields of the player
+func (p *Player) ToRequest() PlayerRequest {
+  return PlayerRequest{
+    Name:         p.Name,
+    JerseyNumber: p.JerseyNumber,
+    Rating:       p.Rating,
+    TeamID:       p.TeamID,
+  }
+}
+
+// Update updates the player with new data. PUT replaces team membership like
+// the other fields, so a request without a team_id releases the player; use
+// a PATCH to change other fields and keep the team.
 func (p *Player) Update(req PlayerRequest) {
   if req.Name != "" {
     p.Name = req.Name
@@ -71,4 +130,26 @@ func (p *Player) Update(req PlayerRequest) {
   if req.Rating > 0 {
     p.Rating = req.Rating
   }
+  // Unlike the other fields an empty team is meaningful: the player is released
+  p.TeamID = req.TeamID
+  p.Version++
+}
+
+// Validate validates the team request data
+func (tr *TeamRequest) Validate() error {
+  if strings.TrimSpace(tr.Name) == "" {
+    return fmt.Errorf("%w: name is required", ErrInvalidInput)
+  }
+  return nil
+}
+
+// ToTeam converts TeamRequest to Team with given ID
+func (tr *TeamRequest) ToTeam(id string) Team {
+  return Team{ID: id, Name: tr.Name, Version: 1}
+}
+
+// Update updates the team with new data
+func (t *Team) Update(req TeamRequest) {
+  t.Name = req.Name
+  t.Version++
 }
@@ -0,0 +1,604 @@
+package main
+
+import (
+  "bytes"
+  "context"
+  "crypto/hmac"
+  "crypto/rand"
+  "crypto/sha256"
+  "encoding/hex"
+  "encoding/json"
+  "errors"
+  "fmt"
+  "io"
+  "log/slog"
+  mathrand "math/rand/v2"
+  "net/http"
+  "net/url"
+  "os"
+  "path/filepath"
+  "slices"
+  "strconv"
+  "sync"
+  "time"
+)
+
+// webhooksFileName is the webhook subscriptions file inside DATA_DIR
+const webhooksFileName = "webhooks.json"
+
+// Webhook defaults
+const (
+  DefaultWebhookAttempts = 8
+  DefaultWebhookBackoff  = time.Second
+  DefaultWebhookMaxDelay = time.Hour
+  DefaultWebhookTimeout  = 10 * time.Second
+  DefaultWebhookWorkers  = 4
+  DefaultWebhookQueue    = 10_000
+  MaxWebhooks            = 100
+  MinWebhookSecretLength = 16
+  // MaxWebhookDeliveries is how many deliveries are kept per webhook
+  MaxWebhookDeliveries = 100
+)
+
+// Headers sent with every webhook delivery
+const (
+  WebhookDeliveryHeader  = "X-Webhook-Delivery"
+  WebhookEventHeader     = "X-Webhook-Event"
+  WebhookTimestampHeader = "X-Webhook-Timestamp"
+  WebhookSignatureHeader = "X-Webhook-Signature"
+)
+
+// Delivery states
+const (
+  DeliveryPending   = "pending"
+  DeliveryDelivered = "delivered"
+  // DeliveryDead deliveries failed every attempt and won't be retried
+  DeliveryDead = "dead"
+)
+
+// ErrWebhookNotFound is returned when a webhook doesn't exist
+var ErrWebhookNotFound = errors.New("webhook not found")
+
+// Webhook is a subscription to player change events. Secret is only shown
+// when the webhook is created.
+type Webhook struct {
+  ID        string    `json:"id"`
+  URL       string    `json:"url"`
+  Events    []string  `json:"events"`
+  Secret    string    `json:"secret,omitempty"`
+  CreatedAt time.Time `json:"created_at"`
+}
+
+// WebhookRequest represents the request structure for creating webhooks
+type WebhookRequest struct {
+  URL string `json:"url"`
+  // Events limits the webhook to these change types; empty means all
+  Events []string `json:"events,omitempty"`
+  // Secret signs deliveries; one is generated when left empty
+  Secret string `json:"secret,omitempty"`
+}
+
+// Validate validates the webhook request data
+func (wr *WebhookRequest) Validate() error {
+  target, err := url.Parse(wr.URL)
+  if err != nil || (target.Scheme != "http" && target.Scheme != "https") || target.Host == "" {
+    return fmt.Errorf("%w: url must be an absolute http or https URL", ErrInvalidInput)
+  }
+  for _, event := range wr.Events {
+    switch event {
+    case ChangeCreated, ChangeUpdated, ChangeDeleted:
+    default:
+      return fmt.Errorf("%w: events must be %s, %s or %s", ErrInvalidInput, ChangeCreated, ChangeUpdated, ChangeDeleted)
+    }
+  }
+  if wr.Secret != "" && len(wr.Secret) < MinWebhookSecretLength {
+    return fmt.Errorf("%w: secret must be at least %d characters", ErrInvalidInput, MinWebhookSecretLength)
+  }
+  return nil
+}
+
+// wants reports whether the webhook subscribes to an event
+func (w *Webhook) wants(event ChangeEvent) bool {
+  return event.Type != ChangeReset && (len(w.Events) == 0 || slices.Contains(w.Events, event.Type))
+}
+
+// redacted returns a copy of the webhook without its secret
+func (w *Webhook) redacted() Webhook {
+  copied := *w
+  copied.Secret = ""
+  return copied
+}
+
+// DeliveryAttempt is one try at delivering an event. StatusCode is zero
+// when no response was received.
+type DeliveryAttempt struct {
+  At         time.Time `json:"at"`
+  StatusCode int       `json:"status_code,omitempty"`
+  Error      string    `json:"error,omitempty"`
+  DurationMS int64     `json:"duration_ms"`
+}
+
+// WebhookDelivery tracks an event on its way to a webhook
+type WebhookDelivery struct {
+  ID            string            `json:"id"`
+  WebhookID     string            `json:"webhook_id"`
+  Event         ChangeEvent       `json:"event"`
+  Status        string            `json:"status"`
+  Attempts      []DeliveryAttempt `json:"attempts"`
+  NextAttemptAt time.Time         `json:"next_attempt_at,omitzero"`
+  // Error says why a dead delivery was given up on before its last attempt
+  Error string `json:"error,omitempty"`
+}
+
+// WebhookOptions configures a WebhookDispatcher. Zero values use the defaults.
+type WebhookOptions struct {
+  // MaxAttempts is how many times a delivery is tried before it is dead-lettered
+  MaxAttempts int
+  // Backoff is the delay after the first failure, doubling after each
+  // further failure up to MaxDelay. Delays are jittered by up to half.
+  Backoff  time.Duration
+  MaxDelay time.Duration
+  Timeout  time.Duration
+  Workers  int
+  // MaxQueued is how many deliveries may wait to be sent; beyond it the
+  // longest-waiting one is dead-lettered to make room
+  MaxQueued int
+  // Client sends the deliveries; tests can use an httptest server's client
+  Client *http.Client
+}
+
+// webhooksFile is the on-disk format of the webhook subscriptions
+type webhooksFile struct {
+  IDCounter int       `json:"id_counter"`
+  Webhooks  []Webhook `json:"webhooks"`
+}
+
+// WebhookDispatcher delivers player change events to webhook subscribers.
+// Events are queued as they are published, so delivery never holds up a
+// write, and failed deliveries are retried with exponential backoff until
+// they succeed or run out of attempts. When receivers fall too far behind,
+// the oldest queued deliveries are dead-lettered. Subscriptions survive
+// restarts when the dispatcher is backed by a file; queued deliveries don't.
+type WebhookDispatcher struct {
+  mu         sync.Mutex
+  path       string
+  opts       WebhookOptions
+  webhooks   map[string]*Webhook
+  idCounter  int
+  deliveries map[string][]*WebhookDelivery
+  queue      []*WebhookDelivery
+  wake       chan struct{}
+
+  // now and jitter are replaced by tests
+  now    func() time.Time
+  jitter func() float64
+}
+
+// NewWebhookDispatcher creates a dispatcher for the broker's events that
+// keeps its subscriptions in memory
+func NewWebhookDispatcher(broker *EventBroker, opts WebhookOptions) *WebhookDispatcher {
+  d := newWebhookDispatcher(opts)
+  broker.OnPublish(d.enqueue)
+  return d
+}
+
+// OpenWebhookDispatcher creates a dispatcher that keeps its subscriptions in
+// the file at path, loading any saved there before
+func OpenWebhookDispatcher(path string, broker *EventBroker, opts WebhookOptions) (*WebhookDispatcher, error) {
+  d := newWebhookDispatcher(opts)
+  d.path = path
+
+  raw, err := os.ReadFile(path)
+  if err != nil && !errors.Is(err, os.ErrNotExist) {
+    return nil, fmt.Errorf("failed to read webhooks: %w", err)
+  }
+  if err == nil {
+    var saved webhooksFile
+    if err := json.Unmarshal(raw, &saved); err != nil {
+      return nil, fmt.Errorf("failed to decode webhooks: %w", err)
+    }
+    d.idCounter = saved.IDCounter
+    for _, webhook := range saved.Webhooks {
+      d.webhooks[webhook.ID] = &webhook
+    }
+  }
+
+  broker.OnPublish(d.enqueue)
+  return d, nil
+}
+
+func newWebhookDispatcher(opts WebhookOptions) *WebhookDispatcher {
+  if opts.MaxAttempts <= 0 {
+    opts.MaxAttempts = DefaultWebhookAttempts
+  }
+  if opts.Backoff <= 0 {
+    opts.Backoff = DefaultWebhookBackoff
+  }
+  if opts.MaxDelay <= 0 {
+    opts.MaxDelay = DefaultWebhookMaxDelay
+  }
+  if opts.Timeout <= 0 {
+    opts.Timeout = DefaultWebhookTimeout
+  }
+  if opts.Workers <= 0 {
+    opts.Workers = DefaultWebhookWorkers
+  }
+  if opts.MaxQueued <= 0 {
+    opts.MaxQueued = DefaultWebhookQueue
+  }
+  if opts.Client == nil {
+    opts.Client = &http.Client{}
+  }
+
+  return &WebhookDispatcher{
+    opts:       opts,
+    webhooks:   make(map[string]*Webhook),
+    deliveries: make(map[string][]*WebhookDelivery),
+    wake:       make(chan struct{}, 1),
+    now:        time.Now,
+    jitter:     mathrand.Float64,
+  }
+}
+
+// save writes the subscriptions to disk. The caller must hold the lock.
+func (d *WebhookDispatcher) save() error {
+  if d.path == "" {
+    return nil
+  }
+  saved := webhooksFile{IDCounter: d.idCounter, Webhooks: make([]Webhook, 0, len(d.webhooks))}
+  for _, webhook := range d.webhooks {
+    saved.Webhooks = append(saved.Webhooks, *webhook)
+  }
+  slices.SortFunc(saved.Webhooks, func(a, b Webhook) int { return compareIDs(a.ID, b.ID) })
+
+  raw, err := json.Marshal(saved)
+  if err != nil {
+    return fmt.Errorf("failed to encode webhooks: %w", err)
+  }
+  if err := os.MkdirAll(filepath.Dir(d.path), 0o755); err != nil {
+    return fmt.Errorf("failed to create webhooks directory: %w", err)
+  }
+  // The file holds the signing secrets
+  return writeFileAtomic(d.path, raw, 0o600, true)
+}
+
+// CreateWebhook subscribes a URL to player change events. The returned
+// webhook includes its secret, which is not shown again.
+func (d *WebhookDispatcher) CreateWebhook(req WebhookRequest) (Webhook, error) {
+  if err := req.Validate(); err != nil {
+    return Webhook{}, err
+  }
+
+  d.mu.Lock()
+  defer d.mu.Unlock()
+
+  if len(d.webhooks) >= MaxWebhooks {
+    return Webhook{}, fmt.Errorf("%w: at most %d webhooks are allowed", ErrInvalidInput, MaxWebhooks)
+  }
+  secret := req.Secret
+  if secret == "" {
+    var b [32]byte
+    rand.Read(b[:])
+    secret = hex.EncodeToString(b[:])
+  }
+  events := make([]string, 0, len(req.Events))
+  for _, event := range req.Events {
+    if !slices.Contains(events, event) {
+      events = append(events, event)
+    }
+  }
+
+  d.idCounter++
+  webhook := &Webhook{
+    ID:        strconv.Itoa(d.idCounter),
+    URL:       req.URL,
+    Events:    events,
+    Secret:    secret,
+    CreatedAt: d.now().UTC(),
+  }
+  d.webhooks[webhook.ID] = webhook
+  if err := d.save(); err != nil {
+    delete(d.webhooks, webhook.ID)
+    return Webhook{}, err
+  }
+  return *webhook, nil
+}
+
+// Webhooks returns every webhook, without secrets
+func (d *WebhookDispatcher) Webhooks() []Webhook {
+  d.mu.Lock()
+  defer d.mu.Unlock()
+
+  webhooks := make([]Webhook, 0, len(d.webhooks))
+  for _, webhook := range d.webhooks {
+    webhooks = append(webhooks, webhook.redacted())
+  }
+  slices.SortFunc(webhooks, func(a, b Webhook) int { return compareIDs(a.ID, b.ID) })
+  return webhooks
+}
+
+// GetWebhook returns a webhook by ID, without its secret
+func (d *WebhookDispatcher) GetWebhook(id string) (Webhook, error) {
+  d.mu.Lock()
+  defer d.mu.Unlock()
+
+  webhook, exists := d.webhooks[id]
+  if !exists {
+    return Webhook{}, fmt.Errorf("%w: %s", ErrWebhookNotFound, id)
+  }
+  return webhook.redacted(), nil
+}
+
+// DeleteWebhook unsubscribes a webhook and drops its queued deliveries
+func (d *WebhookDispatcher) DeleteWebhook(id string) (Webhook, error) {
+  d.mu.Lock()
+  defer d.mu.Unlock()
+
+  webhook, exists := d.webhooks[id]
+  if !exists {
+    return Webhook{}, fmt.Errorf("%w: %s", ErrWebhookNotFound, id)
+  }
+  delete(d.webhooks, id)
+  if err := d.save(); err != nil {
+    d.webhooks[id] = webhook
+    return Webhook{}, err
+  }
+
+  delete(d.deliveries, id)
+  d.queue = slices.DeleteFunc(d.queue, func(delivery *WebhookDelivery) bool {
+    return delivery.WebhookID == id
+  })
+  return webhook.redacted(), nil
+}
+
+// Deliveries returns a webhook's most recent deliveries, newest first,
+// optionally only those in the given state
+func (d *WebhookDispatcher) Deliveries(id, status string) ([]WebhookDelivery, error) {
+  d.mu.Lock()
+  defer d.mu.Unlock()
+
+  if _, exists := d.webhooks[id]; !exists {
+    return nil, fmt.Errorf("%w: %s", ErrWebhookNotFound, id)
+  }
+  deliveries := make([]WebhookDelivery, 0)
+  for _, delivery := range slices.Backward(d.deliveries[id]) {
+    if status == "" || delivery.Status == status {
+      copied := *delivery
+      copied.Attempts = slices.Clone(delivery.Attempts)
+      deliveries = append(deliveries, copied)
+    }
+  }
+  return deliveries, nil
+}
+
+// enqueue queues an event for every webhook that wants it. It runs while
+// the broker publishes, so it only does bookkeeping.
+func (d *WebhookDispatcher) enqueue(event ChangeEvent) {
+  d.mu.Lock()
+  defer d.mu.Unlock()
+
+  queued := false
+  for _, webhook := range d.webhooks {
+    if !webhook.wants(event) {
+      continue
+    }
+    delivery := &WebhookDelivery{
+      ID:            newDeliveryID(),
+      WebhookID:     webhook.ID,
+      Event:         event,
+      Status:        DeliveryPending,
+      Attempts:      make([]DeliveryAttempt, 0),
+      NextAttemptAt: d.now().UTC(),
+    }
+    d.deliveries[webhook.ID] = trimDeliveries(append(d.deliveries[webhook.ID], delivery))
+    d.push(delivery)
+    queued = true
+  }
+  if queued {
+    d.signal()
+  }
+}
+
+// newDeliveryID returns a random 128-bit ID in hex, so receivers can
+// deduplicate on it across restarts
+func newDeliveryID() string {
+  var b [16]byte
+  rand.Read(b[:])
+  return hex.EncodeToString(b[:])
+}
+
+// push adds a delivery to the queue, dead-lettering the one that has waited
+// longest when the queue is full. The caller must hold the lock.
+func (d *WebhookDispatcher) push(delivery *WebhookDelivery) {
+  if len(d.queue) >= d.opts.MaxQueued {
+    dropped := d.queue[0]
+    d.queue = d.queue[1:]
+    dropped.Status = DeliveryDead
+    dropped.NextAttemptAt = time.Time{}
+    dropped.Error = fmt.Sprintf("dropped: more than %d deliveries queued", d.opts.MaxQueued)
+    slog.Warn("Dropping webhook delivery", "webhook_id", dropped.WebhookID, "delivery_id", dropped.ID, LogKeyError, dropped.Error)
+  }
+  d.queue = append(d.queue, delivery)
+}
+
+// trimDeliveries drops the oldest finished deliveries beyond MaxWebhookDeliveries
+func trimDeliveries(deliveries []*WebhookDelivery) []*WebhookDelivery {
+  for i := 0; len(deliveries) > MaxWebhookDeliveries && i < len(deliveries); {
+    if deliveries[i].Status == DeliveryPending {
+      i++
+      continue
+    }
+    deliveries = slices.Delete(deliveries, i, i+1)
+  }
+  return deliveries
+}
+
+// signal wakes Run without blocking
+func (d *WebhookDispatcher) signal() {
+  select {
+  case d.wake <- struct{}{}:
+  default:
+  }
+}
+
+// Run sends queued deliveries until ctx is done, then waits for the ones in
+// flight to finish
+func (d *WebhookDispatcher) Run(ctx context.Context) {
+  var inFlight sync.WaitGroup
+  defer inFlight.Wait()
+  workers := make(chan struct{}, d.opts.Workers)
+
+  for {
+    due, wait := d.takeDue()
+    for _, delivery := range due {
+      select {
+      case workers <- struct{}{}:
+      case <-ctx.Done():
+        return
+      }
+      inFlight.Add(1)
+      go func() {
+        defer inFlight.Done()
+        defer func() { <-workers }()
+        d.attempt(ctx, delivery)
+      }()
+    }
+
+    timer := time.NewTimer(wait)
+    select {
+    case <-ctx.Done():
+      timer.Stop()
+      return
+    case <-d.wake:
+    case <-timer.C:
+    }
+    timer.Stop()
+  }
+}
+
+// takeDue removes the deliveries that are due from the queue and returns
+// them along with how long to wait for the next one
+func (d *WebhookDispatcher) takeDue() ([]*WebhookDelivery, time.Duration) {
+  d.mu.Lock()
+  defer d.mu.Unlock()
+
+  now := d.now()
+  wait := time.Hour
+  var due []*WebhookDelivery
+  d.queue = slices.DeleteFunc(d.queue, func(delivery *WebhookDelivery) bool {
+    if !delivery.NextAttemptAt.After(now) {
+      due = append(due, delivery)
+      return true
+    }
+    wait = min(wait, delivery.NextAttemptAt.Sub(now))
+    return false
+  })
+  return due, wait
+}
+
+// attempt makes one delivery attempt and schedules a retry if it fails
+func (d *WebhookDispatcher) attempt(ctx context.Context, delivery *WebhookDelivery) {
+  d.mu.Lock()
+  webhook, exists := d.webhooks[delivery.WebhookID]
+  var target, secret string
+  if exists {
+    target, secret = webhook.URL, webhook.Secret
+  }
+  d.mu.Unlock()
+  if !exists {
+    return
+  }
+
+  start := d.now()
+  status, err := d.send(ctx, target, secret, delivery)
+  if ctx.Err() != nil {
+    // Shutting down; the failure says nothing about the receiver
+    return
+  }
+  result := DeliveryAttempt{At: start.UTC(), StatusCode: status, DurationMS: d.now().Sub(start).Milliseconds()}
+  if err != nil {
+    result.Error = err.Error()
+  }
+
+  d.mu.Lock()
+  defer d.mu.Unlock()
+
+  delivery.Attempts = append(delivery.Attempts, result)
+  switch {
+  case err == nil:
+    delivery.Status = DeliveryDelivered
+    delivery.NextAttemptAt = time.Time{}
+  case len(delivery.Attempts) >= d.opts.MaxAttempts:
+    delivery.Status = DeliveryDead
+    delivery.NextAttemptAt = time.Time{}
+    slog.Warn("Giving up on webhook delivery", "webhook_id", delivery.WebhookID, "delivery_id", delivery.ID, "attempts", len(delivery.Attempts), LogKeyError, err)
+  default:
+    delivery.NextAttemptAt = d.now().Add(d.backoff(len(delivery.Attempts))).UTC()
+    if _, exists := d.webhooks[delivery.WebhookID]; exists {
+      d.push(delivery)
+      d.signal()
+    }
+  }
+}
+
+// backoff returns the delay after the given number of failed attempts:
+// Backoff doubled for each attempt after the first, capped at MaxDelay,
+// less a random part of up to half of it so retries don't line up
+func (d *WebhookDispatcher) backoff(failures int) time.Duration {
+  delay := d.opts.Backoff
+  for i := 1; i < failures && delay < d.opts.MaxDelay; i++ {
+    delay *= 2
+  }
+  delay = min(delay, d.opts.MaxDelay)
+  return delay - time.Duration(d.jitter()*float64(delay/2))
+}
+
+// send POSTs the event to the webhook and returns the response status. Any
+// status outside 2xx is an error.
+func (d *WebhookDispatcher) send(ctx context.Context, target, secret string, delivery *WebhookDelivery) (int, error) {
+  body, err := json.Marshal(delivery.Event)
+  if err != nil {
+    return 0, fmt.Errorf("failed to encode event: %w", err)
+  }
+
+  ctx, cancel := context.WithTimeout(ctx, d.opts.Timeout)
+  defer cancel()
+  req, err := http.NewRequestWithContext(ctx, http.MethodPost, target, bytes.NewReader(body))
+  if err != nil {
+    return 0, err
+  }
+  timestamp := d.now().Unix()
+  req.Header.Set("Content-Type", "application/json")
+  req.Header.Set(WebhookDeliveryHeader, delivery.ID)
+  req.Header.Set(WebhookEventHeader, delivery.Event.Type)
+  req.Header.Set(WebhookTimestampHeader, strconv.FormatInt(timestamp, 10))
+  req.Header.Set(WebhookSignatureHeader, signWebhook(secret, timestamp, body))
+  if trace := delivery.Event.Trace; trace.TraceID != "" {
+    req.Header.Set(TraceparentHeader, trace.Traceparent())
+    if trace.State != "" {
+      req.Header.Set(TracestateHeader, trace.State)
+    }
+  }
+
+  resp, err := d.opts.Client.Do(req)
+  if err != nil {
+    return 0, err
+  }
+  defer resp.Body.Close()
+  // Drain a little of the body so the connection can be reused
+  io.Copy(io.Discard, io.LimitReader(resp.Body, 4096))
+
+  if resp.StatusCode < 200 || resp.StatusCode > 299 {
+    return resp.StatusCode, fmt.Errorf("receiver returned %s", resp.Status)
+  }
+  return resp.StatusCode, nil
+}
+
+// signWebhook returns the signature header for a delivery: the hex
+// HMAC-SHA256 of "<timestamp>.<body>" keyed with the webhook's secret
+func signWebhook(secret string, timestamp int64, body []byte) string {
+  mac := hmac.New(sha256.New, []byte(secret))
+  fmt.Fprintf(mac, "%d.", timestamp)
+  mac.Write(body)
+  return "sha256=" + hex.EncodeToString(mac.Sum(nil))
+}
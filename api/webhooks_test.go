@@ -0,0 +1,351 @@
+package main
+
+import (
+  "context"
+  "encoding/json"
+  "io"
+  "net/http"
+  "net/http/httptest"
+  "os"
+  "path/filepath"
+  "strconv"
+  "strings"
+  "sync"
+  "testing"
+  "time"
+)
+
+// waitFor polls cond until it holds, failing the test after a second
+func waitFor(t *testing.T, what string, cond func() bool) {
+  t.Helper()
+  deadline := time.Now().Add(time.Second)
+  for !cond() {
+    if time.Now().After(deadline) {
+      t.Fatalf("Timed out waiting for %s", what)
+    }
+    time.Sleep(time.Millisecond)
+  }
+}
+
+// receiver is a local webhook endpoint that records what it is sent and
+// answers with the status codes it is given, then 200
+type receiver struct {
+  mu       sync.Mutex
+  requests []*http.Request
+  bodies   [][]byte
+  statuses []int
+}
+
+func (rc *receiver) ServeHTTP(w http.ResponseWriter, r *http.Request) {
+  body, _ := io.ReadAll(r.Body)
+  rc.mu.Lock()
+  defer rc.mu.Unlock()
+
+  rc.requests = append(rc.requests, r)
+  rc.bodies = append(rc.bodies, body)
+  status := http.StatusOK
+  if len(rc.statuses) > 0 {
+    status, rc.statuses = rc.statuses[0], rc.statuses[1:]
+  }
+  w.WriteHeader(status)
+}
+
+func (rc *receiver) count() int {
+  rc.mu.Lock()
+  defer rc.mu.Unlock()
+  return len(rc.requests)
+}
+
+// startDispatcher runs a dispatcher for the service's events until the test ends
+func startDispatcher(t *testing.T, service *PlayerService, opts WebhookOptions) *WebhookDispatcher {
+  t.Helper()
+  dispatcher := NewWebhookDispatcher(service.Events(), opts)
+  done := make(chan struct{})
+  ctx, cancel := context.WithCancel(t.Context())
+  go func() {
+    defer close(done)
+    dispatcher.Run(ctx)
+  }()
+  t.Cleanup(func() {
+    cancel()
+    <-done
+  })
+  return dispatcher
+}
+
+func TestWebhookDispatcher_DeliversSignedEvents(t *testing.T) {
+  rc := &receiver{}
+  server := httptest.NewServer(rc)
+  defer server.Close()
+
+  service := NewPlayerService()
+  dispatcher := startDispatcher(t, service, WebhookOptions{})
+  webhook, err := dispatcher.CreateWebhook(WebhookRequest{URL: server.URL, Events: []string{ChangeCreated}})
+  if err != nil {
+    t.Fatalf("Failed to create webhook: %v", err)
+  }
+  if len(webhook.Secret) != 64 {
+    t.Errorf("Expected a generated secret, got %q", webhook.Secret)
+  }
+
+  // Only the create matches the webhook's filter
+  player, err := service.CreatePlayer(WithActor(t.Context(), "alice"), PlayerRequest{Name: "Pedri", JerseyNumber: 8, Rating: 86})
+  if err != nil {
+    t.Fatalf("Failed to create player: %v", err)
+  }
+  setRating(t, service, "alice", player.ID, 88)
+  waitFor(t, "the delivery", func() bool {
+    delivered, _ := dispatcher.Deliveries(webhook.ID, DeliveryDelivered)
+    return len(delivered) == 1
+  })
+
+  rc.mu.Lock()
+  req, body := rc.requests[0], rc.bodies[0]
+  rc.mu.Unlock()
+
+  timestamp, _ := strconv.ParseInt(req.Header.Get(WebhookTimestampHeader), 10, 64)
+  if got, want := req.Header.Get(WebhookSignatureHeader), signWebhook(webhook.Secret, timestamp, body); got != want {
+    t.Errorf("Expected signature %s, got %s", want, got)
+  }
+  if req.Header.Get(WebhookEventHeader) != ChangeCreated || req.Header.Get("Content-Type") != "application/json" {
+    t.Errorf("Unexpected headers: %v", req.Header)
+  }
+  var event ChangeEvent
+  if err := json.Unmarshal(body, &event); err != nil || event.PlayerID != player.ID || event.Actor != "alice" {
+    t.Errorf("Expected Pedri's creation, got %s", body)
+  }
+
+  deliveries, _ := dispatcher.Deliveries(webhook.ID, "")
+  if len(deliveries) != 1 || deliveries[0].Status != DeliveryDelivered || deliveries[0].Attempts[0].StatusCode != http.StatusOK {
+    t.Errorf("Expected one successful delivery, got %+v", deliveries)
+  }
+}
+
+func TestWebhookDispatcher_PropagatesTrace(t *testing.T) {
+  rc := &receiver{}
+  server := httptest.NewServer(rc)
+  defer server.Close()
+
+  service := NewPlayerService()
+  dispatcher := startDispatcher(t, service, WebhookOptions{})
+  webhook, _ := dispatcher.CreateWebhook(WebhookRequest{URL: server.URL})
+
+  // The change is made while handling a request that continues a trace
+  ctx, span := NewTracer(nil).Start(t.Context(), "PUT /players/{id}", SpanKindServer,
+    SpanContext{TraceID: testTraceID, SpanID: testSpanID, Sampled: true, State: "vendor=abc"})
+  player, _ := service.GetPlayerByID("1")
+  req := player.ToRequest()
+  req.Rating = 97
+  if _, err := service.UpdatePlayer(ctx, "1", req, AnyVersion); err != nil {
+    t.Fatalf("Failed to update player: %v", err)
+  }
+  span.End()
+  waitFor(t, "the delivery", func() bool {
+    delivered, _ := dispatcher.Deliveries(webhook.ID, DeliveryDelivered)
+    return len(delivered) == 1
+  })
+
+  rc.mu.Lock()
+  header := rc.requests[0].Header
+  rc.mu.Unlock()
+  sc, err := ParseTraceparent(header.Get(TraceparentHeader))
+  if err != nil || sc.TraceID != testTraceID || sc.SpanID == testSpanID || !sc.Sampled {
+    t.Errorf("Expected the delivery to continue the request's trace, got %q %v", header.Get(TraceparentHeader), err)
+  }
+  if state := header.Get(TracestateHeader); state != "vendor=abc" {
+    t.Errorf("Expected the tracestate to be passed on, got %q", state)
+  }
+
+  // Changes made outside a trace are delivered without trace headers
+  setRating(t, service, "alice", "1", 98)
+  waitFor(t, "the second delivery", func() bool {
+    delivered, _ := dispatcher.Deliveries(webhook.ID, DeliveryDelivered)
+    return len(delivered) == 2
+  })
+  rc.mu.Lock()
+  header = rc.requests[1].Header
+  rc.mu.Unlock()
+  if header.Get(TraceparentHeader) != "" || header.Get(TracestateHeader) != "" {
+    t.Errorf("Expected no trace headers, got %v", header)
+  }
+}
+
+func TestWebhookDispatcher_RetriesAndDeadLetters(t *testing.T) {
+  flaky := &receiver{statuses: []int{500, 503}}
+  flakyServer := httptest.NewServer(flaky)
+  defer flakyServer.Close()
+  broken := &receiver{statuses: []int{500, 500, 500, 500}}
+  brokenServer := httptest.NewServer(broken)
+  defer brokenServer.Close()
+
+  service := NewPlayerService()
+  dispatcher := startDispatcher(t, service, WebhookOptions{MaxAttempts: 3, Backoff: time.Millisecond})
+  flakyHook, _ := dispatcher.CreateWebhook(WebhookRequest{URL: flakyServer.URL})
+  brokenHook, _ := dispatcher.CreateWebhook(WebhookRequest{URL: brokenServer.URL})
+
+  setRating(t, service, "alice", "1", 97)
+  waitFor(t, "the retries", func() bool {
+    delivered, _ := dispatcher.Deliveries(flakyHook.ID, DeliveryDelivered)
+    dead, _ := dispatcher.Deliveries(brokenHook.ID, DeliveryDead)
+    return len(delivered) == 1 && len(dead) == 1
+  })
+
+  delivered, _ := dispatcher.Deliveries(flakyHook.ID, "")
+  if attempts := delivered[0].Attempts; len(attempts) != 3 || attempts[0].StatusCode != 500 || attempts[2].StatusCode != 200 {
+    t.Errorf("Expected two failures and a success, got %+v", attempts)
+  }
+  dead, _ := dispatcher.Deliveries(brokenHook.ID, "")
+  if len(dead[0].Attempts) != 3 || !strings.Contains(dead[0].Attempts[2].Error, "500") || !dead[0].NextAttemptAt.IsZero() {
+    t.Errorf("Expected three failed attempts and no retry, got %+v", dead[0])
+  }
+  if n := broken.count(); n != 3 {
+    t.Errorf("Expected the broken receiver to be tried 3 times, got %d", n)
+  }
+}
+
+func TestWebhookDispatcher_CapsQueue(t *testing.T) {
+  broker := NewEventBroker(0)
+  dispatcher := NewWebhookDispatcher(broker, WebhookOptions{MaxQueued: 2})
+  webhook, _ := dispatcher.CreateWebhook(WebhookRequest{URL: "http://localhost:9000/hook"})
+
+  // Nothing is running, so the queue only fills up
+  broker.Publish([]ChangeEvent{{Type: ChangeCreated}, {Type: ChangeUpdated}, {Type: ChangeDeleted}})
+  if n := len(dispatcher.queue); n != 2 {
+    t.Errorf("Expected 2 queued deliveries, got %d", n)
+  }
+  dead, _ := dispatcher.Deliveries(webhook.ID, DeliveryDead)
+  if len(dead) != 1 || dead[0].Event.Type != ChangeCreated || !strings.Contains(dead[0].Error, "dropped") || len(dead[0].Attempts) != 0 {
+    t.Errorf("Expected the oldest delivery to be dead-lettered, got %+v", dead)
+  }
+
+  deliveries, _ := dispatcher.Deliveries(webhook.ID, "")
+  ids := make(map[string]bool)
+  for _, delivery := range deliveries {
+    if len(delivery.ID) != 32 || ids[delivery.ID] {
+      t.Errorf("Expected unique random delivery IDs, got %q", delivery.ID)
+    }
+    ids[delivery.ID] = true
+  }
+}
+
+func TestWebhookDispatcher_Backoff(t *testing.T) {
+  dispatcher := NewWebhookDispatcher(NewEventBroker(0), WebhookOptions{Backoff: time.Second, MaxDelay: 10 * time.Second})
+
+  tests := []struct {
+    failures int
+    jitter   float64
+    want     time.Duration
+  }{
+    {1, 0, time.Second},
+    {2, 0, 2 * time.Second},
+    {4, 0, 8 * time.Second},
+    {5, 0, 10 * time.Second},
+    {50, 0, 10 * time.Second},
+    {2, 1, time.Second},
+    {3, 0.5, 3 * time.Second},
+  }
+  for _, tt := range tests {
+    dispatcher.jitter = func() float64 { return tt.jitter }
+    if got := dispatcher.backoff(tt.failures); got != tt.want {
+      t.Errorf("backoff(%d) with jitter %v: expected %v, got %v", tt.failures, tt.jitter, tt.want, got)
+    }
+  }
+}
+
+func TestWebhookDispatcher_PersistsWebhooks(t *testing.T) {
+  path := filepath.Join(t.TempDir(), webhooksFileName)
+  dispatcher, err := OpenWebhookDispatcher(path, NewEventBroker(0), WebhookOptions{})
+  if err != nil {
+    t.Fatalf("Failed to open webhooks: %v", err)
+  }
+  first, _ := dispatcher.CreateWebhook(WebhookRequest{URL: "http://localhost:9000/a", Secret: "0123456789abcdef"})
+  second, _ := dispatcher.CreateWebhook(WebhookRequest{URL: "http://localhost:9000/b"})
+  if _, err := dispatcher.DeleteWebhook(second.ID); err != nil {
+    t.Fatalf("Failed to delete webhook: %v", err)
+  }
+
+  if info, err := os.Stat(path); err != nil || info.Mode().Perm() != 0o600 {
+    t.Errorf("Expected the secrets file to be private, got %v %v", info.Mode(), err)
+  }
+
+  dispatcher, err = OpenWebhookDispatcher(path, NewEventBroker(0), WebhookOptions{})
+  if err != nil {
+    t.Fatalf("Failed to reopen webhooks: %v", err)
+  }
+  if webhooks := dispatcher.Webhooks(); len(webhooks) != 1 || webhooks[0].URL != first.URL || webhooks[0].Secret != "" {
+    t.Errorf("Expected only the first webhook, without its secret, got %+v", webhooks)
+  }
+  if dispatcher.webhooks[first.ID].Secret != "0123456789abcdef" {
+    t.Errorf("Expected the secret to survive a restart")
+  }
+  if third, _ := dispatcher.CreateWebhook(WebhookRequest{URL: "http://localhost:9000/c"}); third.ID != "3" {
+    t.Errorf("Expected IDs not to be reused, got %s", third.ID)
+  }
+}
+
+func TestPlayerHandler_Webhooks(t *testing.T) {
+  service := NewPlayerService()
+  handler := NewPlayerHandlerWithWebhooks(service, NewWebhookDispatcher(service.Events(), WebhookOptions{}))
+
+  mux := http.NewServeMux()
+  mux.HandleFunc("GET /webhooks", handler.GetWebhooks)
+  mux.HandleFunc("GET /webhooks/{id}", handler.GetWebhook)
+  mux.HandleFunc("GET /webhooks/{id}/deliveries", handler.GetWebhookDeliveries)
+  mux.HandleFunc("POST /webhooks", handler.CreateWebhook)
+  mux.HandleFunc("DELETE /webhooks/{id}", handler.DeleteWebhook)
+
+  do := func(method, target, body string) *httptest.ResponseRecorder {
+    w := httptest.NewRecorder()
+    mux.ServeHTTP(w, httptest.NewRequest(method, target, strings.NewReader(body)))
+    return w
+  }
+
+  tests := []struct {
+    name string
+    body string
+    want int
+  }{
+    {"valid", `{"url": "https://partner.example/hooks", "events": ["created", "deleted"]}`, http.StatusCreated},
+    {"relative url", `{"url": "/hooks"}`, http.StatusBadRequest},
+    {"ftp url", `{"url": "ftp://partner.example/hooks"}`, http.StatusBadRequest},
+    {"unknown event", `{"url": "https://partner.example/hooks", "events": ["renamed"]}`, http.StatusBadRequest},
+    {"short secret", `{"url": "https://partner.example/hooks", "secret": "hunter2"}`, http.StatusBadRequest},
+    {"bad json", `{"url": `, http.StatusBadRequest},
+  }
+  for _, tt := range tests {
+    t.Run(tt.name, func(t *testing.T) {
+      if w := do("POST", "/webhooks", tt.body); w.Code != tt.want {
+        t.Errorf("Expected status %d, got %d: %s", tt.want, w.Code, w.Body.String())
+      }
+    })
+  }
+
+  // The secret is only shown on creation
+  w := do("GET", "/webhooks/1", "")
+  if w.Code != http.StatusOK || strings.Contains(w.Body.String(), "secret") {
+    t.Errorf("Expected the webhook without its secret, got %d %s", w.Code, w.Body.String())
+  }
+
+  setRating(t, service, "alice", "1", 97)
+  if _, err := service.DeletePlayer(t.Context(), "2", AnyVersion); err != nil {
+    t.Fatalf("Failed to delete player: %v", err)
+  }
+  w = do("GET", "/webhooks/1/deliveries?status=pending", "")
+  var response struct {
+    Data []WebhookDelivery `json:"data"`
+  }
+  json.NewDecoder(w.Body).Decode(&response)
+  if len(response.Data) != 1 || response.Data[0].Event.Type != ChangeDeleted {
+    t.Errorf("Expected the delete to be queued and the update filtered out, got %+v", response.Data)
+  }
+
+  if w := do("GET", "/webhooks/1/deliveries?status=lost", ""); w.Code != http.StatusBadRequest {
+    t.Errorf("Expected status 400, got %d", w.Code)
+  }
+  if w := do("DELETE", "/webhooks/1", ""); w.Code != http.StatusOK {
+    t.Errorf("Expected status 200, got %d", w.Code)
+  }
+  if w := do("GET", "/webhooks/1/deliveries", ""); w.Code != http.StatusNotFound {
+    t.Errorf("Expected status 404, got %d", w.Code)
+  }
+}